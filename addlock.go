@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/lock"
+)
+
+var (
+	lockBackend = kingpin.Flag(
+		"lock-backend",
+		"Serialize the additions of members across the instances with a lock in a DynamoDB table, see --lock-table, or an S3 object, see --lock-s3-url.",
+	).Default(
+		"none",
+	).Envar(
+		"ETCDMATE_LOCK_BACKEND",
+	).Enum("none", "dynamodb", "s3")
+	lockTable = kingpin.Flag(
+		"lock-table",
+		"DynamoDB table of the lock, with the string partition key LockID.",
+	).Default(
+		"etcdmate",
+	).Envar(
+		"ETCDMATE_LOCK_TABLE",
+	).String()
+	lockS3URL = kingpin.Flag(
+		"lock-s3-url",
+		"s3://bucket/prefix under which the lock is NAME.json.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_LOCK_S3_URL",
+	).String()
+	lockName = kingpin.Flag(
+		"lock-name",
+		"Name of the lock, the Autoscaling group of the local instance if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_LOCK_NAME",
+	).String()
+	lockTTL = kingpin.Flag(
+		"lock-ttl",
+		"Longest an instance keeps the lock when the member it added doesn't start.",
+	).Default(
+		"5m",
+	).Envar(
+		"ETCDMATE_LOCK_TTL",
+	).Duration()
+	lockWait = kingpin.Flag(
+		"lock-wait",
+		"Longest a run waits for the lock before failing.",
+	).Default(
+		"2m",
+	).Envar(
+		"ETCDMATE_LOCK_WAIT",
+	).Duration()
+)
+
+// newAddLock returns the lock of --lock-backend, nil for none
+func newAddLock(ctx context.Context, sess *session.Session, svc discovery.AWS, insId string) (lock.Lock, error) {
+	if *lockBackend == "none" {
+		return nil, nil
+	}
+	name := *lockName
+	if name == "" {
+		var err error
+		name, err = svc.GetAsg(ctx, insId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *lockBackend == "s3" {
+		l, err := lock.NewS3(s3.New(sess), *lockS3URL, name)
+		if err != nil {
+			return nil, misconfigured(err)
+		}
+		return l, nil
+	}
+	return lock.DynamoDB{API: dynamodb.New(sess), Table: *lockTable, Name: name}, nil
+}
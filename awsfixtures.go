@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/awsfixture"
+)
+
+var (
+	awsRecord = kingpin.Flag(
+		"aws-record",
+		"Append every AWS call and its response, with account IDs and credentials masked, to this file.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_AWS_RECORD",
+	).String()
+	awsReplay = kingpin.Flag(
+		"aws-replay",
+		"Answer AWS calls with the ones recorded in this file instead of calling AWS.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_AWS_REPLAY",
+	).String()
+)
+
+// useAWSFixtures must run before any client is created from sess
+func useAWSFixtures(sess *session.Session) {
+	if *awsRecord != "" && *awsReplay != "" {
+		log.Fatal("--aws-record and --aws-replay can't be combined")
+	}
+	if *awsRecord != "" {
+		f, err := os.OpenFile(*awsRecord, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		awsfixture.Record(&sess.Handlers, f)
+	}
+	if *awsReplay != "" {
+		calls, err := awsfixture.Load(*awsReplay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		awsfixture.Replay(&sess.Handlers, calls)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/backup"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	backupS3URL = kingpin.Flag(
+		"backup-s3-url",
+		"Upload snapshots under this s3://bucket/prefix, on a schedule in daemon mode.",
+	).Default("").Envar(
+		"ETCDMATE_BACKUP_S3_URL",
+	).String()
+	backupInterval = kingpin.Flag(
+		"backup-interval",
+		"How often to take a snapshot.",
+	).Default(
+		"1h",
+	).Envar(
+		"ETCDMATE_BACKUP_INTERVAL",
+	).Duration()
+	backupMember = kingpin.Flag(
+		"backup-member",
+		"Instance ID of the member taking the snapshots, the leader if empty.",
+	).Default("").Envar(
+		"ETCDMATE_BACKUP_MEMBER",
+	).String()
+	backupKeepDaily = kingpin.Flag(
+		"backup-keep-daily",
+		"Keep the newest snapshot of this many days.",
+	).Default(
+		"7",
+	).Envar(
+		"ETCDMATE_BACKUP_KEEP_DAILY",
+	).Int()
+	backupKeepWeekly = kingpin.Flag(
+		"backup-keep-weekly",
+		"Keep the newest snapshot of this many weeks.",
+	).Default(
+		"4",
+	).Envar(
+		"ETCDMATE_BACKUP_KEEP_WEEKLY",
+	).Int()
+	backupDir = kingpin.Flag(
+		"backup-dir",
+		"Where snapshots are written before the upload.",
+	).Default(
+		os.TempDir(),
+	).Envar(
+		"ETCDMATE_BACKUP_DIR",
+	).String()
+	backupVerify = kingpin.Flag(
+		"backup-verify",
+		"Check every snapshot by restoring it and starting etcd on it.",
+	).Envar(
+		"ETCDMATE_BACKUP_VERIFY",
+	).Bool()
+	backupVerifyTimeout = kingpin.Flag(
+		"backup-verify-timeout",
+		"How long etcd serving a restored snapshot may take to be healthy.",
+	).Default(
+		"2m",
+	).Envar(
+		"ETCDMATE_BACKUP_VERIFY_TIMEOUT",
+	).Duration()
+	backupBeforeRemove = kingpin.Flag(
+		"backup-before-remove",
+		"Upload a snapshot under <--backup-s3-url>/pre-remove before removing any member, keeping the member if it fails.",
+	).Envar(
+		"ETCDMATE_BACKUP_BEFORE_REMOVE",
+	).Bool()
+	backupKeepBeforeRemove = kingpin.Flag(
+		"backup-keep-before-remove",
+		"Keep this many of the newest snapshots taken before removals.",
+	).Default(
+		"10",
+	).Envar(
+		"ETCDMATE_BACKUP_KEEP_BEFORE_REMOVE",
+	).Int()
+	etcdBinary = kingpin.Flag(
+		"etcd",
+		"The etcd binary verifying snapshots and reporting the local etcd version.",
+	).Default(
+		"etcd",
+	).Envar(
+		"ETCDMATE_ETCD",
+	).String()
+	etcdctl = kingpin.Flag(
+		"etcdctl",
+		"The etcdctl binary taking snapshots.",
+	).Default(
+		"etcdctl",
+	).Envar(
+		"ETCDMATE_ETCDCTL",
+	).String()
+)
+
+// startBackups takes scheduled backups in daemon mode
+func startBackups(ctx context.Context, sess *session.Session, cfg reconcile.Config) error {
+	if *backupS3URL == "" || *backupInterval <= 0 {
+		return nil
+	}
+	store, err := backup.NewStore(s3.New(sess), *backupS3URL)
+	if err != nil {
+		return err
+	}
+	schedule := backup.Schedule{
+		Config:      cfg,
+		Snapshotter: snapshotter(),
+		Store:       store,
+		Retention: backup.Retention{
+			Daily:  *backupKeepDaily,
+			Weekly: *backupKeepWeekly,
+		},
+		Interval: *backupInterval,
+		Member:   *backupMember,
+		Dir:      *backupDir,
+	}
+	if *backupVerify {
+		schedule.Verifier = &backup.Verifier{
+			Etcdctl: *etcdctl,
+			Etcd:    *etcdBinary,
+			Dir:     *backupDir,
+			Timeout: *backupVerifyTimeout,
+			Client:  cfg.Client,
+		}
+	}
+	go schedule.Run(ctx)
+	return nil
+}
+
+// newPreRemoval returns nil unless --backup-before-remove is set
+func newPreRemoval(sess *session.Session) (reconcile.SnapshotSink, error) {
+	if !*backupBeforeRemove {
+		return nil, nil
+	}
+	store, err := backup.NewStore(s3.New(sess), *backupS3URL)
+	if err != nil {
+		return nil, err
+	}
+	store.Prefix = path.Join(store.Prefix, backup.PreRemoveDir)
+	return backup.PreRemoval{
+		Snapshotter: snapshotter(),
+		Store:       store,
+		Keep:        *backupKeepBeforeRemove,
+		Dir:         *backupDir,
+	}, nil
+}
+
+func snapshotter() backup.Snapshotter {
+	return backup.Snapshotter{
+		Etcdctl:  *etcdctl,
+		CAFile:   *caFile,
+		CertFile: *certFile,
+		KeyFile:  *keyFile,
+	}
+}
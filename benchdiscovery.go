@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery/bench"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	benchDiscoveryCmd = kingpin.Command(
+		"bench-discovery",
+		"Measure the time and AWS calls discovering the expected members takes, against fake fleets or the local Autoscaling group.",
+	)
+	benchDiscoverySizes = benchDiscoveryCmd.Flag(
+		"sizes",
+		"Comma separated sizes of the fake fleets.",
+	).Default(
+		"3,5,50,250,1000",
+	).String()
+	benchDiscoveryLatency = benchDiscoveryCmd.Flag(
+		"latency",
+		"How long each fake AWS call takes.",
+	).Default(
+		"50ms",
+	).Duration()
+	benchDiscoveryRuns = benchDiscoveryCmd.Flag(
+		"runs",
+		"How many times to discover each fleet.",
+	).Default(
+		"5",
+	).Int()
+	benchDiscoveryReal = benchDiscoveryCmd.Flag(
+		"real",
+		"Discover the Autoscaling group of the local instance instead of fake fleets.",
+	).Bool()
+)
+
+// benchFakeDiscovery runs before anything AWS is set up, fake fleets don't
+// need it
+func benchFakeDiscovery(ctx context.Context) error {
+	for _, field := range strings.Split(*benchDiscoverySizes, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return err
+		}
+		svc, instanceID := bench.Fake(size, *benchDiscoveryLatency)
+		svc.Parallelism = *parallelism
+		result, err := bench.Measure(ctx, svc, instanceID, memberURLs(), *benchDiscoveryRuns)
+		if err != nil {
+			return err
+		}
+		fmt.Println(result)
+	}
+	return nil
+}
+
+func benchRealDiscovery(ctx context.Context, cfg reconcile.Config) error {
+	svc := cfg.AWS
+	svc.Cache = nil
+	result, err := bench.Measure(ctx, svc, cfg.InstanceID, cfg.URLs, *benchDiscoveryRuns)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
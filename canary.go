@@ -0,0 +1,62 @@
+package main
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	canary = kingpin.Flag(
+		"canary",
+		"Join as a learner and soak it: promote it once its lag, disk and peer latency stayed within bounds for --canary-soak, remove it otherwise. Implies --learner-join.",
+	).Envar(
+		"ETCDMATE_CANARY",
+	).Bool()
+	canarySoak = kingpin.Flag(
+		"canary-soak",
+		"How long the checks of a canary must keep passing, and how long it has to catch up first.",
+	).Default(
+		"10m",
+	).Envar(
+		"ETCDMATE_CANARY_SOAK",
+	).Duration()
+	canaryMaxLag = kingpin.Flag(
+		"canary-max-lag",
+		"How many revisions a canary may be behind the cluster.",
+	).Default(
+		"1000",
+	).Envar(
+		"ETCDMATE_CANARY_MAX_LAG",
+	).Int64()
+	canaryMaxFsync = kingpin.Flag(
+		"canary-max-fsync",
+		"Longest a synced write to --data-dir of a canary may take, 0 doesn't check.",
+	).Default(
+		"50ms",
+	).Envar(
+		"ETCDMATE_CANARY_MAX_FSYNC",
+	).Duration()
+	canaryMaxPeerRTT = kingpin.Flag(
+		"canary-max-peer-rtt",
+		"Longest connecting from a canary to the peer port of another member may take, 0 doesn't check.",
+	).Default(
+		"50ms",
+	).Envar(
+		"ETCDMATE_CANARY_MAX_PEER_RTT",
+	).Duration()
+)
+
+// newCanary returns nil unless --canary is set
+func newCanary() *reconcile.Canary {
+	if !*canary {
+		return nil
+	}
+	return &reconcile.Canary{
+		Soak:       *canarySoak,
+		Interval:   *interval,
+		MaxLag:     *canaryMaxLag,
+		MaxFsync:   *canaryMaxFsync,
+		MaxPeerRTT: *canaryMaxPeerRTT,
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/certs"
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/wiretrace"
+)
+
+var (
+	certDir = kingpin.Flag(
+		"cert-dir",
+		"Where to write issued certificates.",
+	).Default(
+		"/etc/etcdmate/tls",
+	).Envar(
+		"ETCDMATE_CERT_DIR",
+	).String()
+	certTTL = kingpin.Flag(
+		"cert-ttl",
+		"Requested lifetime of issued certificates.",
+	).Default(
+		"720h",
+	).Envar(
+		"ETCDMATE_CERT_TTL",
+	).String()
+	cfsslURL = kingpin.Flag(
+		"cfssl-url",
+		"Have this cfssl remote signer sign the member certificate.",
+	).Default("").Envar(
+		"ETCDMATE_CFSSL_URL",
+	).String()
+	cfsslCAFile = kingpin.Flag(
+		"cfssl-ca-file",
+		"CA bundle used to verify the cfssl server.",
+	).Default("").Envar(
+		"ETCDMATE_CFSSL_CA_FILE",
+	).String()
+	cfsslProfile = kingpin.Flag(
+		"cfssl-profile",
+		"cfssl signing profile.",
+	).Default("").Envar(
+		"ETCDMATE_CFSSL_PROFILE",
+	).String()
+	cfsslAuthKey = kingpin.Flag(
+		"cfssl-auth-key",
+		"Hex encoded key of an authenticated cfssl signer.",
+	).Default("").Envar(
+		"ETCDMATE_CFSSL_AUTH_KEY",
+	).String()
+	certRenewInterval = kingpin.Flag(
+		"cert-renew-interval",
+		"Issue the certificate again this often in daemon mode, 0 to disable.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_CERT_RENEW_INTERVAL",
+	).Duration()
+	caParameter = kingpin.Flag(
+		"ca-parameter",
+		"Sign the member certificate with a cluster CA kept in this SSM parameter, created if missing.",
+	).Default("").Envar(
+		"ETCDMATE_CA_PARAMETER",
+	).String()
+	caParameterKMSKey = kingpin.Flag(
+		"ca-parameter-kms-key",
+		"KMS key encrypting the cluster CA parameter.",
+	).Default("").Envar(
+		"ETCDMATE_CA_PARAMETER_KMS_KEY",
+	).String()
+	spireSocket = kingpin.Flag(
+		"spire-socket",
+		"Use the X.509 SVID of the SPIRE agent listening on this socket.",
+	).Default("").Envar(
+		"ETCDMATE_SPIRE_SOCKET",
+	).String()
+	spireAgent = kingpin.Flag(
+		"spire-agent",
+		"The spire-agent binary.",
+	).Default(
+		"spire-agent",
+	).Envar(
+		"ETCDMATE_SPIRE_AGENT",
+	).String()
+	vaultAddr = kingpin.Flag(
+		"vault-addr",
+		"Issue the member certificate from this Vault server.",
+	).Default("").Envar(
+		"ETCDMATE_VAULT_ADDR",
+	).String()
+	vaultCAFile = kingpin.Flag(
+		"vault-ca-file",
+		"CA bundle used to verify the Vault server.",
+	).Default("").Envar(
+		"ETCDMATE_VAULT_CA_FILE",
+	).String()
+	vaultAuthMount = kingpin.Flag(
+		"vault-auth-mount",
+		"Path of the Vault AWS auth method.",
+	).Default(
+		"auth/aws",
+	).Envar(
+		"ETCDMATE_VAULT_AUTH_MOUNT",
+	).String()
+	vaultAuthRole = kingpin.Flag(
+		"vault-auth-role",
+		"Vault AWS auth role to log in with.",
+	).Default(
+		"etcd",
+	).Envar(
+		"ETCDMATE_VAULT_AUTH_ROLE",
+	).String()
+	vaultServerID = kingpin.Flag(
+		"vault-server-id",
+		"Value of the X-Vault-AWS-IAM-Server-ID header, if Vault requires one.",
+	).Default("").Envar(
+		"ETCDMATE_VAULT_SERVER_ID",
+	).String()
+	vaultPKIMount = kingpin.Flag(
+		"vault-pki-mount",
+		"Path of the Vault PKI secrets engine.",
+	).Default(
+		"pki",
+	).Envar(
+		"ETCDMATE_VAULT_PKI_MOUNT",
+	).String()
+	vaultPKIRole = kingpin.Flag(
+		"vault-pki-role",
+		"Vault PKI role used to issue the certificate.",
+	).Default(
+		"etcd",
+	).Envar(
+		"ETCDMATE_VAULT_PKI_ROLE",
+	).String()
+)
+
+// CertIssuer issues the local member certificate
+type CertIssuer struct {
+	issuer certs.Issuer
+	req    certs.Request
+}
+
+// NewCertIssuer returns nil when no issuer is configured
+func NewCertIssuer(
+	ctx context.Context,
+	sess *session.Session,
+	metadataSvc *ec2metadata.EC2Metadata,
+	metadata ec2metadata.EC2InstanceIdentityDocument,
+) (*CertIssuer, error) {
+	var issuer certs.Issuer
+	switch {
+	case *vaultAddr != "":
+		client, err := issuerClient(*vaultCAFile)
+		if err != nil {
+			return nil, err
+		}
+		issuer = certs.Vault{
+			Address:    *vaultAddr,
+			AuthMount:  *vaultAuthMount,
+			AuthRole:   *vaultAuthRole,
+			PKIMount:   *vaultPKIMount,
+			PKIRole:    *vaultPKIRole,
+			ServerID:   *vaultServerID,
+			Session:    sess,
+			HTTPClient: client,
+		}
+	case *cfsslURL != "":
+		client, err := issuerClient(*cfsslCAFile)
+		if err != nil {
+			return nil, err
+		}
+		issuer = certs.CFSSL{
+			URL:        *cfsslURL,
+			Profile:    *cfsslProfile,
+			AuthKey:    *cfsslAuthKey,
+			HTTPClient: client,
+		}
+	case *caParameter != "":
+		issuer = certs.ClusterCA{
+			SSM:       ssm.New(sess),
+			Parameter: *caParameter,
+			KMSKeyID:  *caParameterKMSKey,
+		}
+	case *spireSocket != "":
+		issuer = certs.SPIRE{
+			AgentPath:  *spireAgent,
+			SocketPath: *spireSocket,
+		}
+	default:
+		return nil, nil
+	}
+	req := certs.Request{
+		CommonName: metadata.InstanceID,
+		IPs:        []string{metadata.PrivateIP, "127.0.0.1"},
+		DNSNames:   []string{"localhost"},
+		TTL:        *certTTL,
+	}
+	if hostname, err := metadataSvc.GetMetadataWithContext(ctx, "local-hostname"); err == nil {
+		req.DNSNames = append(req.DNSNames, hostname)
+	}
+	// Members of other regions may reach this one at its public address
+	if ip, err := metadataSvc.GetMetadataWithContext(ctx, "public-ipv4"); err == nil && ip != "" {
+		req.IPs = append(req.IPs, ip)
+	}
+	if hostname, err := metadataSvc.GetMetadataWithContext(ctx, "public-hostname"); err == nil && hostname != "" {
+		req.DNSNames = append(req.DNSNames, hostname)
+	}
+	if *clientURLTemplate != "" || *peerURLTemplate != "" || len(*advertiseSubnets) > 0 || *advertiseInterface != "" {
+		hosts, err := memberHosts(ctx, sess, metadata.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if net.ParseIP(host) != nil {
+				req.IPs = append(req.IPs, host)
+			} else {
+				req.DNSNames = append(req.DNSNames, host)
+			}
+		}
+	}
+	return &CertIssuer{issuer: issuer, req: req}, nil
+}
+
+// Issue writes a new certificate to --cert-dir
+func (c *CertIssuer) Issue(ctx context.Context) (certs.Files, error) {
+	ca, cert, key, err := c.issuer.Issue(ctx, c.req)
+	if err != nil {
+		return certs.Files{}, err
+	}
+	return certs.WriteFiles(*certDir, "etcd", ca, cert, key)
+}
+
+// Renew issues a new certificate every interval until ctx is done, the TLS
+// reload picks the new files up
+func (c *CertIssuer) Renew(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := c.Issue(ctx)
+		if err != nil {
+			log.Println("Renewing certificate failed:", err)
+		} else {
+			log.Println("Renewed certificate")
+		}
+	}
+}
+
+// memberHosts returns the hosts of the URLs built by the URL templates for
+// the local member, the certificate must be valid for them
+func memberHosts(ctx context.Context, sess *session.Session, instanceID string) ([]string, error) {
+	instances, err := discovery.NewAWS(sess, log.Default()).GetEC2Instances(ctx, []*string{&instanceID})
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, errors.New(fmt.Sprint("Instance ", instanceID, " not found"))
+	}
+	m := memberURLs().Member(instances[0])
+	hosts := []string{}
+	for _, memberURL := range append(m.AllClientURLs(), m.AllPeerURLs()...) {
+		u, err := url.Parse(memberURL)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts, nil
+}
+
+func issuerClient(caFile string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	if traceRequests() {
+		client = wiretrace.Client(client, traceLogger())
+	}
+	return client, nil
+}
+
+func useCerts(files certs.Files) {
+	if *caFile == "" && *certFile == "" {
+		*caFile, *certFile, *keyFile = files.CAFile, files.CertFile, files.KeyFile
+	}
+	if *peerCAFile == "" && *peerCertFile == "" {
+		*peerCAFile, *peerCertFile, *peerKeyFile = files.CAFile, files.CertFile, files.KeyFile
+	}
+}
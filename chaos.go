@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/chaos"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var chaosSpec = kingpin.Flag(
+	"chaos",
+	"Inject failures for testing, e.g. aws-throttle=0.2,etcd-timeout=0.1,partial-members=0.3,crash=member-added,seed=42.",
+).Hidden().Default(
+	"",
+).Envar(
+	"ETCDMATE_CHAOS",
+).String()
+
+// newChaos returns nil unless --chaos is set
+func newChaos() *chaos.Chaos {
+	if *chaosSpec == "" {
+		return nil
+	}
+	c, err := chaos.Parse(*chaosSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Injecting failures:", *chaosSpec)
+	return c
+}
+
+// chaosCrash exits the process right after the event --chaos crashes on,
+// the way a killed instance would leave the work half done
+func chaosCrash(c *chaos.Chaos, next reconcile.EventHandler) reconcile.EventHandler {
+	if c == nil || c.Crash == "" {
+		return next
+	}
+	return func(e reconcile.Event) {
+		if next != nil {
+			next(e)
+		}
+		if c.Crashes(string(e.Type)) {
+			log.Println("Crashing after", e.Type, "event")
+			os.Exit(3)
+		}
+	}
+}
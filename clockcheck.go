@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/clock"
+)
+
+var (
+	clockCheck = kingpin.Flag(
+		"clock-check",
+		"Compare the local clock with --ntp-server before joining: off, warn or fail.",
+	).Default(
+		"warn",
+	).Envar(
+		"ETCDMATE_CLOCK_CHECK",
+	).Enum("off", "warn", "fail")
+	clockMaxSkew = kingpin.Flag(
+		"clock-max-skew",
+		"Largest acceptable difference between the local clock and --ntp-server.",
+	).Default(
+		"500ms",
+	).Envar(
+		"ETCDMATE_CLOCK_MAX_SKEW",
+	).Duration()
+	ntpServer = kingpin.Flag(
+		"ntp-server",
+		"NTP server the local clock is compared with.",
+	).Default(
+		clock.AmazonTimeSync,
+	).Envar(
+		"ETCDMATE_NTP_SERVER",
+	).String()
+)
+
+// checkClock only fails with --clock-check fail and a skew larger than
+// --clock-max-skew, not when the server can't be asked
+func checkClock(ctx context.Context) error {
+	if *clockCheck == "off" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, *dialTimeout)
+	defer cancel()
+	offset, err := clock.Offset(ctx, *ntpServer)
+	if err != nil {
+		log.Println("Not checking the clock:", err)
+		return nil
+	}
+	skew, direction := offset, "behind"
+	if skew < 0 {
+		skew, direction = -skew, "ahead of"
+	}
+	if skew <= *clockMaxSkew {
+		return nil
+	}
+	err = errors.New(fmt.Sprint(
+		"Local clock is ", skew.Round(time.Millisecond), " ", direction, " ", *ntpServer,
+		", check chrony or ntpd is running and synchronized",
+	))
+	if *clockCheck == "fail" {
+		return err
+	}
+	log.Println("Warning:", err)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+)
+
+var (
+	cloud = kingpin.Flag(
+		"cloud",
+		"Where the instances run: aws, gcp to take the identity from the Compute Engine metadata server and discover the members from the managed instance group of the local instance, or azure for the Instance Metadata Service and the scale set of the local VM.",
+	).Default(
+		"aws",
+	).Envar(
+		"ETCDMATE_CLOUD",
+	).Enum("aws", "gcp", "azure")
+)
+
+// gcpIdentity returns the identity of the local Compute Engine instance in
+// the shape of an EC2 one: its name as instance ID, its project as account
+func gcpIdentity(ctx context.Context) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	id, err := discovery.GCP{Logger: log.Default()}.Identity(ctx)
+	if err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	log.Printf("Local identity: %+v\n", id)
+	return ec2metadata.EC2InstanceIdentityDocument{
+		InstanceID:       id.Name,
+		PrivateIP:        id.PrivateIP,
+		AvailabilityZone: id.Zone,
+		Region:           id.Region(),
+		AccountID:        id.Project,
+	}, nil
+}
+
+// azureIdentity returns the identity of the local Azure VM in the shape of
+// an EC2 one: its name as instance ID, its subscription as account
+func azureIdentity(ctx context.Context) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	id, err := discovery.Azure{Logger: log.Default()}.Identity(ctx)
+	if err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	log.Printf("Local identity: %+v\n", id)
+	return ec2metadata.EC2InstanceIdentityDocument{
+		InstanceID:       id.Name,
+		PrivateIP:        id.PrivateIP,
+		AvailabilityZone: id.AvailabilityZone(),
+		Region:           id.Location,
+		AccountID:        id.Subscription,
+	}, nil
+}
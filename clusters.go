@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var clustersFile = kingpin.Flag(
+	"clusters-file",
+	"JSON file listing several clusters for join to manage in one run, see the README.",
+).Default(
+	"",
+).Envar(
+	"ETCDMATE_CLUSTERS_FILE",
+).String()
+
+// clustersTag on the Autoscaling group lists the clusters its instances
+// run, comma separated. All clusters of the file when missing.
+const clustersTag = "etcdmate:clusters"
+
+// ClusterSpec is a cluster of --clusters-file, the fields left empty take
+// the value of the matching flag
+type ClusterSpec struct {
+	Name         string
+	ClientSchema string
+	ClientPort   int
+	PeerSchema   string
+	PeerPort     int
+	EnvFile      string
+	StateFile    string
+	RestartUnit  string
+}
+
+func loadClusters(file string) ([]ClusterSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var specs []ClusterSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, err
+	}
+	// Clusters sharing a file would overwrite each other
+	seen := map[string]string{}
+	for _, spec := range specs {
+		if spec.Name == "" || spec.EnvFile == "" || spec.StateFile == "" {
+			return nil, errors.New(fmt.Sprint("Clusters need a Name, EnvFile and StateFile in ", file))
+		}
+		for _, f := range []string{spec.Name, spec.EnvFile, spec.StateFile} {
+			if other, ok := seen[f]; ok {
+				return nil, errors.New(fmt.Sprintf("Clusters %s and %s both use %s", other, spec.Name, f))
+			}
+			seen[f] = spec.Name
+		}
+	}
+	return specs, nil
+}
+
+// selectClusters keeps the clusters listed by the clustersTag of the
+// instance Autoscaling group
+func selectClusters(ctx context.Context, cfg reconcile.Config, specs []ClusterSpec) ([]ClusterSpec, error) {
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	value, ok, err := cfg.AWS.GetAsgTag(ctx, asgName, clustersTag)
+	if err != nil || !ok {
+		return specs, err
+	}
+	selected := []ClusterSpec{}
+	for _, name := range strings.Split(value, ",") {
+		found := false
+		for _, spec := range specs {
+			if spec.Name == strings.TrimSpace(name) {
+				selected = append(selected, spec)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New(fmt.Sprint("Cluster ", name, " of tag ", clustersTag, " not in ", *clustersFile))
+		}
+	}
+	return selected, nil
+}
+
+func (spec ClusterSpec) config(cfg reconcile.Config) reconcile.Config {
+	if spec.ClientSchema != "" {
+		cfg.URLs.ClientSchema = spec.ClientSchema
+	}
+	if spec.ClientPort != 0 {
+		cfg.URLs.ClientPort = spec.ClientPort
+	}
+	if spec.PeerSchema != "" {
+		cfg.URLs.PeerSchema = spec.PeerSchema
+	}
+	if spec.PeerPort != 0 {
+		cfg.URLs.PeerPort = spec.PeerPort
+	}
+	cfg.EnvFile = spec.EnvFile
+	cfg.StateFile = spec.StateFile
+	cfg.Logger = log.New(os.Stderr, fmt.Sprint("[", spec.Name, "] "), log.LstdFlags)
+	// Every cluster has its own budget of changes
+	cfg.Changes = newChangeLimiter()
+	return cfg
+}
+
+func (spec ClusterSpec) unit() string {
+	if spec.RestartUnit != "" {
+		return spec.RestartUnit
+	}
+	return *restartUnit
+}
+
+// joinClusters runs join for every selected cluster of --clusters-file,
+// one after the other or, as a daemon, side by side
+func joinClusters(ctx context.Context, cfg reconcile.Config, certIssuer *CertIssuer) error {
+	specs, err := loadClusters(*clustersFile)
+	if err != nil {
+		return err
+	}
+	specs, err = selectClusters(ctx, cfg, specs)
+	if err != nil {
+		return err
+	}
+	if !*daemon {
+		for _, spec := range specs {
+			log.Println("Joining cluster", spec.Name)
+			err := join(ctx, spec.config(cfg), certIssuer, spec.unit(), nil, nil, nil)
+			if err != nil {
+				return fmt.Errorf("Cluster %s: %w", spec.Name, err)
+			}
+		}
+		return nil
+	}
+	if *controlSocket != "" || *healthAddr != "" {
+		return errors.New("The control API and health checks serve a single cluster, they can't be used with --clusters-file")
+	}
+	cfg = withDiscoveryCache(cfg)
+	startRenewals(ctx, cfg, certIssuer)
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func(i int, spec ClusterSpec) {
+			defer wg.Done()
+			errs[i] = reconcile.Supervise(ctx, spec.config(cfg), superviseOptions(spec.unit()))
+		}(i, spec)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("Cluster %s: %w", specs[i].Name, err)
+		}
+	}
+	log.Println("Stopping")
+	return nil
+}
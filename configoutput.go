@@ -0,0 +1,95 @@
+package main
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/output"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	configOutput = kingpin.Flag(
+		"config-output",
+		"Where the etcd configuration goes: the env file, the user data of a host container through the Bottlerocket API, or the etcd arguments of the Talos machine configuration.",
+	).Default(
+		"file",
+	).Envar(
+		"ETCDMATE_CONFIG_OUTPUT",
+	).Enum("file", "bottlerocket", "talos")
+	outputFormat = kingpin.Flag(
+		"output-format",
+		"Format of --env-file: env lines, a systemd drop-in with Environment= lines, an etcd configuration file for --config-file, or none to print the env lines to stdout instead.",
+	).Default(
+		"envfile",
+	).Envar(
+		"ETCDMATE_OUTPUT_FORMAT",
+	).Enum("envfile", "systemd", "etcd-yaml", "none")
+	templateFile = kingpin.Flag(
+		"template-file",
+		"Go text/template rendering the content of --env-file instead of the etcd variables, see the README for its data.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TEMPLATE_FILE",
+	).String()
+	etcdYAMLBase = kingpin.Flag(
+		"etcd-yaml-base",
+		"YAML file starting the etcd configuration file of --output-format etcd-yaml, for the settings etcdmate doesn't write such as the client certificates.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ETCD_YAML_BASE",
+	).String()
+	bottlerocketSocket = kingpin.Flag(
+		"bottlerocket-api-socket",
+		"Socket of the Bottlerocket API, with --config-output bottlerocket.",
+	).Default(
+		"/run/api.sock",
+	).Envar(
+		"ETCDMATE_BOTTLEROCKET_API_SOCKET",
+	).String()
+	bottlerocketHostContainer = kingpin.Flag(
+		"bottlerocket-host-container",
+		"Host container running etcd, which gets the configuration as its user data.",
+	).Default(
+		"etcd",
+	).Envar(
+		"ETCDMATE_BOTTLEROCKET_HOST_CONTAINER",
+	).String()
+	talosctl = kingpin.Flag(
+		"talosctl",
+		"talosctl binary, with --config-output talos.",
+	).Default(
+		"talosctl",
+	).Envar(
+		"ETCDMATE_TALOSCTL",
+	).String()
+	talosNode = kingpin.Flag(
+		"talos-node",
+		"Node whose machine configuration talosctl patches, that of the talosconfig context if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TALOS_NODE",
+	).String()
+	talosconfig = kingpin.Flag(
+		"talosconfig",
+		"talosconfig file for talosctl.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TALOSCONFIG",
+	).String()
+)
+
+// newConfigOutput returns the --config-output other than the env file, nil
+// for the env file
+func newConfigOutput() reconcile.Output {
+	switch *configOutput {
+	case "bottlerocket":
+		return output.Bottlerocket{Socket: *bottlerocketSocket, HostContainer: *bottlerocketHostContainer}
+	case "talos":
+		return output.Talos{Talosctl: *talosctl, Node: *talosNode, Talosconfig: *talosconfig}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// yamlConfigFile tells whether file is read as YAML rather than VAR=value
+// lines
+func yamlConfigFile(file string) bool {
+	switch path.Ext(file) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// settingEnvar is the variable of the setting name, e.g. ETCDMATE_RESTART_UNIT
+// for restart-unit
+func settingEnvar(name string) string {
+	return "ETCDMATE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readYAMLConfigFile parses the settings of a YAML file, keyed by the flag
+// names, into their variables. Only a flat mapping is supported: scalars,
+// and lists either as [a, b] or as - items on the lines below the key.
+//
+//	interval: 1m
+//	remove-stale: true
+//	member:
+//	  - etcd-1=10.0.1.10
+//	  - etcd-2=10.0.2.10
+func readYAMLConfigFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars := map[string]string{}
+	// list is the variable the - items below go to
+	list := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		raw := strings.TrimRight(scanner.Text(), " \t")
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if list == "" {
+				return nil, errors.New(fmt.Sprintf("%s:%d: list item without a setting", file, n))
+			}
+			item := yamlScalar(strings.TrimSpace(strings.TrimPrefix(line, "-")))
+			if vars[list] != "" {
+				item = vars[list] + "," + item
+			}
+			vars[list] = item
+			continue
+		}
+		if raw[0] == ' ' || raw[0] == '\t' {
+			return nil, errors.New(fmt.Sprintf("%s:%d: only top-level settings are supported", file, n))
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected setting: value", file, n))
+		}
+		name := settingEnvar(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		list = ""
+		switch {
+		case value == "":
+			list = name
+			vars[name] = ""
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, errors.New(fmt.Sprintf("%s:%d: lists must end on the same line", file, n))
+			}
+			items := []string{}
+			for _, item := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"), ",") {
+				if item = yamlScalar(strings.TrimSpace(item)); item != "" {
+					items = append(items, item)
+				}
+			}
+			vars[name] = strings.Join(items, ",")
+		default:
+			vars[name] = yamlScalar(value)
+		}
+	}
+	return vars, scanner.Err()
+}
+
+// yamlScalar unquotes value, or drops its trailing comment
+func yamlScalar(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	versionCheck = kingpin.Flag(
+		"version-check",
+		"Compare the local etcd version with the cluster version before joining: off, warn or fail.",
+	).Default(
+		"warn",
+	).Envar(
+		"ETCDMATE_VERSION_CHECK",
+	).Enum("off", "warn", "fail")
+	etcdVersion = kingpin.Flag(
+		"etcd-version",
+		"Version of the local etcd, by default asked to the --etcd binary.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ETCD_VERSION",
+	).String()
+)
+
+// localEtcdVersion parses the "etcd Version: 3.5.15" line etcd --version
+// prints
+func localEtcdVersion() (string, error) {
+	if *etcdVersion != "" {
+		return *etcdVersion, nil
+	}
+	out, err := exec.Command(*etcdBinary, "--version").Output()
+	if err != nil {
+		return "", errors.New(fmt.Sprint("Running ", *etcdBinary, " --version failed: ", err))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "etcd Version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "etcd Version:")), nil
+		}
+	}
+	return "", errors.New(fmt.Sprint("No version in the output of ", *etcdBinary, " --version"))
+}
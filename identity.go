@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/wiretrace"
+)
+
+var (
+	identitySource = kingpin.Flag(
+		"identity",
+		"Where the local identity comes from: metadata, the EC2 metadata service, local, the hostname and --identity-interface, auto, local when there is no metadata service, or nomad, the allocation of the task with --nomad-job.",
+	).Default(
+		"metadata",
+	).Envar(
+		"ETCDMATE_IDENTITY",
+	).Enum("metadata", "local", "auto", "nomad")
+	imdsVersion = kingpin.Flag(
+		"imds-version",
+		"EC2 metadata service version: auto, IMDSv2 falling back to IMDSv1 when no session token can be had, or 2 never to fall back.",
+	).Default(
+		"auto",
+	).Envar(
+		"ETCDMATE_IMDS_VERSION",
+	).Enum("auto", "2")
+	identityInterface = kingpin.Flag(
+		"identity-interface",
+		"Network interface whose address is the local one without metadata, the first one up if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_IDENTITY_INTERFACE",
+	).String()
+)
+
+// newMetadata returns the metadata client of sess. The SDK fetches an
+// IMDSv2 session token and reuses it until it expires, falling back to
+// IMDSv1 as --imds-version allows, see awsConfig. Traced requests keep the
+// short timeout the SDK only gives its default client, or a token response
+// dropped by the hop limit would hang them. The metadata service keeps the
+// 2 quick retries of the SDK rather than --retries, so runs off EC2 fall
+// back to the host identity fast.
+func newMetadata(sess *session.Session) *ec2metadata.EC2Metadata {
+	cfg := request.WithRetryer(aws.NewConfig(), client.DefaultRetryer{NumMaxRetries: 2})
+	if traceRequests() {
+		cfg.HTTPClient = wiretrace.Client(&http.Client{Timeout: time.Second}, traceLogger())
+	}
+	return ec2metadata.New(sess, cfg)
+}
+
+// localIdentity returns the instance identity document, or one derived
+// from the host when --identity allows it. The region of a derived one is
+// that of the session, e.g. from AWS_REGION. With --identity nomad the
+// instance ID is the allocation of the task, with --cloud gcp or azure the
+// identity comes from the metadata service of that cloud.
+func localIdentity(
+	ctx context.Context,
+	metadataSvc *ec2metadata.EC2Metadata,
+	sess *session.Session,
+) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	switch *cloud {
+	case "gcp":
+		return gcpIdentity(ctx)
+	case "azure":
+		return azureIdentity(ctx)
+	}
+	if *identitySource == "metadata" || (*identitySource == "auto" && metadataSvc.AvailableWithContext(ctx)) {
+		return discovery.GetMetadata(ctx, metadataSvc, log.Default())
+	}
+	id, err := discovery.LocalIdentity(*identityInterface)
+	if err != nil {
+		return id, err
+	}
+	id.Region = aws.StringValue(sess.Config.Region)
+	if *identitySource == "nomad" {
+		// Nomad runs etcd anywhere, AWS may not be used at all
+		id.InstanceID = os.Getenv("NOMAD_ALLOC_ID")
+		if id.InstanceID == "" {
+			return id, errors.New("NOMAD_ALLOC_ID isn't set, --identity nomad only works in a Nomad task")
+		}
+		log.Printf("Local identity: %+v\n", id)
+		return id, nil
+	}
+	if id.Region == "" {
+		return id, errors.New("Without metadata the region must be configured, e.g. with AWS_REGION")
+	}
+	log.Printf("Local identity: %+v\n", id)
+	return id, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/output"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	ignitionUnit = kingpin.Flag(
+		"ignition-unit",
+		"Unit of the drop-in printed by --plan-format ignition or butane.",
+	).Default(
+		"etcd-member.service",
+	).Envar(
+		"ETCDMATE_IGNITION_UNIT",
+	).String()
+	ignitionDropIn = kingpin.Flag(
+		"ignition-dropin",
+		"Name of the drop-in printed by --plan-format ignition or butane.",
+	).Default(
+		"20-etcdmate.conf",
+	).Envar(
+		"ETCDMATE_IGNITION_DROPIN",
+	).String()
+)
+
+// printProvisioning prints the env file of plan as a drop-in of an Ignition
+// or Butane config, for provisioning pipelines to feed to Flatcar
+func printProvisioning(w io.Writer, plan reconcile.Plan, format string) error {
+	dropIn := output.SystemdDropIn{Unit: *ignitionUnit, Name: *ignitionDropIn}
+	if format == "butane" {
+		_, err := fmt.Fprint(w, dropIn.RenderButane(plan.Config))
+		return err
+	}
+	config, err := dropIn.RenderIgnition(plan.Config)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, config)
+	return err
+}
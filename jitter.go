@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// DurationRange is a kingpin value accepting either "MAX" or "MIN-MAX",
+// e.g. "30s" or "0-30s". A bare number takes the unit of the upper bound.
+type DurationRange struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (r *DurationRange) Set(value string) error {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 1 {
+		max, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return err
+		}
+		r.Min, r.Max = 0, max
+		return nil
+	}
+	max, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return err
+	}
+	min, err := time.ParseDuration(parts[0])
+	if err != nil {
+		// Allow "0-30s" style ranges
+		unit := strings.TrimLeft(parts[1], "0123456789.")
+		min, err = time.ParseDuration(parts[0] + unit)
+		if err != nil {
+			return err
+		}
+	}
+	if min < 0 || max < min {
+		return errors.New(fmt.Sprint("Invalid duration range ", value))
+	}
+	r.Min, r.Max = min, max
+	return nil
+}
+
+func (r *DurationRange) String() string {
+	if r.Min == 0 {
+		return r.Max.String()
+	}
+	return fmt.Sprintf("%s-%s", r.Min, r.Max)
+}
+
+// Random returns a random duration within the range drawn from rnd
+func (r *DurationRange) Random(rnd *rand.Rand) time.Duration {
+	if r.Max <= r.Min {
+		return r.Min
+	}
+	return r.Min + time.Duration(rnd.Int63n(int64(r.Max-r.Min)))
+}
+
+func DurationRangeFlag(s kingpin.Settings) *DurationRange {
+	r := &DurationRange{}
+	s.SetValue(r)
+	return r
+}
+
+func Jitter(r *DurationRange) {
+	if r.Max == 0 {
+		return
+	}
+	delay := r.Random(rand.New(rand.NewSource(time.Now().UnixNano())))
+	log.Printf("Sleeping %s of startup jitter\n", delay)
+	time.Sleep(delay)
+}
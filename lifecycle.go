@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	lifecycleCmd = kingpin.Command(
+		"lifecycle",
+		"Remove the members of the instances the Autoscaling group scales in, then complete their termination lifecycle action, until interrupted.",
+	)
+	lifecycleQueue = lifecycleCmd.Flag(
+		"queue-url",
+		"SQS queue the termination lifecycle hook notifies, the group is polled every --interval for the instances waiting on --termination-hook otherwise.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_LIFECYCLE_QUEUE_URL",
+	).String()
+)
+
+func runLifecycle(ctx context.Context, sess *session.Session, cfg reconcile.Config) error {
+	cfg.Changes = newChangeLimiter()
+	return reconcile.WatchLifecycle(ctx, cfg, reconcile.LifecycleOptions{
+		Hook:     *terminationHook,
+		Queue:    *lifecycleQueue,
+		SQS:      sqs.New(sess),
+		Interval: *interval,
+		Lock:     lockRun,
+	})
+}
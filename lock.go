@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// Lock takes an exclusive flock on file, waiting up to timeout for another
+// run to release it. The lock is held until the returned file is closed or
+// the process exits.
+func Lock(file string, timeout time.Duration) (*os.File, error) {
+	err := os.MkdirAll(path.Dir(file), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			log.Println("Acquired lock", file)
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, errors.New(fmt.Sprint(
+				"Timed out waiting for lock ", file,
+			))
+		}
+		log.Println("Waiting for another etcdmate run to release", file)
+		time.Sleep(time.Second)
+	}
+}
+
+// lockRun takes --lock-file and returns the function releasing it. Dry runs
+// change nothing, not even the lock file.
+func lockRun() (func(), error) {
+	if *dryRun {
+		return func() {}, nil
+	}
+	f, err := Lock(*lockFile, *lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// lockedCommand is whether command changes the cluster or the local
+// configuration, and holds --lock-file for its whole run. Daemons only hold
+// it for their passes, the read-only commands not at all.
+func lockedCommand(command string) bool {
+	switch command {
+	case joinCmd.FullCommand():
+		return !*daemon
+	case scaleDownCmd.FullCommand(),
+		bootstrapCmd.FullCommand(),
+		rolloutCmd.FullCommand(),
+		replaceMemberCmd.FullCommand(),
+		promoteCmd.FullCommand(),
+		restoreCmd.FullCommand(),
+		leaveCmd.FullCommand(),
+		migrateCmd.FullCommand(),
+		rotateCertsCmd.FullCommand():
+		return true
+	}
+	return false
+}
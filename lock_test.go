@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	file := path.Join(t.TempDir(), "etcdmate.lock")
+	held, err := Lock(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(file, 0); err == nil {
+		t.Fatal("locked a file another run holds")
+	}
+	held.Close()
+	f, err := Lock(file, 0)
+	if err != nil {
+		t.Fatalf("got %v, want the released lock", err)
+	}
+	f.Close()
+}
+
+func TestLockedCommand(t *testing.T) {
+	defer func(d bool) { *daemon = d }(*daemon)
+	for _, tc := range []struct {
+		command string
+		daemon  bool
+		locked  bool
+	}{
+		{command: joinCmd.FullCommand(), locked: true},
+		{command: joinCmd.FullCommand(), daemon: true, locked: false},
+		{command: scaleDownCmd.FullCommand(), locked: true},
+		{command: replaceMemberCmd.FullCommand(), locked: true},
+		{command: rotateCertsCmd.FullCommand(), locked: true},
+		{command: verifyCmd.FullCommand(), locked: false},
+	} {
+		*daemon = tc.daemon
+		if got := lockedCommand(tc.command); got != tc.locked {
+			t.Errorf("got %s locked %t with daemon %t, want %t", tc.command, got, tc.daemon, tc.locked)
+		}
+	}
+}
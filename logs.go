@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	logLevel = kingpin.Flag(
+		"log-level",
+		"Least level logged: debug, info, warn or error. debug also traces the requests as --trace-http does.",
+	).Default(
+		"info",
+	).Envar(
+		"ETCDMATE_LOG_LEVEL",
+	).Enum("debug", "info", "warn", "error")
+	logFormat = kingpin.Flag(
+		"log-format",
+		"The log format: text, or json with an object per line.",
+	).Default(
+		"text",
+	).Envar(
+		"ETCDMATE_LOG_FORMAT",
+	).Enum("text", "json")
+)
+
+// logs takes the entries of the standard logger once setupLogging ran
+var logs = &logging.Structured{Out: os.Stderr, Level: logging.LevelInfo}
+
+// setupLogging routes the standard logger, which every component logs to,
+// through logs with --log-level and --log-format
+func setupLogging() {
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		exit(misconfigured(err))
+	}
+	logs.Level = level
+	logs.JSON = *logFormat == "json"
+	log.SetFlags(0)
+	log.SetOutput(logs)
+}
+
+// traceRequests tells whether requests are traced, with --trace-http or at
+// the debug level
+func traceRequests() bool {
+	return *traceHTTP || logs.Level == logging.LevelDebug
+}
+
+// traceLogger logs the traced requests, at the debug level unless
+// --trace-http asked for them
+func traceLogger() logging.Logger {
+	if *traceHTTP {
+		return log.Default()
+	}
+	return logs.At(logging.LevelDebug)
+}
+
+// errorLogger logs at the error level
+func errorLogger() logging.Logger {
+	return logs.At(logging.LevelError)
+}
+
+// logAsg adds the Autoscaling group of the instance to every entry, when
+// the members are discovered from it
+func logAsg(ctx context.Context, svc discovery.AWS, source discovery.Source, instanceID string) {
+	if source != nil || proxying() {
+		return
+	}
+	if asgName, err := svc.GetAsg(ctx, instanceID); err == nil {
+		logs.With("asg", asgName)
+	}
+}
+
+// eventLevels are the levels of the events worth more than info
+var eventLevels = map[reconcile.EventType]logging.Level{
+	reconcile.EventReconcileError: logging.LevelError,
+	reconcile.EventQuorumLost:     logging.LevelWarn,
+	reconcile.EventRemovalRefused: logging.LevelWarn,
+	reconcile.EventPeerURLDrift:   logging.LevelWarn,
+}
+
+// logEvents logs every event as an entry with the action and the member,
+// for log pipelines to extract
+func logEvents(next reconcile.EventHandler) reconcile.EventHandler {
+	return func(e reconcile.Event) {
+		level, ok := eventLevels[e.Type]
+		if !ok {
+			level = logging.LevelInfo
+		}
+		msg := "Event " + string(e.Type)
+		if e.Message != "" {
+			msg += ": " + e.Message
+		}
+		logs.Log(level, msg, map[string]string{
+			"action":    string(e.Type),
+			"member":    e.Member.Name,
+			"member_id": e.Member.ID,
+		})
+		if next != nil {
+			next(e)
+		}
+	}
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"gopkg.in/alecthomas/kingpin.v2"
 
-	"github.com/viruxel/etcdmate/etcdclient"
+	"github.com/viruxel/etcdmate/pkg/certs"
+	"github.com/viruxel/etcdmate/pkg/control"
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+	"github.com/viruxel/etcdmate/pkg/seal"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+	"github.com/viruxel/etcdmate/pkg/wiretrace"
 )
 
 var (
@@ -36,6 +48,62 @@ var (
 	).Envar(
 		"ETCDMATE_TIMEOUT",
 	).Duration()
+	dialTimeout = kingpin.Flag(
+		"dial-timeout",
+		"Timeout connecting to etcd members, including the TLS handshake.",
+	).Default(
+		"2s",
+	).Envar(
+		"ETCDMATE_DIAL_TIMEOUT",
+	).Duration()
+	retries = kingpin.Flag(
+		"retries",
+		"Times an etcd request left unanswered or a failed AWS call is retried.",
+	).Default(
+		"3",
+	).Envar(
+		"ETCDMATE_RETRIES",
+	).Int()
+	retryBackoff = kingpin.Flag(
+		"retry-backoff",
+		"Delay before the first retry, doubled for every next one and jittered.",
+	).Default(
+		"500ms",
+	).Envar(
+		"ETCDMATE_RETRY_BACKOFF",
+	).Duration()
+	etcdAPIVersion = kingpin.Flag(
+		"etcd-api-version",
+		"Membership API: 3 through the gRPC gateway, needed by etcd 3.6 and clusters running without v2, 2, or auto to use 3 when the members serve it.",
+	).Default(
+		"auto",
+	).Envar(
+		"ETCDMATE_ETCD_API_VERSION",
+	).Enum("auto", "2", "3")
+	probeTimeout = kingpin.Flag(
+		"probe-timeout",
+		"Longest a health probe of a member may take, retries included. 0 only applies --timeout to every attempt.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_PROBE_TIMEOUT",
+	).Duration()
+	healthSearchTimeout = kingpin.Flag(
+		"health-search-timeout",
+		"Longest the search for a healthy member among the probed ones may take. 0 doesn't limit.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_HEALTH_SEARCH_TIMEOUT",
+	).Duration()
+	parallelism = kingpin.Flag(
+		"parallelism",
+		"Maximum concurrent health probes and EC2 describe calls.",
+	).Default(
+		"4",
+	).Envar(
+		"ETCDMATE_PARALLELISM",
+	).Int()
 	clientSchema = kingpin.Flag(
 		"client-schema",
 		"The Etcd client schema.",
@@ -74,6 +142,92 @@ var (
 	).Envar(
 		"ETCDMATE_PEER_PORT",
 	).Int()
+	addressType = kingpin.Flag(
+		"address-type",
+		"The instance address members reach each other at, public ones for clusters spanning regions without peering.",
+	).Default(
+		"private-ip",
+	).Envar(
+		"ETCDMATE_ADDRESS_TYPE",
+	).Enum("private-ip", "private-dns", "public-ip", "public-dns", "private", "public")
+	clientAddressType = kingpin.Flag(
+		"client-address-type",
+		"The instance address in client URLs, --address-type if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_CLIENT_ADDRESS_TYPE",
+	).HintOptions(
+		"private-ip",
+		"private-dns",
+		"public-ip",
+		"public-dns",
+	).String()
+	peerAddressType = kingpin.Flag(
+		"peer-address-type",
+		"The instance address in peer URLs, --address-type if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_PEER_ADDRESS_TYPE",
+	).HintOptions(
+		"private-ip",
+		"private-dns",
+		"public-ip",
+		"public-dns",
+	).String()
+	advertiseSubnets = kingpin.Flag(
+		"advertise-subnet",
+		"Use the private address of the instances in this CIDR, e.g. 10.40.0.0/16, rather than the primary one. Repeatable or comma separated, in order of preference.",
+	).Envar(
+		"ETCDMATE_ADVERTISE_SUBNET",
+	).Strings()
+	advertiseInterface = kingpin.Flag(
+		"advertise-interface",
+		"Use the addresses of this network interface of the instances, e.g. eth1, rather than those of the primary one.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ADVERTISE_INTERFACE",
+	).String()
+	advertiseIP = kingpin.Flag(
+		"advertise-ip",
+		"Private IP addresses the members advertise: primary, the one picked, all, every private IPv4 and IPv6 address of the instances, or ipv6.",
+	).Default(
+		"primary",
+	).Envar(
+		"ETCDMATE_ADVERTISE_IP",
+	).Enum("primary", "all", "ipv6")
+	memberNameTemplate = kingpin.Flag(
+		"member-name-template",
+		"Go template naming the members after instance attributes, e.g. etcd-{{.AvailabilityZoneSuffix}}-{{.Tags.Index}}, instance IDs if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_MEMBER_NAME_TEMPLATE",
+	).String()
+	clientURLTemplate = kingpin.Flag(
+		"client-url-template",
+		"Go template building the member client URLs, e.g. https://{{.Name}}.etcd.internal:2379, from the schema, address and port if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_CLIENT_URL_TEMPLATE",
+	).String()
+	peerURLTemplate = kingpin.Flag(
+		"peer-url-template",
+		"Go template building the member peer URLs, e.g. https://{{.Name}}.etcd.internal:2380, from the schema, address and port if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_PEER_URL_TEMPLATE",
+	).String()
+	remoteAsgs = kingpin.Flag(
+		"remote-asg",
+		"Another Autoscaling group of a stretched cluster as region:name, repeatable.",
+	).Envar(
+		"ETCDMATE_REMOTE_ASG",
+	).Strings()
 	caFile = kingpin.Flag(
 		"ca-file",
 		"verify certificates of HTTPS-enabled servers using this CA bundle",
@@ -92,11 +246,617 @@ var (
 	).Envar(
 		"ETCDMATE_KEY_FILE",
 	).Default("").String()
+	etcdUsername = kingpin.Flag(
+		"etcd-username",
+		"User etcdmate authenticates as when etcd has auth enabled.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ETCD_USERNAME",
+	).String()
+	etcdPassword = kingpin.Flag(
+		"etcd-password",
+		"Password of --etcd-username, better set through ETCDMATE_ETCD_PASSWORD.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ETCD_PASSWORD",
+	).String()
+	peerCAFile = kingpin.Flag(
+		"peer-ca-file",
+		"CA bundle etcd uses to verify peers, written into the env file.",
+	).Envar(
+		"ETCDMATE_PEER_CA_FILE",
+	).Default("").String()
+	peerCertFile = kingpin.Flag(
+		"peer-cert-file",
+		"Certificate etcd presents to peers, written into the env file.",
+	).Envar(
+		"ETCDMATE_PEER_CERT_FILE",
+	).Default("").String()
+	peerKeyFile = kingpin.Flag(
+		"peer-key-file",
+		"Key of the peer certificate, written into the env file.",
+	).Envar(
+		"ETCDMATE_PEER_KEY_FILE",
+	).Default("").String()
+	tlsMinVersion = kingpin.Flag(
+		"tls-min-version",
+		"Minimum TLS version used to talk to etcd.",
+	).Default(
+		"1.2",
+	).Envar(
+		"ETCDMATE_TLS_MIN_VERSION",
+	).Enum("1.0", "1.1", "1.2", "1.3")
+	tlsCipherSuites = kingpin.Flag(
+		"tls-cipher-suites",
+		"Comma separated TLS 1.2 cipher suites allowed, defaults to the Go ones.",
+	).Default("").Envar(
+		"ETCDMATE_TLS_CIPHER_SUITES",
+	).String()
+	insecureSkipVerify = kingpin.Flag(
+		"insecure-skip-verify",
+		"Accept any certificate from etcd, for tests only.",
+	).Envar(
+		"ETCDMATE_INSECURE_SKIP_VERIFY",
+	).Bool()
+	tlsServerName = kingpin.Flag(
+		"tls-server-name",
+		"Name the certificates of the client URLs are verified against rather than the URL host.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TLS_SERVER_NAME",
+	).String()
+	tlsReloadInterval = kingpin.Flag(
+		"tls-reload-interval",
+		"How often to check the TLS files for changes in daemon mode, 0 to disable.",
+	).Default(
+		"1m",
+	).Envar(
+		"ETCDMATE_TLS_RELOAD_INTERVAL",
+	).Duration()
+	stateFile = kingpin.Flag(
+		"state-file",
+		"The file used to persist join progress between runs.",
+	).Default(
+		"/var/lib/etcdmate/state.json",
+	).Envar(
+		"ETCDMATE_STATE_FILE",
+	).String()
+	stateMaxAge = kingpin.Flag(
+		"state-max-age",
+		"Start an interrupted join over from discovery instead of resuming it when its state is older than this. 0 always resumes.",
+	).Default(
+		"1h",
+	).Envar(
+		"ETCDMATE_STATE_MAX_AGE",
+	).Duration()
+	pauseFile = kingpin.Flag(
+		"pause-file",
+		"Pause the automatic membership changes while this file exists, see also the etcdmate:paused tag and the /etcdmate/paused key.",
+	).Default(
+		"/var/lib/etcdmate/paused",
+	).Envar(
+		"ETCDMATE_PAUSE_FILE",
+	).String()
+	publishTopology = kingpin.Flag(
+		"publish-topology",
+		"In daemon mode, keep the members, observers, zones and versions in the /etcdmate/topology key of the cluster.",
+	).Envar(
+		"ETCDMATE_PUBLISH_TOPOLOGY",
+	).Bool()
+	stepDown = kingpin.Flag(
+		"step-down",
+		"In daemon mode, hand the leadership over to another member when stopping while the local member leads.",
+	).Envar(
+		"ETCDMATE_STEP_DOWN",
+	).Bool()
+	coordinate = kingpin.Flag(
+		"coordinate",
+		"In daemon mode, elect one active reconciler with a lease in the cluster, the others skip their passes and reuse its discovery while their member is healthy.",
+	).Envar(
+		"ETCDMATE_COORDINATE",
+	).Bool()
+	coordinationTTL = kingpin.Flag(
+		"coordination-ttl",
+		"How long the active reconciler keeps the lease without renewing it, 3 intervals by default.",
+	).Default("0s").Envar(
+		"ETCDMATE_COORDINATION_TTL",
+	).Duration()
+	discoverySRV = kingpin.Flag(
+		"discovery-srv",
+		"Have etcd find the members in the DNS SRV records of this domain instead of listing them in the env file.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_DISCOVERY_SRV",
+	).String()
+	discoverySRVName = kingpin.Flag(
+		"discovery-srv-name",
+		"Suffix of the DNS SRV records with --discovery-srv or --discovery dns, e.g. _etcd-server-ssl-NAME._tcp.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_DISCOVERY_SRV_NAME",
+	).String()
+	endpointsFile = kingpin.Flag(
+		"endpoints-file",
+		"Write ETCDCTL_ENDPOINTS with the client URLs of the members and observers to this file.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ENDPOINTS_FILE",
+	).String()
+	targetsFile = kingpin.Flag(
+		"targets-file",
+		"Write the members and observers as Prometheus file_sd targets to this file.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TARGETS_FILE",
+	).String()
+	startupJitter = DurationRangeFlag(kingpin.Flag(
+		"startup-jitter",
+		"Random delay before starting, as MAX or MIN-MAX (e.g. 0-30s).",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_STARTUP_JITTER",
+	))
+	lockFile = kingpin.Flag(
+		"lock-file",
+		"The file locked by the runs changing the cluster or the local configuration, and by every pass of a daemon, to prevent concurrent changes.",
+	).Default(
+		"/var/run/etcdmate.lock",
+	).Envar(
+		"ETCDMATE_LOCK_FILE",
+	).String()
+	lockTimeout = kingpin.Flag(
+		"lock-timeout",
+		"How long to wait for a concurrent run to finish.",
+	).Default(
+		"5m",
+	).Envar(
+		"ETCDMATE_LOCK_TIMEOUT",
+	).Duration()
+	dryRun = kingpin.Flag(
+		"dry-run",
+		"Only print the plan of actions, without changing anything.",
+	).Envar(
+		"ETCDMATE_DRY_RUN",
+	).Bool()
+	planFormat = kingpin.Flag(
+		"plan-format",
+		"The dry-run plan output format: text, json, or the env file as a systemd drop-in in an ignition or butane config.",
+	).Default(
+		"text",
+	).Envar(
+		"ETCDMATE_PLAN_FORMAT",
+	).HintOptions(
+		"text",
+		"json",
+		"ignition",
+		"butane",
+	).Enum("text", "json", "ignition", "butane")
+	etcdDataDir = kingpin.Flag(
+		"etcd-data-dir",
+		"The data dir of the local member. A new cluster is only assumed when it is empty.",
+	).Default(
+		"/var/lib/etcd",
+	).Envar(
+		"ETCDMATE_ETCD_DATA_DIR",
+	).String()
+	newClusterReachable = kingpin.Flag(
+		"new-cluster-reachable",
+		"Fraction of the expected members whose host must be reachable before assuming a new cluster.",
+	).Default(
+		"1",
+	).Envar(
+		"ETCDMATE_NEW_CLUSTER_REACHABLE",
+	).Float64()
+	waitForCapacity = kingpin.Flag(
+		"wait-for-capacity",
+		"Before assuming a new cluster, wait until the Autoscaling group has as many instances in service as its desired capacity, or --min-cluster-size.",
+	).Envar(
+		"ETCDMATE_WAIT_FOR_CAPACITY",
+	).Bool()
+	minClusterSize = kingpin.Flag(
+		"min-cluster-size",
+		"With --wait-for-capacity, the number of expected members to wait for rather than the desired capacity.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_MIN_CLUSTER_SIZE",
+	).Int()
+	capacityWait = kingpin.Flag(
+		"capacity-wait-timeout",
+		"Longest --wait-for-capacity waits before refusing to assume a new cluster.",
+	).Default(
+		"10m",
+	).Envar(
+		"ETCDMATE_CAPACITY_WAIT_TIMEOUT",
+	).Duration()
+	historySize = kingpin.Flag(
+		"history-size",
+		"Record the membership changes under /etcdmate/history in etcd, keeping the last N, 0 to disable.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_HISTORY_SIZE",
+	).Int()
+	explain = kingpin.Flag(
+		"explain",
+		"Log why every decision is made, which members were healthy or stale and why.",
+	).Envar(
+		"ETCDMATE_EXPLAIN",
+	).Bool()
+	traceHTTP = kingpin.Flag(
+		"trace-http",
+		"Log the method, URL, status and latency of every AWS, etcd and issuer request.",
+	).Envar(
+		"ETCDMATE_TRACE_HTTP",
+	).Bool()
+	otlpEndpoint = kingpin.Flag(
+		"otlp-endpoint",
+		"Export trace spans to this OTLP/HTTP collector, e.g. http://localhost:4318.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_OTLP_ENDPOINT",
+	).String()
+	summaryFile = kingpin.Flag(
+		"summary-file",
+		"Also write the end of run summary to this file as JSON.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_SUMMARY_FILE",
+	).String()
+	daemon = kingpin.Flag(
+		"daemon",
+		"Keep running and reconcile periodically.",
+	).Envar(
+		"ETCDMATE_DAEMON",
+	).Bool()
+	interval = kingpin.Flag(
+		"interval",
+		"Time between reconciles in daemon mode.",
+	).Default(
+		"60s",
+	).Envar(
+		"ETCDMATE_INTERVAL",
+	).Duration()
+	restartUnit = kingpin.Flag(
+		"restart-unit",
+		"The systemd unit to restart when the configuration changes, or in a one-shot join while the local member isn't active yet.",
+	).Default("").Envar(
+		"ETCDMATE_RESTART_UNIT",
+	).String()
+	systemdReload = kingpin.Flag(
+		"systemd-reload",
+		"Reload systemd when the configuration changes, for etcd to pick it up at its next start. --restart-unit reloads it already.",
+	).Envar(
+		"ETCDMATE_SYSTEMD_RELOAD",
+	).Bool()
+	restartMinInterval = kingpin.Flag(
+		"restart-min-interval",
+		"Minimum time between two restarts of the etcd unit.",
+	).Default(
+		"10m",
+	).Envar(
+		"ETCDMATE_RESTART_MIN_INTERVAL",
+	).Duration()
+	maxChangesPerInterval = kingpin.Flag(
+		"max-changes-per-interval",
+		"Most members join may add and remove within --interval, the next changes wait for a later pass. 0 doesn't limit.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_MAX_CHANGES_PER_INTERVAL",
+	).Int()
+	discoveryWait = kingpin.Flag(
+		"discovery-wait",
+		"How long to wait for the instance to be in service in its Autoscaling group.",
+	).Default(
+		"2m",
+	).Envar(
+		"ETCDMATE_DISCOVERY_WAIT",
+	).Duration()
+	removeStale = kingpin.Flag(
+		"remove-stale",
+		"Remove the cluster members no expected member matches, they are only reported otherwise.",
+	).Envar(
+		"ETCDMATE_REMOVE_STALE",
+	).Bool()
+	force = kingpin.Flag(
+		"force",
+		"Allow the destructive changes: remove stale members as --remove-stale does, and assume a new cluster despite the cluster token of a previous one.",
+	).Envar(
+		"ETCDMATE_FORCE",
+	).Bool()
+	maxRemovals = kingpin.Flag(
+		"max-removals-per-run",
+		"Most stale members a run removes, the others wait for a later run. 0 doesn't limit.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_MAX_REMOVALS_PER_RUN",
+	).Int()
+	forceRemove = kingpin.Flag(
+		"force-remove",
+		"Remove the stale members even when the healthy members left would fall short of quorum, and beyond --max-removals-per-run.",
+	).Envar(
+		"ETCDMATE_FORCE_REMOVE",
+	).Bool()
+	staleGrace = kingpin.Flag(
+		"stale-grace",
+		"How long a member must be missing from the discovery, over several runs, before it is removed as stale. 0 removes it on the first run that misses it.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_STALE_GRACE",
+	).Duration()
+	minZones = kingpin.Flag(
+		"min-zones",
+		"Refuse to configure the local member unless the voting members span this many availability zones, none of them holding their quorum. 0 doesn't check.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_MIN_ZONES",
+	).Int()
+	removalConfirmation = kingpin.Flag(
+		"removal-confirmation",
+		"Plan the removal of stale members and make it once confirmed: off, next-run if the member is still stale on a later run, or operator once listed by --confirm-removal or the etcdmate:confirm-removal tag.",
+	).Default(
+		"off",
+	).Envar(
+		"ETCDMATE_REMOVAL_CONFIRMATION",
+	).Enum("off", "next-run", "operator")
+	confirmRemovals = kingpin.Flag(
+		"confirm-removal",
+		"Name of a stale member whose planned removal is confirmed, with --removal-confirmation operator. Repeatable.",
+	).Envar(
+		"ETCDMATE_CONFIRM_REMOVAL",
+	).Strings()
+	identityCheck = kingpin.Flag(
+		"identity-check",
+		"Verify the certificates of the other members match their addresses: off, warn or fail.",
+	).Default(
+		"off",
+	).Envar(
+		"ETCDMATE_IDENTITY_CHECK",
+	).Enum("off", "warn", "fail")
+	repairPeerURLs = kingpin.Flag(
+		"repair-peer-urls",
+		"Update the members registered under another peer URL than the address of their instance: from the leader in daemon mode, after the local member is verified with --verify-timeout otherwise.",
+	).Envar(
+		"ETCDMATE_REPAIR_PEER_URLS",
+	).Bool()
+	stateKMSKey = kingpin.Flag(
+		"state-kms-key",
+		"KMS key to envelope encrypt the state file with.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_STATE_KMS_KEY",
+	).String()
+	verifyTimeout = kingpin.Flag(
+		"verify-timeout",
+		"Wait this long for the local member to be healthy after configuring it, 0 to skip.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_VERIFY_TIMEOUT",
+	).Duration()
+	discoveryCacheTTL = kingpin.Flag(
+		"discovery-cache-ttl",
+		"Reuse Autoscaling and EC2 lookups for this long in daemon mode, 0 to disable.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_DISCOVERY_CACHE_TTL",
+	).Duration()
+	controlSocket = kingpin.Flag(
+		"control-socket",
+		"Serve the control API on this unix socket in daemon mode.",
+	).Default("").Envar(
+		"ETCDMATE_CONTROL_SOCKET",
+	).String()
+	controlToken = kingpin.Flag(
+		"control-token",
+		"Bearer token required by the control API.",
+	).Default("").Envar(
+		"ETCDMATE_CONTROL_TOKEN",
+	).String()
+	controlPprof = kingpin.Flag(
+		"control-pprof",
+		"Serve the Go pprof profiles under /debug/pprof/ on the control socket.",
+	).Envar(
+		"ETCDMATE_CONTROL_PPROF",
+	).Bool()
+	healthAddr = kingpin.Flag(
+		"health-addr",
+		"Also serve /healthz and /readyz on this TCP address in daemon mode, e.g. 127.0.0.1:9191.",
+	).Default("").Envar(
+		"ETCDMATE_HEALTH_ADDR",
+	).String()
+	healthMaxStaleness = kingpin.Flag(
+		"health-max-staleness",
+		"Report unhealthy when no reconcile succeeded for this long, 3 intervals by default.",
+	).Default("0s").Envar(
+		"ETCDMATE_HEALTH_MAX_STALENESS",
+	).Duration()
+
+	joinCmd = kingpin.Command(
+		"join",
+		"Join the local instance to the cluster.",
+	).Default()
+
+	scaleDownCmd = kingpin.Command(
+		"scale-down",
+		"Remove the youngest members one at a time to shrink the cluster.",
+	)
+	scaleDownTarget = scaleDownCmd.Flag(
+		"target-size",
+		"The number of members to keep.",
+	).Required().Int()
+	scaleDownWait = scaleDownCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the cluster to be healthy after each removal.",
+	).Default(
+		"5m",
+	).Duration()
+	scaleDownTerminate = scaleDownCmd.Flag(
+		"terminate",
+		"Terminate the removed instances after detaching them.",
+	).Bool()
+	scaleDownIgnoreZones = scaleDownCmd.Flag(
+		"ignore-zones",
+		"Also remove the last member of an availability zone.",
+	).Bool()
+
+	bootstrapCmd = kingpin.Command(
+		"bootstrap",
+		"Create a new cluster once all expected instances are in service.",
+	)
+	bootstrapSize = bootstrapCmd.Flag(
+		"cluster-size",
+		"The number of instances to wait for, defaults to the Autoscaling group desired capacity.",
+	).Int()
+	bootstrapWait = bootstrapCmd.Flag(
+		"wait-timeout",
+		"How long to wait for all instances and the coordinator.",
+	).Default(
+		"10m",
+	).Duration()
+
+	rolloutCmd = kingpin.Command(
+		"rollout",
+		"Replace outdated members one at a time using learner joins.",
+	)
+	rolloutAll = rolloutCmd.Flag(
+		"all",
+		"Replace all members, not only those with an outdated launch configuration.",
+	).Bool()
+	rolloutWait = rolloutCmd.Flag(
+		"wait-timeout",
+		"How long to wait for each replacement step.",
+	).Default(
+		"15m",
+	).Duration()
+
+	replaceMemberCmd = kingpin.Command(
+		"replace-member",
+		"Replace a member by a new instance using a learner join.",
+	)
+	replaceMemberName = replaceMemberCmd.Flag(
+		"name",
+		"Instance ID of the member to replace.",
+	).Required().String()
+	replaceMemberWait = replaceMemberCmd.Flag(
+		"wait-timeout",
+		"How long to wait for each replacement step.",
+	).Default(
+		"15m",
+	).Duration()
+
+	promoteCmd = kingpin.Command(
+		"promote",
+		"Promote the local member once it caught up, when it joined as a learner.",
+	)
+	promoteWait = promoteCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the local member to catch up.",
+	).Default(
+		"10m",
+	).Duration()
+
+	verifyCmd = kingpin.Command(
+		"verify",
+		"Wait for the local member to be a healthy voting member caught up with the leader, failing after --wait-timeout.",
+	)
+	verifyWait = verifyCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the local member to pass the checks.",
+	).Default(
+		"5m",
+	).Duration()
+	verifyLearner = verifyCmd.Flag(
+		"learner",
+		"Also accept a local member not promoted yet, e.g. a canary or --learner join.",
+	).Bool()
+	verifyMaxLag = verifyCmd.Flag(
+		"max-lag",
+		"How many raft entries the local member may be behind the leader.",
+	).Default(
+		"100",
+	).Uint64()
+
+	leaveCmd = kingpin.Command(
+		"leave",
+		"Remove the local member from the cluster, e.g. before the instance shuts down.",
+	)
+	leaveRemoveData = leaveCmd.Flag(
+		"remove-data",
+		"Also empty --etcd-data-dir once the member is removed.",
+	).Bool()
+
+	migrateCmd = kingpin.Command(
+		"migrate",
+		"Move the local member from the etcd2 unit to an etcd3 one, one node at a time.",
+	)
+	migrateEnvFile = migrateCmd.Flag(
+		"target-env-file",
+		"Drop-in of the etcd3 unit.",
+	).Default(
+		"/etc/systemd/system/etcd-member.service.d/30-etcdmate.conf",
+	).String()
+	migrateSourceUnit = migrateCmd.Flag(
+		"source-unit",
+		"The etcd2 unit to stop.",
+	).Default(
+		"etcd2.service",
+	).String()
+	migrateTargetUnit = migrateCmd.Flag(
+		"target-unit",
+		"The etcd3 unit to start.",
+	).Default(
+		"etcd-member.service",
+	).String()
+	migrateWait = migrateCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the previous nodes and for the local member.",
+	).Default(
+		"30m",
+	).Duration()
+
+	rotateCertsCmd = kingpin.Command(
+		"rotate-certs",
+		"Issue new certificates and restart the local member, one node at a time.",
+	)
+	rotateCertsID = rotateCertsCmd.Flag(
+		"id",
+		"Names the rotation, run it with the same id on every node.",
+	).Required().String()
+	rotateCertsWait = rotateCertsCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the previous nodes and for the local member.",
+	).Default(
+		"30m",
+	).Duration()
 )
 
 func main() {
 	kingpin.Version(version)
-	kingpin.Parse()
+	command := kingpin.Parse()
+	result.command = command
+	if *configFile != "" {
+		if err := loadConfigFile(kingpin.CommandLine, os.Args[1:], nil); err != nil {
+			exit(misconfigured(err))
+		}
+	}
+	setupLogging()
 	log.Printf("env file: %s\n", *envFile)
 	log.Printf("Timeout: %s\n", *timeout)
 	log.Printf("Client schema: %s\n", *clientSchema)
@@ -104,245 +864,746 @@ func main() {
 	log.Printf("Peer schema: %s\n", *peerSchema)
 	log.Printf("Peer port: %d\n", *peerPort)
 
-	localSess := session.Must(session.NewSession())
-	metadata, err := GetMetadata(localSess)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if command == printConfigCmd.FullCommand() {
+		if err := printConfig(os.Stdout, os.Args[1:], *printConfigFormat); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if command == configValidateCmd.FullCommand() {
+		exit(validateConfigFile())
+	}
+
+	mustValidateConfig(command)
+
+	// Nothing below applies, the simulation brings its own AWS and etcd
+	if command == simulateCmd.FullCommand() {
+		if err := runSimulation(ctx); err != nil {
+			exit(err)
+		}
+		log.Println("Simulation passed")
+		return
+	}
+
+	if command == simulatePlanCmd.FullCommand() {
+		if err := runSimulatePlan(ctx); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if command == serveRegistryCmd.FullCommand() {
+		if err := serveRegistry(ctx); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if command == benchDiscoveryCmd.FullCommand() && !*benchDiscoveryReal {
+		if err := benchFakeDiscovery(ctx); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	Jitter(startupJitter)
+	if lockedCommand(command) {
+		release, err := lockRun()
+		if err != nil {
+			exit(err)
+		}
+		defer release()
+	}
+
+	var tracer *tracing.Tracer
+	if *otlpEndpoint != "" {
+		tracer = tracing.NewTracer(*otlpEndpoint, "etcdmate", log.Default())
+		tracer.Attributes["service.version"] = version
+		ctx = tracing.WithTracer(ctx, tracer)
+	}
+	// Daemons run forever, only one-shot runs get a summary
+	var summary *reconcile.Summary
+	if !*daemon && !*dryRun {
+		summary = reconcile.NewSummary()
+	}
+	// The instance role credentials follow --imds-version too
+	awsConfig := aws.NewConfig().WithEC2MetadataEnableFallback(*imdsVersion != "2")
+	// The SDK retries throttling and server errors with a jittered backoff
+	awsConfig = request.WithRetryer(awsConfig, client.DefaultRetryer{
+		NumMaxRetries:    *retries,
+		MinRetryDelay:    *retryBackoff,
+		MinThrottleDelay: *retryBackoff,
+	})
+	if traceRequests() {
+		awsConfig.HTTPClient = wiretrace.Client(nil, traceLogger())
+	}
+	localSess := session.Must(session.NewSession(awsConfig))
+	chaosMonkey := newChaos()
+	if chaosMonkey != nil {
+		chaosMonkey.Throttle(&localSess.Handlers)
+	}
+	useAWSFixtures(localSess)
+	metadataSvc := newMetadata(localSess)
+	done := summary.Time("metadata")
+	metadata, err := localIdentity(ctx, metadataSvc, localSess)
+	done()
 	if err != nil {
-		log.Fatal(err)
+		exit(err)
+	}
+	result.instanceID = metadata.InstanceID
+	logs.With("instance_id", metadata.InstanceID)
+	if tracer != nil {
+		tracer.Attributes["host.id"] = metadata.InstanceID
+		if *daemon {
+			go tracer.Run(ctx, 10*time.Second)
+		}
 	}
 	sess := localSess.Copy(&aws.Config{
 		Region: aws.String(metadata.Region),
 	})
-	expectedMembers, err := GetExpectedMembers(sess, metadata.InstanceID)
+	certIssuer, err := NewCertIssuer(ctx, sess, metadataSvc, metadata)
 	if err != nil {
-		log.Fatal(err)
+		exit(err)
 	}
-	etcdClient, err := etcdclient.NewClient(
-		*caFile,
-		*certFile,
-		*keyFile,
-		*timeout,
-	)
+	if certIssuer != nil && (command == rotateCertsCmd.FullCommand() || *dryRun) {
+		// Rotation backs up the certificates in use before issuing new ones,
+		// dry runs plan with them
+		useCerts(certs.FilesIn(*certDir, "etcd"))
+	} else if certIssuer != nil {
+		done := summary.Time("certificates")
+		files, err := certIssuer.Issue(ctx)
+		done()
+		if err != nil {
+			exit(err)
+		}
+		useCerts(files)
+	}
+	minVersion, err := etcd.ParseTLSVersion(*tlsMinVersion)
 	if err != nil {
-		log.Fatal(err)
+		exit(misconfigured(err))
 	}
-	healthyMember, err := etcdClient.FindHealthyMember(expectedMembers)
+	cipherSuites, err := etcd.ParseCipherSuites(*tlsCipherSuites)
 	if err != nil {
-		// The cluster is not up. Assume new cluster
-		log.Println(err)
-		WriteEnv(expectedMembers, "new")
-		os.Exit(0)
+		exit(misconfigured(err))
 	}
-	existingMembers, err := etcdClient.ListMembers(healthyMember)
+	etcdOpts := []etcd.Option{
+		etcd.WithTLS(*caFile, *certFile, *keyFile),
+		etcd.WithTLSMinVersion(minVersion),
+		etcd.WithCipherSuites(cipherSuites),
+		etcd.WithTimeout(*timeout),
+		etcd.WithDialTimeout(*dialTimeout),
+		etcd.WithRetries(*retries, *retryBackoff),
+		etcd.WithParallelism(*parallelism),
+		etcd.WithProbeTimeouts(*probeTimeout, *healthSearchTimeout),
+		etcd.WithAPIVersion(etcd.APIVersion(*etcdAPIVersion)),
+		etcd.WithLogger(log.Default()),
+	}
+	if *etcdUsername != "" {
+		etcdOpts = append(etcdOpts, etcd.WithAuth(*etcdUsername, *etcdPassword))
+	}
+	if *peerCAFile != "" || *peerCertFile != "" {
+		etcdOpts = append(etcdOpts, etcd.WithPeerTLS(*peerCAFile, *peerCertFile, *peerKeyFile))
+	}
+	if *insecureSkipVerify {
+		etcdOpts = append(etcdOpts, etcd.WithInsecureSkipVerify())
+	}
+	if *tlsServerName != "" {
+		etcdOpts = append(etcdOpts, etcd.WithServerName(*tlsServerName))
+	}
+	if traceRequests() {
+		etcdOpts = append(etcdOpts, etcd.WithTrace(traceLogger()))
+	}
+	if chaosMonkey != nil {
+		etcdOpts = append(etcdOpts, etcd.WithTransportWrapper(chaosMonkey.Transport))
+	}
+	etcdClient, err := etcd.New(etcdOpts...)
 	if err != nil {
-		log.Println(err)
-		WriteEnv(expectedMembers, "new")
-		os.Exit(0)
-	}
-	RemoveStaleMembers(
-		etcdClient,
-		healthyMember,
-		expectedMembers,
-		existingMembers,
-	)
-	myself := GetMyself(expectedMembers, metadata.InstanceID)
-	MaybeAddMyself(
-		etcdClient,
-		healthyMember,
-		existingMembers,
-		myself,
-	)
-	WriteEnv(expectedMembers, "existing")
-}
-
-func GetMetadata(sess *session.Session) (ec2metadata.EC2InstanceIdentityDocument, error) {
-	metadata := ec2metadata.New(sess)
-	if !metadata.Available() {
-		return ec2metadata.EC2InstanceIdentityDocument{}, errors.New("Not An AWS EC2 instance")
+		exit(err)
+	}
+	// The cert dir stays writable for renewals
+	ownedFiles := []string{*envFile, *stateFile}
+	for _, file := range []string{*endpointsFile, *targetsFile} {
+		if file != "" {
+			ownedFiles = append(ownedFiles, file)
+		}
+	}
+	if certIssuer != nil {
+		ownedFiles = append(ownedFiles, path.Join(*certDir, "etcd.pem"))
 	}
-	id, err := metadata.GetInstanceIdentityDocument()
+	err = dropPrivileges(ownedFiles...)
 	if err != nil {
-		return ec2metadata.EC2InstanceIdentityDocument{}, err
+		exit(err)
 	}
-	log.Printf("Metadata: %+v\n", id)
-	return id, nil
-}
-
-func GetAsg(svc *autoscaling.AutoScaling, insId string) (string, error) {
-	log.Println("Looking for Autoscaling group of instance", insId)
-	params := &autoscaling.DescribeAutoScalingInstancesInput{
-		InstanceIds: []*string{&insId},
-		MaxRecords:  aws.Int64(1),
+	awsServices := discovery.NewAWS(sess, log.Default())
+	awsServices.Parallelism = *parallelism
+	awsServices.Registry, err = newRegistry()
+	if err != nil {
+		exit(err)
+	}
+	awsServices.RegistryTTL = *registryTTL
+	for _, remote := range *remoteAsgs {
+		parts := strings.SplitN(remote, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			exit(misconfigured(errors.New(fmt.Sprint("Invalid --remote-asg ", remote, ", expected region:name"))))
+		}
+		remoteServices := discovery.NewAWS(localSess.Copy(&aws.Config{
+			Region: aws.String(parts[0]),
+		}), log.Default())
+		remoteServices.Parallelism = *parallelism
+		awsServices.Remotes = append(awsServices.Remotes, discovery.RemoteGroup{
+			AWS:     remoteServices,
+			AsgName: parts[1],
+		})
 	}
-	resp, err := svc.DescribeAutoScalingInstances(params)
+	source, err := newSource(awsServices, metadata.PrivateIP)
 	if err != nil {
-		return "", err
+		exit(misconfigured(err))
 	}
-	asgName := resp.AutoScalingInstances[0].AutoScalingGroupName
-	log.Println("Found Autoscaling group", *asgName)
-	return *asgName, nil
-}
-
-func GetAsgInstanceIds(svc *autoscaling.AutoScaling, asgName string) ([]*string, error) {
-	log.Println("Looking for instances in Autoscaling group", asgName)
-	params := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{&asgName},
-		MaxRecords:            aws.Int64(1),
+	logAsg(ctx, awsServices, source, metadata.InstanceID)
+	claimZoneIndex(ctx, awsServices, source, metadata.InstanceID)
+	cfg := reconcile.Config{
+		AWS:         awsServices,
+		Source:      source,
+		Client:      etcdClient,
+		URLs:        memberURLs(),
+		InstanceID:  metadata.InstanceID,
+		StateFile:   *stateFile,
+		StateMaxAge: *stateMaxAge,
+		EnvFile:     *envFile,
+		PeerTLS: output.PeerTLS{
+			CAFile:   *peerCAFile,
+			CertFile: *peerCertFile,
+			KeyFile:  *peerKeyFile,
+		},
+		DiscoverySRV: output.DiscoverySRV{
+			Domain: *discoverySRV,
+			Name:   *discoverySRVName,
+		},
+		DiscoveryWait: *discoveryWait,
+		Logger:        log.Default(),
+		Explain:       *explain,
+		HistorySize:   *historySize,
+		DataDir:       *etcdDataDir,
+		EndpointsFile: *endpointsFile,
+		TargetsFile:   *targetsFile,
+		PauseFile:     *pauseFile,
+		Output:        newConfigOutput(),
+		OutputFormat:  *outputFormat,
+		EtcdYAMLBase:  *etcdYAMLBase,
+		Records:       newRecords(sess),
 	}
-	resp, err := svc.DescribeAutoScalingGroups(params)
+	applyPolicy(&cfg)
+	cfg.ClusterToken, err = newClusterToken(ctx, awsServices, metadata)
 	if err != nil {
-		return []*string{}, err
-	}
-	instances := resp.AutoScalingGroups[0].Instances
-	instanceIds := []*string{}
-	for _, instance := range instances {
-		log.Printf("Found instance %+v\n", instance)
-		if *instance.LifecycleState == "InService" {
-			instanceIds = append(instanceIds, instance.InstanceId)
-		} else {
-			log.Println("Ignoring instance", *instance.InstanceId)
+		exit(err)
+	}
+	cfg.AddLock, err = newAddLock(ctx, sess, awsServices, metadata.InstanceID)
+	if err != nil {
+		exit(err)
+	}
+	cfg.AddLockTTL = *lockTTL
+	cfg.AddLockWait = *lockWait
+	if proxying() {
+		asgName, err := proxyGroup(ctx, awsServices)
+		if err != nil {
+			exit(err)
 		}
+		cfg.Source = discovery.Group{AWS: awsServices, AsgName: asgName}
+		cfg.Proxy = newProxy()
 	}
-	return instanceIds, nil
-}
-
-func GetEC2Instances(sess *session.Session, instanceIds []*string) ([]ec2.Instance, error) {
-	svc := ec2.New(sess)
-	params := &ec2.DescribeInstancesInput{
-		InstanceIds: instanceIds,
+	if *templateFile != "" {
+		cfg.Template, err = output.ParseTemplate(*templateFile)
+		if err != nil {
+			exit(misconfigured(err))
+		}
 	}
-	resp, err := svc.DescribeInstances(params)
-	if err != nil {
-		return []ec2.Instance{}, err
+	if *stateKMSKey != "" {
+		cfg.StateSealer = seal.KMS{KMS: kms.New(sess), KeyID: *stateKMSKey}
+	}
+	if *identityCheck != "off" {
+		cfg.IdentityCheck = reconcile.IdentityCheck(*identityCheck)
 	}
-	instances := []ec2.Instance{}
-	for _, reservation := range resp.Reservations {
-		for _, instance := range reservation.Instances {
-			instances = append(instances, *instance)
+	if *versionCheck != "off" {
+		cfg.LocalVersion, err = localEtcdVersion()
+		if err != nil {
+			log.Println("Not checking the etcd version:", err)
 		}
+		cfg.VersionCheck = reconcile.VersionCheck(*versionCheck)
 	}
-	return instances, nil
-}
-
-func GetExpectedMembers(sess *session.Session, insId string) ([]etcdclient.Member, error) {
-	etcdMembers := []etcdclient.Member{}
-	asg := autoscaling.New(sess)
-	asgName, err := GetAsg(asg, insId)
+	cfg.Metrics, err = newMetrics()
 	if err != nil {
-		return etcdMembers, err
+		exit(err)
 	}
-	instanceIds, err := GetAsgInstanceIds(asg, asgName)
+	cfg.Snapshots, err = newPreRemoval(sess)
 	if err != nil {
-		return etcdMembers, err
+		exit(err)
 	}
-	instances, err := GetEC2Instances(sess, instanceIds)
-	if err != nil {
-		return etcdMembers, err
-	}
-	for _, instance := range instances {
-		etcdMembers = append(etcdMembers, etcdclient.Member{
-			Name: *instance.InstanceId,
-			ClientURL: fmt.Sprint(
-				*clientSchema,
-				"://",
-				*instance.PrivateIpAddress,
-				":",
-				*clientPort,
-			),
-			PeerURL: fmt.Sprint(
-				*peerSchema,
-				"://",
-				*instance.PrivateIpAddress,
-				":",
-				*peerPort,
-			),
-		})
+	if summary != nil {
+		cfg.Summary = summary
+		cfg.Events = summary.Events(cfg.Events)
 	}
-	log.Printf("Expected Members %+v\n", etcdMembers)
-	return etcdMembers, nil
-}
+	cfg.Events = notifySNS(sess, cfg.InstanceID, cfg.Events)
+	cfg.Events = result.Events(cfg.Events)
+	cfg.Events = chaosCrash(chaosMonkey, cfg.Events)
+	cfg.Events = logEvents(cfg.Events)
 
-func RemoveStaleMembers(
-	c etcdclient.Client,
-	hm etcdclient.Member,
-	expectedMembers []etcdclient.Member,
-	existingMembers []etcdclient.Member,
-) {
-	Expected := func(exiM etcdclient.Member) bool {
-		for _, expM := range expectedMembers {
-			if exiM.Name == expM.Name {
-				return true
+	switch command {
+	case scaleDownCmd.FullCommand():
+		err = reconcile.ScaleDown(ctx, cfg, reconcile.ScaleDownOptions{
+			TargetSize:  *scaleDownTarget,
+			Wait:        *scaleDownWait,
+			Terminate:   *scaleDownTerminate,
+			IgnoreZones: *scaleDownIgnoreZones,
+		})
+	case bootstrapCmd.FullCommand():
+		err = reconcile.Bootstrap(ctx, cfg, reconcile.BootstrapOptions{
+			Size: *bootstrapSize,
+			Wait: *bootstrapWait,
+		})
+	case rolloutCmd.FullCommand():
+		err = reconcile.Rollout(ctx, cfg, reconcile.RolloutOptions{
+			All:  *rolloutAll,
+			Wait: *rolloutWait,
+		})
+	case replaceMemberCmd.FullCommand():
+		err = reconcile.Replace(ctx, cfg, reconcile.RolloutOptions{
+			Wait: *replaceMemberWait,
+		}, *replaceMemberName)
+	case promoteCmd.FullCommand():
+		err = reconcile.Promote(ctx, cfg, *promoteWait)
+	case restoreCmd.FullCommand():
+		err = runRestore(ctx, sess, cfg)
+	case spotWatchCmd.FullCommand():
+		opts := spotOptions(localSess)
+		// A join running meanwhile could add the member back, the lock is
+		// held until etcdmate exits
+		opts.Stop = func() {
+			if _, err := lockRun(); err != nil {
+				log.Println(err)
+			}
+		}
+		_, err = reconcile.WatchSpot(ctx, cfg, opts)
+	case verifyCmd.FullCommand():
+		err = reconcile.Verify(ctx, cfg, reconcile.VerifyOptions{
+			Wait:    *verifyWait,
+			Learner: *verifyLearner,
+			MaxLag:  *verifyMaxLag,
+		})
+	case leaveCmd.FullCommand():
+		err = reconcile.Leave(ctx, cfg, reconcile.LeaveOptions{RemoveData: *leaveRemoveData})
+	case migrateCmd.FullCommand():
+		result.watchFile(*migrateEnvFile)
+		err = reconcile.Migrate(ctx, cfg, reconcile.MigrateOptions{
+			TargetEnvFile: *migrateEnvFile,
+			SourceUnit:    *migrateSourceUnit,
+			TargetUnit:    *migrateTargetUnit,
+			Wait:          *migrateWait,
+		})
+	case rotateCertsCmd.FullCommand():
+		err = reconcile.RotateCerts(ctx, cfg, reconcile.RotateOptions{
+			ID:    *rotateCertsID,
+			Files: certs.FilesIn(*certDir, "etcd"),
+			Issue: func(ctx context.Context) error {
+				_, err := certIssuer.Issue(ctx)
+				return err
+			},
+			Unit: *restartUnit,
+			Wait: *rotateCertsWait,
+		})
+	case benchDiscoveryCmd.FullCommand():
+		err = benchRealDiscovery(ctx, cfg)
+	case topologyCmd.FullCommand():
+		err = printTopology(ctx, cfg)
+	case topCmd.FullCommand():
+		err = runTop(ctx, cfg)
+	case lifecycleCmd.FullCommand():
+		err = runLifecycle(ctx, sess, cfg)
+	case joinCmd.FullCommand():
+		if err := checkClock(ctx); err != nil {
+			exit(err)
+		}
+		cfg.Changes = newChangeLimiter()
+		watchEnvFiles(cfg)
+		if *clustersFile == "" && !proxying() {
+			if err := auditSecurityGroups(ctx, cfg); err != nil {
+				exit(err)
 			}
 		}
-		return false
+		if *daemon && !*dryRun {
+			if err := startBackups(ctx, sess, cfg); err != nil {
+				exit(err)
+			}
+		}
+		recovery, err := newRecovery(sess)
+		if err != nil {
+			exit(err)
+		}
+		if *clustersFile != "" {
+			err = joinClusters(ctx, cfg, certIssuer)
+		} else {
+			err = join(ctx, cfg, certIssuer, *restartUnit, recovery, newScalingEvents(sess), newSpotWatch(localSess))
+		}
 	}
-	for _, exiM := range existingMembers {
-		if !Expected(exiM) {
-			err := c.RemoveMember(hm, exiM)
-			if err != nil {
-				log.Fatal(err)
+	if summary != nil {
+		summary.Finish(err)
+		summary.Print(log.Default())
+		if *summaryFile != "" {
+			if err := summary.WriteFile(*summaryFile); err != nil {
+				log.Println("Writing the summary failed:", err)
 			}
 		}
+		if err := uploadReport(sess, cfg, summary); err != nil {
+			log.Println("Uploading the summary failed:", err)
+		}
 	}
-}
-
-func GetMyself(expectedMembers []etcdclient.Member, insId string) etcdclient.Member {
-	for _, member := range expectedMembers {
-		if member.Name == insId {
-			return member
+	pushMetrics(cfg.Metrics, summary, cfg.InstanceID)
+	writeTextfile(cfg.Metrics, err)
+	if tracer != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := tracer.Flush(flushCtx); err != nil {
+			log.Println("Exporting spans failed:", err)
 		}
+		cancel()
 	}
-	panic(errors.New(fmt.Sprint(
-		"Couldn't find instance in expected members", insId,
-	)))
+	if errors.Is(err, discovery.ErrNotInASG) {
+		log.Println("etcdmate discovers the cluster members from the instance Autoscaling group," +
+			" check that the instance was launched by one and is not detached or in standby")
+	}
+	exit(err)
 }
 
-func MaybeAddMyself(
-	c etcdclient.Client,
-	hm etcdclient.Member,
-	existingMembers []etcdclient.Member,
-	myself etcdclient.Member,
-) {
-	exists := false
-	for _, member := range existingMembers {
-		if member.Name == myself.Name {
-			exists = true
+func join(
+	ctx context.Context,
+	cfg reconcile.Config,
+	certIssuer *CertIssuer,
+	unit string,
+	recovery *reconcile.Recovery,
+	events *reconcile.ScalingEvents,
+	spot *reconcile.SpotOptions,
+) error {
+	if *dryRun {
+		state, err := cfg.LoadState(ctx)
+		if err != nil {
+			return err
+		}
+		plan, err := cfg.Reconciler(state.ClusterToken).Plan(ctx)
+		if err != nil {
+			return err
+		}
+		// As a run, which restarts the unit once the configuration is written
+		if unit != "" && !plan.LocalActive && *verifyTimeout != 0 {
+			plan.Restarts = append(plan.Restarts, unit)
+		}
+		if *planFormat == "ignition" || *planFormat == "butane" {
+			return printProvisioning(os.Stdout, plan, *planFormat)
+		}
+		return reconcile.PrintPlan(os.Stdout, plan, *planFormat)
+	}
+	if *daemon {
+		cfg = withDiscoveryCache(cfg)
+		if spot != nil {
+			var stopped func()
+			ctx, stopped = watchSpot(ctx, cfg, *spot)
+			defer stopped()
+		}
+		if *coordinate {
+			cfg.Coordinator = &reconcile.Coordinator{TTL: *coordinationTTL}
+			if cfg.Coordinator.TTL == 0 {
+				cfg.Coordinator.TTL = 3 * *interval
+			}
+		}
+		startRenewals(ctx, cfg, certIssuer)
+		serveMetrics(ctx, cfg.Metrics)
+		opts := superviseOptions(unit)
+		reloads := make(chan func(*reconcile.Config, *reconcile.SuperviseOptions))
+		opts.Reload = reloads
+		// The periodic checks below follow the reloaded configuration
+		go watchReloads(ctx, cfg.Client, reloads, func(reloaded reconcile.Config) { cfg = reloaded })
+		maintenance := newMaintenance()
+		var topology *reconcile.TopologyPublisher
+		if *publishTopology {
+			topology = &reconcile.TopologyPublisher{}
+		}
+		scaler := newScaler()
+		protection := newProtection()
+		canary := newCanary()
+		var peerRepair *reconcile.PeerRepair
+		if *repairPeerURLs {
+			peerRepair = &reconcile.PeerRepair{}
+		}
+		if recovery != nil || maintenance != nil || topology != nil || scaler != nil || protection != nil ||
+			canary != nil || peerRepair != nil {
+			opts.AfterPass = func(ctx context.Context) {
+				if recovery != nil {
+					if err := recovery.Check(ctx, cfg); err != nil {
+						log.Println("Quorum recovery:", err)
+					}
+				}
+				if maintenance != nil {
+					if err := maintenance.Check(ctx, cfg); err != nil {
+						log.Println("Maintenance:", err)
+					}
+				}
+				if topology != nil {
+					if err := topology.Check(ctx, cfg); err != nil {
+						log.Println("Publishing the topology:", err)
+					}
+				}
+				if scaler != nil {
+					if err := scaler.Check(ctx, cfg); err != nil {
+						log.Println("Following the desired capacity:", err)
+					}
+				}
+				if protection != nil {
+					if err := protection.Check(ctx, cfg); err != nil {
+						log.Println("Scale-in protection:", err)
+					}
+				}
+				if canary != nil {
+					if err := canary.Check(ctx, cfg); err != nil {
+						log.Println("Canary:", err)
+					}
+				}
+				if peerRepair != nil {
+					if err := peerRepair.Check(ctx, cfg); err != nil {
+						log.Println("Repairing peer URLs:", err)
+					}
+				}
+			}
+		}
+		if *controlSocket != "" || *healthAddr != "" {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			server := control.New(cfg, *controlSocket, *controlToken)
+			server.OnLeave = cancel
+			server.MaxStaleness = *healthMaxStaleness
+			server.Debug = *controlPprof
+			if server.MaxStaleness == 0 {
+				server.MaxStaleness = 3 * *interval
+			}
+			opts.Trigger = server.Trigger()
+			opts.Report = server.Report
+			if *controlSocket != "" {
+				go func() {
+					err := server.Serve(ctx)
+					if err != nil {
+						log.Println("Control API stopped:", err)
+					}
+				}()
+			}
+			if *healthAddr != "" {
+				go func() {
+					err := server.ServeHealth(ctx, *healthAddr)
+					if err != nil {
+						log.Println("Health checks stopped:", err)
+					}
+				}()
+			}
+		}
+		opts.Trigger = watchScalingEvents(ctx, cfg, events, opts.Trigger)
+		err := reconcile.Supervise(ctx, cfg, opts)
+		if errors.Is(err, context.Canceled) {
+			log.Println("Stopping")
+			return nil
+		}
+		return err
+	}
+	before, _ := cfg.ReadConfig()
+	state, err := reconcile.Reconcile(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	result.clusterState = state.ClusterState
+	after, _ := cfg.ReadConfig()
+	restart, reload := configActions(unit, *systemdReload, before != after, state.LocalActive)
+	if reload {
+		if err := output.ReloadSystemd(cfg.Logger); err != nil {
+			return err
+		}
+	}
+	if restart {
+		if err := output.RestartUnit(unit, cfg.Logger); err != nil {
+			return err
 		}
 	}
-	if !exists {
-		err := c.AddMember(hm, myself)
+	if *verifyTimeout == 0 || state.Proxy {
+		return nil
+	}
+	err = reconcile.VerifyLocal(ctx, cfg, state.Myself, *verifyTimeout)
+	if err != nil {
+		return err
+	}
+	if *repairPeerURLs && !state.Observer {
+		err = reconcile.RepairPeerURLs(ctx, cfg, state.Myself, state.ExpectedMembers)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
+	if state.LocalActive || state.ClusterState != "existing" {
+		return nil
+	}
+	if canary := newCanary(); canary != nil {
+		return canary.Run(ctx, cfg, state.ExpectedMembers, state.Myself)
+	}
+	return nil
+}
+
+// configActions tells how a one-shot join gets etcd to use the configuration
+// it wrote, whether or not it verifies the local member afterwards: unit is
+// restarted when the configuration changed or the local member isn't active
+// yet, systemd only reloaded for its next start otherwise, with reload.
+// Restarting unit reloads systemd already.
+func configActions(unit string, reload bool, changed bool, localActive bool) (restartUnit bool, reloadSystemd bool) {
+	if unit != "" {
+		return changed || !localActive, false
+	}
+	return false, reload && changed
 }
 
-func WriteEnv(expectedMembers []etcdclient.Member, state string) {
-	initCluster := []string{}
-	for _, member := range expectedMembers {
-		initCluster = append(initCluster, fmt.Sprint(
-			member.Name,
-			"=",
-			member.PeerURL,
-		))
+func memberURLs() discovery.URLs {
+	urls := discovery.URLs{
+		ClientSchema: *clientSchema,
+		ClientPort:   *clientPort,
+		PeerSchema:   *peerSchema,
+		PeerPort:     *peerPort,
 	}
-	err := os.MkdirAll(path.Dir(*envFile), 0777)
+	var err error
+	urls.Address, err = discovery.ParseAddressType(*addressType)
 	if err != nil {
-		log.Fatal(err)
+		exit(misconfigured(err))
+	}
+	if *clientAddressType != "" {
+		urls.ClientAddress, err = discovery.ParseAddressType(*clientAddressType)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+	}
+	if *peerAddressType != "" {
+		urls.PeerAddress, err = discovery.ParseAddressType(*peerAddressType)
+		if err != nil {
+			exit(misconfigured(err))
+		}
 	}
-	file, err := os.Create(*envFile)
+	urls.Subnets, err = discovery.ParseSubnets(*advertiseSubnets...)
 	if err != nil {
-		log.Fatal(err)
+		exit(misconfigured(err))
+	}
+	if *advertiseInterface != "" {
+		index, err := discovery.ParseInterface(*advertiseInterface)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+		urls.Interface = index
+	}
+	urls.AdvertiseIP = discovery.AdvertiseIP(*advertiseIP)
+	nameTemplate := *memberNameTemplate
+	if nameTemplate == "" {
+		var err error
+		nameTemplate, err = discovery.NameSourceTemplate(*nameSource)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+	}
+	if nameTemplate != "" {
+		name, err := discovery.ParseNameTemplate(nameTemplate)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+		urls.Name = name
+	}
+	if *clientURLTemplate != "" {
+		clientURL, err := discovery.ParseURLTemplate(*clientURLTemplate)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+		urls.ClientURL = clientURL
 	}
-	defer file.Close()
+	if *peerURLTemplate != "" {
+		peerURL, err := discovery.ParseURLTemplate(*peerURLTemplate)
+		if err != nil {
+			exit(misconfigured(err))
+		}
+		urls.PeerURL = peerURL
+	}
+	return urls
+}
 
-	fmt.Fprintf(
-		file,
-		"ETCD_INITIAL_CLUSTER=%s\n",
-		strings.Join(initCluster, ","),
-	)
-	fmt.Fprintf(
-		file,
-		"ETCD_INITIAL_CLUSTER_STATE=%s\n",
-		state,
-	)
+// withDiscoveryCache caches the discovery lookups of a daemon between
+// passes, see --discovery-cache-ttl
+func withDiscoveryCache(cfg reconcile.Config) reconcile.Config {
+	if *discoveryCacheTTL <= 0 {
+		return cfg
+	}
+	cache := discovery.NewCache(*discoveryCacheTTL)
+	cfg.AWS.Cache = cache
+	events := cfg.Events
+	cfg.Events = func(e reconcile.Event) {
+		// Membership changes and failures may come from stale lookups
+		if e.Type != reconcile.EventBootstrapDecision {
+			cache.Invalidate()
+		}
+		if events != nil {
+			events(e)
+		}
+	}
+	return cfg
+}
+
+// startRenewals keeps the TLS material of a daemon fresh
+func startRenewals(ctx context.Context, cfg reconcile.Config, certIssuer *CertIssuer) {
+	if *tlsReloadInterval > 0 {
+		go cfg.Client.WatchTLS(ctx, *tlsReloadInterval)
+	}
+	if certIssuer != nil && *certRenewInterval > 0 {
+		go certIssuer.Renew(ctx, *certRenewInterval)
+	}
+}
+
+// applyPolicy sets the options deciding which members are added and
+// removed, shared with simulate-plan so it plays the same policy
+func applyPolicy(cfg *reconcile.Config) {
+	cfg.NewClusterReachable = *newClusterReachable
+	cfg.WaitForCapacity = *waitForCapacity
+	cfg.MinClusterSize = *minClusterSize
+	cfg.CapacityWait = *capacityWait
+	cfg.LearnerJoin = *learnerJoin || *canary
+	cfg.ScalingCooldown = *scalingCooldown
+	cfg.StaleGrace = *staleGrace
+	cfg.RemoveStale = *removeStale || *force
+	cfg.Force = *force
+	cfg.MaxRemovals = *maxRemovals
+	cfg.ForceRemove = *forceRemove
+	cfg.MinZones = *minZones
+	if *removalConfirmation != "off" {
+		cfg.RemovalConfirmation = reconcile.RemovalConfirmation(*removalConfirmation)
+		cfg.ConfirmedRemovals = *confirmRemovals
+	}
+	if *evenSize != "off" {
+		cfg.EvenSize = reconcile.EvenSize(*evenSize)
+	}
+}
+
+// newChangeLimiter returns nil unless --max-changes-per-interval is set
+func newChangeLimiter() *reconcile.ChangeLimiter {
+	if *maxChangesPerInterval <= 0 {
+		return nil
+	}
+	return &reconcile.ChangeLimiter{Max: *maxChangesPerInterval, Window: *interval}
+}
+
+func superviseOptions(unit string) reconcile.SuperviseOptions {
+	return reconcile.SuperviseOptions{
+		Interval:           *interval,
+		RestartUnit:        unit,
+		RestartMinInterval: *restartMinInterval,
+		SystemdReload:      *systemdReload,
+		VerifyTimeout:      *verifyTimeout,
+		StepDown:           *stepDown,
+		Lock:               lockRun,
+	}
 }
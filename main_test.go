@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestConfigActions(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		unit        string
+		reload      bool
+		changed     bool
+		localActive bool
+		restart     bool
+		reloaded    bool
+	}{
+		{name: "unit restarted on a change, with --systemd-reload", unit: "etcd.service", reload: true, changed: true, localActive: true, restart: true},
+		{name: "unit restarted for an inactive member", unit: "etcd.service", localActive: false, restart: true},
+		{name: "unit left alone", unit: "etcd.service", reload: true, localActive: true},
+		{name: "systemd reloaded on a change", reload: true, changed: true, reloaded: true},
+		{name: "systemd left alone without a change", reload: true, localActive: true},
+		{name: "nothing without reload", changed: true},
+	} {
+		restart, reloaded := configActions(tc.unit, tc.reload, tc.changed, tc.localActive)
+		if restart != tc.restart || reloaded != tc.reloaded {
+			t.Errorf("%s: got restart %t reload %t, want %t %t", tc.name, restart, reloaded, tc.restart, tc.reloaded)
+		}
+	}
+}
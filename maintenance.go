@@ -0,0 +1,55 @@
+package main
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	compactInterval = kingpin.Flag(
+		"compact-interval",
+		"In daemon mode, have the leader compact old revisions this often, 0 never.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_COMPACT_INTERVAL",
+	).Duration()
+	compactRetain = kingpin.Flag(
+		"compact-retain",
+		"How many revisions compaction keeps.",
+	).Default(
+		"10000",
+	).Envar(
+		"ETCDMATE_COMPACT_RETAIN",
+	).Int64()
+	defragInterval = kingpin.Flag(
+		"defrag-interval",
+		"In daemon mode, have the leader defragment the members one at a time this often, 0 never.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_DEFRAG_INTERVAL",
+	).Duration()
+	defragTimeout = kingpin.Flag(
+		"defrag-timeout",
+		"How long each member may take to defragment and be healthy again.",
+	).Default(
+		"5m",
+	).Envar(
+		"ETCDMATE_DEFRAG_TIMEOUT",
+	).Duration()
+)
+
+// newMaintenance returns nil unless compaction or defragmentation is on
+func newMaintenance() *reconcile.Maintenance {
+	if *compactInterval <= 0 && *defragInterval <= 0 {
+		return nil
+	}
+	return &reconcile.Maintenance{
+		CompactInterval: *compactInterval,
+		Retain:          *compactRetain,
+		DefragInterval:  *defragInterval,
+		Wait:            *defragTimeout,
+	}
+}
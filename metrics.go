@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/metrics"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+	"github.com/viruxel/etcdmate/pkg/wiretrace"
+)
+
+var (
+	statsdAddr = kingpin.Flag(
+		"statsd-addr",
+		"Send the metrics to this statsd server, host:port over UDP.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_STATSD_ADDR",
+	).String()
+	statsdTags = kingpin.Flag(
+		"statsd-tags",
+		"Comma separated key:value tags added to every metric, implies --statsd-dogstatsd.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_STATSD_TAGS",
+	).String()
+	pushgatewayURL = kingpin.Flag(
+		"pushgateway-url",
+		"Push the metrics of one-shot runs to this Prometheus Pushgateway.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_PUSHGATEWAY_URL",
+	).String()
+	textfile = kingpin.Flag(
+		"textfile",
+		"Write the metrics of one-shot runs to this file for the node_exporter textfile collector, e.g. /var/lib/node_exporter/textfile/etcdmate.prom.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TEXTFILE",
+	).String()
+	metricsAddr = kingpin.Flag(
+		"metrics-addr",
+		"Serve the metrics of the daemon on /metrics of this TCP address, e.g. 127.0.0.1:9192.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_METRICS_ADDR",
+	).String()
+	statsdDogStatsD = kingpin.Flag(
+		"statsd-dogstatsd",
+		"Send labels as DogStatsD tags instead of appending them to the metric name.",
+	).Envar(
+		"ETCDMATE_STATSD_DOGSTATSD",
+	).Bool()
+)
+
+// newMetrics returns the registry feeding the configured sinks, nil when
+// metrics aren't used
+func newMetrics() (*metrics.Registry, error) {
+	sinks := []metrics.Sink{}
+	if *statsdAddr != "" {
+		statsd, err := metrics.NewStatsD(*statsdAddr)
+		if err != nil {
+			return nil, err
+		}
+		statsd.Logger = log.Default()
+		if *statsdTags != "" {
+			statsd.Tags = strings.Split(*statsdTags, ",")
+			statsd.DogStatsD = true
+		}
+		if *statsdDogStatsD {
+			statsd.DogStatsD = true
+		}
+		sinks = append(sinks, statsd)
+	}
+	oneShot := (*pushgatewayURL != "" || *textfile != "") && !*daemon && !*dryRun
+	scraped := *metricsAddr != "" && *daemon && !*dryRun
+	if len(sinks) == 0 && !oneShot && !scraped {
+		return nil, nil
+	}
+	return metrics.NewRegistry(sinks...), nil
+}
+
+// serveMetrics serves the metrics of the daemon on --metrics-addr until ctx
+// is done
+func serveMetrics(ctx context.Context, registry *metrics.Registry) {
+	if registry == nil || *metricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	srv := &http.Server{Addr: *metricsAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("Serving metrics stopped:", err)
+		}
+	}()
+}
+
+// writeTextfile writes the metrics of a one-shot run to --textfile, with
+// when it ended, whether it succeeded and how many changes it made
+func writeTextfile(registry *metrics.Registry, err error) {
+	if registry == nil || *textfile == "" || *daemon || *dryRun {
+		return
+	}
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	registry.Set("etcdmate_last_run_timestamp_seconds", float64(time.Now().Unix()), nil)
+	registry.Set("etcdmate_last_run_success", success, nil)
+	registry.Set("etcdmate_last_run_changes", float64(len(result.changes)), nil)
+	if err := registry.WriteTextfile(*textfile); err != nil {
+		log.Println("Writing the textfile failed:", err)
+	}
+}
+
+// pushMetrics sends the outcome of a one-shot run to the Pushgateway,
+// grouped by instance so every member keeps its last run
+func pushMetrics(registry *metrics.Registry, summary *reconcile.Summary, instanceID string) {
+	if registry == nil || *pushgatewayURL == "" || *daemon || *dryRun {
+		return
+	}
+	if summary != nil {
+		for _, phase := range summary.Phases {
+			registry.Set(
+				"etcdmate_phase_duration_seconds",
+				phase.Seconds,
+				metrics.Labels{"phase": phase.Name},
+			)
+		}
+		registry.Set("etcdmate_run_duration_seconds", summary.Total, nil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := &http.Client{}
+	if traceRequests() {
+		client = wiretrace.Client(client, traceLogger())
+	}
+	err := registry.Push(ctx, client, *pushgatewayURL, "etcdmate", metrics.Labels{"instance": instanceID})
+	if err != nil {
+		log.Println("Pushing metrics failed:", err)
+	}
+}
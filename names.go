@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	nameSource = kingpin.Flag(
+		"name-source",
+		"What the members are named after: instance-id, private-dns, tag:KEY, or az-index for their zone and an index claimed per zone, e.g. us-east-1a-0.",
+	).Default(
+		"instance-id",
+	).Envar(
+		"ETCDMATE_NAME_SOURCE",
+	).String()
+	clusterToken = kingpin.Flag(
+		"cluster-token",
+		"The ETCD_INITIAL_CLUSTER_TOKEN when no bootstrap chose one, auto derives it from the account, region and Autoscaling group. etcd's default if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_CLUSTER_TOKEN",
+	).String()
+)
+
+// claimZoneIndex gives the local instance its index in its zone, which
+// --name-source az-index names it after. Dry runs claim nothing, the name
+// of an instance without an index is then reported as missing.
+func claimZoneIndex(ctx context.Context, svc discovery.AWS, source discovery.Source, instanceID string) {
+	if *nameSource != "az-index" || *memberNameTemplate != "" || source != nil || proxying() || *dryRun {
+		return
+	}
+	index, err := svc.ClaimZoneIndex(ctx, instanceID)
+	if err != nil {
+		exit(err)
+	}
+	log.Println("Zone index", index)
+}
+
+// newClusterToken is the --cluster-token, derived from the Autoscaling group
+// of the local instance with auto
+func newClusterToken(ctx context.Context, svc discovery.AWS, metadata ec2metadata.EC2InstanceIdentityDocument) (string, error) {
+	if *clusterToken != "auto" {
+		return *clusterToken, nil
+	}
+	asgName, err := svc.GetAsg(ctx, metadata.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	return reconcile.DeriveClusterToken(metadata.AccountID, metadata.Region, asgName), nil
+}
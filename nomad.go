@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+)
+
+var (
+	nomadJob = kingpin.Flag(
+		"nomad-job",
+		"Discover the members from the allocations of this Nomad job instead of the Autoscaling group, needs --identity nomad.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_NOMAD_JOB",
+	).String()
+	nomadService = kingpin.Flag(
+		"nomad-service",
+		"Nomad service the allocations of --nomad-job register, its address is that of the member.",
+	).Default(
+		"etcd",
+	).Envar(
+		"ETCDMATE_NOMAD_SERVICE",
+	).String()
+	nomadAddr = kingpin.Flag(
+		"nomad-addr",
+		"Address of the Nomad HTTP API.",
+	).Default(
+		"http://127.0.0.1:4646",
+	).Envar(
+		"ETCDMATE_NOMAD_ADDR",
+	).String()
+	nomadToken = kingpin.Flag(
+		"nomad-token",
+		"ACL token for the Nomad API, allowed to read the job and its services.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_NOMAD_TOKEN",
+	).String()
+	nomadNamespace = kingpin.Flag(
+		"nomad-namespace",
+		"Nomad namespace of --nomad-job.",
+	).Default(
+		"default",
+	).Envar(
+		"ETCDMATE_NOMAD_NAMESPACE",
+	).String()
+)
+
+// newSource returns the Nomad discovery with --nomad-job, the one of
+// --discovery tags, dns or static, the managed instance group or scale set one with
+// --cloud gcp or azure, nil to discover the members from the Autoscaling
+// group
+func newSource(svc discovery.AWS, localIP string) (discovery.Source, error) {
+	switch *cloud {
+	case "gcp":
+		return discovery.GCP{Logger: log.Default()}, nil
+	case "azure":
+		return discovery.Azure{Logger: log.Default()}, nil
+	}
+	switch *discoveryMode {
+	case "tags":
+		return newTagSource(svc)
+	case "dns":
+		return newDNSSource(localIP), nil
+	case "static":
+		return newStaticSource(localIP)
+	}
+	if *nomadJob == "" {
+		return nil, nil
+	}
+	return discovery.Nomad{
+		Addr:      *nomadAddr,
+		Token:     *nomadToken,
+		Namespace: *nomadNamespace,
+		Job:       *nomadJob,
+		Service:   *nomadService,
+		Logger:    log.Default(),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var notifySNSTopic = kingpin.Flag(
+	"notify-sns-topic",
+	"Publish membership changes, bootstrap decisions and quorum recovery steps to this SNS topic ARN.",
+).Default(
+	"",
+).Envar(
+	"ETCDMATE_NOTIFY_SNS_TOPIC",
+).String()
+
+// notifySNS publishes the events worth telling operators about in the
+// background, handlers must not block
+func notifySNS(sess *session.Session, instanceID string, next reconcile.EventHandler) reconcile.EventHandler {
+	if *notifySNSTopic == "" {
+		return next
+	}
+	svc := sns.New(sess)
+	return func(e reconcile.Event) {
+		if next != nil {
+			next(e)
+		}
+		if e.Type == reconcile.EventReconcileError {
+			return
+		}
+		body, err := json.Marshal(struct {
+			reconcile.Event
+			InstanceID string
+		}{e, instanceID})
+		if err != nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_, err := svc.PublishWithContext(ctx, &sns.PublishInput{
+				TopicArn: notifySNSTopic,
+				Subject:  aws.String("etcdmate " + string(e.Type)),
+				Message:  aws.String(string(body)),
+			})
+			if err != nil {
+				log.Println("Publishing the", e.Type, "event failed:", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,228 @@
+// Package awsfixture records the AWS API calls of a session to a file and
+// replays them, so a run can be reproduced without the account it ran in.
+package awsfixture
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Call is a recorded request and its outcome, one JSON object per line
+type Call struct {
+	Service   string
+	Operation string
+	// Path tells apart the EC2 metadata lookups, which have no input
+	Path    string          `json:",omitempty"`
+	Input   json.RawMessage `json:",omitempty"`
+	Output  json.RawMessage `json:",omitempty"`
+	Code    string          `json:",omitempty"`
+	Message string          `json:",omitempty"`
+}
+
+func (c Call) key() string {
+	return strings.Join([]string{c.Service, c.Operation, c.Path, string(c.Input)}, " ")
+}
+
+var (
+	accountID = regexp.MustCompile(`\b\d{12}\b`)
+	secret    = regexp.MustCompile(`(?i)"([a-z]*(secret|token|password|accesskey)[a-z]*)":"[^"]*"`)
+)
+
+// sanitize masks account IDs and credentials, and leaves out the unset
+// fields the SDK structs are full of
+func sanitize(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(withoutNulls(value))
+	if err != nil {
+		return nil, err
+	}
+	data = accountID.ReplaceAll(data, []byte("000000000000"))
+	return secret.ReplaceAll(data, []byte(`"$1":"REDACTED"`)), nil
+}
+
+func withoutNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if field == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = withoutNulls(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = withoutNulls(v[i])
+		}
+	}
+	return value
+}
+
+// recorded tells whether a request is worth keeping, credentials never are
+func recorded(r *request.Request) bool {
+	return r.Operation.Name != "GetToken" && !strings.Contains(r.Operation.HTTPPath, "security-credentials")
+}
+
+func newCall(r *request.Request) (Call, error) {
+	call := Call{Service: r.ClientInfo.ServiceName, Operation: r.Operation.Name}
+	if r.Params == nil {
+		call.Path = r.Operation.HTTPPath
+		return call, nil
+	}
+	input, err := json.Marshal(r.Params)
+	if err != nil {
+		return call, err
+	}
+	call.Input, err = sanitize(input)
+	return call, err
+}
+
+// Record appends every completed call of the clients created from handlers
+// to w. Clients copy the handlers of their session when created, install
+// it before creating any.
+func Record(handlers *request.Handlers, w io.Writer) {
+	var mu sync.Mutex
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "awsfixture.Record",
+		Fn: func(r *request.Request) {
+			if !recorded(r) {
+				return
+			}
+			call, err := newCall(r)
+			if err != nil {
+				return
+			}
+			if r.Error != nil {
+				call.Code, call.Message = "Error", r.Error.Error()
+				if aerr, ok := r.Error.(awserr.Error); ok {
+					call.Code, call.Message = aerr.Code(), aerr.Message()
+				}
+			} else if r.Data != nil {
+				output, err := json.Marshal(r.Data)
+				if err == nil {
+					output, err = sanitize(output)
+				}
+				if err != nil {
+					return
+				}
+				call.Output = output
+			}
+			line, err := json.Marshal(call)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			w.Write(append(line, '\n'))
+		},
+	})
+}
+
+// Load reads the calls recorded in file
+func Load(file string) ([]Call, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	calls := []Call{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var call Call
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, scanner.Err()
+}
+
+// Replay answers the calls of the clients created from handlers with the
+// recorded ones instead of sending them. Identical calls get the recorded
+// answers in order, the last one repeating once they run out. A call that
+// wasn't recorded fails.
+func Replay(handlers *request.Handlers, calls []Call) {
+	var mu sync.Mutex
+	queues := map[string][]Call{}
+	for _, call := range calls {
+		queues[call.key()] = append(queues[call.key()], call)
+	}
+	next := func(key string) (Call, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		queue := queues[key]
+		if len(queue) == 0 {
+			return Call{}, false
+		}
+		if len(queue) > 1 {
+			queues[key] = queue[1:]
+		}
+		return queue[0], true
+	}
+	// Build runs for every client, even those replacing their Validate
+	// handlers, and before signing which would need credentials
+	handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "awsfixture.Replay",
+		Fn: func(r *request.Request) {
+			call, err := newCall(r)
+			if err != nil {
+				r.Error = err
+				return
+			}
+			recordedCall, ok := next(call.key())
+			if !ok {
+				r.Error = errors.New(fmt.Sprint("No recorded response for ", call.key()))
+				return
+			}
+			respond(r, recordedCall)
+		},
+	})
+}
+
+// respond replaces the remaining handlers of r with the recorded outcome
+func respond(r *request.Request, call Call) {
+	r.Handlers.Sign.Clear()
+	r.Handlers.Send.Clear()
+	r.Handlers.ValidateResponse.Clear()
+	r.Handlers.UnmarshalMeta.Clear()
+	r.Handlers.Unmarshal.Clear()
+	r.Handlers.UnmarshalError.Clear()
+	r.Handlers.Retry.Clear()
+	r.Handlers.Send.PushBack(func(r *request.Request) {
+		r.HTTPResponse = &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}
+		if call.Code != "" {
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = awserr.New(call.Code, call.Message, nil)
+		}
+	})
+	r.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		if r.Data != nil && len(call.Output) > 0 {
+			if err := json.Unmarshal(call.Output, r.Data); err != nil {
+				r.Error = err
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// PreRemoveDir is where PreRemoval keeps its snapshots under the prefix of
+// the scheduled ones, apart from their retention
+const PreRemoveDir = "pre-remove"
+
+// PreRemoval implements reconcile.SnapshotSink, it uploads a snapshot before
+// every removal of a member and keeps the Keep newest ones
+type PreRemoval struct {
+	Snapshotter Snapshotter
+	// Store is under PreRemoveDir
+	Store Store
+	Keep  int
+	// Dir holds the snapshot until it's uploaded
+	Dir    string
+	Logger logging.Logger
+}
+
+func (p PreRemoval) SaveSnapshot(ctx context.Context, hm etcd.Member) error {
+	f, err := ioutil.TempFile(p.Dir, "etcdmate-snapshot-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	// etcdctl refuses to overwrite
+	os.Remove(f.Name())
+	at := time.Now()
+	err = p.Snapshotter.Save(ctx, hm.ClientURL, f.Name())
+	if err != nil {
+		return err
+	}
+	b, err := p.Store.Upload(ctx, f.Name(), at)
+	if err != nil {
+		return err
+	}
+	p.log().Println("Uploaded snapshot before removal", b.Key, b.Size, "bytes")
+	backups, err := p.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	// Pruning only tidies up, the snapshot is there
+	for _, expired := range (Retention{Last: p.Keep}).Expired(backups) {
+		if err := p.Store.Delete(ctx, expired); err != nil {
+			p.log().Println("Deleting expired snapshot", expired.Key, "failed:", err)
+		}
+	}
+	return nil
+}
+
+func (p PreRemoval) log() logging.Logger {
+	return logging.OrDefault(p.Logger)
+}
@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Restorer implements reconcile.SnapshotSource with the stored backups
+type Restorer struct {
+	Store       Store
+	Snapshotter Snapshotter
+	// Dir holds the snapshot while it's restored
+	Dir string
+}
+
+func (r Restorer) RestoreLatest(ctx context.Context, dataDir string, m etcd.Member) error {
+	backups, err := r.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return errors.New("No backup to restore")
+	}
+	f, err := ioutil.TempFile(r.Dir, "etcdmate-restore-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	err = r.Store.Download(ctx, backups[len(backups)-1], f.Name())
+	if err != nil {
+		return err
+	}
+	return r.Snapshotter.Restore(ctx, f.Name(), dataDir, m.Name, m.PeerURL)
+}
@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention keeps the newest backup of each of the Daily most recent days
+// and of each of the Weekly most recent weeks that have backups, and the
+// Last most recent backups
+type Retention struct {
+	Daily  int
+	Weekly int
+	Last   int
+}
+
+// Expired returns the backups the policy doesn't keep. backups must be
+// sorted oldest first, the newest one is always kept.
+func (r Retention) Expired(backups []Backup) []Backup {
+	if len(backups) == 0 {
+		return nil
+	}
+	keep := map[string]bool{backups[len(backups)-1].Key: true}
+	keepNewest(backups, r.Daily, keep, func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	})
+	keepNewest(backups, r.Weekly, keep, func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprint(year, "-", week)
+	})
+	for i := len(backups) - 1; i >= 0 && i >= len(backups)-r.Last; i-- {
+		keep[backups[i].Key] = true
+	}
+	expired := []Backup{}
+	for _, b := range backups {
+		if !keep[b.Key] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// keepNewest marks the newest backup of each of the n most recent periods
+func keepNewest(backups []Backup, n int, keep map[string]bool, period func(time.Time) string) {
+	seen := map[string]bool{}
+	for i := len(backups) - 1; i >= 0 && len(seen) < n; i-- {
+		p := period(backups[i].Time)
+		if !seen[p] {
+			seen[p] = true
+			keep[backups[i].Key] = true
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/metrics"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+// Schedule takes a snapshot every Interval on the leader, or on Member when
+// set, and applies the retention policy. Every node reports the age of the
+// newest backup so a missing one alerts even if the leader is gone.
+type Schedule struct {
+	Config      reconcile.Config
+	Snapshotter Snapshotter
+	Store       Store
+	Retention   Retention
+	Interval    time.Duration
+	// Member is the name or instance ID of the member taking the
+	// snapshots, the leader if empty
+	Member string
+	// Dir holds the snapshot until it's uploaded
+	Dir string
+	// Verifier, when set, checks every uploaded snapshot
+	Verifier *Verifier
+}
+
+// Run takes backups until ctx is done
+func (s Schedule) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := s.RunOnce(ctx)
+		if err != nil {
+			s.Config.Metrics.Add("etcdmate_backups_total", 1, metrics.Labels{"result": "error"})
+			s.log().Println("Backup failed:", err)
+		}
+		s.reportAge(ctx)
+	}
+}
+
+// RunOnce takes a backup if the local member is the one to
+func (s Schedule) RunOnce(ctx context.Context) error {
+	cfg := s.Config
+	_, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	if s.Member != "" && s.Member != myself.Name && s.Member != myself.Instance {
+		return nil
+	}
+	if s.Member == "" {
+		leader, err := cfg.Client.IsLeader(ctx, myself)
+		if err != nil || !leader {
+			return err
+		}
+	}
+	f, err := ioutil.TempFile(s.Dir, "etcdmate-snapshot-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	// etcdctl refuses to overwrite
+	os.Remove(f.Name())
+	at := time.Now()
+	err = s.Snapshotter.Save(ctx, myself.ClientURL, f.Name())
+	if err != nil {
+		return err
+	}
+	b, err := s.Store.Upload(ctx, f.Name(), at)
+	if err != nil {
+		return err
+	}
+	s.log().Println("Uploaded backup", b.Key, b.Size, "bytes")
+	s.Config.Metrics.Add("etcdmate_backups_total", 1, metrics.Labels{"result": "success"})
+	if s.Verifier != nil {
+		s.verify(ctx, f.Name(), b)
+	}
+	return s.prune(ctx)
+}
+
+// verify records whether b is usable, a failure doesn't fail the backup
+// since the snapshot is kept either way
+func (s Schedule) verify(ctx context.Context, file string, b Backup) {
+	v := Verification{Time: time.Now(), Verified: true}
+	err := s.Verifier.Verify(ctx, file)
+	result := "success"
+	if err != nil {
+		v.Verified, v.Error, result = false, err.Error(), "error"
+		s.log().Println("Verifying backup", b.Key, "failed:", err)
+	} else {
+		s.log().Println("Verified backup", b.Key)
+	}
+	s.Config.Metrics.Add("etcdmate_backup_verifications_total", 1, metrics.Labels{"result": result})
+	err = s.Store.Record(ctx, b, v)
+	if err != nil {
+		s.log().Println("Recording the verification of", b.Key, "failed:", err)
+	}
+}
+
+func (s Schedule) prune(ctx context.Context) error {
+	backups, err := s.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, b := range s.Retention.Expired(backups) {
+		err := s.Store.Delete(ctx, b)
+		if err != nil {
+			return err
+		}
+		s.log().Println("Deleted expired backup", b.Key)
+	}
+	return nil
+}
+
+func (s Schedule) reportAge(ctx context.Context) {
+	backups, err := s.Store.List(ctx)
+	if err != nil {
+		s.log().Println("Listing backups failed:", err)
+		return
+	}
+	s.Config.Metrics.Set("etcdmate_backups", float64(len(backups)), nil)
+	if len(backups) == 0 {
+		return
+	}
+	newest := backups[len(backups)-1]
+	s.Config.Metrics.Set("etcdmate_backup_age_seconds", time.Since(newest.Time).Seconds(), nil)
+	s.Config.Metrics.Set("etcdmate_backup_last_timestamp_seconds", float64(newest.Time.Unix()), nil)
+}
+
+func (s Schedule) log() logging.Logger {
+	return logging.OrDefault(s.Config.Logger)
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Snapshotter saves snapshots with etcdctl, the v2 API has no equivalent
+type Snapshotter struct {
+	Etcdctl  string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Save writes a snapshot of the member serving endpoint to file
+func (s Snapshotter) Save(ctx context.Context, endpoint string, file string) error {
+	args := []string{"snapshot", "save", file, "--endpoints", endpoint}
+	if s.CAFile != "" {
+		args = append(args, "--cacert", s.CAFile)
+	}
+	if s.CertFile != "" {
+		args = append(args, "--cert", s.CertFile, "--key", s.KeyFile)
+	}
+	cmd := exec.CommandContext(ctx, s.Etcdctl, args...)
+	cmd.Env = append(os.Environ(), "ETCDCTL_API=3")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("etcdctl snapshot save failed: %v: %s", err, out))
+	}
+	return nil
+}
+
+// Restore creates dataDir from file for a cluster of the single member
+// name reachable at peerURL
+func (s Snapshotter) Restore(ctx context.Context, file string, dataDir string, name string, peerURL string) error {
+	cmd := exec.CommandContext(
+		ctx,
+		s.Etcdctl,
+		"snapshot", "restore", file,
+		"--data-dir", dataDir,
+		"--name", name,
+		"--initial-cluster", name+"="+peerURL,
+		"--initial-advertise-peer-urls", peerURL,
+	)
+	cmd.Env = append(os.Environ(), "ETCDCTL_API=3")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("etcdctl snapshot restore failed: %v: %s", err, out))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package backup takes etcd snapshots and keeps them in S3.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Backup is a snapshot stored in S3
+type Backup struct {
+	Key  string
+	Time time.Time
+	Size int64
+}
+
+// Store keeps snapshots under s3://Bucket/Prefix
+type Store struct {
+	S3     s3iface.S3API
+	Bucket string
+	Prefix string
+}
+
+// NewStore parses an s3://bucket/prefix URL
+func NewStore(svc s3iface.S3API, s3URL string) (Store, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return Store{}, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return Store{}, errors.New(fmt.Sprint("Invalid S3 URL ", s3URL))
+	}
+	return Store{S3: svc, Bucket: u.Host, Prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+// Upload stores file as <prefix>/<timestamp>.db
+func (s Store) Upload(ctx context.Context, file string, at time.Time) (Backup, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Backup{}, err
+	}
+	b := Backup{
+		Key:  path.Join(s.Prefix, at.UTC().Format("20060102T150405Z")+".db"),
+		Time: at,
+		Size: st.Size(),
+	}
+	_, err = s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(b.Key),
+		Body:   f,
+	})
+	return b, err
+}
+
+// List returns the stored snapshots, oldest first
+func (s Store) List(ctx context.Context) ([]Backup, error) {
+	prefix := s.Prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+	backups := []Backup{}
+	err := s.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			key := aws.StringValue(o.Key)
+			if !strings.HasSuffix(key, ".db") || strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+				continue
+			}
+			backups = append(backups, Backup{
+				Key:  key,
+				Time: aws.TimeValue(o.LastModified),
+				Size: aws.Int64Value(o.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// Delete removes b and its verification
+func (s Store) Delete(ctx context.Context, b Backup) error {
+	for _, key := range []string{b.Key, b.Key + ".verification.json"} {
+		_, err := s.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record stores the verification of b next to it
+func (s Store) Record(ctx context.Context, b Backup, v Verification) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(b.Key + ".verification.json"),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Download writes the content of b to file
+func (s Store) Download(ctx context.Context, b Backup, file string) error {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(b.Key),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, out.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Verifier checks a snapshot is usable by restoring it into a temporary
+// data dir and starting a single member etcd on it
+type Verifier struct {
+	Etcdctl string
+	Etcd    string
+	// Dir holds the temporary data dir
+	Dir     string
+	Timeout time.Duration
+	Client  etcd.Client
+}
+
+// Verification is stored next to the backup as <key>.verification.json
+type Verification struct {
+	Time     time.Time
+	Verified bool
+	Error    string `json:",omitempty"`
+}
+
+func (v Verifier) Verify(ctx context.Context, file string) error {
+	dir, err := ioutil.TempDir(v.Dir, "etcdmate-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	clientURL, err := localURL()
+	if err != nil {
+		return err
+	}
+	peerURL, err := localURL()
+	if err != nil {
+		return err
+	}
+	dataDir := path.Join(dir, "data")
+	err = Snapshotter{Etcdctl: v.Etcdctl}.Restore(ctx, file, dataDir, "verify", peerURL)
+	if err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server := exec.CommandContext(
+		runCtx,
+		v.Etcd,
+		"--name", "verify",
+		"--data-dir", dataDir,
+		"--listen-client-urls", clientURL,
+		"--advertise-client-urls", clientURL,
+		"--listen-peer-urls", peerURL,
+		"--initial-advertise-peer-urls", peerURL,
+		"--initial-cluster", "verify="+peerURL,
+	)
+	logFile, err := os.Create(path.Join(dir, "etcd.log"))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	server.Stdout, server.Stderr = logFile, logFile
+	err = server.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- server.Wait() }()
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	member := etcd.Member{Name: "verify", ClientURL: clientURL}
+	deadline := time.Now().Add(v.Timeout)
+	for {
+		err = v.Client.CheckHealth(ctx, member)
+		if err == nil {
+			return nil
+		}
+		select {
+		case werr := <-exited:
+			exited <- werr
+			return errors.New(fmt.Sprint("etcd exited serving the restored snapshot: ", werr, ": ", tail(logFile.Name())))
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("etcd serving the restored snapshot isn't healthy: ", err))
+		}
+	}
+}
+
+// localURL returns the URL of a free loopback port
+func localURL() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return "http://" + l.Addr().String(), nil
+}
+
+// tail returns the end of file for error messages
+func tail(file string) string {
+	data, _ := ioutil.ReadFile(file)
+	if len(data) > 512 {
+		data = data[len(data)-512:]
+	}
+	return string(data)
+}
@@ -0,0 +1,99 @@
+// Package certs obtains the TLS material etcd and etcdmate use, so it
+// doesn't have to be baked into machine images.
+package certs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Issuer returns PEM encoded CA bundle, certificate and key
+type Issuer interface {
+	Issue(ctx context.Context, req Request) (ca, cert, key []byte, err error)
+}
+
+// Request describes the certificate to issue for the local member
+type Request struct {
+	CommonName string
+	IPs        []string
+	DNSNames   []string
+	TTL        string
+}
+
+// Files are the paths of issued TLS material
+type Files struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// FilesIn returns the paths WriteFiles uses
+func FilesIn(dir string, name string) Files {
+	return Files{
+		CAFile:   path.Join(dir, "ca.pem"),
+		CertFile: path.Join(dir, name+".pem"),
+		KeyFile:  path.Join(dir, name+"-key.pem"),
+	}
+}
+
+// WriteFiles stores PEM encoded material in dir as <name>.pem,
+// <name>-key.pem and ca.pem, the key readable by the owner only.
+func WriteFiles(dir string, name string, ca, cert, key []byte) (Files, error) {
+	files := FilesIn(dir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return files, err
+	}
+	// Write the key first so the pair never references a missing key
+	err = writeFile(files.KeyFile, key, 0600)
+	if err != nil {
+		return files, err
+	}
+	err = writeFile(files.CertFile, cert, 0644)
+	if err != nil {
+		return files, err
+	}
+	return files, writeFile(files.CAFile, ca, 0644)
+}
+
+// writeFile replaces file atomically so readers never see partial content
+func writeFile(file string, data []byte, mode os.FileMode) error {
+	tmp := file + ".tmp"
+	err := ioutil.WriteFile(tmp, data, mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Backup copies the files next to themselves with a .bak suffix
+func (f Files) Backup() error {
+	for _, file := range []string{f.KeyFile, f.CertFile, f.CAFile} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		st, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		err = writeFile(file+".bak", data, st.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore puts the files saved by Backup back in place
+func (f Files) Restore() error {
+	for _, file := range []string{f.KeyFile, f.CertFile, f.CAFile} {
+		err := os.Rename(file+".bak", file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// CFSSL has a cfssl remote signer sign a locally generated key, the key
+// never leaves the instance.
+type CFSSL struct {
+	URL     string
+	Profile string
+	// AuthKey is the hex encoded key of an authenticated signer
+	AuthKey    string
+	HTTPClient *http.Client
+}
+
+func (c CFSSL) Issue(ctx context.Context, req Request) (ca, cert, key []byte, err error) {
+	csr, key, err := newCSR(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signReq, err := json.Marshal(map[string]interface{}{
+		"certificate_request": string(csr),
+		"hosts":               append(append([]string{}, req.IPs...), req.DNSNames...),
+		"profile":             c.Profile,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	endpoint := "sign"
+	if c.AuthKey != "" {
+		endpoint = "authsign"
+		signReq, err = c.authenticate(signReq)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	cert, err = c.call(ctx, endpoint, signReq)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	infoReq, _ := json.Marshal(map[string]string{"profile": c.Profile})
+	ca, err = c.call(ctx, "info", infoReq)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ca, cert, key, nil
+}
+
+func (c CFSSL) authenticate(request []byte) ([]byte, error) {
+	authKey, err := hex.DecodeString(c.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, authKey)
+	mac.Write(request)
+	// []byte fields are base64 encoded by encoding/json
+	return json.Marshal(map[string][]byte{
+		"token":   mac.Sum(nil),
+		"request": request,
+	})
+}
+
+// call posts to a cfssl API endpoint and returns the certificate it returned
+func (c CFSSL) call(ctx context.Context, endpoint string, body []byte) ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v1/cfssl/%s", strings.TrimSuffix(c.URL, "/"), endpoint)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var jresp struct {
+		Success bool `json:"success"`
+		Result  struct {
+			Certificate string `json:"certificate"`
+		} `json:"result"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err = json.Unmarshal(data, &jresp)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cfssl %s failed: %d %s", endpoint, resp.StatusCode, data))
+	}
+	if !jresp.Success || jresp.Result.Certificate == "" {
+		return nil, errors.New(fmt.Sprintf("cfssl %s failed: %+v", endpoint, jresp.Errors))
+	}
+	return []byte(strings.TrimSpace(jresp.Result.Certificate) + "\n"), nil
+}
+
+// newCSR generates a P-256 key and a certificate request for it
+func newCSR(req Request) (csrPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: req.CommonName},
+		DNSNames: req.DNSNames,
+	}
+	for _, ip := range req.IPs {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			template.IPAddresses = append(template.IPAddresses, parsed)
+		}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return csrPEM, keyPEM, nil
+}
@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// SSMAPI is the subset of the SSM API the cluster CA uses
+type SSMAPI interface {
+	GetParameterWithContext(aws.Context, *ssm.GetParameterInput, ...request.Option) (*ssm.GetParameterOutput, error)
+	PutParameterWithContext(aws.Context, *ssm.PutParameterInput, ...request.Option) (*ssm.PutParameterOutput, error)
+}
+
+// ClusterCA signs member certificates with a cluster CA kept in an SSM
+// SecureString parameter. The first node to find the parameter missing
+// generates the CA, the others use it.
+type ClusterCA struct {
+	SSM       SSMAPI
+	Parameter string
+	// KMSKeyID encrypts the parameter, the account default key when empty
+	KMSKeyID string
+}
+
+func (c ClusterCA) Issue(ctx context.Context, req Request) (ca, cert, key []byte, err error) {
+	caCert, caKey, err := c.loadOrCreate(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ttl := 30 * 24 * time.Hour
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	memberKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.CommonName},
+		DNSNames:     req.DNSNames,
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		// Members use the same certificate as server and as client
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, ip := range req.IPs {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			template.IPAddresses = append(template.IPAddresses, parsed)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &memberKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(memberKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return ca, cert, key, nil
+}
+
+func (c ClusterCA) loadOrCreate(ctx context.Context) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caCert, caKey, err := c.load(ctx)
+	if err == nil || !isParameterNotFound(err) {
+		return caCert, caKey, err
+	}
+	value, err := newCA()
+	if err != nil {
+		return nil, nil, err
+	}
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(c.Parameter),
+		Type:      aws.String(ssm.ParameterTypeSecureString),
+		Value:     aws.String(string(value)),
+		Overwrite: aws.Bool(false),
+	}
+	if c.KMSKeyID != "" {
+		input.KeyId = aws.String(c.KMSKeyID)
+	}
+	_, err = c.SSM.PutParameterWithContext(ctx, input)
+	// Another node created it first, use that one
+	if err != nil && !isAWSError(err, ssm.ErrCodeParameterAlreadyExists) {
+		return nil, nil, err
+	}
+	return c.load(ctx)
+}
+
+func (c ClusterCA) load(ctx context.Context) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	resp, err := c.SSM.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(c.Parameter),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var caCert *x509.Certificate
+	var caKey *ecdsa.PrivateKey
+	rest := []byte(aws.StringValue(resp.Parameter.Value))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			caCert, err = x509.ParseCertificate(block.Bytes)
+		case "EC PRIVATE KEY":
+			caKey, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if caCert == nil || caKey == nil {
+		return nil, nil, errors.New(fmt.Sprint("No CA certificate and key in parameter ", c.Parameter))
+	}
+	return caCert, caKey, nil
+}
+
+// newCA returns the PEM encoded certificate and key of a new CA
+func newCA() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "etcdmate cluster CA"},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	value := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	value = append(value, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	return value, nil
+}
+
+func isParameterNotFound(err error) bool {
+	return isAWSError(err, ssm.ErrCodeParameterNotFound)
+}
+
+func isAWSError(err error, code string) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == code
+}
@@ -0,0 +1,59 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// SPIRE fetches the workload X.509 SVID from the local SPIRE agent. The
+// SPIFFE ID comes from the agent registration, the request is ignored.
+type SPIRE struct {
+	// AgentPath is the spire-agent binary, looked up in PATH by default
+	AgentPath  string
+	SocketPath string
+}
+
+func (s SPIRE) Issue(ctx context.Context, req Request) (ca, cert, key []byte, err error) {
+	dir, err := ioutil.TempDir("", "etcdmate-svid")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	agent := s.AgentPath
+	if agent == "" {
+		agent = "spire-agent"
+	}
+	out, err := exec.CommandContext(
+		ctx,
+		agent,
+		"api",
+		"fetch",
+		"x509",
+		"-socketPath",
+		s.SocketPath,
+		"-write",
+		dir,
+	).CombinedOutput()
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("Fetching SVID failed: %v: %s", err, out))
+	}
+	// The first SVID is the default one
+	cert, err = ioutil.ReadFile(path.Join(dir, "svid.0.pem"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err = ioutil.ReadFile(path.Join(dir, "svid.0.key"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca, err = ioutil.ReadFile(path.Join(dir, "bundle.0.pem"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ca, cert, key, nil
+}
@@ -0,0 +1,134 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Vault issues certificates from a Vault PKI mount, logging in with the
+// AWS IAM auth method using the instance role.
+type Vault struct {
+	Address   string
+	AuthMount string
+	AuthRole  string
+	PKIMount  string
+	PKIRole   string
+	// ServerID is the iam_server_id_header_value configured in Vault, if any
+	ServerID   string
+	Session    *session.Session
+	HTTPClient *http.Client
+}
+
+// Issue logs in and requests a certificate
+func (v Vault) Issue(ctx context.Context, req Request) (ca, cert, key []byte, err error) {
+	token, err := v.login(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var resp struct {
+		Data struct {
+			Certificate string   `json:"certificate"`
+			PrivateKey  string   `json:"private_key"`
+			IssuingCA   string   `json:"issuing_ca"`
+			CAChain     []string `json:"ca_chain"`
+		} `json:"data"`
+	}
+	err = v.post(ctx, fmt.Sprintf("%s/issue/%s", v.PKIMount, v.PKIRole), token, map[string]string{
+		"common_name": req.CommonName,
+		"ip_sans":     strings.Join(req.IPs, ","),
+		"alt_names":   strings.Join(req.DNSNames, ","),
+		"ttl":         req.TTL,
+	}, &resp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca = []byte(resp.Data.IssuingCA + "\n")
+	if len(resp.Data.CAChain) > 0 {
+		ca = []byte(strings.Join(resp.Data.CAChain, "\n") + "\n")
+	}
+	return ca, []byte(resp.Data.Certificate + "\n"), []byte(resp.Data.PrivateKey + "\n"), nil
+}
+
+// login proves the instance identity with a signed sts:GetCallerIdentity
+// request that Vault replays to AWS
+func (v Vault) login(ctx context.Context) (string, error) {
+	req, _ := sts.New(v.Session).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.SetContext(ctx)
+	if v.ServerID != "" {
+		req.HTTPRequest.Header.Add("X-Vault-AWS-IAM-Server-ID", v.ServerID)
+	}
+	err := req.Sign()
+	if err != nil {
+		return "", err
+	}
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return "", err
+	}
+	body, err := ioutil.ReadAll(req.HTTPRequest.Body)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err = v.post(ctx, v.AuthMount+"/login", "", map[string]string{
+		"role":                    v.AuthRole,
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("Vault login returned no token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func (v Vault) post(ctx context.Context, apiPath string, token string, in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(v.Address, "/"), apiPath)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Vault %s failed: %d %s", apiPath, resp.StatusCode, body))
+	}
+	return json.Unmarshal(body, out)
+}
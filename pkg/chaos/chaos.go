@@ -0,0 +1,173 @@
+// Package chaos injects failures at defined points so the retry, resume and
+// recovery logic can be exercised in CI. It is never enabled in production.
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Chaos decides which calls fail. The same spec and seed make the same
+// decisions for the same sequence of calls.
+type Chaos struct {
+	// AWSThrottle is the probability of an AWS call being throttled
+	AWSThrottle float64
+	// EtcdTimeout is the probability of an etcd request timing out
+	EtcdTimeout float64
+	// PartialMembers is the probability of a member list missing a member
+	PartialMembers float64
+	// Crash is the event type after which the process exits
+	Crash string
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Parse reads a spec like
+// "aws-throttle=0.2,etcd-timeout=0.1,partial-members=0.3,crash=member-added,seed=42"
+func Parse(spec string) (*Chaos, error) {
+	c := &Chaos{}
+	seed := int64(1)
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New(fmt.Sprint("Invalid chaos setting ", part))
+		}
+		var err error
+		switch kv[0] {
+		case "aws-throttle":
+			c.AWSThrottle, err = probability(kv[1])
+		case "etcd-timeout":
+			c.EtcdTimeout, err = probability(kv[1])
+		case "partial-members":
+			c.PartialMembers, err = probability(kv[1])
+		case "crash":
+			c.Crash = kv[1]
+		case "seed":
+			seed, err = strconv.ParseInt(kv[1], 10, 64)
+		default:
+			err = errors.New(fmt.Sprint("Unknown chaos setting ", kv[0]))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.rand = rand.New(rand.NewSource(seed))
+	return c, nil
+}
+
+func probability(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, errors.New(fmt.Sprint("Chaos probability ", value, " isn't between 0 and 1"))
+	}
+	return p, nil
+}
+
+func (c *Chaos) roll(p float64) bool {
+	if p == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < p
+}
+
+// Throttle makes AWS calls of the clients created from handlers fail with
+// throttling errors, which the SDK retries. EC2 metadata lookups are spared.
+// Install it before awsfixture.Replay for both to apply.
+func (c *Chaos) Throttle(handlers *request.Handlers) {
+	if c.AWSThrottle == 0 {
+		return
+	}
+	handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "chaos.Throttle",
+		Fn: func(r *request.Request) {
+			if r.ClientInfo.ServiceName == "ec2metadata" {
+				return
+			}
+			// Send runs again for every retry, the failed attempt must stop
+			// before the real send
+			r.Handlers.Send.AfterEachFn = request.HandlerListStopOnError
+			r.Handlers.Send.PushFront(func(r *request.Request) {
+				if !c.roll(c.AWSThrottle) {
+					return
+				}
+				r.HTTPResponse = &http.Response{
+					StatusCode: http.StatusBadRequest,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}
+				r.Error = awserr.New("Throttling", "Rate exceeded (chaos)", nil)
+			})
+		},
+	})
+}
+
+// Transport makes etcd requests time out and member lists miss members
+func (c *Chaos) Transport(next http.RoundTripper) http.RoundTripper {
+	return transport{chaos: c, next: next}
+}
+
+type transport struct {
+	chaos *Chaos
+	next  http.RoundTripper
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout (chaos)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.chaos.roll(t.chaos.EtcdTimeout) {
+		return nil, timeoutError{}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != "GET" || !strings.HasSuffix(req.URL.Path, "/v2/members") ||
+		resp.StatusCode != http.StatusOK || !t.chaos.roll(t.chaos.PartialMembers) {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	var members map[string][]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, err
+	}
+	if list := members["members"]; len(list) > 0 {
+		t.chaos.mu.Lock()
+		i := t.chaos.rand.Intn(len(list))
+		t.chaos.mu.Unlock()
+		members["members"] = append(list[:i:i], list[i+1:]...)
+	}
+	body, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// Crashes tells whether the process should exit after an event of type
+func (c *Chaos) Crashes(eventType string) bool {
+	return c.Crash != "" && c.Crash == eventType
+}
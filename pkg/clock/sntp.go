@@ -0,0 +1,64 @@
+// Package clock measures how far the local clock is from an NTP server.
+// etcd leases and TLS certificates rely on the clocks of the members agreeing.
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// AmazonTimeSync is the Amazon Time Sync Service, reachable from every
+// instance without leaving the host
+const AmazonTimeSync = "169.254.169.123:123"
+
+// ntpEpoch is 1900-01-01, the origin of NTP timestamps
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Offset queries server with SNTP and returns how far the local clock is
+// from it, positive when the local clock is behind
+func Offset(ctx context.Context, server string) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+	req := make([]byte, 48)
+	// Leap indicator 0, version 3, client mode
+	req[0] = 0x1b
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 || resp[0]&0x7 != 4 {
+		return 0, errors.New(fmt.Sprint("Invalid NTP response from ", server))
+	}
+	if resp[1] == 0 {
+		return 0, errors.New(fmt.Sprint("NTP server ", server, " isn't synchronized"))
+	}
+	serverReceived := ntpTime(resp[32:40])
+	serverSent := ntpTime(resp[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:4])
+	fraction := binary.BigEndian.Uint32(b[4:8])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return ntpEpoch.Add(time.Duration(seconds)*time.Second + time.Duration(nanos))
+}
@@ -0,0 +1,278 @@
+// Package control serves a small HTTP API on a unix socket so operators and
+// node tooling can talk to a running etcdmate daemon.
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+// Status is the outcome of the last reconcile pass
+type Status struct {
+	State     reconcile.State `json:"state"`
+	LastRun   time.Time       `json:"lastRun"`
+	LastError string          `json:"lastError,omitempty"`
+	// Paused is why the automatic changes are paused, a paused pass
+	// counts as successful for the health checks
+	Paused string `json:"paused,omitempty"`
+}
+
+// Health is what /healthz and /readyz report
+type Health struct {
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	// Age is the seconds since the last successful pass, or since the
+	// start before the first one
+	Age       float64 `json:"age"`
+	LastError string  `json:"lastError,omitempty"`
+}
+
+// Server exposes:
+//
+//	GET  /status     the last reconcile outcome
+//	POST /reconcile  start a pass now
+//	GET  /plan       what a pass would change
+//	POST /leave      remove the local member and stop the daemon
+//	GET  /healthz    200 unless no pass succeeded for MaxStaleness
+//	GET  /readyz     200 if the last pass succeeded within MaxStaleness
+//	GET  /debug/pprof/  the runtime profiles, only with Debug set
+type Server struct {
+	Config reconcile.Config
+	Socket string
+	// Token, when set, must be sent as a bearer token. The socket is
+	// only accessible by its owner regardless. Health checks don't need it.
+	Token string
+	// OnLeave is called after the local member left the cluster
+	OnLeave func()
+	// MaxStaleness is how old the last successful pass may be before the
+	// daemon is reported unhealthy, 0 never
+	MaxStaleness time.Duration
+	// Debug serves the net/http/pprof handlers
+	Debug bool
+
+	mu          sync.Mutex
+	status      Status
+	started     time.Time
+	lastSuccess time.Time
+	trigger     chan struct{}
+}
+
+func New(cfg reconcile.Config, socket string, token string) *Server {
+	return &Server{
+		Config:  cfg,
+		Socket:  socket,
+		Token:   token,
+		started: time.Now(),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Trigger is meant for SuperviseOptions.Trigger
+func (s *Server) Trigger() <-chan struct{} {
+	return s.trigger
+}
+
+// Report is meant for SuperviseOptions.Report
+func (s *Server) Report(state reconcile.State, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = Status{State: state, LastRun: time.Now()}
+	switch {
+	case errors.Is(err, reconcile.ErrPaused):
+		s.status.Paused = err.Error()
+		s.lastSuccess = s.status.LastRun
+	case errors.Is(err, reconcile.ErrRateLimited):
+		// The limit holds the pass back on purpose, it isn't stuck
+		s.status.LastError = err.Error()
+		s.lastSuccess = s.status.LastRun
+	case err != nil:
+		s.status.LastError = err.Error()
+	default:
+		s.lastSuccess = s.status.LastRun
+	}
+}
+
+// Serve listens on the socket until ctx is done
+func (s *Server) Serve(ctx context.Context) error {
+	// A socket left behind by a previous run would make Listen fail
+	err := os.Remove(s.Socket)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.Socket)
+	err = os.Chmod(s.Socket, 0600)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	return serve(ctx, l, s.Handler())
+}
+
+// ServeHealth serves only the health checks on a TCP address until ctx is
+// done, for probes that can't use the socket
+func (s *Server) ServeHealth(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	s.handleHealth(mux)
+	return serve(ctx, l, mux)
+}
+
+func serve(ctx context.Context, l net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err := srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.only("GET", s.handleStatus))
+	mux.HandleFunc("/reconcile", s.only("POST", s.handleReconcile))
+	mux.HandleFunc("/plan", s.only("GET", s.handlePlan))
+	mux.HandleFunc("/leave", s.only("POST", s.handleLeave))
+	s.handleHealth(mux)
+	if s.Debug {
+		mux.HandleFunc("/debug/pprof/", s.authorized(pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", s.authorized(pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", s.authorized(pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", s.authorized(pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", s.authorized(pprof.Trace))
+	}
+	return mux
+}
+
+func (s *Server) handleHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.health(false))
+	mux.HandleFunc("/readyz", s.health(true))
+}
+
+func (s *Server) only(method string, h http.HandlerFunc) http.HandlerFunc {
+	return s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			auth := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(auth, []byte("Bearer "+s.Token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+	writeJSON(w, status)
+}
+
+// health answers 503 when the daemon is stale, or with ready set also
+// when the last pass failed or none ran yet
+func (s *Server) health(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.mu.Lock()
+		health := Health{
+			LastRun:     s.status.LastRun,
+			LastSuccess: s.lastSuccess,
+			LastError:   s.status.LastError,
+		}
+		since := s.lastSuccess
+		if since.IsZero() {
+			since = s.started
+		}
+		s.mu.Unlock()
+		age := time.Since(since)
+		health.Age = age.Seconds()
+		ok := s.MaxStaleness == 0 || age <= s.MaxStaleness
+		if ready {
+			ok = ok && !health.LastSuccess.IsZero() && health.LastError == ""
+		}
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(health)
+			return
+		}
+		writeJSON(w, health)
+	}
+}
+
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A pass is already pending
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	cfg := s.Config
+	state, err := cfg.LoadState(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plan, err := cfg.Reconciler(state.ClusterToken).Plan(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, plan)
+}
+
+func (s *Server) handleLeave(w http.ResponseWriter, r *http.Request) {
+	err := reconcile.Leave(r.Context(), s.Config, reconcile.LeaveOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	if s.OnLeave != nil {
+		s.OnLeave()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
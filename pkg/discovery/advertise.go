@@ -0,0 +1,226 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ParseInterface returns the device index of a network interface named
+// like eth1, or given as the index itself
+func ParseInterface(name string) (int, error) {
+	index, err := strconv.Atoi(strings.TrimPrefix(name, "eth"))
+	if err != nil || index < 0 {
+		return 0, errors.New(fmt.Sprint("Invalid network interface ", name, ", expected eth0, eth1... or a device index"))
+	}
+	return index, nil
+}
+
+// AdvertiseIP is which IP addresses of the instances the members advertise
+// with the ip address types
+type AdvertiseIP string
+
+const (
+	// AdvertisePrimary advertises the address picked, the default
+	AdvertisePrimary AdvertiseIP = "primary"
+	// AdvertiseAll advertises every private IPv4 address, in Subnets if
+	// set, and every IPv6 address, for dual-stack and multi-ENI instances
+	AdvertiseAll AdvertiseIP = "all"
+	// AdvertiseIPv6 advertises the IPv6 address instead of the IPv4 one
+	AdvertiseIPv6 AdvertiseIP = "ipv6"
+)
+
+// ParseAdvertiseIP returns the AdvertiseIP named text
+func ParseAdvertiseIP(text string) (AdvertiseIP, error) {
+	switch a := AdvertiseIP(text); a {
+	case AdvertisePrimary, AdvertiseAll, AdvertiseIPv6:
+		return a, nil
+	}
+	return "", errors.New(fmt.Sprint("Invalid advertised IP ", text, ", expected primary, all or ipv6"))
+}
+
+// privateIP returns the private address of instance on the network
+// interface Interface, the primary one by default. With Subnets, it is the
+// first address found in the first subnet that has one, so peers stay on
+// the intended network even when it isn't the primary address. With
+// AdvertiseIPv6 it is the IPv6 address.
+func (u URLs) privateIP(instance ec2.Instance) string {
+	if u.AdvertiseIP == AdvertiseIPv6 {
+		return pickIP(u.ipv6s(instance), u.Subnets)
+	}
+	if len(u.Subnets) == 0 && u.Interface == 0 {
+		return aws.StringValue(instance.PrivateIpAddress)
+	}
+	return pickIP(u.privateIPs(instance), u.Subnets)
+}
+
+// pickIP returns the first of ips, or with subnets the first in the first
+// subnet that has one
+func pickIP(ips []net.IP, subnets []*net.IPNet) string {
+	if len(subnets) == 0 && len(ips) > 0 {
+		return ips[0].String()
+	}
+	for _, subnet := range subnets {
+		for _, ip := range ips {
+			if subnet.Contains(ip) {
+				return ip.String()
+			}
+		}
+	}
+	return ""
+}
+
+// privateIPs returns the private IPv4 addresses of the interfaces of
+// instance, the primary address of each interface first
+func (u URLs) privateIPs(instance ec2.Instance) []net.IP {
+	ips := []net.IP{}
+	for _, ni := range u.interfaces(instance) {
+		for _, primary := range []bool{true, false} {
+			for _, addr := range ni.PrivateIpAddresses {
+				ip := net.ParseIP(aws.StringValue(addr.PrivateIpAddress))
+				if ip != nil && aws.BoolValue(addr.Primary) == primary {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	// Instances described without their interfaces only have the primary
+	// address
+	if len(instance.NetworkInterfaces) == 0 && u.Interface == 0 {
+		if ip := net.ParseIP(aws.StringValue(instance.PrivateIpAddress)); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// ipv6s returns the IPv6 addresses of the interfaces of instance
+func (u URLs) ipv6s(instance ec2.Instance) []net.IP {
+	ips := []net.IP{}
+	for _, ni := range u.interfaces(instance) {
+		for _, addr := range ni.Ipv6Addresses {
+			if ip := net.ParseIP(aws.StringValue(addr.Ipv6Address)); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if len(instance.NetworkInterfaces) == 0 && u.Interface == 0 {
+		if ip := net.ParseIP(aws.StringValue(instance.Ipv6Address)); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// advertisedIPs returns the addresses of instance its member advertises
+// besides addr, the one of its address type, with AdvertiseAll
+func (u URLs) advertisedIPs(instance ec2.Instance, t AddressType, addr string) []string {
+	addrs := []string{addr}
+	// Address types default to the private IP
+	if u.AdvertiseIP != AdvertiseAll || (t != AddressPrivateIP && t != "") {
+		return addrs
+	}
+	for _, ip := range append(u.privateIPs(instance), u.ipv6s(instance)...) {
+		if inSubnets(ip, u.Subnets) {
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs
+}
+
+func inSubnets(ip net.IP, subnets []*net.IPNet) bool {
+	if len(subnets) == 0 {
+		return true
+	}
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSubnets parses comma separated CIDRs, repeated flags can be given
+// as several texts
+func ParseSubnets(texts ...string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+	for _, text := range texts {
+		for _, field := range strings.Split(text, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			_, subnet, err := net.ParseCIDR(field)
+			if err != nil {
+				return nil, err
+			}
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// publicIP returns the public address of the network interface Interface
+func (u URLs) publicIP(instance ec2.Instance) string {
+	if u.Interface == 0 {
+		return aws.StringValue(instance.PublicIpAddress)
+	}
+	for _, ni := range u.interfaces(instance) {
+		if ni.Association != nil {
+			return aws.StringValue(ni.Association.PublicIp)
+		}
+	}
+	return ""
+}
+
+// interfaces returns the network interfaces of instance addresses are
+// picked from, all of them in device order when Interface is the primary
+// one so Subnets can pick from any
+func (u URLs) interfaces(instance ec2.Instance) []*ec2.InstanceNetworkInterface {
+	byIndex := map[int64]*ec2.InstanceNetworkInterface{}
+	maxIndex := int64(-1)
+	for _, ni := range instance.NetworkInterfaces {
+		if ni.Attachment == nil {
+			continue
+		}
+		index := aws.Int64Value(ni.Attachment.DeviceIndex)
+		byIndex[index] = ni
+		if index > maxIndex {
+			maxIndex = index
+		}
+	}
+	if u.Interface != 0 {
+		if ni, ok := byIndex[int64(u.Interface)]; ok {
+			return []*ec2.InstanceNetworkInterface{ni}
+		}
+		return nil
+	}
+	result := []*ec2.InstanceNetworkInterface{}
+	for i := int64(0); i <= maxIndex; i++ {
+		if ni, ok := byIndex[i]; ok {
+			result = append(result, ni)
+		}
+	}
+	return result
+}
+
+// addressScope describes the addresses looked for, for logs
+func (u URLs) addressScope() string {
+	scope := []string{fmt.Sprint(u.clientAddress(), " or ", u.peerAddress(), " address")}
+	if u.Interface != 0 {
+		scope = append(scope, fmt.Sprint("on eth", u.Interface))
+	}
+	if len(u.Subnets) > 0 {
+		cidrs := []string{}
+		for _, subnet := range u.Subnets {
+			cidrs = append(cidrs, subnet.String())
+		}
+		scope = append(scope, fmt.Sprint("in ", strings.Join(cidrs, ", ")))
+	}
+	return strings.Join(scope, " ")
+}
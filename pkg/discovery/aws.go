@@ -0,0 +1,554 @@
+// Package discovery finds the members the etcd cluster is expected to have:
+// the instances of an Autoscaling group, or those of another Source such as
+// a static list, DNS, Nomad, GCP, Azure or a registry.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/internal/parallel"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/registry"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// AutoScalingAPI is the subset of the Autoscaling API etcdmate uses
+type AutoScalingAPI interface {
+	DescribeAutoScalingInstancesWithContext(aws.Context, *autoscaling.DescribeAutoScalingInstancesInput, ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error)
+	DescribeAutoScalingGroupsWithContext(aws.Context, *autoscaling.DescribeAutoScalingGroupsInput, ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+	DescribeAutoScalingGroupsPagesWithContext(aws.Context, *autoscaling.DescribeAutoScalingGroupsInput, func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, ...request.Option) error
+	DescribeInstanceRefreshesWithContext(aws.Context, *autoscaling.DescribeInstanceRefreshesInput, ...request.Option) (*autoscaling.DescribeInstanceRefreshesOutput, error)
+	DetachInstancesWithContext(aws.Context, *autoscaling.DetachInstancesInput, ...request.Option) (*autoscaling.DetachInstancesOutput, error)
+	SetDesiredCapacityWithContext(aws.Context, *autoscaling.SetDesiredCapacityInput, ...request.Option) (*autoscaling.SetDesiredCapacityOutput, error)
+	CreateOrUpdateTagsWithContext(aws.Context, *autoscaling.CreateOrUpdateTagsInput, ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error)
+	CompleteLifecycleActionWithContext(aws.Context, *autoscaling.CompleteLifecycleActionInput, ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error)
+	SetInstanceProtectionWithContext(aws.Context, *autoscaling.SetInstanceProtectionInput, ...request.Option) (*autoscaling.SetInstanceProtectionOutput, error)
+	DescribeScalingActivitiesWithContext(aws.Context, *autoscaling.DescribeScalingActivitiesInput, ...request.Option) (*autoscaling.DescribeScalingActivitiesOutput, error)
+}
+
+// EC2API is the subset of the EC2 API etcdmate uses
+type EC2API interface {
+	DescribeInstancesWithContext(aws.Context, *ec2.DescribeInstancesInput, ...request.Option) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstancesPagesWithContext(aws.Context, *ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool, ...request.Option) error
+	TerminateInstancesWithContext(aws.Context, *ec2.TerminateInstancesInput, ...request.Option) (*ec2.TerminateInstancesOutput, error)
+	DescribeSecurityGroupsWithContext(aws.Context, *ec2.DescribeSecurityGroupsInput, ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error)
+	CreateTagsWithContext(aws.Context, *ec2.CreateTagsInput, ...request.Option) (*ec2.CreateTagsOutput, error)
+}
+
+// MetadataAPI is the subset of the EC2 metadata API etcdmate uses
+type MetadataAPI interface {
+	AvailableWithContext(aws.Context) bool
+	GetInstanceIdentityDocumentWithContext(aws.Context) (ec2metadata.EC2InstanceIdentityDocument, error)
+}
+
+// AWS bundles the AWS services used for discovery
+type AWS struct {
+	AutoScaling AutoScalingAPI
+	EC2         EC2API
+	Logger      logging.Logger
+	// Parallelism bounds the concurrent EC2 describe calls, default 1
+	Parallelism int
+	// Cache, when set, is used for the lookups done by GetExpectedMembers
+	Cache *Cache
+	// Remotes are more Autoscaling groups, possibly in other regions,
+	// whose instances are expected members too
+	Remotes []RemoteGroup
+	// Registry, when set, gets the local member registered and adds the
+	// members registered there by others, with RegistryTTL for the entry of
+	// the local member
+	Registry    registry.Registry
+	RegistryTTL time.Duration
+}
+
+// RemoteGroup is an Autoscaling group of a stretched cluster other than the
+// one of the local instance
+type RemoteGroup struct {
+	AWS     AWS
+	AsgName string
+}
+
+func NewAWS(sess *session.Session, logger logging.Logger) AWS {
+	return AWS{
+		AutoScaling: autoscaling.New(sess),
+		EC2:         ec2.New(sess),
+		Logger:      logger,
+	}
+}
+
+func (svc AWS) log() logging.Logger {
+	return logging.OrDefault(svc.Logger)
+}
+
+// URLs describes how member URLs are built from instance addresses
+type URLs struct {
+	ClientSchema string
+	ClientPort   int
+	PeerSchema   string
+	PeerPort     int
+	// Address is the instance address used, AddressPrivateIP by default
+	Address AddressType
+	// ClientAddress and PeerAddress, when set, are the addresses used for
+	// the client and the peer URLs instead of Address
+	ClientAddress AddressType
+	PeerAddress   AddressType
+	// Subnets, when set, pick the private address of the instances in
+	// them rather than the primary one, in order of preference
+	Subnets []*net.IPNet
+	// Interface is the device index of the network interface whose IP
+	// addresses are used, 0 for the primary one
+	Interface int
+	// AdvertiseIP picks the IP addresses of the members, AdvertisePrimary
+	// by default
+	AdvertiseIP AdvertiseIP
+	// Name, when set, builds the member names from the instances instead
+	// of using their IDs, see ParseNameTemplate
+	Name *template.Template
+	// ClientURL and PeerURL, when set, build the member URLs instead of the
+	// schemas, ports and Address, see ParseURLTemplate. Schemas and ports
+	// are overridden per instance by tags, see ForInstance
+	ClientURL *template.Template
+	PeerURL   *template.Template
+}
+
+type AddressType string
+
+const (
+	AddressPrivateIP  AddressType = "private-ip"
+	AddressPrivateDNS AddressType = "private-dns"
+	AddressPublicIP   AddressType = "public-ip"
+	AddressPublicDNS  AddressType = "public-dns"
+)
+
+// ParseAddressType returns the address type named text, public and private
+// standing for the IP addresses
+func ParseAddressType(text string) (AddressType, error) {
+	switch t := AddressType(text); t {
+	case AddressPrivateIP, AddressPrivateDNS, AddressPublicIP, AddressPublicDNS:
+		return t, nil
+	case "private":
+		return AddressPrivateIP, nil
+	case "public":
+		return AddressPublicIP, nil
+	}
+	return "", errors.New(fmt.Sprint(
+		"Invalid address type ", text, ", expected private-ip, private-dns, public-ip, public-dns, private or public",
+	))
+}
+
+// Addr returns the address of instance members are reached at, empty if
+// the instance has none of that type
+func (u URLs) Addr(instance ec2.Instance) string {
+	return u.addr(instance, u.Address)
+}
+
+// ClientAddr returns the address of instance in client URLs
+func (u URLs) ClientAddr(instance ec2.Instance) string {
+	return u.addr(instance, u.clientAddress())
+}
+
+// PeerAddr returns the address of instance in peer URLs
+func (u URLs) PeerAddr(instance ec2.Instance) string {
+	return u.addr(instance, u.peerAddress())
+}
+
+func (u URLs) clientAddress() AddressType {
+	if u.ClientAddress != "" {
+		return u.ClientAddress
+	}
+	return u.Address
+}
+
+func (u URLs) peerAddress() AddressType {
+	if u.PeerAddress != "" {
+		return u.PeerAddress
+	}
+	return u.Address
+}
+
+func (u URLs) addr(instance ec2.Instance, t AddressType) string {
+	switch t {
+	case AddressPrivateDNS:
+		return aws.StringValue(instance.PrivateDnsName)
+	case AddressPublicIP:
+		return u.publicIP(instance)
+	case AddressPublicDNS:
+		return aws.StringValue(instance.PublicDnsName)
+	}
+	return u.privateIP(instance)
+}
+
+func (u URLs) Member(instance ec2.Instance) etcd.Member {
+	zone := ""
+	if instance.Placement != nil {
+		zone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	clientAddr := u.ClientAddr(instance)
+	peerAddr := u.PeerAddr(instance)
+	clientAddrs := u.advertisedIPs(instance, u.clientAddress(), clientAddr)
+	peerAddrs := u.advertisedIPs(instance, u.peerAddress(), peerAddr)
+	u = u.ForInstance(instance)
+	m := etcd.Member{
+		Name:     u.memberName(instance),
+		Zone:     zone,
+		Instance: *instance.InstanceId,
+		Address:  u.privateIP(instance),
+	}
+	clientURLs := []string{}
+	for _, addr := range clientAddrs {
+		clientURLs = append(clientURLs, memberURL(u.ClientSchema, addr, u.ClientPort))
+	}
+	peerURLs := []string{}
+	for _, addr := range peerAddrs {
+		peerURLs = append(peerURLs, memberURL(u.PeerSchema, addr, u.PeerPort))
+	}
+	if url, err := u.templateURL(u.ClientURL, instance, m.Name, clientAddr); err == nil && url != "" {
+		clientURLs = []string{url}
+	}
+	if url, err := u.templateURL(u.PeerURL, instance, m.Name, peerAddr); err == nil && url != "" {
+		peerURLs = []string{url}
+	}
+	return m.WithURLs(clientURLs, peerURLs)
+}
+
+// memberURL is the URL of addr, IPv6 addresses in brackets
+func memberURL(schema string, addr string, port int) string {
+	return fmt.Sprint(schema, "://", net.JoinHostPort(addr, strconv.Itoa(port)))
+}
+
+func GetMetadata(
+	ctx context.Context,
+	metadata MetadataAPI,
+	logger logging.Logger,
+) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	if !metadata.AvailableWithContext(ctx) {
+		// IMDSv2 token responses don't go further than the hop limit of the
+		// instance, 1 by default, which a container is one hop behind
+		return ec2metadata.EC2InstanceIdentityDocument{}, errors.New(
+			"EC2 metadata service unavailable: not an EC2 instance, or the IMDSv2 token responses don't reach etcdmate," +
+				" raise the hop limit of the instance to 2 in a container",
+		)
+	}
+	id, err := metadata.GetInstanceIdentityDocumentWithContext(ctx)
+	if err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	logging.OrDefault(logger).Printf("Metadata: %+v\n", id)
+	return id, nil
+}
+
+func (svc AWS) GetAsg(ctx context.Context, insId string) (string, error) {
+	if asgName, ok := svc.Cache.get("asg/" + insId); ok {
+		return asgName.(string), nil
+	}
+	svc.log().Println("Looking for Autoscaling group of instance", insId)
+	params := &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{&insId},
+		MaxRecords:  aws.Int64(1),
+	}
+	resp, err := svc.AutoScaling.DescribeAutoScalingInstancesWithContext(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.AutoScalingInstances) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNotInASG, insId)
+	}
+	asgName := resp.AutoScalingInstances[0].AutoScalingGroupName
+	if asgName == nil || *asgName == "" {
+		return "", fmt.Errorf("%w: %s", ErrNotInASG, insId)
+	}
+	svc.log().Println("Found Autoscaling group", *asgName)
+	svc.Cache.put("asg/"+insId, *asgName)
+	return *asgName, nil
+}
+
+func (svc AWS) DescribeAsg(ctx context.Context, asgName string) (*autoscaling.Group, error) {
+	resp, err := svc.AutoScaling.DescribeAutoScalingGroupsWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{&asgName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.AutoScalingGroups) == 0 {
+		return nil, errors.New(fmt.Sprint("Autoscaling group not found ", asgName))
+	}
+	return resp.AutoScalingGroups[0], nil
+}
+
+// LastScalingActivity returns when the latest scaling activity of the
+// Autoscaling group, e.g. an instance launch or termination, ended, now
+// while one is in progress. It reports false when the group has none.
+func (svc AWS) LastScalingActivity(ctx context.Context, asgName string) (time.Time, bool, error) {
+	resp, err := svc.AutoScaling.DescribeScalingActivitiesWithContext(ctx, &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: &asgName,
+		MaxRecords:           aws.Int64(10),
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var last time.Time
+	for _, activity := range resp.Activities {
+		if activity.EndTime == nil {
+			return time.Now(), true, nil
+		}
+		if activity.EndTime.After(last) {
+			last = *activity.EndTime
+		}
+	}
+	return last, !last.IsZero(), nil
+}
+
+// GetAsgTag returns the value of the tag key of the Autoscaling group, and
+// whether it is set
+func (svc AWS) GetAsgTag(ctx context.Context, asgName string, key string) (string, bool, error) {
+	group, err := svc.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return "", false, err
+	}
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (svc AWS) GetAsgInstanceIds(ctx context.Context, asgName string) ([]*string, error) {
+	var group *autoscaling.Group
+	if cached, ok := svc.Cache.get("group/" + asgName); ok {
+		group = cached.(*autoscaling.Group)
+	} else {
+		svc.log().Println("Looking for instances in Autoscaling group", asgName)
+		var err error
+		group, err = svc.DescribeAsg(ctx, asgName)
+		if err != nil {
+			return []*string{}, err
+		}
+		svc.Cache.put("group/"+asgName, group)
+	}
+	instanceIds := []*string{}
+	for _, instance := range group.Instances {
+		svc.log().Printf("Found instance %+v\n", instance)
+		if instance.InstanceId == nil {
+			continue
+		}
+		if aws.StringValue(instance.LifecycleState) == "InService" {
+			instanceIds = append(instanceIds, instance.InstanceId)
+		} else {
+			svc.log().Println("Ignoring instance", *instance.InstanceId)
+		}
+	}
+	return instanceIds, nil
+}
+
+// describeBatch is the number of instance ids sent per DescribeInstances call
+const describeBatch = 100
+
+func (svc AWS) GetEC2Instances(ctx context.Context, instanceIds []*string) ([]ec2.Instance, error) {
+	found := map[string]ec2.Instance{}
+	missing := []*string{}
+	for _, id := range instanceIds {
+		if instance, ok := svc.Cache.get("ec2/" + *id); ok {
+			found[*id] = instance.(ec2.Instance)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		err := svc.describeInstances(ctx, missing, found)
+		if err != nil {
+			return []ec2.Instance{}, err
+		}
+	}
+	// Keep the requested order so the generated configuration is stable
+	instances := []ec2.Instance{}
+	for _, id := range instanceIds {
+		if instance, ok := found[*id]; ok {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// InstanceGone tells whether the instance is terminated or terminating,
+// including instances EC2 no longer knows
+func (svc AWS) InstanceGone(ctx context.Context, insId string) (bool, error) {
+	resp, err := svc.EC2.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(insId)},
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "InvalidInstanceID.NotFound") {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, reservation := range resp.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State == nil {
+				return false, nil
+			}
+			switch aws.StringValue(instance.State.Name) {
+			case ec2.InstanceStateNameTerminated, ec2.InstanceStateNameShuttingDown:
+				return true, nil
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (svc AWS) describeInstances(ctx context.Context, instanceIds []*string, found map[string]ec2.Instance) error {
+	batches := [][]*string{}
+	for len(instanceIds) > describeBatch {
+		batches = append(batches, instanceIds[:describeBatch])
+		instanceIds = instanceIds[describeBatch:]
+	}
+	batches = append(batches, instanceIds)
+	pages := make([][]*ec2.Reservation, len(batches))
+	errs := make([]error, len(batches))
+	parallel.ForEach(svc.Parallelism, len(batches), func(i int) {
+		pages[i], errs[i] = svc.describePages(ctx, batches[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	reservations := []*ec2.Reservation{}
+	for _, page := range pages {
+		reservations = append(reservations, page...)
+	}
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			// Terminated instances have no private address anymore
+			if instance.InstanceId == nil || instance.PrivateIpAddress == nil {
+				svc.log().Printf("Ignoring instance without address %+v\n", instance)
+				continue
+			}
+			found[*instance.InstanceId] = *instance
+			svc.Cache.put("ec2/"+*instance.InstanceId, *instance)
+		}
+	}
+	return nil
+}
+
+// describePages returns the reservations of instanceIds over every page of
+// the responses, a page left behind would silently drop expected members
+func (svc AWS) describePages(ctx context.Context, instanceIds []*string) ([]*ec2.Reservation, error) {
+	input := &ec2.DescribeInstancesInput{InstanceIds: instanceIds}
+	reservations := []*ec2.Reservation{}
+	err := svc.EC2.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		reservations = append(reservations, page.Reservations...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (svc AWS) GetExpectedMembers(ctx context.Context, insId string, urls URLs) ([]etcd.Member, error) {
+	members, err := svc.GetMembers(ctx, insId, urls)
+	return members.Voters, err
+}
+
+func (svc AWS) Describe() string {
+	description := "the InService instances of the Autoscaling group with an address"
+	if len(svc.Remotes) > 0 {
+		description += fmt.Sprint(" and those of ", len(svc.Remotes), " remote groups")
+	}
+	if svc.Registry != nil {
+		description += ", with the members of the registry"
+	}
+	return description
+}
+
+// GetMembers returns the members of the instances of the Autoscaling
+// groups, split by role
+func (svc AWS) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	asgName, err := svc.GetAsg(ctx, insId)
+	if err != nil {
+		return members, err
+	}
+	instanceIds, err := svc.GetAsgInstanceIds(ctx, asgName)
+	if err != nil {
+		return members, err
+	}
+	instances, err := svc.GetEC2Instances(ctx, instanceIds)
+	if err != nil {
+		return members, err
+	}
+	for _, remote := range svc.Remotes {
+		remoteInstances, err := remote.instances(ctx)
+		if err != nil {
+			return members, err
+		}
+		instances = append(instances, remoteInstances...)
+	}
+	members, err = svc.members(instances, urls)
+	if err != nil {
+		return members, err
+	}
+	if svc.Registry != nil {
+		if err := svc.withRegistry(ctx, insId, &members); err != nil {
+			return members, err
+		}
+	}
+	svc.log().Printf("Expected Members %+v\n", members.Voters)
+	if len(members.Observers) > 0 {
+		svc.log().Printf("Observers %+v\n", members.Observers)
+	}
+	return members, nil
+}
+
+// members returns the members of the instances with an address, split by
+// role
+func (svc AWS) members(instances []ec2.Instance, urls URLs) (Members, error) {
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	if err := urls.checkNames(instances); err != nil {
+		return members, err
+	}
+	if err := urls.checkURLs(instances); err != nil {
+		return members, err
+	}
+	if err := urls.checkOverrides(instances); err != nil {
+		return members, err
+	}
+	for _, instance := range instances {
+		if urls.ClientAddr(instance) == "" || urls.PeerAddr(instance) == "" {
+			svc.log().Println("Ignoring instance without", urls.addressScope(), *instance.InstanceId)
+			continue
+		}
+		if isObserver(instance) {
+			members.Observers = append(members.Observers, urls.Member(instance))
+			continue
+		}
+		members.Voters = append(members.Voters, urls.Member(instance))
+	}
+	return members, nil
+}
+
+func (r RemoteGroup) instances(ctx context.Context) ([]ec2.Instance, error) {
+	instanceIds, err := r.AWS.GetAsgInstanceIds(ctx, r.AsgName)
+	if err != nil {
+		return nil, err
+	}
+	return r.AWS.GetEC2Instances(ctx, instanceIds)
+}
@@ -0,0 +1,138 @@
+package discovery_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+)
+
+// launch adds n instances to a group of the fake, returning their ids
+func launch(f *fake.AWS, n int) []*string {
+	f.AddGroup("etcd", 1, int64(n))
+	ids := []*string{}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprint("i-", i)
+		f.Launch("etcd", id, fmt.Sprint("10.0.", i/250, ".", i%250+1))
+		ids = append(ids, aws.String(id))
+	}
+	return ids
+}
+
+func TestGetEC2InstancesPages(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		instances int
+		pageSize  int
+		calls     int
+	}{
+		{name: "one batch, one page", instances: 3, calls: 1},
+		{name: "one batch, several pages", instances: 90, pageSize: 25, calls: 4},
+		{name: "full batch", instances: 100, calls: 1},
+		{name: "several batches", instances: 250, calls: 3},
+		{name: "several batches and pages", instances: 250, pageSize: 40, calls: 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := fake.New()
+			ids := launch(f, tc.instances)
+			f.SetPageSize(tc.pageSize)
+			instances, err := f.Services().GetEC2Instances(context.Background(), ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(instances) != len(ids) {
+				t.Fatalf("got %d instances, want %d", len(instances), len(ids))
+			}
+			for i, instance := range instances {
+				if aws.StringValue(instance.InstanceId) != *ids[i] {
+					t.Fatalf("instance %d is %s, want %s in the requested order", i, aws.StringValue(instance.InstanceId), *ids[i])
+				}
+			}
+			calls := f.Described()
+			if len(calls) != tc.calls {
+				t.Errorf("got %d DescribeInstances calls, want %d", len(calls), tc.calls)
+			}
+			for _, call := range calls {
+				if len(call) > 100 {
+					t.Errorf("a DescribeInstances call has %d instance ids, more than a batch", len(call))
+				}
+			}
+		})
+	}
+}
+
+func TestGetEC2InstancesCached(t *testing.T) {
+	f := fake.New()
+	ids := launch(f, 150)
+	f.SetPageSize(60)
+	svc := f.Services()
+	svc.Cache = discovery.NewCache(time.Minute)
+	if _, err := svc.GetEC2Instances(context.Background(), ids[:50]); err != nil {
+		t.Fatal(err)
+	}
+	instances, err := svc.GetEC2Instances(context.Background(), ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != len(ids) {
+		t.Fatalf("got %d instances, want %d", len(instances), len(ids))
+	}
+	described := 0
+	for _, call := range f.Described() {
+		described += len(call)
+	}
+	// The 50 first ids are cached, each of the two pages of the next call
+	// repeats the 100 ids of its batch
+	if want := 50 + 100 + 100; described != want {
+		t.Errorf("described %d instance ids, want %d", described, want)
+	}
+}
+
+func TestGetTaggedInstancesPages(t *testing.T) {
+	f := fake.New()
+	for i := 0; i < 1200; i++ {
+		instance := f.Run(fmt.Sprint("i-", 10000+i), fmt.Sprint("10.1.", i/250, ".", i%250+1))
+		instance.Tags = map[string]string{"etcd-cluster": "prod"}
+	}
+	f.Run("i-other", "10.2.0.1")
+	instances, err := f.Services().GetTaggedInstances(context.Background(), []discovery.TagFilter{{Key: "etcd-cluster", Value: "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1200 {
+		t.Fatalf("got %d instances, want the 1200 tagged ones over two pages", len(instances))
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for _, tc := range []struct {
+		source discovery.Source
+		want   string
+	}{
+		{
+			source: discovery.AWS{},
+			want:   "the InService instances of the Autoscaling group with an address",
+		},
+		{
+			source: discovery.Tags{Filters: []discovery.TagFilter{{Key: "cluster", Value: "etcd"}, {Key: "etcd"}}},
+			want:   "the running instances tagged cluster=etcd and etcd",
+		},
+		{
+			source: discovery.Static{File: "/etc/etcdmate/members.json"},
+			want:   "the members listed in /etc/etcdmate/members.json",
+		},
+		{
+			source: discovery.DNS{Domain: "example.com"},
+			want:   "the targets of the SRV records of example.com",
+		},
+	} {
+		if got := tc.source.Describe(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
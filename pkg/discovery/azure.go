@@ -0,0 +1,327 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+const (
+	// AzureMetadataURL is the Instance Metadata Service of Azure VMs
+	AzureMetadataURL = "http://169.254.169.254/metadata"
+	// AzureManagementURL is the Azure Resource Manager API
+	AzureManagementURL = "https://management.azure.com"
+)
+
+// Azure discovers the members from the virtual machine scale set of the
+// local VM, the way AWS does from its Autoscaling group. The instance of a
+// member is its VM name, SCALESET_INSTANCEID, which is also its name, and
+// its zone the location and availability zone of the VM. The API is called
+// with the token of the managed identity of the VM, which needs to read the
+// scale set, its VMs and their network interfaces, e.g. with the Reader role
+// on the resource group.
+type Azure struct {
+	// Metadata defaults to AzureMetadataURL, Management to
+	// AzureManagementURL
+	Metadata   string
+	Management string
+	// HTTP defaults to http.DefaultClient
+	HTTP   *http.Client
+	Logger logging.Logger
+}
+
+// AzureIdentity is the local VM as told by the Instance Metadata Service
+type AzureIdentity struct {
+	Name          string
+	Subscription  string
+	ResourceGroup string
+	Location      string
+	Zone          string
+	PrivateIP     string
+	ScaleSet      string
+}
+
+// AvailabilityZone is the location and zone of the VM, e.g. westeurope-1,
+// the location alone for a VM outside of zones
+func (id AzureIdentity) AvailabilityZone() string {
+	return azureZone(id.Location, id.Zone)
+}
+
+func azureZone(location string, zone string) string {
+	if zone == "" {
+		return location
+	}
+	return location + "-" + zone
+}
+
+type azureVM struct {
+	ID         string
+	Name       string
+	Location   string
+	Zones      []string
+	Properties struct {
+		ProvisioningState string
+		InstanceView      *struct {
+			Statuses []struct {
+				Code string
+			}
+		}
+	}
+}
+
+type azureNIC struct {
+	Properties struct {
+		Primary          bool
+		VirtualMachine   struct{ ID string }
+		IPConfigurations []struct {
+			Properties struct {
+				Primary          bool
+				PrivateIPAddress string
+			}
+		}
+	}
+}
+
+func (a Azure) log() logging.Logger {
+	return logging.OrDefault(a.Logger)
+}
+
+func (a Azure) client() *http.Client {
+	if a.HTTP == nil {
+		return http.DefaultClient
+	}
+	return a.HTTP
+}
+
+// Identity reads the local VM from the Instance Metadata Service
+func (a Azure) Identity(ctx context.Context) (AzureIdentity, error) {
+	var instance struct {
+		Compute struct {
+			Name              string
+			SubscriptionID    string
+			ResourceGroupName string
+			Location          string
+			Zone              string
+			VMScaleSetName    string
+		}
+		Network struct {
+			Interface []struct {
+				IPv4 struct {
+					IPAddress []struct {
+						PrivateIPAddress string
+					}
+				}
+			}
+		}
+	}
+	if err := a.metadata(ctx, "/instance?api-version=2021-02-01", &instance); err != nil {
+		return AzureIdentity{}, err
+	}
+	id := AzureIdentity{
+		Name:          instance.Compute.Name,
+		Subscription:  instance.Compute.SubscriptionID,
+		ResourceGroup: instance.Compute.ResourceGroupName,
+		Location:      instance.Compute.Location,
+		Zone:          instance.Compute.Zone,
+		ScaleSet:      instance.Compute.VMScaleSetName,
+	}
+	if nics := instance.Network.Interface; len(nics) > 0 && len(nics[0].IPv4.IPAddress) > 0 {
+		id.PrivateIP = nics[0].IPv4.IPAddress[0].PrivateIPAddress
+	}
+	return id, nil
+}
+
+func (a Azure) metadata(ctx context.Context, p string, v interface{}) error {
+	base := a.Metadata
+	if base == "" {
+		base = AzureMetadataURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(base, "/")+p, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("Azure metadata GET %s failed: %s %s", p, resp.Status, bytes.TrimSpace(data)))
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (a Azure) token(ctx context.Context) (string, error) {
+	resource := a.Management
+	if resource == "" {
+		resource = AzureManagementURL
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := a.metadata(ctx, "/identity/oauth2/token?api-version=2018-02-01&resource="+url.QueryEscape(resource+"/"), &token)
+	return token.AccessToken, err
+}
+
+// list gets every page of the Resource Manager collection at target, a
+// path under Management, into values
+func (a Azure) list(ctx context.Context, token string, target string, values func(json.RawMessage) error) error {
+	base := a.Management
+	if base == "" {
+		base = AzureManagementURL
+	}
+	u := strings.TrimSuffix(base, "/") + target
+	for u != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := a.client().Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 {
+			return errors.New(fmt.Sprintf("Azure GET %s failed: %s %s", u, resp.Status, bytes.TrimSpace(data)))
+		}
+		var page struct {
+			Value    json.RawMessage
+			NextLink string
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		if len(page.Value) > 0 {
+			if err := values(page.Value); err != nil {
+				return err
+			}
+		}
+		u = page.NextLink
+	}
+	return nil
+}
+
+func (a Azure) Describe() string {
+	return "the running VMs of the virtual machine scale set"
+}
+
+// GetMembers returns a voter for every running VM of the scale set of the
+// local VM, leaving out those being deleted or that failed to provision.
+// Members use the primary private IP address of their primary network
+// interface.
+func (a Azure) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	id, err := a.Identity(ctx)
+	if err != nil {
+		return members, err
+	}
+	if id.ScaleSet == "" {
+		return members, errors.New(fmt.Sprint("The VM ", id.Name, " isn't part of a virtual machine scale set"))
+	}
+	token, err := a.token(ctx)
+	if err != nil {
+		return members, err
+	}
+	scaleSet := fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s",
+		url.PathEscape(id.Subscription), url.PathEscape(id.ResourceGroup), url.PathEscape(id.ScaleSet),
+	)
+	vms := []azureVM{}
+	err = a.list(ctx, token, scaleSet+"/virtualMachines?$expand=instanceView&api-version=2023-09-01", func(raw json.RawMessage) error {
+		var page []azureVM
+		err := json.Unmarshal(raw, &page)
+		vms = append(vms, page...)
+		return err
+	})
+	if err != nil {
+		return members, err
+	}
+	addresses := map[string]string{}
+	err = a.list(ctx, token, scaleSet+"/networkInterfaces?api-version=2018-10-01", func(raw json.RawMessage) error {
+		var page []azureNIC
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return err
+		}
+		for _, nic := range page {
+			vm := strings.ToLower(nic.Properties.VirtualMachine.ID)
+			if _, ok := addresses[vm]; ok && !nic.Properties.Primary {
+				continue
+			}
+			for i, ip := range nic.Properties.IPConfigurations {
+				if i == 0 || ip.Properties.Primary {
+					addresses[vm] = ip.Properties.PrivateIPAddress
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return members, err
+	}
+	for _, vm := range vms {
+		if !azureRunning(vm) {
+			continue
+		}
+		address := addresses[strings.ToLower(vm.ID)]
+		if address == "" {
+			a.log().Println("Ignoring VM without a private IP address", vm.Name)
+			continue
+		}
+		zone := ""
+		if len(vm.Zones) > 0 {
+			zone = vm.Zones[0]
+		}
+		members.Voters = append(members.Voters, etcd.Member{
+			Name:      vm.Name,
+			Zone:      azureZone(vm.Location, zone),
+			Instance:  vm.Name,
+			ClientURL: fmt.Sprint(urls.ClientSchema, "://", address, ":", urls.ClientPort),
+			PeerURL:   fmt.Sprint(urls.PeerSchema, "://", address, ":", urls.PeerPort),
+		})
+	}
+	sort.Slice(members.Voters, func(i, j int) bool { return members.Voters[i].Name < members.Voters[j].Name })
+	a.log().Printf("Expected Members %+v\n", members.Voters)
+	return members, nil
+}
+
+// azureRunning tells whether vm is provisioned and, when its instance view
+// says, running
+func azureRunning(vm azureVM) bool {
+	switch vm.Properties.ProvisioningState {
+	case "Deleting", "Failed":
+		return false
+	}
+	if vm.Properties.InstanceView == nil {
+		return true
+	}
+	for _, status := range vm.Properties.InstanceView.Statuses {
+		if strings.HasPrefix(status.Code, "PowerState/") {
+			return path.Base(status.Code) == "running"
+		}
+	}
+	return true
+}
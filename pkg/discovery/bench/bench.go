@@ -0,0 +1,180 @@
+// Package bench measures the discovery path, so regressions in the number
+// of AWS calls or their cost show up before they reach large fleets.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+)
+
+// Result of discovering the expected members Runs times
+type Result struct {
+	Size int
+	Runs int
+	Mean time.Duration
+	Max  time.Duration
+	// Calls is the number of calls per run by API
+	Calls map[string]int
+}
+
+func (r Result) String() string {
+	apis := []string{}
+	for api := range r.Calls {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+	calls := ""
+	for _, api := range apis {
+		calls += fmt.Sprintf(" %s=%d", api, r.Calls[api])
+	}
+	return fmt.Sprintf("size=%d runs=%d mean=%s max=%s%s", r.Size, r.Runs, r.Mean, r.Max, calls)
+}
+
+// Measure discovers the expected members of the group of instanceID runs
+// times, svc shouldn't have a cache
+func Measure(ctx context.Context, svc discovery.AWS, instanceID string, urls discovery.URLs, runs int) (Result, error) {
+	if runs < 1 {
+		return Result{}, errors.New("Discovery must run at least once")
+	}
+	c := &counter{calls: map[string]int{}}
+	svc.AutoScaling = countingAutoScaling{AutoScalingAPI: svc.AutoScaling, counter: c}
+	svc.EC2 = countingEC2{EC2API: svc.EC2, counter: c}
+	result := Result{Runs: runs}
+	var total time.Duration
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		members, err := svc.GetExpectedMembers(ctx, instanceID, urls)
+		if err != nil {
+			return result, err
+		}
+		elapsed := time.Since(start)
+		total += elapsed
+		if elapsed > result.Max {
+			result.Max = elapsed
+		}
+		result.Size = len(members)
+	}
+	result.Mean = total / time.Duration(runs)
+	result.Calls = map[string]int{}
+	for api, n := range c.calls {
+		result.Calls[api] = n / runs
+	}
+	return result, nil
+}
+
+// Fake returns the discovery services of an Autoscaling group of size
+// instances whose calls take latency, and the id of one of the instances
+func Fake(size int, latency time.Duration) (discovery.AWS, string) {
+	f := fake.New()
+	f.AddGroup("bench", 0, int64(size))
+	for i := 0; i < size; i++ {
+		f.Launch("bench", fmt.Sprintf("i-%08d", i), fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255))
+	}
+	svc := f.Services()
+	if latency > 0 {
+		svc.AutoScaling = slowAutoScaling{AutoScalingAPI: svc.AutoScaling, latency: latency}
+		svc.EC2 = slowEC2{EC2API: svc.EC2, latency: latency}
+	}
+	return svc, "i-00000000"
+}
+
+type counter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *counter) add(api string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[api]++
+}
+
+type countingAutoScaling struct {
+	discovery.AutoScalingAPI
+	counter *counter
+}
+
+func (a countingAutoScaling) DescribeAutoScalingInstancesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingInstancesInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	a.counter.add("DescribeAutoScalingInstances")
+	return a.AutoScalingAPI.DescribeAutoScalingInstancesWithContext(ctx, in, opts...)
+}
+
+func (a countingAutoScaling) DescribeAutoScalingGroupsWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	a.counter.add("DescribeAutoScalingGroups")
+	return a.AutoScalingAPI.DescribeAutoScalingGroupsWithContext(ctx, in, opts...)
+}
+
+func (a countingAutoScaling) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
+	return a.AutoScalingAPI.DescribeAutoScalingGroupsPagesWithContext(ctx, in, func(page *autoscaling.DescribeAutoScalingGroupsOutput, last bool) bool {
+		a.counter.add("DescribeAutoScalingGroups")
+		return fn(page, last)
+	}, opts...)
+}
+
+type countingEC2 struct {
+	discovery.EC2API
+	counter *counter
+}
+
+func (e countingEC2) DescribeInstancesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	e.counter.add("DescribeInstances")
+	return e.EC2API.DescribeInstancesWithContext(ctx, in, opts...)
+}
+
+func (e countingEC2) DescribeInstancesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	return e.EC2API.DescribeInstancesPagesWithContext(ctx, in, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		e.counter.add("DescribeInstances")
+		return fn(page, last)
+	}, opts...)
+}
+
+type slowAutoScaling struct {
+	discovery.AutoScalingAPI
+	latency time.Duration
+}
+
+func (a slowAutoScaling) DescribeAutoScalingInstancesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingInstancesInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	time.Sleep(a.latency)
+	return a.AutoScalingAPI.DescribeAutoScalingInstancesWithContext(ctx, in, opts...)
+}
+
+func (a slowAutoScaling) DescribeAutoScalingGroupsWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	time.Sleep(a.latency)
+	return a.AutoScalingAPI.DescribeAutoScalingGroupsWithContext(ctx, in, opts...)
+}
+
+func (a slowAutoScaling) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
+	return a.AutoScalingAPI.DescribeAutoScalingGroupsPagesWithContext(ctx, in, func(page *autoscaling.DescribeAutoScalingGroupsOutput, last bool) bool {
+		time.Sleep(a.latency)
+		return fn(page, last)
+	}, opts...)
+}
+
+type slowEC2 struct {
+	discovery.EC2API
+	latency time.Duration
+}
+
+func (e slowEC2) DescribeInstancesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	time.Sleep(e.latency)
+	return e.EC2API.DescribeInstancesWithContext(ctx, in, opts...)
+}
+
+func (e slowEC2) DescribeInstancesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	return e.EC2API.DescribeInstancesPagesWithContext(ctx, in, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		time.Sleep(e.latency)
+		return fn(page, last)
+	}, opts...)
+}
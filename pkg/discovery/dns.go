@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// DNS discovers the members from the SRV records of Domain, as etcd does
+// with --discovery-srv: _etcd-server-ssl._tcp with an https peer schema,
+// _etcd-server._tcp otherwise, with the -Name suffix if set. A member is
+// named after the first label of its target and its peer URL uses the port
+// of the record, its client URL the client port of URLs. The target
+// resolving to LocalIP is the local instance.
+type DNS struct {
+	Domain string
+	Name   string
+	// LocalIP is the address of the local instance
+	LocalIP string
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver
+	Logger   logging.Logger
+}
+
+func (d DNS) log() logging.Logger {
+	return logging.OrDefault(d.Logger)
+}
+
+func (d DNS) resolver() *net.Resolver {
+	if d.Resolver != nil {
+		return d.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// service returns the SRV service of the peers for schema
+func (d DNS) service(schema string) string {
+	service := "etcd-server"
+	if schema == "https" {
+		service = "etcd-server-ssl"
+	}
+	if d.Name != "" {
+		service += "-" + d.Name
+	}
+	return service
+}
+
+func (d DNS) Describe() string {
+	return fmt.Sprint("the targets of the SRV records of ", d.Domain)
+}
+
+func (d DNS) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	service := d.service(urls.PeerSchema)
+	_, records, err := d.resolver().LookupSRV(ctx, service, "tcp", d.Domain)
+	if err != nil {
+		return members, fmt.Errorf("Looking up _%s._tcp.%s failed: %w", service, d.Domain, err)
+	}
+	for _, srv := range records {
+		target := strings.TrimSuffix(srv.Target, ".")
+		name := strings.SplitN(target, ".", 2)[0]
+		instance := name
+		if d.LocalIP != "" {
+			addrs, err := d.resolver().LookupHost(ctx, target)
+			if err != nil {
+				return members, err
+			}
+			for _, addr := range addrs {
+				if addr == d.LocalIP {
+					instance = insId
+				}
+			}
+		}
+		members.Voters = append(members.Voters, etcd.Member{
+			Name:      name,
+			Instance:  instance,
+			ClientURL: fmt.Sprint(urls.ClientSchema, "://", target, ":", urls.ClientPort),
+			PeerURL:   fmt.Sprint(urls.PeerSchema, "://", target, ":", srv.Port),
+		})
+	}
+	sort.Slice(members.Voters, func(i, j int) bool { return members.Voters[i].Name < members.Voters[j].Name })
+	for i := 1; i < len(members.Voters); i++ {
+		if members.Voters[i].Name == members.Voters[i-1].Name {
+			return members, errors.New(fmt.Sprint(
+				members.Voters[i].Name, " is the name of several targets of _", service, "._tcp.", d.Domain,
+			))
+		}
+	}
+	d.log().Printf("Expected Members %+v\n", members.Voters)
+	return members, nil
+}
@@ -0,0 +1,9 @@
+package discovery
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotInASG = errors.New("Instance is not in an Autoscaling group")
+)
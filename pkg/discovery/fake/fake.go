@@ -0,0 +1,707 @@
+// Package fake provides in-memory implementations of the AWS APIs used by
+// discovery, so workflows can be exercised and ASG churn simulated without
+// an AWS account.
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+type Instance struct {
+	ID               string
+	PrivateIP        string
+	AvailabilityZone string
+	LifecycleState   string
+	LaunchTime       time.Time
+	Terminated       bool
+	Protected        bool
+	// Tags are the EC2 tags of the instance
+	Tags  map[string]string
+	group string
+}
+
+type group struct {
+	name     string
+	min      int64
+	max      int64
+	desired  int64
+	tags     map[string]string
+	refresh  []*autoscaling.InstanceRefresh
+	launches []string
+	// activities are the scaling activities, newest first
+	activities []*autoscaling.Activity
+}
+
+// AWS is an in-memory Autoscaling and EC2 backend
+type AWS struct {
+	mu        sync.Mutex
+	groups    map[string]*group
+	instances map[string]*Instance
+	// pageSize, when set, pages the DescribeInstances answers by instance
+	// ids, see SetPageSize
+	pageSize int
+	// described are the instance ids of every DescribeInstances call
+	described [][]string
+}
+
+func New() *AWS {
+	return &AWS{
+		groups:    map[string]*group{},
+		instances: map[string]*Instance{},
+	}
+}
+
+// Services returns discovery services backed by this fake
+func (f *AWS) Services() discovery.AWS {
+	return discovery.AWS{AutoScaling: f, EC2: f, Logger: logging.Discard}
+}
+
+func (f *AWS) AddGroup(name string, min, max int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.groups[name] = &group{name: name, min: min, max: max, tags: map[string]string{}}
+}
+
+// Launch adds an InService instance to the group
+func (f *AWS) Launch(groupName, id, ip string) *Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.groups[groupName].desired++
+	return f.launch(groupName, id, ip)
+}
+
+// Pending is how many instances the group lacks to reach its desired
+// capacity, the number an ASG would launch
+func (f *AWS) Pending(groupName string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := f.groups[groupName]
+	n := g.desired
+	for _, id := range g.launches {
+		if f.instances[id].group == g.name {
+			n--
+		}
+	}
+	return int(n)
+}
+
+// Run adds a standalone instance, in no group
+func (f *AWS) Run(id, ip string) *Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance := &Instance{ID: id, PrivateIP: ip, LaunchTime: time.Now()}
+	f.instances[id] = instance
+	return instance
+}
+
+// Fill adds an InService instance for a pending launch, without changing
+// the desired capacity
+func (f *AWS) Fill(groupName, id, ip string) *Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.launch(groupName, id, ip)
+}
+
+func (f *AWS) launch(groupName, id, ip string) *Instance {
+	instance := &Instance{
+		ID:             id,
+		PrivateIP:      ip,
+		LifecycleState: "InService",
+		LaunchTime:     time.Now(),
+		group:          groupName,
+	}
+	f.instances[id] = instance
+	g := f.groups[groupName]
+	g.launches = append(g.launches, id)
+	g.activity(fmt.Sprint("Launching a new EC2 instance: ", id))
+	return instance
+}
+
+// activity records a scaling activity of the group that completed now
+func (g *group) activity(description string) {
+	now := time.Now()
+	g.activities = append([]*autoscaling.Activity{{
+		AutoScalingGroupName: aws.String(g.name),
+		Description:          aws.String(description),
+		StartTime:            aws.Time(now),
+		EndTime:              aws.Time(now),
+		StatusCode:           aws.String("Successful"),
+	}}, g.activities...)
+}
+
+// SetActivityTime moves the time of the scaling activities of the group,
+// e.g. into the past to end a cooldown
+func (f *AWS) SetActivityTime(groupName string, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, activity := range f.groups[groupName].activities {
+		activity.StartTime = aws.Time(t)
+		activity.EndTime = aws.Time(t)
+	}
+}
+
+// Terminate removes the instance from its group, without changing the
+// desired capacity, the way an ASG health replacement would.
+func (f *AWS) Terminate(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terminate(id)
+}
+
+func (f *AWS) terminate(id string) {
+	instance, ok := f.instances[id]
+	if !ok {
+		return
+	}
+	if g, ok := f.groups[instance.group]; ok {
+		g.activity(fmt.Sprint("Terminating EC2 instance: ", id))
+	}
+	instance.Terminated = true
+	instance.LifecycleState = "Terminated"
+	instance.group = ""
+}
+
+// ScaleIn lowers the desired capacity and puts the instance in
+// Terminating:Wait, the way an ASG with a termination lifecycle hook picks
+// it for a scale-in. Instances protected from scale-in are left alone.
+func (f *AWS) ScaleIn(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.groupOf(id)
+	if err != nil || f.instances[id].Protected {
+		return
+	}
+	g.desired--
+	f.instances[id].LifecycleState = "Terminating:Wait"
+}
+
+func (f *AWS) AddInstanceRefresh(groupName, id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := f.groups[groupName]
+	g.refresh = append(g.refresh, &autoscaling.InstanceRefresh{
+		AutoScalingGroupName: aws.String(groupName),
+		InstanceRefreshId:    aws.String(id),
+		Status:               aws.String(status),
+	})
+}
+
+func (f *AWS) SetLifecycleState(id, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances[id].LifecycleState = state
+}
+
+func (f *AWS) Instance(id string) *Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.instances[id]
+}
+
+func (f *AWS) Metadata(id, region string) *Metadata {
+	return &Metadata{InstanceID: id, Region: region}
+}
+
+func (f *AWS) groupOf(id string) (*group, error) {
+	instance, ok := f.instances[id]
+	if !ok || instance.group == "" {
+		return nil, errors.New(fmt.Sprint("Instance not in any group ", id))
+	}
+	return f.groups[instance.group], nil
+}
+
+func (f *AWS) DescribeAutoScalingInstancesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingInstancesInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &autoscaling.DescribeAutoScalingInstancesOutput{}
+	for _, id := range in.InstanceIds {
+		g, err := f.groupOf(*id)
+		if err != nil {
+			continue
+		}
+		instance := f.instances[*id]
+		out.AutoScalingInstances = append(out.AutoScalingInstances, &autoscaling.InstanceDetails{
+			InstanceId:           aws.String(instance.ID),
+			AutoScalingGroupName: aws.String(g.name),
+			LifecycleState:       aws.String(instance.LifecycleState),
+			AvailabilityZone:     aws.String(instance.AvailabilityZone),
+		})
+	}
+	return out, nil
+}
+
+func (f *AWS) DescribeAutoScalingGroupsWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	if len(in.AutoScalingGroupNames) == 0 {
+		names := []string{}
+		for name, g := range f.groups {
+			if g.matches(in.Filters) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			out.AutoScalingGroups = append(out.AutoScalingGroups, f.describe(f.groups[name]))
+		}
+		return out, nil
+	}
+	for _, name := range in.AutoScalingGroupNames {
+		g, ok := f.groups[*name]
+		if !ok {
+			continue
+		}
+		out.AutoScalingGroups = append(out.AutoScalingGroups, f.describe(g))
+	}
+	return out, nil
+}
+
+// DescribeAutoScalingGroupsPagesWithContext follows the NextToken of the
+// answers as the SDK paginator does
+func (f *AWS) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
+	input := *in
+	for {
+		out, err := f.DescribeAutoScalingGroupsWithContext(ctx, &input, opts...)
+		if err != nil {
+			return err
+		}
+		last := aws.StringValue(out.NextToken) == ""
+		if !fn(out, last) || last {
+			return nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// matches supports the tag:KEY and tag-key filters with exact values
+func (g *group) matches(filters []*autoscaling.Filter) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		values := aws.StringValueSlice(filter.Values)
+		var value string
+		var ok bool
+		switch {
+		case name == "tag-key":
+			for key := range g.tags {
+				if contains(values, key) {
+					value, ok = key, true
+				}
+			}
+		case strings.HasPrefix(name, "tag:"):
+			value, ok = g.tags[strings.TrimPrefix(name, "tag:")]
+		}
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *AWS) describe(g *group) *autoscaling.Group {
+	desc := &autoscaling.Group{
+		AutoScalingGroupName: aws.String(g.name),
+		MinSize:              aws.Int64(g.min),
+		MaxSize:              aws.Int64(g.max),
+		DesiredCapacity:      aws.Int64(g.desired),
+	}
+	for _, id := range g.launches {
+		instance := f.instances[id]
+		if instance.group != g.name {
+			continue
+		}
+		desc.Instances = append(desc.Instances, &autoscaling.Instance{
+			InstanceId:           aws.String(instance.ID),
+			LifecycleState:       aws.String(instance.LifecycleState),
+			AvailabilityZone:     aws.String(instance.AvailabilityZone),
+			ProtectedFromScaleIn: aws.Bool(instance.Protected),
+		})
+	}
+	for k, v := range g.tags {
+		desc.Tags = append(desc.Tags, &autoscaling.TagDescription{
+			Key:   aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+	return desc
+}
+
+func (f *AWS) DescribeScalingActivitiesWithContext(ctx aws.Context, in *autoscaling.DescribeScalingActivitiesInput, opts ...request.Option) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &autoscaling.DescribeScalingActivitiesOutput{}
+	if g, ok := f.groups[aws.StringValue(in.AutoScalingGroupName)]; ok {
+		out.Activities = g.activities
+		if n := int(aws.Int64Value(in.MaxRecords)); n > 0 && len(out.Activities) > n {
+			out.Activities = out.Activities[:n]
+		}
+	}
+	return out, nil
+}
+
+func (f *AWS) DescribeInstanceRefreshesWithContext(ctx aws.Context, in *autoscaling.DescribeInstanceRefreshesInput, opts ...request.Option) (*autoscaling.DescribeInstanceRefreshesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &autoscaling.DescribeInstanceRefreshesOutput{}
+	if g, ok := f.groups[*in.AutoScalingGroupName]; ok {
+		out.InstanceRefreshes = g.refresh
+	}
+	return out, nil
+}
+
+func (f *AWS) DetachInstancesWithContext(ctx aws.Context, in *autoscaling.DetachInstancesInput, opts ...request.Option) (*autoscaling.DetachInstancesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.groups[*in.AutoScalingGroupName]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("Autoscaling group not found ", *in.AutoScalingGroupName))
+	}
+	for _, id := range in.InstanceIds {
+		instance, ok := f.instances[*id]
+		if !ok || instance.group != g.name {
+			return nil, errors.New(fmt.Sprint("Instance not in group ", *id))
+		}
+		if aws.BoolValue(in.ShouldDecrementDesiredCapacity) {
+			if g.desired-1 < g.min {
+				return nil, errors.New("Desired capacity would go below min size")
+			}
+			g.desired--
+		}
+		instance.group = ""
+		instance.LifecycleState = "Detached"
+	}
+	return &autoscaling.DetachInstancesOutput{}, nil
+}
+
+func (f *AWS) SetDesiredCapacityWithContext(ctx aws.Context, in *autoscaling.SetDesiredCapacityInput, opts ...request.Option) (*autoscaling.SetDesiredCapacityOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.groups[*in.AutoScalingGroupName]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("Autoscaling group not found ", *in.AutoScalingGroupName))
+	}
+	if *in.DesiredCapacity < g.min || *in.DesiredCapacity > g.max {
+		return nil, errors.New("Desired capacity out of bounds")
+	}
+	g.desired = *in.DesiredCapacity
+	return &autoscaling.SetDesiredCapacityOutput{}, nil
+}
+
+func (f *AWS) CreateOrUpdateTagsWithContext(ctx aws.Context, in *autoscaling.CreateOrUpdateTagsInput, opts ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tag := range in.Tags {
+		g, ok := f.groups[*tag.ResourceId]
+		if !ok {
+			return nil, errors.New(fmt.Sprint("Autoscaling group not found ", *tag.ResourceId))
+		}
+		g.tags[*tag.Key] = *tag.Value
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+// CompleteLifecycleActionWithContext terminates an instance waiting on its
+// termination hook, whatever the result
+func (f *AWS) CompleteLifecycleActionWithContext(ctx aws.Context, in *autoscaling.CompleteLifecycleActionInput, opts ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := aws.StringValue(in.InstanceId)
+	instance, ok := f.instances[id]
+	if !ok || instance.group != aws.StringValue(in.AutoScalingGroupName) || instance.LifecycleState != "Terminating:Wait" {
+		return nil, errors.New(fmt.Sprint("No lifecycle action for instance ", id))
+	}
+	f.terminate(id)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func (f *AWS) SetInstanceProtectionWithContext(ctx aws.Context, in *autoscaling.SetInstanceProtectionInput, opts ...request.Option) (*autoscaling.SetInstanceProtectionOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range in.InstanceIds {
+		instance, ok := f.instances[*id]
+		if !ok || instance.group != aws.StringValue(in.AutoScalingGroupName) {
+			return nil, errors.New(fmt.Sprint("Instance not in group ", *id))
+		}
+		instance.Protected = aws.BoolValue(in.ProtectedFromScaleIn)
+	}
+	return &autoscaling.SetInstanceProtectionOutput{}, nil
+}
+
+// SetPageSize has the DescribeInstances answers by instance ids carry at
+// most n instances, the rest following with NextToken. EC2 may page any
+// answer.
+func (f *AWS) SetPageSize(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pageSize = n
+}
+
+// Described returns the instance ids of every DescribeInstances call by
+// instance ids so far
+func (f *AWS) Described() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.described...)
+}
+
+func (f *AWS) DescribeInstancesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reservation := &ec2.Reservation{}
+	if len(in.InstanceIds) == 0 {
+		return f.describeFiltered(in)
+	}
+	f.described = append(f.described, aws.StringValueSlice(in.InstanceIds))
+	if len(in.InstanceIds) > 1000 {
+		return nil, errors.New(fmt.Sprint("InvalidParameterValue ", len(in.InstanceIds), " instance ids, at most 1000"))
+	}
+	start := 0
+	if in.NextToken != nil {
+		var err error
+		start, err = strconv.Atoi(*in.NextToken)
+		if err != nil || start > len(in.InstanceIds) {
+			return nil, errors.New(fmt.Sprint("InvalidParameterValue NextToken ", *in.NextToken))
+		}
+	}
+	end := len(in.InstanceIds)
+	if f.pageSize > 0 && start+f.pageSize < end {
+		end = start + f.pageSize
+	}
+	for _, id := range in.InstanceIds[start:end] {
+		instance, ok := f.instances[*id]
+		if !ok {
+			return nil, errors.New(fmt.Sprint("InvalidInstanceID.NotFound ", *id))
+		}
+		reservation.Instances = append(reservation.Instances, instance.describe())
+	}
+	out := &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{reservation},
+	}
+	if end < len(in.InstanceIds) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+// DescribeInstancesPagesWithContext follows the NextToken of the answers as
+// the SDK paginator does
+func (f *AWS) DescribeInstancesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	input := *in
+	for {
+		out, err := f.DescribeInstancesWithContext(ctx, &input, opts...)
+		if err != nil {
+			return err
+		}
+		last := aws.StringValue(out.NextToken) == ""
+		if !fn(out, last) || last {
+			return nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+func (instance *Instance) state() string {
+	if instance.Terminated {
+		return "terminated"
+	}
+	return "running"
+}
+
+func (instance *Instance) describe() *ec2.Instance {
+	described := &ec2.Instance{
+		InstanceId:       aws.String(instance.ID),
+		PrivateIpAddress: aws.String(instance.PrivateIP),
+		PrivateDnsName:   aws.String("ip-" + strings.ReplaceAll(instance.PrivateIP, ".", "-") + ".ec2.internal"),
+		LaunchTime:       aws.Time(instance.LaunchTime),
+		Placement: &ec2.Placement{
+			AvailabilityZone: aws.String(instance.AvailabilityZone),
+		},
+		State: &ec2.InstanceState{Name: aws.String(instance.state())},
+	}
+	for k, v := range instance.Tags {
+		described.Tags = append(described.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return described
+}
+
+// describeFiltered supports the tag:KEY, tag-key and instance-state-name
+// filters with exact values, and pages of MaxResults instances
+func (f *AWS) describeFiltered(in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	ids := []string{}
+	for id := range f.instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	matching := []*Instance{}
+	for _, id := range ids {
+		instance := f.instances[id]
+		if instance.matches(in.Filters) {
+			matching = append(matching, instance)
+		}
+	}
+	start := 0
+	if in.NextToken != nil {
+		var err error
+		start, err = strconv.Atoi(*in.NextToken)
+		if err != nil || start > len(matching) {
+			return nil, errors.New(fmt.Sprint("InvalidParameterValue NextToken ", *in.NextToken))
+		}
+	}
+	end := len(matching)
+	if max := int(aws.Int64Value(in.MaxResults)); max > 0 && start+max < end {
+		end = start + max
+	}
+	out := &ec2.DescribeInstancesOutput{}
+	reservation := &ec2.Reservation{}
+	for _, instance := range matching[start:end] {
+		reservation.Instances = append(reservation.Instances, instance.describe())
+	}
+	if len(reservation.Instances) > 0 {
+		out.Reservations = []*ec2.Reservation{reservation}
+	}
+	if end < len(matching) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+func (instance *Instance) matches(filters []*ec2.Filter) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		values := aws.StringValueSlice(filter.Values)
+		var value string
+		var ok bool
+		switch {
+		case name == "instance-state-name":
+			value, ok = instance.state(), true
+		case name == "tag-key":
+			for key := range instance.Tags {
+				if contains(values, key) {
+					value, ok = key, true
+				}
+			}
+		case strings.HasPrefix(name, "tag:"):
+			value, ok = instance.Tags[strings.TrimPrefix(name, "tag:")]
+		}
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeSecurityGroupsWithContext knows no groups, fake instances aren't
+// in any
+func (f *AWS) DescribeSecurityGroupsWithContext(ctx aws.Context, in *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeSecurityGroupsOutput{}, nil
+}
+
+func (f *AWS) TerminateInstancesWithContext(ctx aws.Context, in *ec2.TerminateInstancesInput, opts ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range in.InstanceIds {
+		f.terminate(*id)
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (f *AWS) CreateTagsWithContext(ctx aws.Context, in *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range in.Resources {
+		instance, ok := f.instances[*id]
+		if !ok {
+			return nil, errors.New(fmt.Sprint("InvalidInstanceID.NotFound ", *id))
+		}
+		if instance.Tags == nil {
+			instance.Tags = map[string]string{}
+		}
+		for _, tag := range in.Tags {
+			instance.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// Metadata is a fake EC2 metadata service
+type Metadata struct {
+	InstanceID string
+	Region     string
+}
+
+func (m *Metadata) AvailableWithContext(ctx aws.Context) bool {
+	return ctx.Err() == nil
+}
+
+func (m *Metadata) GetInstanceIdentityDocumentWithContext(ctx aws.Context) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	if err := ctx.Err(); err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	return ec2metadata.EC2InstanceIdentityDocument{
+		InstanceID: m.InstanceID,
+		Region:     m.Region,
+	}, nil
+}
@@ -0,0 +1,292 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+const (
+	// GCPMetadataURL is the metadata server of Compute Engine instances
+	GCPMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	// GCPComputeURL is the Compute Engine API
+	GCPComputeURL = "https://compute.googleapis.com/compute/v1"
+)
+
+// GCP discovers the members from the managed instance group which created
+// the local instance, zonal or regional, the way AWS does from its
+// Autoscaling group. The instance of a member is its instance name, which
+// is also its name, and its zone that of the instance. The API is called
+// with the token of the service account of the instance, which needs the
+// compute.instanceGroupManagers.get and compute.instances.get permissions,
+// e.g. with the Compute Viewer role.
+type GCP struct {
+	// Metadata defaults to GCPMetadataURL, Compute to GCPComputeURL
+	Metadata string
+	Compute  string
+	// HTTP defaults to http.DefaultClient
+	HTTP   *http.Client
+	Logger logging.Logger
+}
+
+// GCPIdentity is the local instance as told by the metadata server
+type GCPIdentity struct {
+	Name      string
+	Project   string
+	Zone      string
+	PrivateIP string
+	// Group is the managed instance group which created the instance,
+	// projects/PROJECT/zones/ZONE/instanceGroupManagers/NAME or a regional
+	// one, empty for an instance created otherwise
+	Group string
+}
+
+// Region is that of Zone, e.g. us-central1 for us-central1-a
+func (id GCPIdentity) Region() string {
+	if i := strings.LastIndex(id.Zone, "-"); i > 0 {
+		return id.Zone[:i]
+	}
+	return id.Zone
+}
+
+type gcpManagedInstance struct {
+	Instance       string
+	InstanceStatus string
+	CurrentAction  string
+}
+
+type gcpInstance struct {
+	Name              string
+	Zone              string
+	NetworkInterfaces []struct {
+		NetworkIP     string
+		AccessConfigs []struct {
+			NatIP string
+		}
+	}
+}
+
+func (g GCP) log() logging.Logger {
+	return logging.OrDefault(g.Logger)
+}
+
+func (g GCP) client() *http.Client {
+	if g.HTTP == nil {
+		return http.DefaultClient
+	}
+	return g.HTTP
+}
+
+// Identity reads the local instance from the metadata server
+func (g GCP) Identity(ctx context.Context) (GCPIdentity, error) {
+	id := GCPIdentity{}
+	for _, v := range []struct {
+		path  string
+		value *string
+	}{
+		{"/instance/name", &id.Name},
+		{"/instance/zone", &id.Zone},
+		{"/project/project-id", &id.Project},
+		{"/instance/network-interfaces/0/ip", &id.PrivateIP},
+	} {
+		data, err := g.metadata(ctx, v.path)
+		if err != nil {
+			return id, err
+		}
+		*v.value = string(data)
+	}
+	// projects/NUMBER/zones/ZONE
+	id.Zone = path.Base(id.Zone)
+	createdBy, err := g.metadata(ctx, "/instance/attributes/created-by")
+	if err != nil && !errors.Is(err, errGCPNotFound) {
+		return id, err
+	}
+	id.Group = string(createdBy)
+	return id, nil
+}
+
+var errGCPNotFound = errors.New("Not found")
+
+func (g GCP) metadata(ctx context.Context, p string) ([]byte, error) {
+	base := g.Metadata
+	if base == "" {
+		base = GCPMetadataURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(base, "/")+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: metadata %s", errGCPNotFound, p)
+	case resp.StatusCode >= 300:
+		return nil, errors.New(fmt.Sprintf("GCP metadata GET %s failed: %s %s", p, resp.Status, bytes.TrimSpace(data)))
+	}
+	return bytes.TrimSpace(data), nil
+}
+
+func (g GCP) token(ctx context.Context) (string, error) {
+	data, err := g.metadata(ctx, "/instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// call sends a request to the Compute API, target is a full URL or a path
+// under Compute
+func (g GCP) call(ctx context.Context, token string, method string, target string, v interface{}) error {
+	u := target
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		base := g.Compute
+		if base == "" {
+			base = GCPComputeURL
+		}
+		u = strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(target, "/")
+	} else if g.Compute != "" {
+		// The self links of the API, rebased for tests and proxies
+		u = strings.Replace(u, GCPComputeURL, strings.TrimSuffix(g.Compute, "/"), 1)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("GCP %s %s failed: %s %s", method, u, resp.Status, bytes.TrimSpace(data)))
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (g GCP) Describe() string {
+	return "the running instances of the managed instance group"
+}
+
+// GetMembers returns a voter for every running instance of the managed
+// instance group of the local instance, leaving out those being deleted,
+// abandoned or recreated
+func (g GCP) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	id, err := g.Identity(ctx)
+	if err != nil {
+		return members, err
+	}
+	if id.Group == "" || !strings.Contains(id.Group, "/instanceGroupManagers/") {
+		return members, errors.New(fmt.Sprint("The instance ", id.Name, " wasn't created by a managed instance group"))
+	}
+	token, err := g.token(ctx)
+	if err != nil {
+		return members, err
+	}
+	managed := []gcpManagedInstance{}
+	pageToken := ""
+	for {
+		var page struct {
+			ManagedInstances []gcpManagedInstance
+			NextPageToken    string
+		}
+		target := id.Group + "/listManagedInstances"
+		if pageToken != "" {
+			target += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		if err := g.call(ctx, token, "POST", target, &page); err != nil {
+			return members, err
+		}
+		managed = append(managed, page.ManagedInstances...)
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	for _, mi := range managed {
+		if mi.InstanceStatus != "RUNNING" {
+			continue
+		}
+		switch mi.CurrentAction {
+		case "DELETING", "ABANDONING", "RECREATING":
+			continue
+		}
+		var instance gcpInstance
+		if err := g.call(ctx, token, "GET", mi.Instance, &instance); err != nil {
+			return members, err
+		}
+		address, err := gcpAddress(instance, id.Project, urls.Address)
+		if err != nil {
+			return members, err
+		}
+		if address == "" {
+			g.log().Println("Ignoring instance without a", urls.Address, "address", instance.Name)
+			continue
+		}
+		members.Voters = append(members.Voters, etcd.Member{
+			Name:      instance.Name,
+			Zone:      path.Base(instance.Zone),
+			Instance:  instance.Name,
+			ClientURL: fmt.Sprint(urls.ClientSchema, "://", address, ":", urls.ClientPort),
+			PeerURL:   fmt.Sprint(urls.PeerSchema, "://", address, ":", urls.PeerPort),
+		})
+	}
+	sort.Slice(members.Voters, func(i, j int) bool { return members.Voters[i].Name < members.Voters[j].Name })
+	g.log().Printf("Expected Members %+v\n", members.Voters)
+	return members, nil
+}
+
+// gcpAddress returns the address of instance on its first network interface,
+// its zonal DNS name for AddressPrivateDNS
+func gcpAddress(instance gcpInstance, project string, t AddressType) (string, error) {
+	if len(instance.NetworkInterfaces) == 0 {
+		return "", nil
+	}
+	nic := instance.NetworkInterfaces[0]
+	switch t {
+	case AddressPrivateIP, "":
+		return nic.NetworkIP, nil
+	case AddressPublicIP:
+		if len(nic.AccessConfigs) == 0 {
+			return "", nil
+		}
+		return nic.AccessConfigs[0].NatIP, nil
+	case AddressPrivateDNS:
+		return fmt.Sprint(instance.Name, ".", path.Base(instance.Zone), ".c.", project, ".internal"), nil
+	}
+	return "", errors.New(fmt.Sprint("Address type ", t, " isn't supported on GCP"))
+}
@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// Group discovers the members of the Autoscaling group AsgName whatever
+// the group of the local instance, for instances outside the cluster such
+// as a proxy tier
+type Group struct {
+	AWS     AWS
+	AsgName string
+}
+
+func (g Group) Describe() string {
+	return fmt.Sprint("the InService instances of the Autoscaling group ", g.AsgName, " with an address")
+}
+
+func (g Group) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	instanceIds, err := g.AWS.GetAsgInstanceIds(ctx, g.AsgName)
+	if err != nil {
+		return Members{}, err
+	}
+	instances, err := g.AWS.GetEC2Instances(ctx, instanceIds)
+	if err != nil {
+		return Members{}, err
+	}
+	members, err := g.AWS.members(instances, urls)
+	if err != nil {
+		return members, err
+	}
+	g.AWS.log().Printf("Members of %s %+v\n", g.AsgName, members.Voters)
+	return members, nil
+}
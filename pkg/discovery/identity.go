@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+)
+
+// LocalIdentity derives the identity of the local host without the EC2
+// metadata service: the first label of the hostname as instance ID and the
+// first IPv4 address of iface, or of the first interface up that isn't
+// loopback if empty, as private IP. Region is left to the caller.
+func LocalIdentity(iface string) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	ip, err := interfaceIP(iface)
+	if err != nil {
+		return ec2metadata.EC2InstanceIdentityDocument{}, err
+	}
+	return ec2metadata.EC2InstanceIdentityDocument{
+		InstanceID: strings.SplitN(hostname, ".", 2)[0],
+		PrivateIP:  ip,
+	}, nil
+}
+
+func interfaceIP(name string) (string, error) {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return "", err
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return "", err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP.String(), nil
+			}
+		}
+	}
+	if name != "" {
+		return "", errors.New(fmt.Sprint("No IPv4 address on interface ", name))
+	}
+	return "", errors.New("No network interface with an IPv4 address")
+}
@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// NameData is what member name templates are evaluated against
+type NameData struct {
+	InstanceID       string
+	AvailabilityZone string
+	// AvailabilityZoneSuffix is the zone letter, a for us-east-1a
+	AvailabilityZoneSuffix string
+	LaunchIndex            int64
+	PrivateIP              string
+	PrivateDNSName         string
+	Tags                   map[string]string
+}
+
+func nameData(instance ec2.Instance) NameData {
+	data := NameData{
+		InstanceID:     aws.StringValue(instance.InstanceId),
+		LaunchIndex:    aws.Int64Value(instance.AmiLaunchIndex),
+		PrivateIP:      aws.StringValue(instance.PrivateIpAddress),
+		PrivateDNSName: aws.StringValue(instance.PrivateDnsName),
+		Tags:           map[string]string{},
+	}
+	if instance.Placement != nil {
+		data.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+		if zone := data.AvailabilityZone; zone != "" {
+			data.AvailabilityZoneSuffix = zone[len(zone)-1:]
+		}
+	}
+	for _, tag := range instance.Tags {
+		data.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return data
+}
+
+// NameSourceTemplate is the member name template of a name source:
+// instance-id, the default which needs none, private-dns, tag:KEY naming
+// the members after the tag KEY, or az-index naming them after their zone
+// and the index ClaimZoneIndex gave them, e.g. us-east-1a-0
+func NameSourceTemplate(source string) (string, error) {
+	switch {
+	case source == "instance-id":
+		return "", nil
+	case source == "private-dns":
+		return "{{.PrivateDNSName}}", nil
+	case source == "az-index":
+		return fmt.Sprintf("{{.AvailabilityZone}}-{{index .Tags %q}}", ZoneIndexTag), nil
+	case strings.HasPrefix(source, "tag:") && source != "tag:":
+		return fmt.Sprintf("{{index .Tags %q}}", strings.TrimPrefix(source, "tag:")), nil
+	}
+	return "", errors.New(fmt.Sprint("Invalid name source ", source, ", expected instance-id, private-dns, tag:KEY or az-index"))
+}
+
+// ParseNameTemplate parses a member name template such as
+// etcd-{{.AvailabilityZoneSuffix}}-{{.Tags.Index}}, see NameData
+func ParseNameTemplate(text string) (*template.Template, error) {
+	t, err := template.New("member-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Catch references to fields that don't exist now rather than per member
+	sample := ec2.Instance{InstanceId: aws.String("i-0123456789abcdef0")}
+	var b bytes.Buffer
+	if err := t.Execute(&b, nameData(sample)); err != nil && !isMissingKey(err) {
+		return nil, err
+	}
+	return t, nil
+}
+
+func isMissingKey(err error) bool {
+	return strings.Contains(err.Error(), "map has no entry for key")
+}
+
+// memberName is the instance ID unless a name template is set. Templates
+// failing for an instance, typically on a missing tag, give the instance ID
+// too, checkNames reports them.
+func (u URLs) memberName(instance ec2.Instance) string {
+	name, err := u.templateName(instance)
+	if err != nil || name == "" {
+		return aws.StringValue(instance.InstanceId)
+	}
+	return name
+}
+
+func (u URLs) templateName(instance ec2.Instance) (string, error) {
+	if u.Name == nil {
+		return "", nil
+	}
+	var b bytes.Buffer
+	if err := u.Name.Execute(&b, nameData(instance)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// checkNames fails when the name template can't name an instance or gives
+// two instances the same name, etcd requires unique names
+func (u URLs) checkNames(instances []ec2.Instance) error {
+	if u.Name == nil {
+		return nil
+	}
+	seen := map[string]string{}
+	for _, instance := range instances {
+		id := aws.StringValue(instance.InstanceId)
+		name, err := u.templateName(instance)
+		if err != nil {
+			return errors.New(fmt.Sprint("Member name template fails for ", id, ": ", err))
+		}
+		if name == "" {
+			return errors.New(fmt.Sprint("Member name template gives ", id, " an empty name"))
+		}
+		if other, ok := seen[name]; ok {
+			return errors.New(fmt.Sprint("Member name template gives ", other, " and ", id, " the same name ", name))
+		}
+		seen[name] = id
+	}
+	return nil
+}
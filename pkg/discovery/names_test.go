@@ -0,0 +1,66 @@
+package discovery_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+)
+
+func TestMemberNames(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// source is a name source, template a name template when empty
+		source   string
+		template string
+		want     string
+		err      bool
+	}{
+		{name: "instance id", source: "instance-id", want: "i-0"},
+		{name: "private dns", source: "private-dns", want: "ip-10-0-0-1.ec2.internal"},
+		{name: "tag", source: "tag:Name", want: "etcd-a"},
+		{name: "zone index", source: "az-index", want: "us-east-1a-0"},
+		{name: "empty tag key", source: "tag:", err: true},
+		{name: "unknown source", source: "hostname", err: true},
+		{name: "template", template: `etcd-{{.AvailabilityZoneSuffix}}{{index .Tags "etcdmate:zone-index"}}`, want: "etcd-a0"},
+		{name: "template of an unknown field", template: "{{.Hostname}}", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text := tc.template
+			if tc.source != "" {
+				var err error
+				text, err = discovery.NameSourceTemplate(tc.source)
+				if err != nil || tc.err {
+					if (err != nil) != tc.err {
+						t.Fatalf("got error %v, want an error %t", err, tc.err)
+					}
+					return
+				}
+			}
+			urls := discovery.URLs{ClientSchema: "http", ClientPort: 2379, PeerSchema: "http", PeerPort: 2380}
+			if text != "" {
+				name, err := discovery.ParseNameTemplate(text)
+				if (err != nil) != tc.err {
+					t.Fatalf("got error %v, want an error %t", err, tc.err)
+				}
+				if err != nil {
+					return
+				}
+				urls.Name = name
+			}
+			f := fake.New()
+			f.AddGroup("etcd", 1, 3)
+			instance := f.Launch("etcd", "i-0", "10.0.0.1")
+			instance.AvailabilityZone = "us-east-1a"
+			instance.Tags = map[string]string{"Name": "etcd-a", discovery.ZoneIndexTag: "0"}
+			members, err := f.Services().GetMembers(context.Background(), "i-0", urls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(members.Voters) != 1 || members.Voters[0].Name != tc.want || members.Voters[0].Instance != "i-0" {
+				t.Errorf("got members %+v, want %s of the instance i-0", members.Voters, tc.want)
+			}
+		})
+	}
+}
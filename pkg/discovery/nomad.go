@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// Source finds the members of the cluster of the local instance insId,
+// AWS unless another one is configured. Describe tells which members it
+// finds, for the explanations of a run.
+type Source interface {
+	GetMembers(ctx context.Context, insId string, urls URLs) (Members, error)
+	Describe() string
+}
+
+// Nomad discovers the members from the running allocations of Job which
+// registered Service with the Nomad service discovery, e.g. those of a
+// system job running etcd on every client. The instance of a member is its
+// allocation ID, NOMAD_ALLOC_ID in the environment of the task, its name
+// the node of the allocation, which a system job keeps across allocations,
+// and its zone the datacenter. The URLs use the address of the service
+// registration with the schemas and ports of URLs.
+type Nomad struct {
+	// Addr is the Nomad HTTP API, http://127.0.0.1:4646 for the local agent
+	Addr      string
+	Token     string
+	Namespace string
+	Job       string
+	Service   string
+	// HTTP defaults to http.DefaultClient
+	HTTP   *http.Client
+	Logger logging.Logger
+}
+
+type nomadAllocation struct {
+	ID            string
+	NodeName      string
+	ClientStatus  string
+	DesiredStatus string
+}
+
+type nomadRegistration struct {
+	AllocID    string
+	Address    string
+	Datacenter string
+}
+
+func (n Nomad) log() logging.Logger {
+	return logging.OrDefault(n.Logger)
+}
+
+func (n Nomad) Describe() string {
+	return fmt.Sprint("the running allocations of the job ", n.Job, " registering the service ", n.Service)
+}
+
+// GetMembers returns a voter for every running allocation of Job with a
+// registration of Service
+func (n Nomad) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	var allocations []nomadAllocation
+	if err := n.get(ctx, "/v1/job/"+url.PathEscape(n.Job)+"/allocations", &allocations); err != nil {
+		return members, err
+	}
+	var registrations []nomadRegistration
+	if err := n.get(ctx, "/v1/service/"+url.PathEscape(n.Service), &registrations); err != nil {
+		return members, err
+	}
+	registered := map[string]nomadRegistration{}
+	for _, r := range registrations {
+		registered[r.AllocID] = r
+	}
+	for _, alloc := range allocations {
+		if alloc.ClientStatus != "running" || alloc.DesiredStatus != "run" {
+			continue
+		}
+		r, ok := registered[alloc.ID]
+		if !ok || r.Address == "" {
+			n.log().Println("Ignoring allocation without a registration of", n.Service, alloc.ID)
+			continue
+		}
+		name := alloc.NodeName
+		if name == "" {
+			name = alloc.ID
+		}
+		members.Voters = append(members.Voters, etcd.Member{
+			Name:      name,
+			Zone:      r.Datacenter,
+			Instance:  alloc.ID,
+			ClientURL: fmt.Sprint(urls.ClientSchema, "://", r.Address, ":", urls.ClientPort),
+			PeerURL:   fmt.Sprint(urls.PeerSchema, "://", r.Address, ":", urls.PeerPort),
+		})
+	}
+	sort.Slice(members.Voters, func(i, j int) bool { return members.Voters[i].Name < members.Voters[j].Name })
+	n.log().Printf("Expected Members %+v\n", members.Voters)
+	return members, nil
+}
+
+func (n Nomad) get(ctx context.Context, path string, v interface{}) error {
+	u := strings.TrimSuffix(n.Addr, "/") + path
+	if n.Namespace != "" {
+		u += "?namespace=" + url.QueryEscape(n.Namespace)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if n.Token != "" {
+		req.Header.Set("X-Nomad-Token", n.Token)
+	}
+	client := n.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("Nomad GET %s failed: %s %s", path, resp.Status, bytes.TrimSpace(data)))
+	}
+	return json.Unmarshal(data, v)
+}
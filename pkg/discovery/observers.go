@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// RoleTag set to RoleObserver on an instance of the group makes it an
+// observer: it gets the endpoints and is monitored like the members but is
+// never added to the cluster
+const (
+	RoleTag      = "etcdmate:role"
+	RoleObserver = "observer"
+)
+
+// Members are the discovered instances by role, Voters are the expected
+// members of the cluster
+type Members struct {
+	Voters    []etcd.Member
+	Observers []etcd.Member
+}
+
+func isObserver(instance ec2.Instance) bool {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == RoleTag {
+			return aws.StringValue(tag.Value) == RoleObserver
+		}
+	}
+	return false
+}
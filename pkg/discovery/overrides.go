@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Instance tags overriding, for that instance only, the schemas and ports
+// its member URLs are built with, e.g. while some members are already
+// moved to https
+const (
+	ClientSchemaTag = "etcdmate:client-schema"
+	ClientPortTag   = "etcdmate:client-port"
+	PeerSchemaTag   = "etcdmate:peer-schema"
+	PeerPortTag     = "etcdmate:peer-port"
+)
+
+// ForInstance returns u with the schemas and ports overridden by the tags
+// of instance, invalid values are ignored, checkOverrides reports them
+func (u URLs) ForInstance(instance ec2.Instance) URLs {
+	for _, tag := range instance.Tags {
+		value := aws.StringValue(tag.Value)
+		switch aws.StringValue(tag.Key) {
+		case ClientSchemaTag:
+			if validSchema(value) {
+				u.ClientSchema = value
+			}
+		case PeerSchemaTag:
+			if validSchema(value) {
+				u.PeerSchema = value
+			}
+		case ClientPortTag:
+			if port, err := parsePort(value); err == nil {
+				u.ClientPort = port
+			}
+		case PeerPortTag:
+			if port, err := parsePort(value); err == nil {
+				u.PeerPort = port
+			}
+		}
+	}
+	return u
+}
+
+func validSchema(schema string) bool {
+	return schema == "http" || schema == "https"
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.New(fmt.Sprint("Invalid port ", value))
+	}
+	return port, nil
+}
+
+// checkOverrides fails when an instance has an override tag with a value
+// that isn't a schema or a port
+func (u URLs) checkOverrides(instances []ec2.Instance) error {
+	for _, instance := range instances {
+		for _, tag := range instance.Tags {
+			key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+			switch key {
+			case ClientSchemaTag, PeerSchemaTag:
+				if !validSchema(value) {
+					return errors.New(fmt.Sprint(
+						"Tag ", key, " of ", aws.StringValue(instance.InstanceId),
+						" is ", value, ", not http or https",
+					))
+				}
+			case ClientPortTag, PeerPortTag:
+				if _, err := parsePort(value); err != nil {
+					return errors.New(fmt.Sprint(
+						"Tag ", key, " of ", aws.StringValue(instance.InstanceId), ": ", err,
+					))
+				}
+			}
+		}
+	}
+	return nil
+}
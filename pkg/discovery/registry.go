@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"context"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/registry"
+)
+
+// withRegistry registers the local member insId among the voters in
+// svc.Registry and adds the members registered there by others, e.g. on
+// premises, to the voters. The members registered for instances are known
+// from AWS already, those of terminated instances are ignored.
+func (svc AWS) withRegistry(ctx context.Context, insId string, members *Members) error {
+	for _, m := range members.Voters {
+		if m.Instance != insId {
+			continue
+		}
+		err := svc.Registry.Register(ctx, registry.Entry{
+			Name:      m.Name,
+			ClientURL: m.ClientURL,
+			PeerURL:   m.PeerURL,
+			Zone:      m.Zone,
+			Source:    registry.SourceAWS,
+			TTL:       int64(svc.RegistryTTL.Seconds()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	entries, err := svc.Registry.Entries(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Source == registry.SourceAWS {
+			continue
+		}
+		m := e.Member()
+		if hasMember(members.Voters, m) {
+			continue
+		}
+		svc.log().Println("Registry member", m.Name, m.PeerURL)
+		members.Voters = append(members.Voters, m)
+	}
+	return nil
+}
+
+func hasMember(members []etcd.Member, m etcd.Member) bool {
+	for _, member := range members {
+		if member.Name == m.Name || member.PeerURL == m.PeerURL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AuditSecurityGroups returns the inbound rules missing from the security
+// groups of the instance for the other expected members to reach its
+// client and peer ports, one description per port and member
+func (svc AWS) AuditSecurityGroups(ctx context.Context, insId string, urls URLs) ([]string, error) {
+	asgName, err := svc.GetAsg(ctx, insId)
+	if err != nil {
+		return nil, err
+	}
+	instanceIds, err := svc.GetAsgInstanceIds(ctx, asgName)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := svc.GetEC2Instances(ctx, instanceIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, remote := range svc.Remotes {
+		remoteInstances, err := remote.instances(ctx)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, remoteInstances...)
+	}
+	var local *ec2.Instance
+	for i := range instances {
+		if aws.StringValue(instances[i].InstanceId) == insId {
+			local = &instances[i]
+		}
+	}
+	if local == nil || len(local.SecurityGroups) == 0 {
+		return nil, nil
+	}
+	groupIds := []*string{}
+	for _, group := range local.SecurityGroups {
+		groupIds = append(groupIds, group.GroupId)
+	}
+	resp, err := svc.EC2.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: groupIds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The ports to open are those of the local member, which may be
+	// overridden by its tags
+	ports := urls.ForInstance(*local)
+	missing := []string{}
+	for _, instance := range instances {
+		if instance.InstanceId == local.InstanceId {
+			continue
+		}
+		for _, p := range []struct {
+			port    int
+			address AddressType
+		}{
+			{ports.ClientPort, urls.clientAddress()},
+			{ports.PeerPort, urls.peerAddress()},
+		} {
+			source := sourceIP(instance, urls, p.address)
+			if !allowed(resp.SecurityGroups, instance, source, p.port) {
+				missing = append(missing, fmt.Sprintf(
+					"TCP port %d from %s (%s)",
+					p.port,
+					*instance.InstanceId,
+					source,
+				))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// sourceIP is the address the traffic of instance comes from, public when
+// members talk over public addresses of type t
+func sourceIP(instance ec2.Instance, urls URLs, t AddressType) string {
+	if t == AddressPublicIP || t == AddressPublicDNS {
+		return urls.publicIP(instance)
+	}
+	return urls.privateIP(instance)
+}
+
+// allowed tells whether an inbound rule of groups lets source, an instance
+// at address ip, reach port
+func allowed(groups []*ec2.SecurityGroup, source ec2.Instance, ip string, port int) bool {
+	sourceGroups := map[string]bool{}
+	for _, group := range source.SecurityGroups {
+		sourceGroups[aws.StringValue(group.GroupId)] = true
+	}
+	addr := net.ParseIP(ip)
+	for _, group := range groups {
+		for _, rule := range group.IpPermissions {
+			if !rulePort(rule, port) {
+				continue
+			}
+			for _, pair := range rule.UserIdGroupPairs {
+				if sourceGroups[aws.StringValue(pair.GroupId)] {
+					return true
+				}
+			}
+			for _, ipRange := range rule.IpRanges {
+				_, cidr, err := net.ParseCIDR(aws.StringValue(ipRange.CidrIp))
+				if err == nil && addr != nil && cidr.Contains(addr) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func rulePort(rule *ec2.IpPermission, port int) bool {
+	switch aws.StringValue(rule.IpProtocol) {
+	case "-1":
+		return true
+	case "tcp", "6":
+		return aws.Int64Value(rule.FromPort) <= int64(port) && int64(port) <= aws.Int64Value(rule.ToPort)
+	}
+	return false
+}
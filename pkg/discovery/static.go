@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// Static discovers the members from a fixed list, those of File, read again
+// on every call so it can be edited while etcdmate runs, then Members. The
+// member named after the local instance, or whose peer URL is on LocalIP,
+// is the local instance, the others are their own instances.
+type Static struct {
+	File    string
+	Members []StaticMember
+	// LocalIP is the address of the local instance
+	LocalIP string
+	Logger  logging.Logger
+}
+
+// StaticMember is a member of a Static list, its URLs default to the
+// schemas and ports of URLs on Address
+type StaticMember struct {
+	Name      string
+	Address   string `json:",omitempty"`
+	ClientURL string `json:",omitempty"`
+	PeerURL   string `json:",omitempty"`
+}
+
+// ParseStaticMember parses NAME=ADDRESS
+func ParseStaticMember(text string) (StaticMember, error) {
+	parts := strings.SplitN(text, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return StaticMember{}, errors.New(fmt.Sprint("Invalid member ", text, ", expected NAME=ADDRESS"))
+	}
+	return StaticMember{Name: parts[0], Address: parts[1]}, nil
+}
+
+func (s Static) log() logging.Logger {
+	return logging.OrDefault(s.Logger)
+}
+
+// load returns the members of File then Members
+func (s Static) load() ([]StaticMember, error) {
+	list := []StaticMember{}
+	if s.File != "" {
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, errors.New(fmt.Sprint("Invalid members file ", s.File, ": ", err))
+		}
+	}
+	return append(list, s.Members...), nil
+}
+
+func (s Static) Describe() string {
+	if s.File != "" {
+		return fmt.Sprint("the members listed in ", s.File)
+	}
+	return "the static members"
+}
+
+func (s Static) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	_, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	members := Members{Voters: []etcd.Member{}, Observers: []etcd.Member{}}
+	list, err := s.load()
+	if err != nil {
+		return members, err
+	}
+	for _, sm := range list {
+		m := etcd.Member{
+			Name:      sm.Name,
+			Instance:  sm.Name,
+			ClientURL: sm.ClientURL,
+			PeerURL:   sm.PeerURL,
+		}
+		if m.ClientURL == "" {
+			m.ClientURL = fmt.Sprint(urls.ClientSchema, "://", sm.Address, ":", urls.ClientPort)
+		}
+		if m.PeerURL == "" {
+			m.PeerURL = fmt.Sprint(urls.PeerSchema, "://", sm.Address, ":", urls.PeerPort)
+		}
+		if sm.Name == "" || (sm.Address == "" && (sm.ClientURL == "" || sm.PeerURL == "")) {
+			return members, errors.New(fmt.Sprintf("Static members need a Name and an Address or URLs, got %+v", sm))
+		}
+		if peer, err := url.Parse(m.PeerURL); err == nil && s.LocalIP != "" && peer.Hostname() == s.LocalIP {
+			m.Instance = insId
+		}
+		members.Voters = append(members.Voters, m)
+	}
+	sort.Slice(members.Voters, func(i, j int) bool { return members.Voters[i].Name < members.Voters[j].Name })
+	for i := 1; i < len(members.Voters); i++ {
+		if members.Voters[i].Name == members.Voters[i-1].Name {
+			return members, errors.New(fmt.Sprint(members.Voters[i].Name, " is the name of several static members"))
+		}
+	}
+	s.log().Printf("Expected Members %+v\n", members.Voters)
+	return members, nil
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// Tags discovers the members from the running EC2 instances matching all of
+// Filters, whatever their Autoscaling group, for clusters spread over
+// several groups or made of standalone instances
+type Tags struct {
+	AWS     AWS
+	Filters []TagFilter
+}
+
+// TagFilter matches the instances with the tag Key, of Value unless empty.
+// Values may use the * and ? wildcards of EC2 filters.
+type TagFilter struct {
+	Key   string
+	Value string
+}
+
+// ParseTagFilter parses KEY=VALUE, or KEY for any value
+func ParseTagFilter(text string) (TagFilter, error) {
+	parts := strings.SplitN(text, "=", 2)
+	if parts[0] == "" {
+		return TagFilter{}, errors.New(fmt.Sprint("Invalid tag filter ", text, ", expected KEY=VALUE or KEY"))
+	}
+	f := TagFilter{Key: parts[0]}
+	if len(parts) == 2 {
+		f.Value = parts[1]
+	}
+	return f, nil
+}
+
+func (f TagFilter) String() string {
+	if f.Value == "" {
+		return f.Key
+	}
+	return f.Key + "=" + f.Value
+}
+
+func (f TagFilter) ec2Filter() *ec2.Filter {
+	if f.Value == "" {
+		return &ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(f.Key)}}
+	}
+	return &ec2.Filter{Name: aws.String("tag:" + f.Key), Values: []*string{aws.String(f.Value)}}
+}
+
+func (f TagFilter) asgFilter() *autoscaling.Filter {
+	if f.Value == "" {
+		return &autoscaling.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(f.Key)}}
+	}
+	return &autoscaling.Filter{Name: aws.String("tag:" + f.Key), Values: []*string{aws.String(f.Value)}}
+}
+
+func (t Tags) Describe() string {
+	filters := []string{}
+	for _, f := range t.Filters {
+		filters = append(filters, f.String())
+	}
+	return fmt.Sprint("the running instances tagged ", strings.Join(filters, " and "))
+}
+
+func (t Tags) GetMembers(ctx context.Context, insId string, urls URLs) (_ Members, err error) {
+	ctx, span := tracing.Start(ctx, "discovery.expected-members")
+	defer func() { span.End(err) }()
+	instances, err := t.AWS.GetTaggedInstances(ctx, t.Filters)
+	if err != nil {
+		return Members{}, err
+	}
+	members, err := t.AWS.members(instances, urls)
+	if err != nil {
+		return members, err
+	}
+	t.AWS.log().Printf("Expected Members %+v\n", members.Voters)
+	if len(members.Observers) > 0 {
+		t.AWS.log().Printf("Observers %+v\n", members.Observers)
+	}
+	return members, nil
+}
+
+// GetTaggedInstances returns the running instances matching all of
+// filters, by instance ID
+func (svc AWS) GetTaggedInstances(ctx context.Context, filters []TagFilter) ([]ec2.Instance, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-state-name"), Values: []*string{aws.String("running")}},
+		},
+		MaxResults: aws.Int64(1000),
+	}
+	for _, f := range filters {
+		input.Filters = append(input.Filters, f.ec2Filter())
+	}
+	instances := []ec2.Instance{}
+	err := svc.EC2.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.InstanceId == nil || instance.PrivateIpAddress == nil {
+					svc.log().Printf("Ignoring instance without address %+v\n", instance)
+					continue
+				}
+				instances = append(instances, *instance)
+				svc.Cache.put("ec2/"+*instance.InstanceId, *instance)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The generated configuration stays stable across pages and calls
+	sort.Slice(instances, func(i, j int) bool {
+		return aws.StringValue(instances[i].InstanceId) < aws.StringValue(instances[j].InstanceId)
+	})
+	return instances, nil
+}
+
+// FindAsg returns the name of the Autoscaling group with the tags of all
+// of filters, there must be exactly one. Autoscaling filters take exact
+// values, without wildcards.
+func (svc AWS) FindAsg(ctx context.Context, filters []TagFilter) (string, error) {
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+	for _, f := range filters {
+		input.Filters = append(input.Filters, f.asgFilter())
+	}
+	names := []string{}
+	err := svc.AutoScaling.DescribeAutoScalingGroupsPagesWithContext(ctx, input, func(page *autoscaling.DescribeAutoScalingGroupsOutput, last bool) bool {
+		for _, group := range page.AutoScalingGroups {
+			names = append(names, aws.StringValue(group.AutoScalingGroupName))
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	described := []string{}
+	for _, f := range filters {
+		described = append(described, f.String())
+	}
+	switch len(names) {
+	case 0:
+		return "", errors.New(fmt.Sprint("No Autoscaling group has the tags ", strings.Join(described, ", ")))
+	case 1:
+		svc.log().Println("Found Autoscaling group", names[0], "by its tags")
+		return names[0], nil
+	}
+	sort.Strings(names)
+	return "", errors.New(fmt.Sprint(
+		"Several Autoscaling groups have the tags ", strings.Join(described, ", "), ": ", strings.Join(names, ", "),
+	))
+}
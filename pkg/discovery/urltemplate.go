@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// URLData is what member URL templates are evaluated against
+type URLData struct {
+	NameData
+	// Name is the member name
+	Name string
+	// Address is the instance address of the Address type, or of the
+	// ClientAddress or PeerAddress type for the URL built
+	Address string
+}
+
+// ParseURLTemplate parses a member URL template such as
+// https://{{.Name}}.etcd.internal:2380, see URLData
+func ParseURLTemplate(text string) (*template.Template, error) {
+	t, err := template.New("member-url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := URLData{
+		NameData: nameData(ec2.Instance{InstanceId: aws.String("i-0123456789abcdef0")}),
+		Name:     "i-0123456789abcdef0",
+		Address:  "10.0.0.1",
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, sample); err != nil {
+		if !isMissingKey(err) {
+			return nil, err
+		}
+		return t, nil
+	}
+	if _, err := parseMemberURL(b.String()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseMemberURL(text string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(text))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" || u.Port() == "" {
+		return nil, errors.New(fmt.Sprint("Member URL ", text, " needs a schema, a host and a port"))
+	}
+	return u, nil
+}
+
+func (u URLs) templateURL(t *template.Template, instance ec2.Instance, name string, addr string) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var b bytes.Buffer
+	err := t.Execute(&b, URLData{NameData: nameData(instance), Name: name, Address: addr})
+	if err != nil {
+		return "", err
+	}
+	parsed, err := parseMemberURL(b.String())
+	if err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+// checkURLs fails when a URL template can't build the URLs of an instance
+func (u URLs) checkURLs(instances []ec2.Instance) error {
+	for _, instance := range instances {
+		name := u.memberName(instance)
+		for _, url := range []struct {
+			t    *template.Template
+			addr string
+		}{
+			{u.ClientURL, u.ClientAddr(instance)},
+			{u.PeerURL, u.PeerAddr(instance)},
+		} {
+			if _, err := u.templateURL(url.t, instance, name, url.addr); err != nil {
+				return errors.New(fmt.Sprint(
+					"Member URL template fails for ", aws.StringValue(instance.InstanceId), ": ", err,
+				))
+			}
+		}
+	}
+	return nil
+}
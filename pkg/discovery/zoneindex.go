@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ZoneIndexTag holds the index ClaimZoneIndex gave an instance among those
+// of its Autoscaling group in its zone
+const ZoneIndexTag = "etcdmate:zone-index"
+
+// zoneIndexSettle is how long a claim is left for concurrent claims of the
+// same index to show up
+var zoneIndexSettle = 2 * time.Second
+
+// ClaimZoneIndex tags the instance with the lowest index no other instance
+// of its Autoscaling group holds in its zone, unless it holds one already.
+// The index of a terminating instance is free again, so its replacement
+// takes over its member name. Two instances claiming the same index at once
+// both see it once their tags settle, the one with the higher instance ID
+// claims another.
+func (svc AWS) ClaimZoneIndex(ctx context.Context, insId string) (string, error) {
+	asgName, err := svc.GetAsg(ctx, insId)
+	if err != nil {
+		return "", err
+	}
+	for {
+		self, others, err := svc.zoneInstances(ctx, asgName, insId)
+		if err != nil {
+			return "", err
+		}
+		held := map[string]bool{}
+		conflict := false
+		index, claimed := tagValue(self, ZoneIndexTag)
+		for _, other := range others {
+			value, ok := tagValue(other, ZoneIndexTag)
+			if !ok {
+				continue
+			}
+			held[value] = true
+			if claimed && value == index && aws.StringValue(other.InstanceId) < insId {
+				conflict = true
+			}
+		}
+		if claimed && !conflict {
+			return index, nil
+		}
+		next := 0
+		for held[strconv.Itoa(next)] {
+			next++
+		}
+		index = strconv.Itoa(next)
+		svc.log().Println("Claiming index", index, "in zone", zoneOf(self))
+		_, err = svc.EC2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{aws.String(insId)},
+			Tags:      []*ec2.Tag{{Key: aws.String(ZoneIndexTag), Value: aws.String(index)}},
+		})
+		if err != nil {
+			return "", err
+		}
+		svc.Cache.Invalidate()
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(zoneIndexSettle):
+		}
+	}
+}
+
+// zoneInstances returns the instance insId and the other instances of the
+// group in its zone, leaving out those terminating
+func (svc AWS) zoneInstances(ctx context.Context, asgName string, insId string) (ec2.Instance, []ec2.Instance, error) {
+	group, err := svc.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return ec2.Instance{}, nil, err
+	}
+	ids := []*string{}
+	for _, instance := range group.Instances {
+		if instance.InstanceId == nil || strings.HasPrefix(aws.StringValue(instance.LifecycleState), "Terminat") {
+			continue
+		}
+		ids = append(ids, instance.InstanceId)
+	}
+	found := map[string]ec2.Instance{}
+	if err := svc.describeInstances(ctx, ids, found); err != nil {
+		return ec2.Instance{}, nil, err
+	}
+	self, ok := found[insId]
+	if !ok {
+		return self, nil, errors.New(fmt.Sprint("Instance ", insId, " isn't in Autoscaling group ", asgName))
+	}
+	others := []ec2.Instance{}
+	for id, instance := range found {
+		if id != insId && zoneOf(instance) == zoneOf(self) {
+			others = append(others, instance)
+		}
+	}
+	return self, others, nil
+}
+
+func zoneOf(instance ec2.Instance) string {
+	if instance.Placement == nil {
+		return ""
+	}
+	return aws.StringValue(instance.Placement.AvailabilityZone)
+}
+
+func tagValue(instance ec2.Instance, key string) (string, bool) {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+	return "", false
+}
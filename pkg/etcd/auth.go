@@ -0,0 +1,117 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// authTokens are the v3 auth tokens of the members, by client URL host.
+// Simple tokens are only known to the member that issued them.
+type authTokens struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (t *authTokens) get(host string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, ok := t.tokens[host]
+	return token, ok
+}
+
+func (t *authTokens) put(host string, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens == nil {
+		t.tokens = map[string]string{}
+	}
+	t.tokens[host] = token
+}
+
+// drop forgets the token of host, false if there was none to renew
+func (t *authTokens) drop(host string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, ok := t.tokens[host]
+	delete(t.tokens, host)
+	return ok && token != ""
+}
+
+// isV3 tells whether u is a request to the v3 gateway, which takes an auth
+// token rather than basic auth
+func isV3(u *url.URL) bool {
+	return strings.HasPrefix(u.Path, "/v3/") && u.Path != "/v3/auth/authenticate"
+}
+
+// authorize sets the credentials of req: basic auth for the v2 API, an auth
+// token for the v3 gateway
+func (c *Client) authorize(ctx context.Context, req *http.Request) error {
+	if c.username == "" {
+		return nil
+	}
+	if !isV3(req.URL) {
+		req.SetBasicAuth(c.username, c.password)
+		return nil
+	}
+	token, err := c.authToken(ctx, req.URL)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return nil
+}
+
+// authToken returns the auth token of the member at u, authenticating
+// once. Members without auth enabled need none, the empty token is kept.
+func (c *Client) authToken(ctx context.Context, u *url.URL) (string, error) {
+	if token, ok := c.tokens.get(u.Host); ok {
+		return token, nil
+	}
+	body, err := json.Marshal(map[string]string{"name": c.username, "password": c.password})
+	if err != nil {
+		return "", err
+	}
+	authURL := fmt.Sprint(u.Scheme, "://", u.Host, "/v3/auth/authenticate")
+	resp, err := c.sendWithRetries(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", authURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(ctx), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer closeBody(resp)
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if strings.Contains(string(data), "authentication is not enabled") {
+			c.tokens.put(u.Host, "")
+			return "", nil
+		}
+		return "", errors.New(fmt.Sprintf("Authenticating as %s failed: %d %s", c.username, resp.StatusCode, data))
+	}
+	var jresp struct {
+		Token string
+	}
+	if err := json.Unmarshal(data, &jresp); err != nil {
+		return "", err
+	}
+	c.logger.Println("Authenticated as", c.username, "to", u.Host)
+	c.tokens.put(u.Host, jresp.Token)
+	return jresp.Token, nil
+}
@@ -0,0 +1,663 @@
+// Package etcd is a small client of the etcd HTTP APIs etcdmate uses, the
+// v2 members API and the v3 gateway, without the etcd client dependencies.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/internal/parallel"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+	"github.com/viruxel/etcdmate/pkg/wiretrace"
+)
+
+// NewClient is kept for backward compatibility, use New with options
+func NewClient(caFile, certFile, keyFile string, timeout time.Duration) (Client, error) {
+	return New(WithTLS(caFile, certFile, keyFile), WithTimeout(timeout))
+}
+
+type Option func(*Client) error
+
+func New(opts ...Option) (Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: defaultDialTimeout,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	swap := &swapTransport{rt: transport}
+	c := Client{
+		httpClient:  &http.Client{Transport: swap},
+		transport:   transport,
+		swap:        swap,
+		logger:      log.Default(),
+		parallelism: defaultParallelism,
+		api:         &membersAPI{version: APIAuto, v3: map[string]bool{}},
+		tokens:      &authTokens{},
+	}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return Client{}, err
+		}
+	}
+	return c, nil
+}
+
+// WithTLS loads the CA bundle and client certificate used for HTTPS members
+func WithTLS(caFile, certFile, keyFile string) Option {
+	return func(c *Client) error {
+		c.files = &tlsFiles{ca: caFile, cert: certFile, key: keyFile}
+		// Record the current modification times
+		if _, err := c.files.changed(); err != nil {
+			return err
+		}
+		return loadTLS(c.tlsConfig(), caFile, certFile, keyFile)
+	}
+}
+
+func loadTLS(tlsConfig *tls.Config, caFile, certFile, keyFile string) error {
+	// Load client cert
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	// Load CA cert
+	if caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+	return nil
+}
+
+// WithTLSMinVersion sets the lowest accepted TLS version, e.g. tls.VersionTLS13
+func WithTLSMinVersion(version uint16) Option {
+	return func(c *Client) error {
+		c.tlsConfig().MinVersion = version
+		return nil
+	}
+}
+
+// WithCipherSuites restricts the TLS 1.0-1.2 cipher suites, TLS 1.3 suites
+// aren't configurable
+func WithCipherSuites(suites []uint16) Option {
+	return func(c *Client) error {
+		c.tlsConfig().CipherSuites = suites
+		return nil
+	}
+}
+
+func (c *Client) tlsConfig() *tls.Config {
+	if c.transport.TLSClientConfig == nil {
+		c.transport.TLSClientConfig = &tls.Config{}
+	}
+	return c.transport.TLSClientConfig
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.httpClient.Timeout = timeout
+		return nil
+	}
+}
+
+// WithAuth sets the credentials sent with every request, as basic auth to
+// the v2 API and as an auth token, obtained with them and renewed when it
+// expires, to the v3 gateway
+func WithAuth(username, password string) Option {
+	return func(c *Client) error {
+		c.username = username
+		c.password = password
+		return nil
+	}
+}
+
+// WithDialTimeout bounds connecting and the TLS handshake separately from
+// the overall request timeout, so unreachable members fail fast.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.transport.DialContext = (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		c.transport.TLSHandshakeTimeout = timeout
+		return nil
+	}
+}
+
+// WithParallelism bounds how many members are probed at once
+func WithParallelism(n int) Option {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New(fmt.Sprint("Invalid parallelism ", n))
+		}
+		c.parallelism = n
+		return nil
+	}
+}
+
+// WithProbeTimeouts bounds a health probe, retries included, by probe and
+// the search for a healthy member by overall, separately from the request
+// timeout. 0 leaves either to the request timeout.
+func WithProbeTimeouts(probe time.Duration, overall time.Duration) Option {
+	return func(c *Client) error {
+		c.probeTimeout = probe
+		c.findTimeout = overall
+		return nil
+	}
+}
+
+// WithTransport replaces the tuned default transport, WithTLS,
+// WithDialTimeout and ReloadTLS have no effect on it.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) error {
+		c.httpClient.Transport = transport
+		c.swap = nil
+		return nil
+	}
+}
+
+// WithTrace logs every request and response to logger, it must come after
+// WithTransport to trace a replaced transport
+func WithTrace(logger Logger) Option {
+	return func(c *Client) error {
+		c.httpClient.Transport = wiretrace.Transport{Next: c.httpClient.Transport, Logger: logger}
+		return nil
+	}
+}
+
+// WithTransportWrapper wraps the transport, it must come after WithTransport
+// to wrap a replaced transport
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) error {
+		c.httpClient.Transport = wrap(c.httpClient.Transport)
+		return nil
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(c *Client) error {
+		c.logger = logging.OrDefault(logger)
+		return nil
+	}
+}
+
+type Logger = logging.Logger
+
+const (
+	defaultDialTimeout = 2 * time.Second
+	defaultParallelism = 4
+)
+
+type Client struct {
+	httpClient *http.Client
+	transport  *http.Transport
+	swap       *swapTransport
+	files      *tlsFiles
+	// peerFiles is the TLS material of the peer URLs, see WithPeerTLS
+	peerFiles   *tlsFiles
+	parallelism int
+	username    string
+	password    string
+	tokens      *authTokens
+	logger      Logger
+	api         *membersAPI
+	retries     int
+	backoff     time.Duration
+	// probeTimeout bounds a health probe, findTimeout FindHealthyMember
+	probeTimeout time.Duration
+	findTimeout  time.Duration
+}
+
+func (c *Client) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	return c.send(ctx, method, url, body, "application/json")
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte, contentType string) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		return c.newRequest(ctx, method, url, body, contentType)
+	}
+	resp, err := c.sendWithRetries(ctx, newRequest)
+	// An expired auth token is renewed once
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && isV3(resp.Request.URL) && c.tokens.drop(resp.Request.URL.Host) {
+		c.logger.Println("Auth token of", resp.Request.URL.Host, "rejected, authenticating again")
+		closeBody(resp)
+		resp, err = c.sendWithRetries(ctx, newRequest)
+	}
+	return resp, err
+}
+
+func (c *Client) newRequest(ctx context.Context, method, url string, body []byte, contentType string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if err := c.authorize(ctx, req); err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+// closeBody drains the body so the connection can be reused
+func closeBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// FindHealthyMember probes the members in parallel and returns the first
+// healthy one found, abandoning the remaining probes.
+func (c *Client) FindHealthyMember(ctx context.Context, members []Member) (Member, error) {
+	probeCtx, cancel := context.WithCancel(ctx)
+	if c.findTimeout > 0 {
+		probeCtx, cancel = context.WithTimeout(ctx, c.findTimeout)
+	}
+	defer cancel()
+	errs := make([]error, len(members))
+	var once sync.Once
+	found := -1
+	parallel.ForEach(c.parallelism, len(members), func(i int) {
+		if errs[i] = probeCtx.Err(); errs[i] != nil {
+			return
+		}
+		errs[i] = c.CheckHealth(probeCtx, members[i])
+		if errs[i] == nil {
+			once.Do(func() {
+				found = i
+				cancel()
+			})
+		}
+	})
+	if found >= 0 {
+		c.logger.Printf("Healthy member %+v\n", members[found])
+		return members[found], nil
+	}
+	if err := ctx.Err(); err != nil {
+		return Member{}, err
+	}
+	var lastErr error
+	for _, err := range errs {
+		c.logger.Println(err)
+		lastErr = err
+	}
+	if lastErr != nil {
+		return Member{}, fmt.Errorf("%w, last error: %v", ErrNoHealthyMember, lastErr)
+	}
+	return Member{}, ErrNoHealthyMember
+}
+
+// CheckHealthAll probes the members in parallel, the errors are in the
+// members order
+func (c *Client) CheckHealthAll(ctx context.Context, members []Member) []error {
+	errs := make([]error, len(members))
+	parallel.ForEach(c.parallelism, len(members), func(i int) {
+		errs[i] = c.CheckHealth(ctx, members[i])
+	})
+	return errs
+}
+
+func (c *Client) IsHealthy(ctx context.Context, member Member) bool {
+	err := c.CheckHealth(ctx, member)
+	if err != nil {
+		c.logger.Println(err)
+		return false
+	}
+	c.logger.Printf("Healthy member %+v\n", member)
+	return true
+}
+
+// CheckHealth returns nil for a healthy member, otherwise an error wrapping
+// ErrUnreachable, ErrUnhealthy or ErrUnparsable.
+func (c *Client) CheckHealth(ctx context.Context, member Member) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.health")
+	span.SetAttribute("etcd.member", member.ClientURL)
+	defer func() { span.End(err) }()
+	if c.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.probeTimeout)
+		defer cancel()
+	}
+	url := fmt.Sprintf("%s/health", member.ClientURL)
+	c.logger.Println("Checking etcd member health at", url)
+	resp, err := c.do(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnreachable, member.Name, err)
+	}
+	defer closeBody(resp)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnreachable, member.Name, err)
+	}
+	// Unhealthy members answer 503 with the same JSON body
+	var jresp struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}
+	err = json.Unmarshal(body, &jresp)
+	if err != nil || jresp.Health == "" {
+		return fmt.Errorf(
+			"%w: %s: status %d: %q",
+			ErrUnparsable,
+			member.Name,
+			resp.StatusCode,
+			body,
+		)
+	}
+	if jresp.Health != "true" || resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(
+			"%w: %s: status %d, health %s %s",
+			ErrUnhealthy,
+			member.Name,
+			resp.StatusCode,
+			jresp.Health,
+			jresp.Reason,
+		)
+	}
+	return nil
+}
+
+func (c *Client) RemoveMember(ctx context.Context, hm Member, rm Member) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.remove-member")
+	span.SetAttribute("etcd.member", rm.PeerURL)
+	defer func() { span.End(err) }()
+	c.logger.Printf("Removing member %+v\n", rm)
+	v3, err := c.useV3(ctx, hm)
+	if err != nil {
+		return err
+	}
+	if v3 {
+		if err := c.removeMemberV3(ctx, hm, rm); err != nil {
+			return err
+		}
+		c.logger.Printf("Member removed %+v\n", rm)
+		return nil
+	}
+	url := fmt.Sprintf("%s/v2/members/%s", hm.ClientURL, rm.ID)
+	resp, err := c.do(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return memberError(resp, "Removing", rm.PeerURL)
+	}
+	c.logger.Printf("Member removed %+v\n", rm)
+	return nil
+}
+
+func (c *Client) AddMember(ctx context.Context, hm Member, am Member) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.add-member")
+	span.SetAttribute("etcd.member", am.PeerURL)
+	defer func() { span.End(err) }()
+	c.logger.Printf("Adding member %+v\n", am)
+	v3, err := c.useV3(ctx, hm)
+	if err != nil {
+		return err
+	}
+	if v3 {
+		if err := c.addMemberV3(ctx, hm, am); err != nil {
+			return err
+		}
+		c.logger.Printf("Member added %+v\n", am)
+		return nil
+	}
+	url := fmt.Sprintf("%s/v2/members", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{Name: am.Name, PeerURLs: am.AllPeerURLs()})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return memberError(resp, "Adding", am.PeerURL)
+	}
+	c.logger.Printf("Member added %+v\n", am)
+	return nil
+}
+
+// UpdateMember changes the peer URLs of the registered member um.ID to
+// those of um, etcd doesn't update it on its own when a member moves to
+// another schema or port
+func (c *Client) UpdateMember(ctx context.Context, hm Member, um Member) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.update-member")
+	span.SetAttribute("etcd.member", um.PeerURL)
+	defer func() { span.End(err) }()
+	c.logger.Printf("Updating member %+v\n", um)
+	v3, err := c.useV3(ctx, hm)
+	if err != nil {
+		return err
+	}
+	if v3 {
+		if err := c.updateMemberV3(ctx, hm, um); err != nil {
+			return err
+		}
+		c.logger.Printf("Member updated %+v\n", um)
+		return nil
+	}
+	url := fmt.Sprintf("%s/v2/members/%s", hm.ClientURL, um.ID)
+	byteData, err := json.Marshal(memberRequest{PeerURLs: um.AllPeerURLs()})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "PUT", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return memberError(resp, "Updating", um.PeerURL)
+	}
+	c.logger.Printf("Member updated %+v\n", um)
+	return nil
+}
+
+func (c *Client) ListMembers(ctx context.Context, hm Member) ([]Member, error) {
+	members := []Member{}
+	v3, err := c.useV3(ctx, hm)
+	if err != nil {
+		return members, err
+	}
+	if v3 {
+		c.logger.Println("Listing members through", hm.ClientURL)
+		listed, err := c.listMembersV3(ctx, hm)
+		if err != nil {
+			return members, err
+		}
+		for _, m := range listed {
+			members = append(members, m.Member)
+		}
+		c.logger.Printf("Found members %+v\n", members)
+		return members, nil
+	}
+	url := fmt.Sprintf("%s/v2/members", hm.ClientURL)
+	c.logger.Println("Listing members using url", url)
+	resp, err := c.do(ctx, "GET", url, nil)
+	if err != nil {
+		return members, err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return members, errors.New(fmt.Sprintf("Listing members failed: %d %s", resp.StatusCode, body))
+	}
+	var jresp map[string][]jsonMember
+	json.NewDecoder(resp.Body).Decode(&jresp)
+	for _, jm := range jresp["members"] {
+		m := Member{
+			ID:   jm.Id,
+			Name: jm.Name,
+		}
+		members = append(members, m.WithURLs(jm.ClientURLs, jm.PeerURLs))
+	}
+	c.logger.Printf("Found members %+v\n", members)
+	return members, nil
+}
+
+type Member struct {
+	ID        string
+	Name      string
+	ClientURL string
+	PeerURL   string
+	// ClientURLs and PeerURLs are all the URLs of a member with several,
+	// e.g. on a dual-stack instance, see WithURLs
+	ClientURLs []string `json:",omitempty"`
+	PeerURLs   []string `json:",omitempty"`
+	// Zone is the availability zone, only known for discovered members
+	Zone string `json:",omitempty"`
+	// Instance is the EC2 instance ID, or the Nomad allocation ID, only
+	// known for discovered members
+	Instance string `json:",omitempty"`
+	// Address is the private address of the EC2 instance, only known for
+	// discovered members
+	Address string `json:",omitempty"`
+}
+
+// memberRequest is the body of the member API requests, of the v2 API and
+// of the v3 gateway, which takes the 64 bit IDs as strings
+type memberRequest struct {
+	ID        string   `json:",omitempty"`
+	Name      string   `json:"name,omitempty"`
+	PeerURLs  []string `json:"peerURLs,omitempty"`
+	IsLearner bool     `json:"isLearner,omitempty"`
+}
+
+// Needed to marshal json response for listing members
+type jsonMember struct {
+	Id         string
+	Name       string
+	ClientURLs []string
+	PeerURLs   []string
+}
+
+// IsLeader tells whether m leads the cluster, from its v3 status
+func (c *Client) IsLeader(ctx context.Context, m Member) (bool, error) {
+	status, err := c.Status(ctx, m)
+	if err != nil {
+		return false, err
+	}
+	return status.IsLeader(), nil
+}
+
+// AddLearner adds am as a non-voting member using the v3 API gateway
+func (c *Client) AddLearner(ctx context.Context, hm Member, am Member) (Member, error) {
+	c.logger.Printf("Adding learner member %+v\n", am)
+	url := fmt.Sprintf("%s/v3/cluster/member/add", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{PeerURLs: am.AllPeerURLs(), IsLearner: true})
+	if err != nil {
+		return am, err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return am, err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return am, memberError(resp, "Adding learner", am.PeerURL)
+	}
+	var jresp struct {
+		Member struct {
+			ID string
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jresp)
+	if err != nil {
+		return am, err
+	}
+	id, err := strconv.ParseUint(jresp.Member.ID, 10, 64)
+	if err != nil {
+		return am, err
+	}
+	am.ID = strconv.FormatUint(id, 16)
+	c.logger.Printf("Learner member added %+v\n", am)
+	return am, nil
+}
+
+// PromoteMember promotes a learner to a voting member. It fails until the
+// learner has caught up with the leader.
+func (c *Client) PromoteMember(ctx context.Context, hm Member, pm Member) error {
+	c.logger.Printf("Promoting member %+v\n", pm)
+	id, err := strconv.ParseUint(pm.ID, 16, 64)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v3/cluster/member/promote", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{ID: strconv.FormatUint(id, 10)})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return memberError(resp, "Promoting", pm.PeerURL)
+	}
+	c.logger.Printf("Member promoted %+v\n", pm)
+	return nil
+}
+
+// ListLearners returns the members that are still learners, using the v3
+// API gateway as the v2 members API doesn't tell them apart
+func (c *Client) ListLearners(ctx context.Context, hm Member) ([]Member, error) {
+	members, err := c.listMembersV3(ctx, hm)
+	if err != nil {
+		return nil, err
+	}
+	learners := []Member{}
+	for _, m := range members {
+		if m.learner {
+			learners = append(learners, m.Member)
+		}
+	}
+	return learners, nil
+}
+
+// Version returns the server and cluster versions reported by m
+func (c *Client) Version(ctx context.Context, m Member) (string, string, error) {
+	resp, err := c.do(ctx, "GET", fmt.Sprintf("%s/version", m.ClientURL), nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer closeBody(resp)
+	var jresp struct {
+		Server  string `json:"etcdserver"`
+		Cluster string `json:"etcdcluster"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&jresp)
+	if err != nil {
+		return "", "", err
+	}
+	return jresp.Server, jresp.Cluster, nil
+}
@@ -0,0 +1,114 @@
+package etcd_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/etcd/fake"
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// start runs a fake cluster of n members on 127.0.22.1, 127.0.22.2...
+func start(t *testing.T, n int) (*fake.Cluster, []etcd.Member) {
+	cluster := fake.NewCluster()
+	t.Cleanup(cluster.Close)
+	members := []etcd.Member{}
+	for i := 1; i <= n; i++ {
+		m, err := cluster.Start(
+			fmt.Sprint("m-", i),
+			fmt.Sprint("http://127.0.22.", i, ":22380"),
+			fmt.Sprint("http://127.0.22.", i, ":22379"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, m)
+	}
+	return cluster, members
+}
+
+func newClient(t *testing.T, opts ...etcd.Option) *etcd.Client {
+	opts = append(opts, etcd.WithLogger(logging.Discard), etcd.WithTimeout(2*time.Second), etcd.WithDialTimeout(time.Second))
+	c, err := etcd.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestCheckHealth(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(cluster *fake.Cluster)
+		err   error
+	}{
+		{name: "healthy", setup: func(cluster *fake.Cluster) {}},
+		{name: "unreachable", setup: func(cluster *fake.Cluster) { cluster.Stop("m-1") }, err: etcd.ErrUnreachable},
+		{name: "unhealthy", setup: func(cluster *fake.Cluster) { cluster.SetHealthy("m-1", false) }, err: etcd.ErrUnhealthy},
+		{
+			name:  "unparsable",
+			setup: func(cluster *fake.Cluster) { cluster.Fail("GET", "/health", http.StatusInternalServerError, 1) },
+			err:   etcd.ErrUnparsable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster, members := start(t, 3)
+			tc.setup(cluster)
+			err := newClient(t).CheckHealth(context.Background(), members[0])
+			if !errors.Is(err, tc.err) || (err != nil && tc.err == nil) {
+				t.Errorf("got error %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestMemberErrors(t *testing.T) {
+	for _, api := range []etcd.APIVersion{etcd.APIv2, etcd.APIv3} {
+		for _, tc := range []struct {
+			name   string
+			change func(ctx context.Context, c *etcd.Client, cluster *fake.Cluster, members []etcd.Member) error
+			err    error
+		}{
+			{
+				name: "add a member of an existing peer URL",
+				change: func(ctx context.Context, c *etcd.Client, cluster *fake.Cluster, members []etcd.Member) error {
+					return c.AddMember(ctx, members[0], etcd.Member{Name: "m-4", PeerURL: members[1].PeerURL})
+				},
+				err: etcd.ErrMemberConflict,
+			},
+			{
+				name: "add a member without quorum",
+				change: func(ctx context.Context, c *etcd.Client, cluster *fake.Cluster, members []etcd.Member) error {
+					cluster.Stop("m-2")
+					cluster.Stop("m-3")
+					return c.AddMember(ctx, members[0], etcd.Member{Name: "m-4", PeerURL: "http://127.0.22.4:22380"})
+				},
+				err: etcd.ErrClusterUnhealthy,
+			},
+			{
+				name: "remove a removed member",
+				change: func(ctx context.Context, c *etcd.Client, cluster *fake.Cluster, members []etcd.Member) error {
+					if err := c.RemoveMember(ctx, members[0], members[2]); err != nil {
+						return err
+					}
+					return c.RemoveMember(ctx, members[0], members[2])
+				},
+				err: etcd.ErrMemberNotFound,
+			},
+		} {
+			t.Run(fmt.Sprint("v", api, " ", tc.name), func(t *testing.T) {
+				cluster, members := start(t, 3)
+				c := newClient(t, etcd.WithAPIVersion(api))
+				err := tc.change(context.Background(), c, cluster, members)
+				if !errors.Is(err, tc.err) {
+					t.Errorf("got error %v, want %v", err, tc.err)
+				}
+			})
+		}
+	}
+}
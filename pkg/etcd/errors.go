@@ -0,0 +1,71 @@
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoHealthyMember = errors.New("No healthy member found")
+	ErrMemberConflict  = errors.New("Member conflicts with an existing member")
+	// ErrMemberNotFound is returned when the member to change isn't
+	// registered, e.g. already removed
+	ErrMemberNotFound = errors.New("Member not found")
+	// ErrClusterUnhealthy is returned when the cluster can't commit a
+	// membership change right now, retrying later may succeed
+	ErrClusterUnhealthy = errors.New("Cluster unable to process the request")
+	// ErrUnstartedMembers is returned when etcd refuses a new member while
+	// too many of the registered ones never started
+	ErrUnstartedMembers = errors.New("Not enough started members")
+	// Health check failures, see CheckHealth
+	ErrUnreachable = errors.New("Member unreachable")
+	ErrUnhealthy   = errors.New("Member unhealthy")
+	ErrUnparsable  = errors.New("Unparsable health response")
+	// ErrIdentityMismatch is returned when a member certificate isn't valid
+	// for the member address, see CheckIdentity
+	ErrIdentityMismatch = errors.New("Member certificate doesn't match its address")
+)
+
+// errorMessage returns the message of an etcd error body, {"message": ...}
+// from the v2 API and {"error": ..., "message": ...} from the gRPC gateway,
+// or the body itself when it isn't one
+func errorMessage(body []byte) string {
+	var jerr struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &jerr) == nil {
+		if jerr.Message != "" {
+			return jerr.Message
+		}
+		if jerr.Error != "" {
+			return jerr.Error
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// memberError reads the body of a failed membership change of member and
+// returns ErrMemberConflict, ErrMemberNotFound, ErrUnstartedMembers or
+// ErrClusterUnhealthy when the status or message tells, or an error naming
+// action otherwise
+func memberError(resp *http.Response, action string, member string) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	message := errorMessage(body)
+	switch {
+	case resp.StatusCode == http.StatusConflict ||
+		strings.Contains(message, "already exists") || strings.Contains(message, "peerURL exists"):
+		return fmt.Errorf("%w: %s: %s", ErrMemberConflict, member, message)
+	case strings.Contains(message, "member not found") || strings.HasPrefix(message, "No such member"):
+		return fmt.Errorf("%w: %s: %s", ErrMemberNotFound, member, message)
+	case strings.Contains(message, "not enough started members"):
+		return fmt.Errorf("%w: %s", ErrUnstartedMembers, message)
+	case resp.StatusCode >= 500 || strings.Contains(message, "unhealthy cluster"):
+		return fmt.Errorf("%w: %s", ErrClusterUnhealthy, message)
+	}
+	return errors.New(fmt.Sprintf("%s member failed: %d %s", action, resp.StatusCode, message))
+}
@@ -0,0 +1,924 @@
+// Package fake serves, from memory, the etcd HTTP endpoints etcdmate uses,
+// so workflows can be exercised against scripted clusters without running
+// etcd. Each started member gets its own loopback server sharing the
+// cluster state.
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+type member struct {
+	id      uint64
+	name    string
+	peerURL string
+	// peerURLs are all the peer URLs of a member with several, sorted
+	peerURLs  []string
+	learner   bool
+	unhealthy bool
+	down      bool
+	// promoteAfter is how many promotions fail before the learner is
+	// considered caught up
+	promoteAfter int
+	server       *httptest.Server
+}
+
+type failure struct {
+	method string
+	path   string
+	status int
+	times  int
+}
+
+// Cluster is an in-memory etcd cluster
+type Cluster struct {
+	mu       sync.Mutex
+	members  []*member
+	nextID   uint64
+	leader   string
+	version  string
+	keys     map[string]string
+	keyIndex int
+	// created is the revision each key was created at, leased the lease a
+	// key is attached to, and leases the expiry of every granted lease
+	created  map[string]int64
+	leased   map[string]int64
+	leases   map[int64]time.Time
+	failures []*failure
+	// compacted is the revision of the last compaction, defragmented the
+	// names of the members defragmented in order
+	compacted    int64
+	defragmented []string
+	// v2Disabled hides the v2 members and keys APIs
+	v2Disabled bool
+	// removed are the servers of removed members, closed with the others
+	removed []*httptest.Server
+}
+
+func NewCluster() *Cluster {
+	return &Cluster{
+		nextID:  0x8e9e05c52164694d,
+		version: "3.5.0",
+		keys:    map[string]string{},
+		created: map[string]int64{},
+		leased:  map[string]int64{},
+		leases:  map[int64]time.Time{},
+	}
+}
+
+// Start serves a started member named name with peerURL on clientURL,
+// registering it if no member has this peer URL yet. With clientURL empty
+// it listens on a free loopback port, otherwise on the host of clientURL
+// so the member can match a discovered one, e.g. http://127.0.0.2:2379.
+// The first started member leads.
+func (c *Cluster) Start(name string, peerURL string, clientURL string) (etcd.Member, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.byPeerURL(peerURL)
+	if m == nil {
+		m = c.register([]string{peerURL}, false)
+	}
+	m.name = name
+	if m.server == nil {
+		server := httptest.NewUnstartedServer(c.handler(m))
+		if clientURL != "" {
+			u, err := url.Parse(clientURL)
+			if err != nil {
+				return etcd.Member{}, err
+			}
+			l, err := net.Listen("tcp", u.Host)
+			if err != nil {
+				return etcd.Member{}, err
+			}
+			server.Listener.Close()
+			server.Listener = l
+		}
+		server.Start()
+		m.server = server
+	}
+	if c.leader == "" {
+		c.leader = name
+	}
+	return c.public(m), nil
+}
+
+// Stop makes the member unreachable, its connections are closed without
+// an answer
+func (c *Cluster) Stop(name string) {
+	c.set(name, func(m *member) { m.down = true })
+}
+
+// Resume undoes Stop
+func (c *Cluster) Resume(name string) {
+	c.set(name, func(m *member) { m.down = false })
+}
+
+// DisableV2 makes the members answer 404 on the v2 members and keys APIs,
+// as etcd 3.6 does, so only the v3 gateway is left
+func (c *Cluster) DisableV2() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.v2Disabled = true
+}
+
+// SetHealthy controls what the member answers on /health
+func (c *Cluster) SetHealthy(name string, healthy bool) {
+	c.set(name, func(m *member) { m.unhealthy = !healthy })
+}
+
+// SetLeader makes name the member reporting StateLeader
+func (c *Cluster) SetLeader(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = name
+}
+
+// SetVersion is the server version every member reports
+func (c *Cluster) SetVersion(version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = version
+}
+
+// PromoteAfter makes the promotion of learners fail n times, as while they
+// catch up with the leader
+func (c *Cluster) PromoteAfter(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.members {
+		if m.learner {
+			m.promoteAfter = n
+		}
+	}
+}
+
+// Fail answers the next times requests with method to path with status,
+// path is matched as a prefix and method "" matches any
+func (c *Cluster) Fail(method string, path string, status int, times int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = append(c.failures, &failure{method: method, path: path, status: status, times: times})
+}
+
+// Members returns the registered members as ListMembers does
+func (c *Cluster) Members() []etcd.Member {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members := []etcd.Member{}
+	for _, m := range c.members {
+		members = append(members, c.public(m))
+	}
+	return members
+}
+
+// Keys returns a copy of the keys, those of the v2 and v3 APIs alike
+func (c *Cluster) Keys() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	keys := map[string]string{}
+	for k, v := range c.keys {
+		keys[k] = v
+	}
+	return keys
+}
+
+// Compacted returns the revision of the last compaction
+func (c *Cluster) Compacted() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compacted
+}
+
+// Defragmented returns the names of the members defragmented, in order
+func (c *Cluster) Defragmented() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.defragmented...)
+}
+
+// Close stops the servers of every member
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.members {
+		if m.server != nil {
+			m.server.Close()
+		}
+	}
+	for _, server := range c.removed {
+		server.Close()
+	}
+}
+
+func (c *Cluster) set(name string, f func(*member)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.members {
+		if m.name == name {
+			f(m)
+		}
+	}
+}
+
+func (c *Cluster) register(peerURLs []string, learner bool) *member {
+	m := &member{id: c.nextID, learner: learner}
+	m.setPeerURLs(peerURLs)
+	c.nextID++
+	c.members = append(c.members, m)
+	return m
+}
+
+func (m *member) setPeerURLs(peerURLs []string) {
+	pm := etcd.Member{}.WithURLs(nil, peerURLs)
+	m.peerURL, m.peerURLs = pm.PeerURL, pm.PeerURLs
+}
+
+func (m *member) allPeerURLs() []string {
+	return etcd.Member{PeerURL: m.peerURL, PeerURLs: m.peerURLs}.AllPeerURLs()
+}
+
+// byPeerURL returns the member with any of peerURLs
+func (c *Cluster) byPeerURL(peerURLs ...string) *member {
+	for _, m := range c.members {
+		for _, u := range m.allPeerURLs() {
+			for _, peerURL := range peerURLs {
+				if u == peerURL {
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) public(m *member) etcd.Member {
+	pm := etcd.Member{ID: strconv.FormatUint(m.id, 16), Name: m.name, PeerURL: m.peerURL, PeerURLs: m.peerURLs}
+	if m.server != nil {
+		pm.ClientURL = m.server.URL
+	}
+	return pm
+}
+
+// healthy tells whether the cluster has quorum, callers hold mu
+func (c *Cluster) healthy() bool {
+	voters, up := 0, 0
+	for _, m := range c.members {
+		if m.learner {
+			continue
+		}
+		voters++
+		if m.server != nil && !m.down && !m.unhealthy {
+			up++
+		}
+	}
+	return up*2 > voters
+}
+
+func (c *Cluster) handler(m *member) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if m.down {
+			// Closing without an answer looks like an unreachable member
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		for _, f := range c.failures {
+			if f.times > 0 && (f.method == "" || f.method == r.Method) && strings.HasPrefix(r.URL.Path, f.path) {
+				f.times--
+				http.Error(w, "injected failure", f.status)
+				return
+			}
+		}
+		c.serve(m, w, r)
+	})
+}
+
+func (c *Cluster) serve(m *member, w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case c.v2Disabled && (strings.HasPrefix(path, "/v2/members") || strings.HasPrefix(path, "/v2/keys")):
+		http.NotFound(w, r)
+	case path == "/health":
+		if m.unhealthy || !c.healthy() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"health": "false"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"health": "true"})
+	case path == "/version":
+		writeJSON(w, http.StatusOK, map[string]string{"etcdserver": c.version, "etcdcluster": clusterVersion(c.version)})
+	case path == "/v2/stats/self":
+		state := "StateFollower"
+		if m.name == c.leader {
+			state = "StateLeader"
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"name": m.name, "state": state})
+	case path == "/v2/members" && r.Method == "GET":
+		c.listMembers(w)
+	case path == "/v2/members" && r.Method == "POST":
+		c.addMember(w, r, false)
+	case strings.HasPrefix(path, "/v2/members/") && r.Method == "DELETE":
+		c.removeMember(w, strings.TrimPrefix(path, "/v2/members/"))
+	case strings.HasPrefix(path, "/v2/members/") && r.Method == "PUT":
+		c.updateMember(w, r, strings.TrimPrefix(path, "/v2/members/"))
+	case path == "/v3/cluster/member/add":
+		c.addMember(w, r, true)
+	case path == "/v3/cluster/member/promote":
+		c.promoteMember(w, r)
+	case path == "/v3/cluster/member/list":
+		c.listMembersV3(w)
+	case path == "/v3/cluster/member/remove":
+		c.removeMemberV3(w, r)
+	case path == "/v3/cluster/member/update":
+		c.updateMemberV3(w, r)
+	case strings.HasPrefix(path, "/v2/keys/"):
+		c.serveKeys(w, r, strings.TrimPrefix(path, "/v2/keys"))
+	case path == "/v3/maintenance/status":
+		c.status(w, m)
+	case path == "/v3/maintenance/transfer-leadership":
+		c.moveLeader(w, r, m)
+	case path == "/v3/kv/compaction":
+		c.compact(w, r)
+	case path == "/v3/kv/range":
+		c.rangeKeys(w, r)
+	case path == "/v3/kv/put":
+		c.putKey(w, r)
+	case path == "/v3/kv/deleterange":
+		c.deleteRange(w, r)
+	case path == "/v3/kv/txn":
+		c.txn(w, r)
+	case path == "/v3/lease/grant":
+		c.grantLease(w, r)
+	case path == "/v3/maintenance/defragment":
+		c.defragmented = append(c.defragmented, m.name)
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// revision grows with every key written
+func (c *Cluster) revision() int64 {
+	return int64(c.keyIndex) + 1
+}
+
+func (c *Cluster) status(w http.ResponseWriter, m *member) {
+	var leader uint64
+	for _, member := range c.members {
+		if c.leader != "" && member.name == c.leader {
+			leader = member.id
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"header": map[string]string{
+			"member_id": strconv.FormatUint(m.id, 10),
+			"revision":  strconv.FormatInt(c.revision(), 10),
+		},
+		"version":   c.version,
+		"leader":    strconv.FormatUint(leader, 10),
+		"raftIndex": strconv.FormatInt(c.revision(), 10),
+	})
+}
+
+func (c *Cluster) moveLeader(w http.ResponseWriter, r *http.Request, m *member) {
+	var req struct {
+		TargetID string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if m.name != c.leader {
+		http.Error(w, "etcdserver: not leader", http.StatusBadRequest)
+		return
+	}
+	for _, target := range c.members {
+		if strconv.FormatUint(target.id, 10) != req.TargetID {
+			continue
+		}
+		if target.learner || target.server == nil || target.down {
+			http.Error(w, "etcdserver: bad leader transferee", http.StatusBadRequest)
+			return
+		}
+		c.leader = target.name
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+		return
+	}
+	http.Error(w, "etcdserver: bad leader transferee", http.StatusBadRequest)
+}
+
+func (c *Cluster) compact(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Revision string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	revision, err := strconv.ParseInt(req.Revision, 10, 64)
+	if err != nil || revision > c.revision() {
+		http.Error(w, "etcdserver: mvcc: required revision is a future revision", http.StatusBadRequest)
+		return
+	}
+	if revision <= c.compacted {
+		http.Error(w, "etcdserver: mvcc: required revision has been compacted", http.StatusBadRequest)
+		return
+	}
+	c.compacted = revision
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (c *Cluster) listMembers(w http.ResponseWriter) {
+	type jsonMember struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		PeerURLs   []string `json:"peerURLs"`
+		ClientURLs []string `json:"clientURLs"`
+	}
+	members := []jsonMember{}
+	for _, m := range c.members {
+		jm := jsonMember{ID: strconv.FormatUint(m.id, 16), Name: m.name, PeerURLs: m.allPeerURLs()}
+		if m.server != nil {
+			jm.ClientURLs = []string{m.server.URL}
+		}
+		members = append(members, jm)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": members})
+}
+
+func (c *Cluster) addMember(w http.ResponseWriter, r *http.Request, v3 bool) {
+	var req struct {
+		PeerURLs  []string
+		IsLearner bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PeerURLs) == 0 {
+		http.Error(w, "invalid member", http.StatusBadRequest)
+		return
+	}
+	if !c.healthy() {
+		http.Error(w, "etcdserver: unhealthy cluster", http.StatusServiceUnavailable)
+		return
+	}
+	if c.byPeerURL(req.PeerURLs...) != nil {
+		if v3 {
+			http.Error(w, "etcdserver: member already exists", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "etcdserver: peerURL exists", http.StatusConflict)
+		return
+	}
+	m := c.register(req.PeerURLs, v3 && req.IsLearner)
+	if v3 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"member": map[string]interface{}{"ID": strconv.FormatUint(m.id, 10), "peerURLs": req.PeerURLs},
+		})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id": strconv.FormatUint(m.id, 16), "peerURLs": req.PeerURLs,
+	})
+}
+
+func (c *Cluster) removeMember(w http.ResponseWriter, id string) {
+	if !c.remove(id) {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove removes the member with the hexadecimal id, false if there is none
+func (c *Cluster) remove(id string) bool {
+	for i, m := range c.members {
+		if strconv.FormatUint(m.id, 16) != id {
+			continue
+		}
+		c.members = append(c.members[:i], c.members[i+1:]...)
+		if m.server != nil {
+			// The removed member stops answering, as etcd shuts down
+			m.down = true
+			c.removed = append(c.removed, m.server)
+		}
+		return true
+	}
+	return false
+}
+
+func (c *Cluster) updateMember(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		PeerURLs []string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PeerURLs) == 0 {
+		http.Error(w, "invalid member", http.StatusBadRequest)
+		return
+	}
+	if other := c.byPeerURL(req.PeerURLs...); other != nil && strconv.FormatUint(other.id, 16) != id {
+		http.Error(w, "etcdserver: peerURL exists", http.StatusConflict)
+		return
+	}
+	if !c.update(id, req.PeerURLs) {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// update sets the peer URLs of the member with the hexadecimal id, false if
+// there is none
+func (c *Cluster) update(id string, peerURLs []string) bool {
+	for _, m := range c.members {
+		if strconv.FormatUint(m.id, 16) == id {
+			m.setPeerURLs(peerURLs)
+			return true
+		}
+	}
+	return false
+}
+
+// removeMemberV3 and updateMemberV3 answer the v3 gateway, which takes
+// decimal IDs
+func (c *Cluster) removeMemberV3(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !c.remove(hexID(req.ID)) {
+		http.Error(w, "etcdserver: member not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (c *Cluster) updateMemberV3(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       string
+		PeerURLs []string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PeerURLs) == 0 {
+		http.Error(w, "invalid member", http.StatusBadRequest)
+		return
+	}
+	id := hexID(req.ID)
+	if other := c.byPeerURL(req.PeerURLs...); other != nil && strconv.FormatUint(other.id, 16) != id {
+		http.Error(w, "etcdserver: peerURL exists", http.StatusBadRequest)
+		return
+	}
+	if !c.update(id, req.PeerURLs) {
+		http.Error(w, "etcdserver: member not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func hexID(decimal string) string {
+	id, err := strconv.ParseUint(decimal, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(id, 16)
+}
+
+// listMembersV3 answers the v3 gateway, which tells the learners apart
+func (c *Cluster) listMembersV3(w http.ResponseWriter) {
+	type jsonMember struct {
+		ID         string   `json:"ID"`
+		Name       string   `json:"name"`
+		PeerURLs   []string `json:"peerURLs"`
+		ClientURLs []string `json:"clientURLs,omitempty"`
+		IsLearner  bool     `json:"isLearner,omitempty"`
+	}
+	members := []jsonMember{}
+	for _, m := range c.members {
+		jm := jsonMember{ID: strconv.FormatUint(m.id, 10), Name: m.name, PeerURLs: m.allPeerURLs(), IsLearner: m.learner}
+		if m.server != nil {
+			jm.ClientURLs = []string{m.server.URL}
+		}
+		members = append(members, jm)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": members})
+}
+
+func (c *Cluster) promoteMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, m := range c.members {
+		if strconv.FormatUint(m.id, 10) != req.ID {
+			continue
+		}
+		if !m.learner {
+			http.Error(w, "etcdserver: can only promote a learner member", http.StatusBadRequest)
+			return
+		}
+		if m.promoteAfter > 0 || m.server == nil {
+			m.promoteAfter--
+			http.Error(w, "etcdserver: can only promote a learner member which is in sync with leader", http.StatusBadRequest)
+			return
+		}
+		m.learner = false
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+		return
+	}
+	http.Error(w, "etcdserver: member not found", http.StatusNotFound)
+}
+
+func (c *Cluster) serveKeys(w http.ResponseWriter, r *http.Request, key string) {
+	body, _ := ioutil.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	switch r.Method {
+	case "GET":
+		nodes := []map[string]string{}
+		for k, v := range c.keys {
+			if strings.HasPrefix(k, key+"/") && !strings.Contains(strings.TrimPrefix(k, key+"/"), "/") {
+				nodes = append(nodes, map[string]string{"key": k, "value": v})
+			}
+		}
+		if value, ok := c.keys[key]; ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"node": map[string]string{"key": key, "value": value}})
+			return
+		}
+		if len(nodes) == 0 {
+			http.Error(w, `{"errorCode":100,"message":"Key not found"}`, http.StatusNotFound)
+			return
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i]["key"] < nodes[j]["key"] })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"node": map[string]interface{}{"key": key, "dir": true, "nodes": nodes}})
+	case "POST":
+		k := fmt.Sprintf("%s/%020d", key, c.keyIndex+1)
+		c.write(k, form.Get("value"), 0)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"node": map[string]string{"key": k, "value": form.Get("value")}})
+	case "PUT":
+		current, existed := c.keys[key]
+		if (form.Get("prevExist") == "false" && existed) ||
+			(form.Get("prevValue") != "" && existed && current != form.Get("prevValue")) {
+			http.Error(w, `{"errorCode":101,"message":"Compare failed"}`, http.StatusPreconditionFailed)
+			return
+		}
+		if form.Get("prevValue") != "" && !existed {
+			http.Error(w, `{"errorCode":100,"message":"Key not found"}`, http.StatusNotFound)
+			return
+		}
+		c.write(key, form.Get("value"), 0)
+		status := http.StatusCreated
+		if existed {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, map[string]interface{}{"node": map[string]string{"key": key, "value": form.Get("value")}})
+	case "DELETE":
+		if _, ok := c.keys[key]; !ok {
+			http.Error(w, `{"errorCode":100,"message":"Key not found"}`, http.StatusNotFound)
+			return
+		}
+		c.delete(key)
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// write sets key to value, attached to lease unless 0
+func (c *Cluster) write(key string, value string, lease int64) {
+	c.keyIndex++
+	if _, ok := c.keys[key]; !ok {
+		c.created[key] = c.revision()
+	}
+	c.keys[key] = value
+	c.leased[key] = lease
+}
+
+func (c *Cluster) delete(key string) {
+	c.keyIndex++
+	delete(c.keys, key)
+	delete(c.created, key)
+	delete(c.leased, key)
+}
+
+// expire deletes the keys of the leases past their TTL
+func (c *Cluster) expire() {
+	now := time.Now()
+	for id, expiry := range c.leases {
+		if now.Before(expiry) {
+			continue
+		}
+		delete(c.leases, id)
+		for key, lease := range c.leased {
+			if lease == id {
+				c.delete(key)
+			}
+		}
+	}
+}
+
+// jsonInt is an int64 of the gateway, which reads them as strings or
+// numbers
+type jsonInt int64
+
+func (i *jsonInt) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*i = jsonInt(n)
+	return err
+}
+
+// kvRequest is a range, put or delete range request, the keys and values
+// base64 encoded
+type kvRequest struct {
+	Key      string  `json:"key"`
+	RangeEnd string  `json:"range_end"`
+	Value    string  `json:"value"`
+	Lease    jsonInt `json:"lease"`
+}
+
+// matching returns the keys of req, sorted
+func (c *Cluster) matching(req kvRequest) []string {
+	key, _ := base64.StdEncoding.DecodeString(req.Key)
+	end, _ := base64.StdEncoding.DecodeString(req.RangeEnd)
+	keys := []string{}
+	for k := range c.keys {
+		if k == string(key) || len(end) > 0 && k >= string(key) && k < string(end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Cluster) rangeKeys(w http.ResponseWriter, r *http.Request) {
+	var req kvRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	c.expire()
+	kvs := []map[string]string{}
+	for _, k := range c.matching(req) {
+		kvs = append(kvs, map[string]string{
+			"key":             base64.StdEncoding.EncodeToString([]byte(k)),
+			"value":           base64.StdEncoding.EncodeToString([]byte(c.keys[k])),
+			"create_revision": strconv.FormatInt(c.created[k], 10),
+			"lease":           strconv.FormatInt(c.leased[k], 10),
+		})
+	}
+	resp := map[string]interface{}{"header": c.header(), "count": strconv.Itoa(len(kvs))}
+	// The gateway leaves out empty fields
+	if len(kvs) > 0 {
+		resp["kvs"] = kvs
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (c *Cluster) putKey(w http.ResponseWriter, r *http.Request) {
+	var req kvRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !c.put(w, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"header": c.header()})
+}
+
+// put writes the key of req, failing the request on an unknown lease
+func (c *Cluster) put(w http.ResponseWriter, req kvRequest) bool {
+	c.expire()
+	if _, ok := c.leases[int64(req.Lease)]; req.Lease != 0 && !ok {
+		http.Error(w, `{"error":"etcdserver: requested lease not found","code":5}`, http.StatusNotFound)
+		return false
+	}
+	key, _ := base64.StdEncoding.DecodeString(req.Key)
+	value, _ := base64.StdEncoding.DecodeString(req.Value)
+	c.write(string(key), string(value), int64(req.Lease))
+	return true
+}
+
+func (c *Cluster) deleteRange(w http.ResponseWriter, r *http.Request) {
+	var req kvRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	c.expire()
+	keys := c.matching(req)
+	for _, k := range keys {
+		c.delete(k)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"header": c.header(), "deleted": strconv.Itoa(len(keys))})
+}
+
+// txn supports the comparisons of the create revision and the value of a
+// key, and puts
+func (c *Cluster) txn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Compare []struct {
+			Target         string  `json:"target"`
+			Key            string  `json:"key"`
+			Result         string  `json:"result"`
+			CreateRevision jsonInt `json:"create_revision"`
+			Value          string  `json:"value"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut *kvRequest `json:"request_put"`
+		} `json:"success"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	c.expire()
+	succeeded := true
+	for _, cmp := range req.Compare {
+		key, _ := base64.StdEncoding.DecodeString(cmp.Key)
+		value, _ := base64.StdEncoding.DecodeString(cmp.Value)
+		if cmp.Result != "" && cmp.Result != "EQUAL" {
+			http.Error(w, "only EQUAL comparisons are supported", http.StatusBadRequest)
+			return
+		}
+		switch cmp.Target {
+		case "CREATE":
+			succeeded = succeeded && c.created[string(key)] == int64(cmp.CreateRevision)
+		case "VALUE":
+			current, ok := c.keys[string(key)]
+			succeeded = succeeded && ok && current == string(value)
+		default:
+			http.Error(w, "only CREATE and VALUE comparisons are supported", http.StatusBadRequest)
+			return
+		}
+	}
+	if succeeded {
+		for _, op := range req.Success {
+			if op.RequestPut != nil && !c.put(w, *op.RequestPut) {
+				return
+			}
+		}
+	}
+	resp := map[string]interface{}{"header": c.header()}
+	if succeeded {
+		resp["succeeded"] = true
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (c *Cluster) grantLease(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TTL jsonInt `json:"TTL"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TTL <= 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	c.nextID++
+	id := int64(c.nextID & 0x7fffffffffffffff)
+	c.leases[id] = time.Now().Add(time.Duration(req.TTL) * time.Second)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"header": c.header(),
+		"ID":     strconv.FormatInt(id, 10),
+		"TTL":    strconv.FormatInt(int64(req.TTL), 10),
+	})
+}
+
+func (c *Cluster) header() map[string]string {
+	return map[string]string{"revision": strconv.FormatInt(c.revision(), 10)}
+}
+
+// clusterVersion is the major.minor.0 of version
+func clusterVersion(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1] + ".0"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
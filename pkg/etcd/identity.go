@@ -0,0 +1,126 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/viruxel/etcdmate/pkg/internal/parallel"
+)
+
+// CheckIdentity connects to the HTTPS client and peer URLs of member and
+// verifies the presented certificates are valid for the URL hosts, and
+// signed by the CA of the client or of the peer URLs when set. etcd
+// otherwise only fails later with a peer handshake error. Errors wrap
+// ErrIdentityMismatch, or ErrUnreachable when no certificate was received.
+func (c *Client) CheckIdentity(ctx context.Context, member Member) error {
+	peerConfig, err := c.peerTLSConfig()
+	if err != nil {
+		return err
+	}
+	for _, memberURL := range append(member.AllClientURLs(), member.AllPeerURLs()...) {
+		u, err := url.Parse(memberURL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "https" {
+			continue
+		}
+		config := c.tlsConfig()
+		if isPeerURL(member, memberURL) {
+			config = peerConfig
+		}
+		chain, err := c.peerCertificates(ctx, u.Host, config)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrUnreachable, member.Name, err)
+		}
+		if err := verifyChain(chain, config); err != nil {
+			return fmt.Errorf("%w: %s: %s: %v", ErrIdentityMismatch, member.Name, memberURL, err)
+		}
+		cert := chain[0]
+		host := u.Hostname()
+		if config.ServerName != "" {
+			host = config.ServerName
+		}
+		if err := cert.VerifyHostname(host); err != nil {
+			return fmt.Errorf("%w: %s: %s: %v", ErrIdentityMismatch, member.Name, memberURL, err)
+		}
+	}
+	return nil
+}
+
+func isPeerURL(member Member, memberURL string) bool {
+	for _, peerURL := range member.AllPeerURLs() {
+		if peerURL == memberURL {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIdentityAll checks the members in parallel, the errors are in the
+// members order
+func (c *Client) CheckIdentityAll(ctx context.Context, members []Member) []error {
+	errs := make([]error, len(members))
+	parallel.ForEach(c.parallelism, len(members), func(i int) {
+		errs[i] = c.CheckIdentity(ctx, members[i])
+	})
+	return errs
+}
+
+// peerCertificates returns the certificate chain presented at addr, the
+// leaf first, without verifying it
+func (c *Client) peerCertificates(ctx context.Context, addr string, config *tls.Config) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	config = config.Clone()
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("No certificate presented")
+		}
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, cert)
+		}
+		return nil
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: c.transport.TLSHandshakeTimeout},
+		Config:    config,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		conn.Close()
+	}
+	// Peers requiring a client certificate may still reject the handshake
+	// after presenting theirs
+	if len(chain) == 0 {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// verifyChain verifies chain against the CA of config, if it has one
+func verifyChain(chain []*x509.Certificate, config *tls.Config) error {
+	if config.RootCAs == nil || config.InsecureSkipVerify {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		Intermediates: intermediates,
+		// Peer certificates are also used as client certificates
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
@@ -0,0 +1,189 @@
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyValue is a key and its value, read through the v3 gateway
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// AppendKey creates a key in dir named after the current time, the keys of
+// a directory sort in the order they were appended
+func (c *Client) AppendKey(ctx context.Context, hm Member, dir string, value string) error {
+	key := fmt.Sprintf("%s/%020d", dir, time.Now().UnixNano())
+	return c.SetKey(ctx, hm, key, value, 0)
+}
+
+// ListKeys returns the keys under dir in order, none if there are none
+func (c *Client) ListKeys(ctx context.Context, hm Member, dir string) ([]KeyValue, error) {
+	// The keys from dir/ up to, without, dir0, '0' following '/'
+	return c.rangeKeys(ctx, hm, kvRequest{
+		Key:        encodeKey(dir + "/"),
+		RangeEnd:   encodeKey(dir + "0"),
+		SortOrder:  "ASCEND",
+		SortTarget: "KEY",
+	})
+}
+
+func (c *Client) DeleteKey(ctx context.Context, hm Member, key string) error {
+	// Deleting a key another instance pruned already deletes nothing
+	return c.kv(ctx, hm, "kv/deleterange", kvRequest{Key: encodeKey(key)}, nil)
+}
+
+// SetKey creates or replaces key, expiring it after ttl unless 0
+func (c *Client) SetKey(ctx context.Context, hm Member, key string, value string, ttl time.Duration) error {
+	lease, err := c.grantLease(ctx, hm, ttl)
+	if err != nil {
+		return err
+	}
+	return c.kv(ctx, hm, "kv/put", kvRequest{Key: encodeKey(key), Value: encodeKey(value), Lease: lease}, nil)
+}
+
+// CompareAndSetKey sets key like SetKey, only if it doesn't exist when
+// prevValue is empty or if it holds prevValue otherwise. It returns false
+// when it didn't, e.g. as another instance holds it.
+func (c *Client) CompareAndSetKey(
+	ctx context.Context,
+	hm Member,
+	key string,
+	prevValue string,
+	value string,
+	ttl time.Duration,
+) (bool, error) {
+	lease, err := c.grantLease(ctx, hm, ttl)
+	if err != nil {
+		return false, err
+	}
+	compare := txnCompare{Key: encodeKey(key), Result: "EQUAL"}
+	if prevValue == "" {
+		// A key that doesn't exist has the create revision 0
+		compare.Target, compare.CreateRevision = "CREATE", "0"
+	} else {
+		compare.Target, compare.Value = "VALUE", encodeKey(prevValue)
+	}
+	req := struct {
+		Compare []txnCompare `json:"compare"`
+		Success []txnOp      `json:"success"`
+	}{
+		Compare: []txnCompare{compare},
+		Success: []txnOp{{RequestPut: &kvRequest{Key: encodeKey(key), Value: encodeKey(value), Lease: lease}}},
+	}
+	var jresp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := c.kv(ctx, hm, "kv/txn", req, &jresp); err != nil {
+		return false, err
+	}
+	return jresp.Succeeded, nil
+}
+
+// GetKey returns the value of key, false if it doesn't exist
+func (c *Client) GetKey(ctx context.Context, hm Member, key string) (string, bool, error) {
+	kvs, err := c.rangeKeys(ctx, hm, kvRequest{Key: encodeKey(key)})
+	if err != nil || len(kvs) == 0 {
+		return "", false, err
+	}
+	return kvs[0].Value, true, nil
+}
+
+// kvRequest is a range, put or delete range request of the v3 gateway,
+// which takes the keys and values base64 encoded
+type kvRequest struct {
+	Key        string `json:"key"`
+	RangeEnd   string `json:"range_end,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Lease      string `json:"lease,omitempty"`
+	SortOrder  string `json:"sort_order,omitempty"`
+	SortTarget string `json:"sort_target,omitempty"`
+}
+
+type txnCompare struct {
+	Target         string `json:"target"`
+	Key            string `json:"key"`
+	Result         string `json:"result"`
+	CreateRevision string `json:"create_revision,omitempty"`
+	Value          string `json:"value,omitempty"`
+}
+
+type txnOp struct {
+	RequestPut *kvRequest `json:"request_put,omitempty"`
+}
+
+func encodeKey(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// rangeKeys returns the keys of req, decoded
+func (c *Client) rangeKeys(ctx context.Context, hm Member, req kvRequest) ([]KeyValue, error) {
+	var jresp struct {
+		Kvs []KeyValue `json:"kvs"`
+	}
+	if err := c.kv(ctx, hm, "kv/range", req, &jresp); err != nil {
+		return nil, err
+	}
+	kvs := []KeyValue{}
+	for _, kv := range jresp.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, KeyValue{Key: string(key), Value: string(value)})
+	}
+	return kvs, nil
+}
+
+// grantLease returns the ID of a lease of ttl, none for 0
+func (c *Client) grantLease(ctx context.Context, hm Member, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", nil
+	}
+	// The gateway encodes 64 bit integers as strings
+	var jresp struct {
+		ID string
+	}
+	seconds := strconv.FormatInt(int64(ttl.Seconds()), 10)
+	if err := c.kv(ctx, hm, "lease/grant", map[string]string{"TTL": seconds}, &jresp); err != nil {
+		return "", err
+	}
+	return jresp.ID, nil
+}
+
+// kv posts req to the v3 gateway endpoint of hm, decoding the answer into
+// jresp unless nil
+func (c *Client) kv(ctx context.Context, hm Member, endpoint string, req interface{}, jresp interface{}) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", fmt.Sprintf("%s/v3/%s", hm.ClientURL, endpoint), data)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Calling %s failed: %d %s", endpoint, resp.StatusCode, body))
+	}
+	if jresp == nil {
+		return nil
+	}
+	return json.Unmarshal(body, jresp)
+}
@@ -0,0 +1,137 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// Status is what a member reports about itself through the v3 gateway
+type Status struct {
+	// MemberID and Leader are hex encoded like Member.ID
+	MemberID string
+	Leader   string
+	Revision int64
+	DBSize   int64
+	// DBSizeInUse is the part of DBSize not freed, 0 before etcd 3.4
+	DBSizeInUse int64
+	// RaftIndex is the last raft entry of the member, the lag of a
+	// follower is how far it is behind that of the leader
+	RaftIndex uint64
+}
+
+// IsLeader tells whether the member reporting the status is the leader
+func (s Status) IsLeader() bool {
+	return s.MemberID != "" && s.MemberID == s.Leader
+}
+
+// Status returns the status of m
+func (c *Client) Status(ctx context.Context, m Member) (Status, error) {
+	resp, err := c.do(ctx, "POST", fmt.Sprintf("%s/v3/maintenance/status", m.ClientURL), []byte("{}"))
+	if err != nil {
+		return Status{}, err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return Status{}, errors.New(fmt.Sprintf("Getting status failed: %s", body))
+	}
+	// The gateway encodes 64 bit integers as strings
+	var jresp struct {
+		Header struct {
+			MemberID string `json:"member_id"`
+			Revision string
+		}
+		Leader      string
+		DBSize      string
+		DBSizeInUse string
+		RaftIndex   string
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jresp)
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{
+		MemberID: hexID(jresp.Header.MemberID),
+		Leader:   hexID(jresp.Leader),
+	}
+	status.Revision, _ = strconv.ParseInt(jresp.Header.Revision, 10, 64)
+	status.DBSize, _ = strconv.ParseInt(jresp.DBSize, 10, 64)
+	status.DBSizeInUse, _ = strconv.ParseInt(jresp.DBSizeInUse, 10, 64)
+	status.RaftIndex, _ = strconv.ParseUint(jresp.RaftIndex, 10, 64)
+	return status, nil
+}
+
+func hexID(decimal string) string {
+	id, err := strconv.ParseUint(decimal, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(id, 16)
+}
+
+// Compact discards the key history before revision, for the whole cluster
+func (c *Client) Compact(ctx context.Context, hm Member, revision int64) error {
+	c.logger.Println("Compacting revisions before", revision)
+	url := fmt.Sprintf("%s/v3/kv/compaction", hm.ClientURL)
+	byteData := []byte(fmt.Sprintf(`{"revision": "%d", "physical": true}`, revision))
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Compacting failed: %s", body))
+	}
+	return nil
+}
+
+// Defragment releases the space freed by compaction on m. The member
+// doesn't answer while it runs, which can outlast the client timeout, so
+// only ctx bounds it.
+func (c *Client) Defragment(ctx context.Context, m Member) error {
+	c.logger.Printf("Defragmenting member %+v\n", m)
+	url := fmt.Sprintf("%s/v3/maintenance/defragment", m.ClientURL)
+	req, err := c.newRequest(ctx, "POST", url, []byte("{}"), "application/json")
+	if err != nil {
+		return err
+	}
+	client := *c.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Defragmenting failed: %s", body))
+	}
+	return nil
+}
+
+// MoveLeader hands the leadership of leader, which must be the current
+// leader, over to target
+func (c *Client) MoveLeader(ctx context.Context, leader Member, target Member) error {
+	c.logger.Printf("Moving leadership from %s to %s\n", leader.Name, target.Name)
+	id, err := strconv.ParseUint(target.ID, 16, 64)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v3/maintenance/transfer-leadership", leader.ClientURL)
+	resp, err := c.do(ctx, "POST", url, []byte(fmt.Sprintf(`{"targetID": "%d"}`, id)))
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Moving leadership failed: %s", body))
+	}
+	return nil
+}
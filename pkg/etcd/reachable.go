@@ -0,0 +1,58 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/internal/parallel"
+)
+
+// HostReachable tells whether the host of the member peer URL answers at
+// the TCP level. A refused connection counts: the host is up and reachable,
+// only etcd isn't listening. Timeouts and unreachable networks don't, the
+// member may be running on the other side of a partition.
+func (c *Client) HostReachable(ctx context.Context, m Member) bool {
+	u, err := url.Parse(m.PeerURL)
+	if err != nil {
+		return false
+	}
+	dialer := &net.Dialer{Timeout: c.transport.TLSHandshakeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// HostReachableAll probes the members in parallel, the results are in the
+// members order
+func (c *Client) HostReachableAll(ctx context.Context, members []Member) []bool {
+	reachable := make([]bool, len(members))
+	parallel.ForEach(c.parallelism, len(members), func(i int) {
+		reachable[i] = c.HostReachable(ctx, members[i])
+	})
+	return reachable
+}
+
+// PeerRTT returns how long connecting to the peer port of m takes, about a
+// network round trip
+func (c *Client) PeerRTT(ctx context.Context, m Member) (time.Duration, error) {
+	u, err := url.Parse(m.PeerURL)
+	if err != nil {
+		return 0, err
+	}
+	dialer := &net.Dialer{Timeout: c.transport.TLSHandshakeTimeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
@@ -0,0 +1,105 @@
+package etcd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsFiles remembers where the TLS material came from, so it can be
+// reloaded when short lived certificates are renewed
+type tlsFiles struct {
+	ca   string
+	cert string
+	key  string
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// changed reports whether any file was modified since the last call
+func (f *tlsFiles) changed() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.modTimes == nil {
+		f.modTimes = map[string]time.Time{}
+	}
+	changed := false
+	for _, file := range []string{f.ca, f.cert, f.key} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(f.modTimes[file]) {
+			f.modTimes[file] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// swapTransport lets the transport be replaced while requests are in flight
+type swapTransport struct {
+	mu sync.RWMutex
+	rt *http.Transport
+}
+
+func (s *swapTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.RLock()
+	rt := s.rt
+	s.mu.RUnlock()
+	return rt.RoundTrip(req)
+}
+
+func (s *swapTransport) swap(rt *http.Transport) {
+	s.mu.Lock()
+	old := s.rt
+	s.rt = rt
+	s.mu.Unlock()
+	old.CloseIdleConnections()
+}
+
+// ReloadTLS reloads the files given to WithTLS when any of them changed and
+// reports whether it did. Only new connections use the new material.
+func (c *Client) ReloadTLS() (bool, error) {
+	if c.files == nil || c.swap == nil {
+		return false, nil
+	}
+	changed, err := c.files.changed()
+	if err != nil || !changed {
+		return false, err
+	}
+	tlsConfig := c.tlsConfig().Clone()
+	err = loadTLS(tlsConfig, c.files.ca, c.files.cert, c.files.key)
+	if err != nil {
+		return false, err
+	}
+	transport := c.transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.swap.swap(transport)
+	return true, nil
+}
+
+// WatchTLS checks the TLS files every interval until ctx is done
+func (c *Client) WatchTLS(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := c.ReloadTLS()
+		if err != nil {
+			c.logger.Println("Reloading TLS files failed:", err)
+		} else if reloaded {
+			c.logger.Println("Reloaded TLS files")
+		}
+	}
+}
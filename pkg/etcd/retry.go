@@ -0,0 +1,65 @@
+package etcd
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// maxRetryDelay caps the exponential backoff between retries
+const maxRetryDelay = 30 * time.Second
+
+// WithRetries retries a request up to retries times when it gets no
+// response at all, e.g. a refused connection or a timeout, waiting a
+// jittered backoff doubling from backoff in between. Answered requests are
+// never retried, their status means something to the caller. Retries stop
+// at the deadline of the request context.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) error {
+		c.retries = retries
+		c.backoff = backoff
+		return nil
+	}
+}
+
+// sendWithRetries sends the request built by newRequest, a fresh one for
+// every attempt since the body is consumed
+func (c *Client) sendWithRetries(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil {
+			return resp, err
+		}
+		delay := retryDelay(c.backoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+		c.logger.Printf("Retrying %s %s in %s: %v\n", req.Method, req.URL, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay is backoff doubled attempt times, capped at maxRetryDelay,
+// with up to half of it jittered away so clients don't retry in lockstep
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	if delay < 2 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
@@ -0,0 +1,87 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses versions like "1.2"
+func ParseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.New(fmt.Sprint("Unknown TLS version ", version))
+	}
+	return v, nil
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names as
+// listed by crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+func ParseCipherSuites(names string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	// nil, not empty, keeps the defaults
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprint("Unknown or insecure cipher suite ", name))
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// WithPeerTLS sets the TLS material used to connect to the peer URLs, which
+// may come from another CA than that of the client URLs. Empty files fall
+// back to those of WithTLS.
+func WithPeerTLS(caFile, certFile, keyFile string) Option {
+	return func(c *Client) error {
+		c.peerFiles = &tlsFiles{ca: caFile, cert: certFile, key: keyFile}
+		return loadTLS(&tls.Config{}, caFile, certFile, keyFile)
+	}
+}
+
+// WithInsecureSkipVerify accepts any certificate from etcd, for tests only
+func WithInsecureSkipVerify() Option {
+	return func(c *Client) error {
+		c.tlsConfig().InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithServerName verifies the certificates of the client URLs against name
+// rather than the host of the URL, e.g. when the members are reached at
+// their IPs and their certificates only name a DNS record
+func WithServerName(name string) Option {
+	return func(c *Client) error {
+		c.tlsConfig().ServerName = name
+		return nil
+	}
+}
+
+// peerTLSConfig returns the TLS configuration of the peer URLs. The files
+// are read on every call, renewed certificates are used right away.
+func (c *Client) peerTLSConfig() (*tls.Config, error) {
+	config := c.tlsConfig().Clone()
+	if c.peerFiles == nil {
+		return config, nil
+	}
+	// The server name of the client URLs doesn't apply
+	config.ServerName = ""
+	return config, loadTLS(config, c.peerFiles.ca, c.peerFiles.cert, c.peerFiles.key)
+}
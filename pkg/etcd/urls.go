@@ -0,0 +1,68 @@
+package etcd
+
+import (
+	"sort"
+)
+
+// WithURLs returns m with the client and peer URLs given, sorted as etcd
+// lists them so a member compares the same whether it was discovered or
+// listed. ClientURL and PeerURL are the first ones, ClientURLs and
+// PeerURLs are only set for members with several.
+func (m Member) WithURLs(clientURLs []string, peerURLs []string) Member {
+	m.ClientURL, m.ClientURLs = sortedURLs(clientURLs)
+	m.PeerURL, m.PeerURLs = sortedURLs(peerURLs)
+	return m
+}
+
+func sortedURLs(urls []string) (string, []string) {
+	seen := map[string]bool{}
+	sorted := []string{}
+	for _, u := range urls {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			sorted = append(sorted, u)
+		}
+	}
+	sort.Strings(sorted)
+	switch len(sorted) {
+	case 0:
+		return "", nil
+	case 1:
+		return sorted[0], nil
+	}
+	return sorted[0], sorted
+}
+
+// AllClientURLs returns every client URL of m
+func (m Member) AllClientURLs() []string {
+	return allURLs(m.ClientURL, m.ClientURLs)
+}
+
+// AllPeerURLs returns every peer URL of m
+func (m Member) AllPeerURLs() []string {
+	return allURLs(m.PeerURL, m.PeerURLs)
+}
+
+func allURLs(first string, all []string) []string {
+	if len(all) > 0 {
+		return all
+	}
+	if first == "" {
+		return nil
+	}
+	return []string{first}
+}
+
+// SamePeerURLs tells whether m and other have the same peer URLs
+func (m Member) SamePeerURLs(other Member) bool {
+	a, b := m.AllPeerURLs(), other.AllPeerURLs()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// APIVersion selects the membership API. etcd 3.4 and later can run
+// without the v2 API, which etcd 3.6 dropped, while the v3 one is served
+// through the gRPC gateway since etcd 3.4.
+type APIVersion string
+
+const (
+	// APIAuto uses the v3 API when the member serves it, v2 otherwise
+	APIAuto APIVersion = "auto"
+	APIv2   APIVersion = "2"
+	APIv3   APIVersion = "3"
+)
+
+// membersAPI remembers which API the members spoke, shared by the copies
+// of a Client
+type membersAPI struct {
+	version APIVersion
+	mu      sync.Mutex
+	// v3 is whether the member with the client URL serves the v3 API
+	v3 map[string]bool
+}
+
+// WithAPIVersion sets the membership API, APIAuto by default
+func WithAPIVersion(version APIVersion) Option {
+	return func(c *Client) error {
+		switch version {
+		case APIAuto, APIv2, APIv3:
+		default:
+			return errors.New(fmt.Sprint("Invalid etcd API version ", version))
+		}
+		c.api = &membersAPI{version: version, v3: map[string]bool{}}
+		return nil
+	}
+}
+
+// useV3 tells whether to manage the members through hm with the v3 API,
+// asking hm the first time in auto mode
+func (c *Client) useV3(ctx context.Context, hm Member) (bool, error) {
+	if c.api == nil || c.api.version == APIv2 {
+		return false, nil
+	}
+	if c.api.version == APIv3 {
+		return true, nil
+	}
+	c.api.mu.Lock()
+	v3, ok := c.api.v3[hm.ClientURL]
+	c.api.mu.Unlock()
+	if ok {
+		return v3, nil
+	}
+	resp, err := c.do(ctx, "POST", fmt.Sprintf("%s/v3/cluster/member/list", hm.ClientURL), []byte("{}"))
+	if err != nil {
+		return false, err
+	}
+	closeBody(resp)
+	// Before etcd 3.4 the gateway is under /v3beta, v2 is then always served
+	v3 = resp.StatusCode != http.StatusNotFound
+	if v3 {
+		c.logger.Println("Using the v3 membership API of", hm.ClientURL)
+	} else {
+		c.logger.Println("Using the v2 membership API of", hm.ClientURL)
+	}
+	c.api.mu.Lock()
+	c.api.v3[hm.ClientURL] = v3
+	c.api.mu.Unlock()
+	return v3, nil
+}
+
+type v3Member struct {
+	Member
+	learner bool
+}
+
+// listMembersV3 lists the members with the v3 gateway, which encodes the
+// IDs as decimal strings where v2 uses hexadecimal ones
+func (c *Client) listMembersV3(ctx context.Context, hm Member) ([]v3Member, error) {
+	resp, err := c.do(ctx, "POST", fmt.Sprintf("%s/v3/cluster/member/list", hm.ClientURL), []byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.New(fmt.Sprintf("Listing members failed: %s", body))
+	}
+	var jresp struct {
+		Members []struct {
+			ID         string
+			Name       string
+			PeerURLs   []string
+			ClientURLs []string
+			IsLearner  bool
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jresp)
+	if err != nil {
+		return nil, err
+	}
+	members := []v3Member{}
+	for _, jm := range jresp.Members {
+		id, err := strconv.ParseUint(jm.ID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		m := Member{ID: strconv.FormatUint(id, 16), Name: jm.Name}
+		members = append(members, v3Member{Member: m.WithURLs(jm.ClientURLs, jm.PeerURLs), learner: jm.IsLearner})
+	}
+	return members, nil
+}
+
+func (c *Client) addMemberV3(ctx context.Context, hm Member, am Member) error {
+	url := fmt.Sprintf("%s/v3/cluster/member/add", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{PeerURLs: am.AllPeerURLs()})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return memberError(resp, "Adding", am.PeerURL)
+}
+
+func (c *Client) removeMemberV3(ctx context.Context, hm Member, rm Member) error {
+	id, err := strconv.ParseUint(rm.ID, 16, 64)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v3/cluster/member/remove", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{ID: strconv.FormatUint(id, 10)})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return memberError(resp, "Removing", rm.PeerURL)
+	}
+	return nil
+}
+
+func (c *Client) updateMemberV3(ctx context.Context, hm Member, um Member) error {
+	id, err := strconv.ParseUint(um.ID, 16, 64)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v3/cluster/member/update", hm.ClientURL)
+	byteData, err := json.Marshal(memberRequest{ID: strconv.FormatUint(id, 10), PeerURLs: um.AllPeerURLs()})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", url, byteData)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return memberError(resp, "Updating", um.PeerURL)
+}
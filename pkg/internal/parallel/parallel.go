@@ -0,0 +1,33 @@
+// Package parallel runs work on a bounded number of goroutines.
+package parallel
+
+import (
+	"sync"
+)
+
+// ForEach calls fn for every index in [0, count) using at most n goroutines
+// and waits for all of them. n below 1 means sequential.
+func ForEach(n int, count int, fn func(i int)) {
+	if n < 1 {
+		n = 1
+	}
+	if n > count {
+		n = count
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
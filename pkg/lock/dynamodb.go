@@ -0,0 +1,90 @@
+package lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDBAPI is the subset of the DynamoDB API DynamoDB uses
+type DynamoDBAPI interface {
+	GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDB keeps the lock Name as an item of Table, whose partition key is
+// the string LockID. The Version attribute of the item is incremented by
+// every conditional write.
+type DynamoDB struct {
+	API   DynamoDBAPI
+	Table string
+	Name  string
+}
+
+func (d DynamoDB) Get(ctx context.Context) (*Entry, error) {
+	resp, err := d.API.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"LockID": {S: aws.String(d.Name)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+	e := &Entry{}
+	if v := resp.Item["Holder"]; v != nil {
+		e.Holder = aws.StringValue(v.S)
+	}
+	if v := resp.Item["PeerURL"]; v != nil {
+		e.PeerURL = aws.StringValue(v.S)
+	}
+	if v := resp.Item["Expires"]; v != nil {
+		expires, err := strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		e.Expires = time.Unix(expires, 0)
+	}
+	if v := resp.Item["Version"]; v != nil {
+		e.Version = aws.StringValue(v.N)
+	}
+	return e, nil
+}
+
+func (d DynamoDB) Swap(ctx context.Context, prev *Entry, e Entry) error {
+	version := int64(1)
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(d.Table),
+		ConditionExpression: aws.String("attribute_not_exists(LockID)"),
+	}
+	if prev != nil {
+		current, err := strconv.ParseInt(prev.Version, 10, 64)
+		if err != nil {
+			return err
+		}
+		version = current + 1
+		input.ConditionExpression = aws.String("Version = :version")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(prev.Version)},
+		}
+	}
+	input.Item = map[string]*dynamodb.AttributeValue{
+		"LockID":  {S: aws.String(d.Name)},
+		"Holder":  {S: aws.String(e.Holder)},
+		"PeerURL": {S: aws.String(e.PeerURL)},
+		"Expires": {N: aws.String(strconv.FormatInt(e.Expires.Unix(), 10))},
+		"Version": {N: aws.String(strconv.FormatInt(version, 10))},
+	}
+	_, err := d.API.PutItemWithContext(ctx, input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrConflict
+	}
+	return err
+}
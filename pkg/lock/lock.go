@@ -0,0 +1,38 @@
+// Package lock shares locks between the instances through DynamoDB or S3.
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned by Swap when the lock changed since it was read
+var ErrConflict = errors.New("The lock changed meanwhile")
+
+// Lock is a lock shared by the instances, kept by a compare and swap on its
+// Entry. It has no owner process: an entry is a lease, taken over once
+// expired or once its holder is done.
+type Lock interface {
+	// Get returns the entry, nil when the lock was never taken
+	Get(ctx context.Context) (*Entry, error)
+	// Swap writes e if the entry still is prev, nil meaning none, and
+	// returns ErrConflict otherwise
+	Swap(ctx context.Context, prev *Entry, e Entry) error
+}
+
+// Entry is a lease on the lock
+type Entry struct {
+	// Holder is the instance ID of the holder
+	Holder string
+	// PeerURL is the member the holder added
+	PeerURL string
+	Expires time.Time
+	// Version identifies the entry for Swap, set by Get
+	Version string `json:"-"`
+}
+
+// Expired tells whether the lease is over at now
+func (e Entry) Expired(now time.Time) bool {
+	return !now.Before(e.Expires)
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3API is the subset of the S3 API S3 uses
+type S3API interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+}
+
+// S3 keeps the lock as the JSON object Key of Bucket, swapped with the
+// conditional writes of S3 on its ETag
+type S3 struct {
+	API    S3API
+	Bucket string
+	Key    string
+}
+
+// NewS3 parses an s3://bucket/prefix URL, the lock being prefix/name.json
+func NewS3(svc S3API, s3URL string, name string) (S3, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return S3{}, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return S3{}, errors.New(fmt.Sprint("Invalid S3 URL ", s3URL))
+	}
+	return S3{API: svc, Bucket: u.Host, Key: path.Join(strings.Trim(u.Path, "/"), name+".json")}, nil
+}
+
+func (s S3) Get(ctx context.Context) (*Entry, error) {
+	resp, err := s.API.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, errors.New(fmt.Sprint("Invalid lock s3://", s.Bucket, "/", s.Key, ": ", err))
+	}
+	e.Version = aws.StringValue(resp.ETag)
+	return e, nil
+}
+
+func (s S3) Swap(ctx context.Context, prev *Entry, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	// The SDK predates the conditional writes of S3, the headers are set on
+	// the request
+	condition := map[string]string{"If-None-Match": "*"}
+	if prev != nil {
+		condition = map[string]string{"If-Match": prev.Version}
+	}
+	_, err = s.API.PutObjectWithContext(ctx, input, request.WithSetRequestHeaders(condition))
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return ErrConflict
+		}
+	}
+	return err
+}
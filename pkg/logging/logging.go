@@ -0,0 +1,25 @@
+// Package logging defines the Logger the other packages write to.
+package logging
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// Logger is satisfied by the standard library *log.Logger, so embedders can
+// route etcdmate's output to their own logging.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// Discard drops everything logged to it
+var Discard Logger = log.New(ioutil.Discard, "", 0)
+
+// OrDefault returns l, or the standard logger when l is nil
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return log.Default()
+	}
+	return l
+}
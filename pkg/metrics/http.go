@@ -0,0 +1,17 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// ServeHTTP answers a Prometheus scrape with the current values of r
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body bytes.Buffer
+	if err := r.WritePrometheus(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(body.Bytes())
+}
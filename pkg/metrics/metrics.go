@@ -0,0 +1,129 @@
+// Package metrics keeps the etcdmate counters and gauges and forwards every
+// update to the configured sinks.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Labels qualify a metric, e.g. the event type
+type Labels map[string]string
+
+// Sink receives every metric update as it happens
+type Sink interface {
+	Count(name string, delta float64, labels Labels)
+	Gauge(name string, value float64, labels Labels)
+}
+
+// Registry holds the current metric values. A nil Registry ignores updates.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]*series
+	gauges   map[string]*series
+	sinks    []Sink
+}
+
+type series struct {
+	name   string
+	labels Labels
+	value  float64
+}
+
+func NewRegistry(sinks ...Sink) *Registry {
+	return &Registry{
+		counters: map[string]*series{},
+		gauges:   map[string]*series{},
+		sinks:    sinks,
+	}
+}
+
+// Add increases the counter name by delta
+func (r *Registry) Add(name string, delta float64, labels Labels) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.get(r.counters, name, labels).value += delta
+	r.mu.Unlock()
+	for _, sink := range r.sinks {
+		sink.Count(name, delta, labels)
+	}
+}
+
+// Set sets the gauge name to value
+func (r *Registry) Set(name string, value float64, labels Labels) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.get(r.gauges, name, labels).value = value
+	r.mu.Unlock()
+	for _, sink := range r.sinks {
+		sink.Gauge(name, value, labels)
+	}
+}
+
+func (r *Registry) get(m map[string]*series, name string, labels Labels) *series {
+	key := name + labels.String()
+	s, ok := m[key]
+	if !ok {
+		s = &series{name: name, labels: labels}
+		m[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes the current values in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, group := range []struct {
+		kind   string
+		series map[string]*series
+	}{{"counter", r.counters}, {"gauge", r.gauges}} {
+		keys := []string{}
+		for key := range group.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		typed := map[string]bool{}
+		for _, key := range keys {
+			s := group.series[key]
+			if !typed[s.name] {
+				typed[s.name] = true
+				if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", s.name, group.kind); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%s%s %g\n", s.name, s.labels, s.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// String renders the labels as in the Prometheus text format, sorted
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for _, k := range l.keys() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, l[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (l Labels) keys() []string {
+	keys := []string{}
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Push replaces the metrics of the group job and grouping on a Prometheus
+// Pushgateway with the current values of r
+func (r *Registry) Push(
+	ctx context.Context,
+	client *http.Client,
+	gateway string,
+	job string,
+	grouping Labels,
+) error {
+	var body bytes.Buffer
+	if err := r.WritePrometheus(&body); err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	for _, k := range grouping.keys() {
+		target += "/" + url.PathEscape(k) + "/" + url.PathEscape(grouping[k])
+	}
+	req, err := http.NewRequest("PUT", target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprint("Pushing metrics failed with status ", resp.StatusCode))
+	}
+	return nil
+}
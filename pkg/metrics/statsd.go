@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// StatsD sends every update over UDP. With DogStatsD set, labels and Tags
+// are sent as tags, otherwise label values are appended to the metric name
+// as plain statsd has no tags.
+type StatsD struct {
+	conn      net.Conn
+	Tags      []string
+	DogStatsD bool
+	Logger    logging.Logger
+}
+
+func NewStatsD(addr string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{conn: conn}, nil
+}
+
+func (s *StatsD) Count(name string, delta float64, labels Labels) {
+	s.send(name, fmt.Sprintf("%g|c", delta), labels)
+}
+
+func (s *StatsD) Gauge(name string, value float64, labels Labels) {
+	s.send(name, fmt.Sprintf("%g|g", value), labels)
+}
+
+func (s *StatsD) send(name string, value string, labels Labels) {
+	tags := append([]string{}, s.Tags...)
+	for _, k := range labels.keys() {
+		if s.DogStatsD {
+			tags = append(tags, k+":"+labels[k])
+		} else {
+			name = name + "." + labels[k]
+		}
+	}
+	line := name + ":" + value
+	if s.DogStatsD && len(tags) > 0 {
+		line = line + "|#" + strings.Join(tags, ",")
+	}
+	// UDP, a lost update isn't worth more than a log line
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		logging.OrDefault(s.Logger).Println("Sending metric failed:", err)
+	}
+}
+
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+)
+
+// WriteTextfile writes the current values of r to file for the textfile
+// collector of node_exporter. The file is replaced at once so the collector
+// never reads it half written, its temporary copy doesn't end in .prom.
+func (r *Registry) WriteTextfile(file string) error {
+	var body bytes.Buffer
+	if err := r.WritePrometheus(&body); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, body.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
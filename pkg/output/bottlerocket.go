@@ -0,0 +1,101 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Bottlerocket hands the configuration to a host container through the
+// Bottlerocket API, whose root filesystem is read only: the env file is the
+// user data of HostContainer, which the container finds in
+// /.bottlerocket/host-containers/NAME/user-data
+type Bottlerocket struct {
+	// Socket is the API socket, /run/api.sock on the host
+	Socket        string
+	HostContainer string
+}
+
+func (b Bottlerocket) Path() string {
+	return "bottlerocket:" + b.setting()
+}
+
+func (b Bottlerocket) setting() string {
+	return "settings.host-containers." + b.HostContainer + ".user-data"
+}
+
+type bottlerocketSettings struct {
+	HostContainers map[string]struct {
+		UserData string `json:"user-data"`
+	} `json:"host-containers"`
+}
+
+// Read returns the current user data, empty if there is none yet
+func (b Bottlerocket) Read() (string, error) {
+	data, err := b.do("GET", "/settings?keys="+url.QueryEscape(b.setting()), nil)
+	if err != nil {
+		return "", err
+	}
+	var settings bottlerocketSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return "", err
+	}
+	content, err := base64.StdEncoding.DecodeString(settings.HostContainers[b.HostContainer].UserData)
+	if err != nil {
+		return "", errors.New(fmt.Sprint("Unreadable user data of host container ", b.HostContainer, ": ", err))
+	}
+	return string(content), nil
+}
+
+// Write sets the user data and applies it in one transaction
+func (b Bottlerocket) Write(content string) error {
+	patch := map[string]interface{}{
+		"host-containers": map[string]interface{}{
+			b.HostContainer: map[string]string{
+				"user-data": base64.StdEncoding.EncodeToString([]byte(content)),
+			},
+		},
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if _, err := b.do("PATCH", "/settings?tx=etcdmate", body); err != nil {
+		return err
+	}
+	_, err = b.do("POST", "/tx/commit_and_apply?tx=etcdmate", nil)
+	return err
+}
+
+func (b Bottlerocket) do(method string, path string, body []byte) ([]byte, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", b.Socket)
+		},
+	}}
+	req, err := http.NewRequest(method, "http://localhost"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("Bottlerocket API %s %s failed: %s %s", method, path, resp.Status, bytes.TrimSpace(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"strings"
+)
+
+// Diff returns a line based diff between a and b, with removed lines
+// prefixed by "-", added lines by "+" and unchanged lines by " ".
+// It returns an empty string when both are equal.
+func Diff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	al := splitLines(a)
+	bl := splitLines(b)
+	// Longest common subsequence table
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	out := []string{}
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			out = append(out, " "+al[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+al[i])
+			i++
+		default:
+			out = append(out, "+"+bl[j])
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		out = append(out, "-"+al[i])
+	}
+	for ; j < len(bl); j++ {
+		out = append(out, "+"+bl[j])
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
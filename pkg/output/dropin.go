@@ -0,0 +1,133 @@
+package output
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// PeerTLS is the TLS material etcd uses on the peer network, it may differ
+// from what etcdmate uses to reach the client URLs
+type PeerTLS struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+func RenderDropIn(
+	expectedMembers []etcd.Member,
+	state string,
+	token string,
+	peerTLS PeerTLS,
+) string {
+	initCluster := []string{}
+	for _, member := range expectedMembers {
+		// A member with several peer URLs is listed once per URL
+		for _, peerURL := range member.AllPeerURLs() {
+			initCluster = append(initCluster, fmt.Sprint(
+				member.Name,
+				"=",
+				peerURL,
+			))
+		}
+	}
+	env := fmt.Sprintf("ETCD_INITIAL_CLUSTER=%s\n", strings.Join(initCluster, ","))
+	return env + renderCommon(state, token, peerTLS)
+}
+
+// DiscoverySRV has etcd find the members in the DNS SRV records of Domain,
+// those with the Name suffix if set, rather than in a list
+type DiscoverySRV struct {
+	Domain string
+	Name   string
+}
+
+// RenderSRVDropIn is RenderDropIn with DNS SRV discovery, the content
+// doesn't change with the members
+func RenderSRVDropIn(srv DiscoverySRV, state string, token string, peerTLS PeerTLS) string {
+	env := fmt.Sprintf("ETCD_DISCOVERY_SRV=%s\n", srv.Domain)
+	if srv.Name != "" {
+		env += fmt.Sprintf("ETCD_DISCOVERY_SRV_NAME=%s\n", srv.Name)
+	}
+	return env + renderCommon(state, token, peerTLS)
+}
+
+func renderCommon(state string, token string, peerTLS PeerTLS) string {
+	env := fmt.Sprintf("ETCD_INITIAL_CLUSTER_STATE=%s\n", state)
+	if token != "" {
+		env += fmt.Sprintf("ETCD_INITIAL_CLUSTER_TOKEN=%s\n", token)
+	}
+	if peerTLS.CAFile != "" {
+		env += fmt.Sprintf("ETCD_PEER_TRUSTED_CA_FILE=%s\n", peerTLS.CAFile)
+		env += "ETCD_PEER_CLIENT_CERT_AUTH=true\n"
+	}
+	if peerTLS.CertFile != "" {
+		env += fmt.Sprintf("ETCD_PEER_CERT_FILE=%s\n", peerTLS.CertFile)
+	}
+	if peerTLS.KeyFile != "" {
+		env += fmt.Sprintf("ETCD_PEER_KEY_FILE=%s\n", peerTLS.KeyFile)
+	}
+	return env
+}
+
+func WriteDropIn(
+	file string,
+	expectedMembers []etcd.Member,
+	state string,
+	token string,
+	peerTLS PeerTLS,
+) error {
+	return DropInFile(file).Write(RenderDropIn(expectedMembers, state, token, peerTLS))
+}
+
+// DropInFile is the path of the systemd drop-in etcdmate generates
+type DropInFile string
+
+func (f DropInFile) Path() string {
+	return string(f)
+}
+
+// Read returns the current content, empty if the file doesn't exist yet
+func (f DropInFile) Read() (string, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// Write writes content unless the file already has it, so watchers of the
+// file and restarts only follow actual changes. The content goes to a
+// temporary file renamed over the file, readers never see a partial one.
+func (f DropInFile) Write(content string) error {
+	current, err := f.Read()
+	if err != nil || current == content {
+		return err
+	}
+	dir := path.Dir(string(f))
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "."+path.Base(string(f))+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = fmt.Fprint(file, content)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), string(f))
+}
@@ -0,0 +1,65 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// RenderEndpoints sets ETCDCTL_ENDPOINTS to the client URLs of the members
+// and of the observers, for clients reading the file as environment
+func RenderEndpoints(members []etcd.Member, observers []etcd.Member) string {
+	urls := []string{}
+	for _, m := range append(append([]etcd.Member{}, members...), observers...) {
+		urls = append(urls, m.ClientURL)
+	}
+	return fmt.Sprintf("ETCDCTL_ENDPOINTS=%s\n", strings.Join(urls, ","))
+}
+
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// RenderTargets lists the client addresses of the members and of the
+// observers as Prometheus file based service discovery targets, labelled
+// with their name and role
+func RenderTargets(members []etcd.Member, observers []etcd.Member) (string, error) {
+	groups := []targetGroup{}
+	for _, role := range []struct {
+		name    string
+		members []etcd.Member
+	}{
+		{"member", members},
+		{"observer", observers},
+	} {
+		for _, m := range role.members {
+			u, err := url.Parse(m.ClientURL)
+			if err != nil {
+				return "", err
+			}
+			groups = append(groups, targetGroup{
+				Targets: []string{u.Host},
+				Labels: map[string]string{
+					"member":     m.Name,
+					"role":       role.name,
+					"__scheme__": u.Scheme,
+				},
+			})
+		}
+	}
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// WriteIfChanged writes content to file unless it already has it, so
+// watchers of the file only see actual changes
+func WriteIfChanged(file string, content string) error {
+	return DropInFile(file).Write(content)
+}
@@ -0,0 +1,247 @@
+// Package output renders the etcd configuration of the local member and
+// writes it where etcd reads it: an env file, a systemd drop-in, an etcd YAML
+// file or the API of an immutable OS. The Render functions only return the
+// configuration of the members they are given, the Write ones and the
+// systemd helpers act on the host, so embedders can render without writing.
+package output
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Local is the local member, for configurations etcd reads all of its
+// settings from
+type Local struct {
+	Member  etcd.Member
+	DataDir string
+}
+
+// RenderLocal renders the name, data dir and URLs of the local member,
+// listening on every address at the ports of its URLs
+func RenderLocal(l Local) string {
+	env := fmt.Sprintf("ETCD_NAME=%s\n", l.Member.Name)
+	if l.DataDir != "" {
+		env += fmt.Sprintf("ETCD_DATA_DIR=%s\n", l.DataDir)
+	}
+	env += fmt.Sprintf("ETCD_INITIAL_ADVERTISE_PEER_URLS=%s\n", strings.Join(l.Member.AllPeerURLs(), ","))
+	env += fmt.Sprintf("ETCD_ADVERTISE_CLIENT_URLS=%s\n", strings.Join(l.Member.AllClientURLs(), ","))
+	env += fmt.Sprintf("ETCD_LISTEN_PEER_URLS=%s\n", listenURLs(l.Member.AllPeerURLs()))
+	env += fmt.Sprintf("ETCD_LISTEN_CLIENT_URLS=%s\n", listenURLs(l.Member.AllClientURLs()))
+	return env
+}
+
+// listenURLs are the URLs us on every address, once per schema and port.
+// etcd only binds to IPs, [::] also takes IPv4 connections on Linux.
+func listenURLs(us []string) string {
+	listen := []string{}
+	seen := map[string]bool{}
+	ipv6 := false
+	for _, u := range us {
+		if parsed, err := url.Parse(u); err == nil && strings.Contains(parsed.Hostname(), ":") {
+			ipv6 = true
+		}
+	}
+	for _, u := range us {
+		l := listenURL(u, ipv6)
+		if !seen[l] {
+			seen[l] = true
+			listen = append(listen, l)
+		}
+	}
+	return strings.Join(listen, ",")
+}
+
+// listenURL is u on every address
+func listenURL(u string, ipv6 bool) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Port() == "" {
+		return u
+	}
+	if ipv6 {
+		return fmt.Sprint(parsed.Scheme, "://[::]:", parsed.Port())
+	}
+	return fmt.Sprint(parsed.Scheme, "://0.0.0.0:", parsed.Port())
+}
+
+// SystemdFile is a systemd drop-in setting the variables as Environment=
+// lines, for units without an EnvironmentFile=
+type SystemdFile string
+
+func (f SystemdFile) Path() string {
+	return string(f)
+}
+
+// Read returns the variables of the drop-in, empty if it doesn't exist yet
+func (f SystemdFile) Read() (string, error) {
+	data, err := DropInFile(f).Read()
+	if err != nil || data == "" {
+		return "", err
+	}
+	unquote := strings.NewReplacer(`\\`, `\`, `\"`, `"`, "%%", "%")
+	env := ""
+	for _, line := range strings.Split(data, "\n") {
+		if !strings.HasPrefix(line, `Environment="`) || !strings.HasSuffix(line, `"`) {
+			continue
+		}
+		env += unquote.Replace(line[len(`Environment="`):len(line)-1]) + "\n"
+	}
+	return env, nil
+}
+
+func (f SystemdFile) Write(content string) error {
+	return DropInFile(f).Write(SystemdDropIn{}.Render(content))
+}
+
+// etcdYAMLHeader separates the base of an etcd configuration file from what
+// etcdmate writes
+const etcdYAMLHeader = "# Written by etcdmate\n"
+
+// peerSecurity are the variables etcd reads from peer-transport-security
+// in a configuration file, by key
+var peerSecurity = map[string]string{
+	"ETCD_PEER_TRUSTED_CA_FILE":  "trusted-ca-file",
+	"ETCD_PEER_CLIENT_CERT_AUTH": "client-cert-auth",
+	"ETCD_PEER_CERT_FILE":        "cert-file",
+	"ETCD_PEER_KEY_FILE":         "key-file",
+}
+
+// EtcdYAML is the configuration file etcd reads with --config-file, which
+// makes it ignore its flags and variables. The variables become keys,
+// ETCD_INITIAL_CLUSTER as initial-cluster, after the content of Base if
+// set, for the settings etcdmate doesn't know such as the client
+// certificates.
+type EtcdYAML struct {
+	File string
+	// Base is a YAML file whose content starts the configuration, it must
+	// not set the keys etcdmate writes
+	Base string
+}
+
+func (y EtcdYAML) Path() string {
+	return y.File
+}
+
+func (y EtcdYAML) base() (string, error) {
+	if y.Base == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(y.Base)
+	if err != nil {
+		return "", err
+	}
+	base := string(data)
+	if base != "" && !strings.HasSuffix(base, "\n") {
+		base += "\n"
+	}
+	return base, nil
+}
+
+// Read returns the variables of the file, empty if it doesn't exist yet or
+// starts with another base than Base, so a change of Base is written
+func (y EtcdYAML) Read() (string, error) {
+	data, err := DropInFile(y.File).Read()
+	if err != nil || data == "" {
+		return "", err
+	}
+	base, err := y.base()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(data, base+etcdYAMLHeader) {
+		return "", nil
+	}
+	env := ""
+	peer := false
+	for _, line := range strings.Split(strings.TrimPrefix(data, base+etcdYAMLHeader), "\n") {
+		if line == "peer-transport-security:" {
+			peer = true
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		variable := "ETCD_" + strings.ToUpper(strings.ReplaceAll(parts[0], "-", "_"))
+		if peer && strings.HasPrefix(line, "  ") {
+			variable = strings.Replace(variable, "ETCD_", "ETCD_PEER_", 1)
+		} else {
+			peer = false
+		}
+		value := parts[1]
+		if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+		env += fmt.Sprint(variable, "=", value, "\n")
+	}
+	return env, nil
+}
+
+// Write renders the variables of content in order, the peer ones where the
+// first of them is
+func (y EtcdYAML) Write(content string) error {
+	base, err := y.base()
+	if err != nil {
+		return err
+	}
+	yaml := base + etcdYAMLHeader
+	peer := ""
+	peerAt := -1
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if key, ok := peerSecurity[parts[0]]; ok {
+			if peerAt < 0 {
+				peerAt = len(yaml)
+			}
+			peer += fmt.Sprint("  ", key, ": ", yamlValue(parts[1]), "\n")
+			continue
+		}
+		key := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(parts[0], "ETCD_")), "_", "-")
+		yaml += fmt.Sprint(key, ": ", yamlValue(parts[1]), "\n")
+	}
+	if peerAt >= 0 {
+		yaml = yaml[:peerAt] + "peer-transport-security:\n" + peer + yaml[peerAt:]
+	}
+	return DropInFile(y.File).Write(yaml)
+}
+
+// yamlValue quotes value unless it's a boolean
+func yamlValue(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Stdout prints the configuration rather than writing it, e.g. for the
+// entrypoint of a container to source. Nothing is ever read back.
+type Stdout struct {
+	// W defaults to os.Stdout
+	W io.Writer
+}
+
+func (s Stdout) Path() string {
+	return "stdout"
+}
+
+func (s Stdout) Read() (string, error) {
+	return "", nil
+}
+
+func (s Stdout) Write(content string) error {
+	w := s.W
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err := fmt.Fprint(w, content)
+	return err
+}
@@ -0,0 +1,78 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IgnitionVersion is the Ignition spec of RenderIgnition, the one Flatcar
+// Container Linux reads
+const IgnitionVersion = "3.3.0"
+
+// SystemdDropIn is a drop-in of Unit setting the variables of an env file
+// as Environment= lines, so Ignition can provision it
+type SystemdDropIn struct {
+	Unit string
+	Name string
+}
+
+// Render turns the VAR=value lines of env into the drop-in content
+func (d SystemdDropIn) Render(env string) string {
+	content := "[Service]\n"
+	for _, line := range strings.Split(strings.TrimSpace(env), "\n") {
+		if line == "" {
+			continue
+		}
+		// Quoted for systemd, where % starts a specifier
+		line = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(line)
+		content += fmt.Sprintf("Environment=\"%s\"\n", line)
+	}
+	return content
+}
+
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Systemd struct {
+		Units []ignitionUnit `json:"units"`
+	} `json:"systemd"`
+}
+
+type ignitionUnit struct {
+	Name    string           `json:"name"`
+	Dropins []ignitionDropIn `json:"dropins"`
+}
+
+type ignitionDropIn struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+// RenderIgnition renders the drop-in of env as an Ignition config
+func (d SystemdDropIn) RenderIgnition(env string) (string, error) {
+	var config ignitionConfig
+	config.Ignition.Version = IgnitionVersion
+	config.Systemd.Units = []ignitionUnit{{
+		Name:    d.Unit,
+		Dropins: []ignitionDropIn{{Name: d.Name, Contents: d.Render(env)}},
+	}}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// RenderButane renders the drop-in of env as a Butane config of the
+// flatcar variant, which transpiles to RenderIgnition
+func (d SystemdDropIn) RenderButane(env string) string {
+	b := "variant: flatcar\nversion: 1.0.0\nsystemd:\n  units:\n"
+	b += fmt.Sprintf("    - name: %s\n      dropins:\n", d.Unit)
+	b += fmt.Sprintf("        - name: %s\n          contents: |\n", d.Name)
+	for _, line := range strings.Split(strings.TrimSuffix(d.Render(env), "\n"), "\n") {
+		b += "            " + line + "\n"
+	}
+	return b
+}
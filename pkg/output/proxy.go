@@ -0,0 +1,44 @@
+package output
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Proxy is the etcd gateway or gRPC proxy of an instance of a proxy tier,
+// which gets the client URLs of the core members as its endpoints. The
+// variables aren't prefixed with ETCD_ so etcd doesn't take them for its
+// own flags.
+type Proxy struct {
+	// Mode is gateway, a TCP proxy, or grpc-proxy
+	Mode       string
+	ListenAddr string
+}
+
+// RenderProxy sets ETCDMATE_PROXY_ENDPOINTS to the endpoints of members
+// and ETCDMATE_PROXY_ARGS to the arguments of etcd running the proxy, for
+// a unit running ExecStart=/usr/local/bin/etcd $ETCDMATE_PROXY_ARGS. The
+// gateway gets host:port endpoints, the gRPC proxy the client URLs.
+func RenderProxy(p Proxy, members []etcd.Member) (string, error) {
+	endpoints := []string{}
+	for _, m := range members {
+		endpoint := m.ClientURL
+		if p.Mode == "gateway" {
+			u, err := url.Parse(m.ClientURL)
+			if err != nil {
+				return "", err
+			}
+			endpoint = u.Host
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	list := strings.Join(endpoints, ",")
+	args := fmt.Sprint(p.Mode, " start --endpoints=", list)
+	if p.ListenAddr != "" {
+		args += " --listen-addr=" + p.ListenAddr
+	}
+	return fmt.Sprintf("ETCDMATE_PROXY_ENDPOINTS=%s\nETCDMATE_PROXY_ARGS=%s\n", list, args), nil
+}
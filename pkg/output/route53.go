@@ -0,0 +1,184 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Route53API is the subset of the Route53 API etcdmate uses
+type Route53API interface {
+	GetHostedZoneWithContext(aws.Context, *route53.GetHostedZoneInput, ...request.Option) (*route53.GetHostedZoneOutput, error)
+	ListResourceRecordSetsPagesWithContext(aws.Context, *route53.ListResourceRecordSetsInput, func(*route53.ListResourceRecordSetsOutput, bool) bool, ...request.Option) error
+	ChangeResourceRecordSetsWithContext(aws.Context, *route53.ChangeResourceRecordSetsInput, ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error)
+}
+
+// DefaultRecordTTL is the TTL of the records, in seconds
+const DefaultRecordTTL = 60
+
+// Route53 keeps an A record NAME.Domain per member in a hosted zone. Each
+// comes with a TXT record naming its Owner and instance, so records etcdmate
+// didn't create, or created for another cluster, are never changed.
+type Route53 struct {
+	API    Route53API
+	ZoneID string
+	// Domain defaults to the name of the zone, see ZoneName
+	Domain string
+	Owner  string
+	// TTL defaults to DefaultRecordTTL
+	TTL int64
+}
+
+// Record is the A record of a member
+type Record struct {
+	// Name is the member name, the first label of the record
+	Name    string
+	Address string
+	// Owner and Instance are those of the TXT record, empty without one
+	Owner    string
+	Instance string
+
+	sets []*route53.ResourceRecordSet
+}
+
+// ZoneName returns the name of the zone, without the final dot
+func (r Route53) ZoneName(ctx context.Context) (string, error) {
+	resp, err := r.API.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(r.ZoneID)})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(aws.StringValue(resp.HostedZone.Name), "."), nil
+}
+
+func (r Route53) fqdn(name string) string {
+	return strings.ToLower(name) + "." + r.Domain + "."
+}
+
+func (r Route53) ownership(instance string) string {
+	return fmt.Sprintf(`"heritage=etcdmate,owner=%s,instance=%s"`, r.Owner, instance)
+}
+
+// Records returns the A records right under Domain, by name
+func (r Route53) Records(ctx context.Context) ([]Record, error) {
+	byName := map[string]*Record{}
+	suffix := "." + strings.ToLower(r.Domain) + "."
+	err := r.API.ListResourceRecordSetsPagesWithContext(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(r.ZoneID),
+		StartRecordName: aws.String(r.Domain),
+	}, func(page *route53.ListResourceRecordSetsOutput, last bool) bool {
+		for _, set := range page.ResourceRecordSets {
+			name := strings.ToLower(aws.StringValue(set.Name))
+			if !strings.HasSuffix(name, suffix) || strings.Contains(strings.TrimSuffix(name, suffix), ".") {
+				continue
+			}
+			name = strings.TrimSuffix(name, suffix)
+			record, ok := byName[name]
+			if !ok {
+				record = &Record{Name: name}
+				byName[name] = record
+			}
+			switch aws.StringValue(set.Type) {
+			case route53.RRTypeA:
+				if len(set.ResourceRecords) > 0 {
+					record.Address = aws.StringValue(set.ResourceRecords[0].Value)
+				}
+			case route53.RRTypeTxt:
+				for _, value := range set.ResourceRecords {
+					owner, instance, ok := parseOwnership(aws.StringValue(value.Value))
+					if ok {
+						record.Owner, record.Instance = owner, instance
+					}
+				}
+			default:
+				continue
+			}
+			record.sets = append(record.sets, set)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	for _, record := range byName {
+		if record.Address != "" || record.Owner != "" {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// parseOwnership reads the TXT record value of ownership
+func parseOwnership(value string) (owner string, instance string, ok bool) {
+	value = strings.Trim(value, `"`)
+	if !strings.HasPrefix(value, "heritage=etcdmate,") {
+		return "", "", false
+	}
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "owner":
+			owner = parts[1]
+		case "instance":
+			instance = parts[1]
+		}
+	}
+	return owner, instance, true
+}
+
+// Change upserts the records of upsert with their TXT record, and deletes
+// the records of remove as they were listed by Records, in one batch
+func (r Route53) Change(ctx context.Context, upsert []Record, remove []Record) error {
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = DefaultRecordTTL
+	}
+	changes := []*route53.Change{}
+	for _, record := range upsert {
+		changes = append(changes, &route53.Change{
+			Action: aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(r.fqdn(record.Name)),
+				Type:            aws.String(route53.RRTypeA),
+				TTL:             aws.Int64(ttl),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(record.Address)}},
+			},
+		}, &route53.Change{
+			Action: aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(r.fqdn(record.Name)),
+				Type:            aws.String(route53.RRTypeTxt),
+				TTL:             aws.Int64(ttl),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(r.ownership(record.Instance))}},
+			},
+		})
+	}
+	for _, record := range remove {
+		for _, set := range record.sets {
+			changes = append(changes, &route53.Change{
+				Action:            aws.String(route53.ChangeActionDelete),
+				ResourceRecordSet: set,
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err := r.API.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.ZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("etcdmate " + r.Owner),
+			Changes: changes,
+		},
+	})
+	return err
+}
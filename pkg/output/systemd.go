@@ -0,0 +1,64 @@
+package output
+
+import (
+	"os/exec"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// ReloadSystemd has systemd read the unit files and drop-ins again, for
+// the changed configuration to apply at the next start of etcd
+func ReloadSystemd(logger logging.Logger) error {
+	log := logging.OrDefault(logger)
+	log.Println("Reloading systemd")
+	out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput()
+	if err != nil {
+		log.Println(string(out))
+	}
+	return err
+}
+
+func RestartUnit(unit string, logger logging.Logger) error {
+	log := logging.OrDefault(logger)
+	log.Println("Reloading systemd and restarting", unit)
+	out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput()
+	if err != nil {
+		log.Println(string(out))
+		return err
+	}
+	out, err = exec.Command("systemctl", "restart", unit).CombinedOutput()
+	if err != nil {
+		log.Println(string(out))
+		return err
+	}
+	return nil
+}
+
+func StopUnit(unit string, logger logging.Logger) error {
+	log := logging.OrDefault(logger)
+	log.Println("Stopping", unit)
+	out, err := exec.Command("systemctl", "stop", unit).CombinedOutput()
+	if err != nil {
+		log.Println(string(out))
+		return err
+	}
+	return nil
+}
+
+// SwitchUnit stops and disables from, then enables and starts to
+func SwitchUnit(from string, to string, logger logging.Logger) error {
+	log := logging.OrDefault(logger)
+	log.Println("Switching from", from, "to", to)
+	for _, args := range [][]string{
+		{"daemon-reload"},
+		{"disable", "--now", from},
+		{"enable", "--now", to},
+	} {
+		out, err := exec.Command("systemctl", args...).CombinedOutput()
+		if err != nil {
+			log.Println(string(out))
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// talosManaged are the variables Talos sets itself, it provisions the peer
+// certificates of etcd
+var talosManaged = map[string]bool{
+	"ETCD_PEER_TRUSTED_CA_FILE":  true,
+	"ETCD_PEER_CLIENT_CERT_AUTH": true,
+	"ETCD_PEER_CERT_FILE":        true,
+	"ETCD_PEER_KEY_FILE":         true,
+}
+
+// talosArgs are the extra arguments etcdmate owns, the others of the
+// machine configuration are left alone
+var talosArgs = []string{
+	"initial-cluster",
+	"initial-cluster-state",
+	"initial-cluster-token",
+	"discovery-srv",
+	"discovery-srv-name",
+	"force-new-cluster",
+}
+
+// Talos sets the configuration as the extra arguments of the etcd Talos
+// runs, cluster.etcd.extraArgs of the machine configuration, patched with
+// talosctl as Talos has no writable configuration files. The variables
+// become arguments, ETCD_INITIAL_CLUSTER as initial-cluster.
+type Talos struct {
+	// Talosctl is the talosctl binary, found in PATH by default
+	Talosctl string
+	// Node and Talosconfig, when set, are passed to talosctl
+	Node        string
+	Talosconfig string
+}
+
+func (t Talos) Path() string {
+	if t.Node != "" {
+		return "talos:" + t.Node + ":cluster.etcd.extraArgs"
+	}
+	return "talos:cluster.etcd.extraArgs"
+}
+
+type talosEtcdConfig struct {
+	Spec struct {
+		ExtraArgs map[string]string `json:"extraArgs"`
+	} `json:"spec"`
+}
+
+// Read returns the arguments etcdmate owns as variables, sorted by name
+func (t Talos) Read() (string, error) {
+	_, args, err := t.extraArgs()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{}
+	for _, arg := range talosArgs {
+		if value, ok := args[arg]; ok {
+			lines = append(lines, fmt.Sprint(talosVariable(arg), "=", value))
+		}
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// Write patches the arguments of content into the machine configuration,
+// without rebooting, and removes those content no longer has
+func (t Talos) Write(content string) error {
+	found, current, err := t.extraArgs()
+	if err != nil {
+		return err
+	}
+	args := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || talosManaged[parts[0]] {
+			continue
+		}
+		arg := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(parts[0], "ETCD_")), "_", "-")
+		if talosVariable(arg) != parts[0] || !ownedArg(arg) {
+			return errors.New(fmt.Sprint("Talos output can't set ", parts[0]))
+		}
+		args[arg] = parts[1]
+	}
+	patch := []map[string]interface{}{}
+	if !found {
+		patch = append(patch, map[string]interface{}{"op": "add", "path": "/cluster/etcd/extraArgs", "value": args})
+	} else {
+		for _, arg := range talosArgs {
+			if value, ok := args[arg]; ok {
+				patch = append(patch, map[string]interface{}{"op": "add", "path": "/cluster/etcd/extraArgs/" + arg, "value": value})
+			} else if _, ok := current[arg]; ok {
+				patch = append(patch, map[string]interface{}{"op": "remove", "path": "/cluster/etcd/extraArgs/" + arg})
+			}
+		}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = t.run("patch", "machineconfig", "--mode", "no-reboot", "--patch", string(data))
+	return err
+}
+
+// extraArgs returns the extra arguments of etcd, found is false when the
+// machine configuration has none
+func (t Talos) extraArgs() (bool, map[string]string, error) {
+	out, err := t.run("get", "etcdconfig", "--output", "json")
+	if err != nil {
+		return false, nil, err
+	}
+	var config talosEtcdConfig
+	if err := json.Unmarshal(out, &config); err != nil {
+		return false, nil, errors.New(fmt.Sprint("Unreadable etcd configuration of Talos: ", err))
+	}
+	return config.Spec.ExtraArgs != nil, config.Spec.ExtraArgs, nil
+}
+
+func (t Talos) run(args ...string) ([]byte, error) {
+	if t.Node != "" {
+		args = append(args, "--nodes", t.Node)
+	}
+	if t.Talosconfig != "" {
+		args = append(args, "--talosconfig", t.Talosconfig)
+	}
+	talosctl := t.Talosctl
+	if talosctl == "" {
+		talosctl = "talosctl"
+	}
+	out, err := exec.Command(talosctl, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.New(fmt.Sprint("talosctl ", args[0], " failed: ", strings.TrimSpace(string(exitErr.Stderr))))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// talosVariable is the variable of the argument arg
+func talosVariable(arg string) string {
+	return "ETCD_" + strings.ToUpper(strings.ReplaceAll(arg, "-", "_"))
+}
+
+func ownedArg(arg string) bool {
+	for _, owned := range talosArgs {
+		if owned == arg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// TemplateData is what a configuration template is executed with
+type TemplateData struct {
+	// Members are the expected members, Myself the local one among them
+	Members []etcd.Member
+	Myself  etcd.Member
+	// State is new or existing
+	State        string
+	ClusterToken string
+	// InitialCluster is the NAME=PEER_URL list of Members
+	InitialCluster string
+	// DiscoverySRV is set when etcd finds the members in DNS
+	DiscoverySRV    DiscoverySRV
+	PeerTLS         PeerTLS
+	DataDir         string
+	ForceNewCluster bool
+	// Env is the configuration written without a template
+	Env string
+}
+
+// templateFuncs are the functions templates can use besides the builtin ones
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// ParseTemplate reads a text/template file, whose missing keys are errors
+func ParseTemplate(file string) (*template.Template, error) {
+	t, err := template.New(path.Base(file)).Funcs(templateFuncs).Option("missingkey=error").ParseFiles(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("Invalid template ", file, ": ", err))
+	}
+	return t, nil
+}
+
+// NewTemplateData returns the data of the members, InitialCluster included
+func NewTemplateData(members []etcd.Member, myself etcd.Member, state string, token string) TemplateData {
+	initCluster := []string{}
+	for _, member := range members {
+		for _, peerURL := range member.AllPeerURLs() {
+			initCluster = append(initCluster, fmt.Sprint(member.Name, "=", peerURL))
+		}
+	}
+	return TemplateData{
+		Members:        members,
+		Myself:         myself,
+		State:          state,
+		ClusterToken:   token,
+		InitialCluster: strings.Join(initCluster, ","),
+	}
+}
+
+// RenderTemplate executes t with data
+func RenderTemplate(t *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.New(fmt.Sprint("Rendering the template failed: ", err))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,94 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/lock"
+)
+
+// ErrAddLocked is returned when another instance kept AddLock for longer
+// than AddLockWait
+var ErrAddLocked = errors.New("Another instance is adding its member")
+
+// lockAdd takes AddLock before the local member is added, so instances
+// launched together don't add members side by side, which etcd refuses
+// while an added member hasn't started. The run adding a member exits
+// before etcd starts, so the lock isn't released: the next instance takes
+// it over once that member started, or after AddLockTTL if it never does.
+// It returns nil without AddLock.
+func (cfg Config) lockAdd(ctx context.Context, hm etcd.Member, myself etcd.Member) (*lock.Entry, error) {
+	if cfg.AddLock == nil {
+		return nil, nil
+	}
+	deadline := cfg.now().Add(cfg.AddLockWait)
+	for {
+		current, err := cfg.AddLock.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		busy, err := cfg.addLockBusy(ctx, hm, current)
+		if err != nil {
+			return nil, err
+		}
+		if busy == "" {
+			entry := lock.Entry{Holder: cfg.InstanceID, PeerURL: myself.PeerURL, Expires: cfg.now().Add(cfg.AddLockTTL)}
+			err := cfg.AddLock.Swap(ctx, current, entry)
+			if err == nil {
+				return &entry, nil
+			}
+			if !errors.Is(err, lock.ErrConflict) {
+				return nil, err
+			}
+			// Another instance took it first
+			continue
+		}
+		if !cfg.now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrAddLocked, busy)
+		}
+		cfg.log().Println("Waiting for the addition lock,", busy)
+		cfg.explain("Waiting to add the local member, %s", busy)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addLockBusy returns why the lease e keeps the lock, empty when it can be
+// taken
+func (cfg Config) addLockBusy(ctx context.Context, hm etcd.Member, e *lock.Entry) (string, error) {
+	if e == nil || e.Holder == cfg.InstanceID || e.Expired(cfg.now()) {
+		return "", nil
+	}
+	members, err := cfg.Client.ListMembers(ctx, hm)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range members {
+		if m.PeerURL != e.PeerURL {
+			continue
+		}
+		// Only started members have a name
+		if m.Name != "" {
+			return "", nil
+		}
+		return fmt.Sprint(e.Holder, " added ", e.PeerURL, " which hasn't started yet"), nil
+	}
+	return fmt.Sprint(e.Holder, " is adding ", e.PeerURL), nil
+}
+
+// unlockAdd releases the lease e when the local member wasn't added after
+// all, errors are only logged as the lease expires anyway
+func (cfg Config) unlockAdd(ctx context.Context, e lock.Entry) {
+	current, err := cfg.AddLock.Get(ctx)
+	if err == nil && current != nil && current.Holder == e.Holder && current.PeerURL == e.PeerURL {
+		e.Expires = cfg.now()
+		err = cfg.AddLock.Swap(ctx, current, e)
+	}
+	if err != nil {
+		cfg.log().Println("Releasing the addition lock:", err)
+	}
+}
@@ -0,0 +1,173 @@
+package reconcile
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+const clusterTokenTag = "etcdmate:cluster-token"
+
+type BootstrapOptions struct {
+	Size int
+	Wait time.Duration
+}
+
+// Bootstrap creates a brand new cluster. It waits for all expected
+// instances, lets the instance with the lowest ID generate the cluster
+// token and publish it as an Autoscaling group tag, and writes the same
+// "new" configuration on every node.
+func Bootstrap(ctx context.Context, cfg Config, opts BootstrapOptions) error {
+	insId := cfg.InstanceID
+	asg := cfg.AWS.AutoScaling
+	asgName, err := cfg.AWS.GetAsg(ctx, insId)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(opts.Wait)
+	var expectedMembers []etcd.Member
+	for {
+		group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+		if err != nil {
+			return err
+		}
+		want := opts.Size
+		if want == 0 {
+			want = int(aws.Int64Value(group.DesiredCapacity))
+		}
+		expectedMembers, err = cfg.ExpectedMembers(ctx)
+		if err != nil {
+			return err
+		}
+		if len(expectedMembers) >= want && len(expectedMembers) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf(
+				"Timed out waiting for %d instances, found %d",
+				want,
+				len(expectedMembers),
+			))
+		}
+		cfg.log().Printf("Waiting for %d instances, found %d\n", want, len(expectedMembers))
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	if healthyMember, err := cfg.Client.FindHealthyMember(ctx, expectedMembers); err == nil {
+		return errors.New(fmt.Sprint(
+			"Cluster already running, found healthy member ", healthyMember.Name,
+		))
+	}
+	names := []string{}
+	for _, m := range expectedMembers {
+		names = append(names, instanceOf(m))
+	}
+	sort.Strings(names)
+	coordinator := names[0]
+	cfg.log().Println("Bootstrap coordinator is", coordinator)
+	cfg.explain(
+		"New cluster, no expected member is healthy; %s has the lowest instance id and coordinates the bootstrap",
+		coordinator,
+	)
+
+	token, err := getClusterToken(ctx, cfg.AWS, asgName)
+	if err != nil {
+		return err
+	}
+	if token == "" && coordinator == insId {
+		token = cfg.ClusterToken
+		if token == "" {
+			token, err = newClusterToken()
+			if err != nil {
+				return err
+			}
+		}
+		cfg.log().Println("Publishing cluster token", token)
+		_, err = asg.CreateOrUpdateTagsWithContext(ctx, &autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{{
+				ResourceId:        &asgName,
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(clusterTokenTag),
+				Value:             &token,
+				PropagateAtLaunch: aws.Bool(false),
+			}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for token == "" {
+		if time.Now().After(deadline) {
+			return errors.New("Timed out waiting for the coordinator to publish the cluster token")
+		}
+		cfg.log().Println("Waiting for coordinator", coordinator, "to publish the cluster token")
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+		token, err = getClusterToken(ctx, cfg.AWS, asgName)
+		if err != nil {
+			return err
+		}
+	}
+	cfg.log().Println("Using cluster token", token)
+	cfg.emit(Event{
+		Type:    EventBootstrapDecision,
+		Message: fmt.Sprint("Bootstrapping new cluster coordinated by ", coordinator),
+	})
+	myself, err := GetMyself(expectedMembers, insId)
+	if err != nil {
+		return err
+	}
+	state := State{
+		InstanceID:      insId,
+		Step:            StepDone,
+		ClusterState:    "new",
+		ClusterToken:    token,
+		ExpectedMembers: expectedMembers,
+		Myself:          myself,
+	}
+	content, err := cfg.render(expectedMembers, myself, state.ClusterState, token, false)
+	if err != nil {
+		return err
+	}
+	err = cfg.output().Write(content)
+	if err != nil {
+		return err
+	}
+	return cfg.SaveState(ctx, state)
+}
+
+func getClusterToken(ctx context.Context, svc discovery.AWS, asgName string) (string, error) {
+	token, _, err := svc.GetAsgTag(ctx, asgName, clusterTokenTag)
+	return token, err
+}
+
+func newClusterToken() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint("etcdmate-", hex.EncodeToString(b)), nil
+}
+
+// DeriveClusterToken is the cluster token of the group asgName of the
+// account in region. Clusters of different groups get different tokens,
+// so a member can never join the other cluster by mistake, while every
+// instance of a group derives the same one.
+func DeriveClusterToken(account string, region string, asgName string) string {
+	sum := sha256.Sum256([]byte(account + "/" + region + "/" + asgName))
+	return fmt.Sprint("etcdmate-", hex.EncodeToString(sum[:8]))
+}
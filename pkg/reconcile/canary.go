@@ -0,0 +1,252 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// CanaryDir holds a key per learner soaking as a canary, named after it, so
+// the Scaler leaves their promotion to them
+const CanaryDir = "/etcdmate/canary"
+
+// Canary soaks a member that joined as a learner, see Config.LearnerJoin,
+// before it votes: once it caught up with the cluster, its replication lag,
+// disk sync latency and peer round trips must stay within bounds for Soak.
+// It is then promoted, or removed from the cluster when a check fails.
+type Canary struct {
+	// Soak is how long the checks must keep passing, and how long the
+	// learner has to catch up first
+	Soak time.Duration
+	// Interval is the time between two rounds of checks in Run
+	Interval time.Duration
+	// MaxLag is how many revisions the learner may be behind
+	MaxLag int64
+	// MaxFsync is the longest a synced write to Config.DataDir may take,
+	// 0 or without DataDir it isn't checked
+	MaxFsync time.Duration
+	// MaxPeerRTT is the longest connecting to the peer port of another
+	// member may take, 0 doesn't check
+	MaxPeerRTT time.Duration
+
+	started  time.Time
+	caughtUp time.Time
+	attempts int
+	// passed is set once the soak passed but the learner was kept, see
+	// holdLearner
+	passed bool
+}
+
+// Check runs a round of checks while the local member is a started
+// learner, it is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (k *Canary) Check(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	if !c.IsHealthy(ctx, myself) {
+		return nil
+	}
+	learners, err := c.ListLearners(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !HasMember(learners, myself) {
+		k.started, k.caughtUp, k.attempts, k.passed = time.Time{}, time.Time{}, 0, false
+		return nil
+	}
+	if k.passed {
+		return nil
+	}
+	_, err = k.round(ctx, cfg, expectedMembers, myself)
+	return err
+}
+
+// Run soaks the local learner myself until it is promoted, or removed and
+// failing with ErrCanaryFailed
+func (k *Canary) Run(ctx context.Context, cfg Config, expectedMembers []etcd.Member, myself etcd.Member) error {
+	for {
+		promoted, err := k.round(ctx, cfg, expectedMembers, myself)
+		if errors.Is(err, ErrPaused) {
+			cfg.log().Println("Canary", myself.Name, "waiting:", err)
+		} else if err != nil || promoted {
+			return err
+		}
+		if err := sleep(ctx, k.Interval); err != nil {
+			return err
+		}
+	}
+}
+
+// round moves the soak one step further and reports whether the learner
+// got promoted
+func (k *Canary) round(ctx context.Context, cfg Config, expectedMembers []etcd.Member, myself etcd.Member) (bool, error) {
+	c := cfg.Client
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return false, err
+	}
+	myself.ID = status.MemberID
+	peers := withoutMember(expectedMembers, myself)
+	healthyMember, err := c.FindHealthyMember(ctx, peers)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.checkPaused(ctx, healthyMember); err != nil {
+		return false, err
+	}
+	key := path.Join(CanaryDir, myself.Name)
+	if k.started.IsZero() {
+		// The key outlives the soak only if etcdmate stops meanwhile, the
+		// Scaler then promotes the learner as any other
+		err = c.SetKey(ctx, healthyMember, key, cfg.InstanceID, 3*k.Soak)
+		if err != nil {
+			return false, err
+		}
+		cfg.log().Println("Canary", myself.Name, "catching up")
+		k.started = time.Now()
+	}
+	if k.caughtUp.IsZero() {
+		lag, err := k.lag(ctx, cfg, healthyMember, myself)
+		if err == nil {
+			cfg.log().Println("Canary", myself.Name, "caught up, soaking for", k.Soak)
+			k.caughtUp = time.Now()
+			return false, nil
+		}
+		if time.Since(k.started) > k.Soak {
+			return false, k.abort(ctx, cfg, healthyMember, myself, key, fmt.Sprint("never caught up: ", err))
+		}
+		cfg.log().Println("Canary", myself.Name, "is", lag, "revisions behind")
+		return false, nil
+	}
+	if err := k.checks(ctx, cfg, healthyMember, myself, peers); err != nil {
+		return false, k.abort(ctx, cfg, healthyMember, myself, key, err.Error())
+	}
+	if time.Since(k.caughtUp) < k.Soak {
+		return false, nil
+	}
+	hold, err := cfg.holdLearner(ctx, healthyMember, false)
+	if err != nil {
+		return false, err
+	}
+	if hold {
+		// The Scaler promotes it once the voting members may grow
+		k.finish(ctx, cfg, healthyMember, key)
+		k.passed = true
+		message := fmt.Sprint("Canary passed its ", k.Soak, " soak, kept a learner so the voting members stay odd")
+		cfg.log().Println(message)
+		cfg.emit(Event{Type: EventCanary, Member: myself, Message: message})
+		return true, nil
+	}
+	if err := c.PromoteMember(ctx, healthyMember, myself); err != nil {
+		k.attempts++
+		if k.attempts == 3 {
+			return false, k.abort(ctx, cfg, healthyMember, myself, key, fmt.Sprint("promotion failed: ", err))
+		}
+		cfg.log().Println("Canary", myself.Name, "not promoted yet:", err)
+		return false, nil
+	}
+	k.finish(ctx, cfg, healthyMember, key)
+	message := fmt.Sprint("Canary passed its ", k.Soak, " soak, promoted to voting member")
+	cfg.log().Println(message)
+	cfg.changed(ctx, healthyMember, Event{Type: EventCanary, Member: myself, Message: message})
+	return true, nil
+}
+
+// checks runs one round of checks
+func (k *Canary) checks(
+	ctx context.Context,
+	cfg Config,
+	hm etcd.Member,
+	myself etcd.Member,
+	peers []etcd.Member,
+) error {
+	c := cfg.Client
+	if lag, err := k.lag(ctx, cfg, hm, myself); err != nil {
+		return errors.New(fmt.Sprint("fell ", lag, " revisions behind: ", err))
+	}
+	if k.MaxFsync > 0 && cfg.DataDir != "" {
+		latency, err := fsyncLatency(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		if latency > k.MaxFsync {
+			return errors.New(fmt.Sprint("disk sync took ", latency, ", more than ", k.MaxFsync))
+		}
+	}
+	if k.MaxPeerRTT > 0 {
+		for _, peer := range peers {
+			rtt, err := c.PeerRTT(ctx, peer)
+			if err != nil {
+				return errors.New(fmt.Sprint("peer ", peer.Name, " unreachable: ", err))
+			}
+			if rtt > k.MaxPeerRTT {
+				return errors.New(fmt.Sprint("round trip to ", peer.Name, " took ", rtt, ", more than ", k.MaxPeerRTT))
+			}
+		}
+	}
+	return nil
+}
+
+// lag returns how many revisions myself is behind hm, failing beyond MaxLag
+func (k *Canary) lag(ctx context.Context, cfg Config, hm etcd.Member, myself etcd.Member) (int64, error) {
+	c := cfg.Client
+	local, err := c.Status(ctx, myself)
+	if err != nil {
+		return 0, err
+	}
+	remote, err := c.Status(ctx, hm)
+	if err != nil {
+		return 0, err
+	}
+	lag := remote.Revision - local.Revision
+	if lag > k.MaxLag {
+		return lag, errors.New(fmt.Sprint("more than ", k.MaxLag, " revisions behind"))
+	}
+	return lag, nil
+}
+
+// abort removes the canary from the cluster
+func (k *Canary) abort(ctx context.Context, cfg Config, hm etcd.Member, myself etcd.Member, key string, reason string) error {
+	k.finish(ctx, cfg, hm, key)
+	cfg.emit(Event{Type: EventCanary, Member: myself, Message: fmt.Sprint("Canary failed, removing it: ", reason)})
+	if err := cfg.removeMember(ctx, hm, myself); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s %s", ErrCanaryFailed, myself.Name, reason)
+}
+
+// finish deletes the canary key and forgets the soak
+func (k *Canary) finish(ctx context.Context, cfg Config, hm etcd.Member, key string) {
+	k.started, k.caughtUp, k.attempts = time.Time{}, time.Time{}, 0
+	if err := cfg.Client.DeleteKey(ctx, hm, key); err != nil {
+		cfg.log().Println(err)
+	}
+}
+
+// fsyncLatency times a synced 4 KiB write in dir, about what etcd pays for
+// every write to its log
+func fsyncLatency(dir string) (time.Duration, error) {
+	f, err := ioutil.TempFile(dir, ".etcdmate-fsync-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	start := time.Now()
+	if _, err := f.Write(make([]byte, 4096)); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
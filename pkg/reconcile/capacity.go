@@ -0,0 +1,64 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// wantedSize is the number of expected members a new cluster waits for
+func (cfg Config) wantedSize(ctx context.Context) (int, error) {
+	if cfg.MinClusterSize > 0 || cfg.Source != nil {
+		return cfg.MinClusterSize, nil
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return 0, err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return 0, err
+	}
+	return int(aws.Int64Value(group.DesiredCapacity)), nil
+}
+
+// waitForCapacity waits until there are as many expected members as
+// wantedSize, so the first instance to boot doesn't create a cluster of
+// itself. waited reports whether found wasn't enough, the members must then
+// be discovered again. Giving up after CapacityWait is an
+// ErrUnsafeNewCluster error.
+func (cfg Config) waitForCapacity(ctx context.Context, found int) (waited bool, err error) {
+	want, err := cfg.wantedSize(ctx)
+	if err != nil {
+		return false, err
+	}
+	if found >= want {
+		return false, nil
+	}
+	cfg.explain("Not assuming a new cluster yet, %d of %d expected members are in service", found, want)
+	if cfg.plan != nil {
+		return false, fmt.Errorf("%w: a run would wait for %d expected members, found %d", ErrUnsafeNewCluster, want, found)
+	}
+	deadline := cfg.now().Add(cfg.CapacityWait)
+	for found < want {
+		if !cfg.now().Before(deadline) {
+			return true, fmt.Errorf("%w: timed out waiting for %d expected members, found %d", ErrUnsafeNewCluster, want, found)
+		}
+		cfg.log().Printf("Waiting for %d expected members before creating the cluster, found %d\n", want, found)
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return true, err
+		}
+		members, err := cfg.ExpectedMembers(ctx)
+		if err != nil {
+			return true, err
+		}
+		found = len(members)
+		want, err = cfg.wantedSize(ctx)
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
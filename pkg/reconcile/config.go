@@ -0,0 +1,331 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/lock"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/metrics"
+	"github.com/viruxel/etcdmate/pkg/output"
+	"github.com/viruxel/etcdmate/pkg/seal"
+)
+
+// Config holds what every reconcile operation needs
+type Config struct {
+	AWS discovery.AWS
+	// Source, when set, discovers the members instead of the Autoscaling
+	// groups of AWS, the checks of the group tags are then skipped
+	Source     discovery.Source
+	Client     etcd.Client
+	URLs       discovery.URLs
+	InstanceID string
+	StateFile  string
+	EnvFile    string
+	// Output, when set, receives the generated configuration instead of
+	// EnvFile, e.g. through the API of an immutable OS
+	Output Output
+	// OutputFormat is how EnvFile is written: "envfile" (the default),
+	// "systemd", "etcd-yaml" or "none", which prints it instead
+	OutputFormat string
+	// EtcdYAMLBase starts the etcd-yaml file, see output.EtcdYAML
+	EtcdYAMLBase string
+	// Template, when set, renders the configuration instead, executed with
+	// an output.TemplateData
+	Template *template.Template
+	// Records, when set, gets a Route53 record per member, see
+	// output.Route53. Owner defaults to the Autoscaling group.
+	Records *output.Route53
+	// AddLock, when set, serializes the additions of members across the
+	// instances, each holding it for AddLockTTL at most and waiting for it
+	// AddLockWait at most
+	AddLock     lock.Lock
+	AddLockTTL  time.Duration
+	AddLockWait time.Duration
+	// Proxy, when set, makes the local instance a proxy of the cluster
+	// Source discovers rather than a member, it only gets the proxy
+	// configuration
+	Proxy *output.Proxy
+	// PeerTLS is written into the env file for the peer network
+	PeerTLS output.PeerTLS
+	// DiscoverySRV, when its Domain is set, is written into the env file
+	// instead of the expected members
+	DiscoverySRV output.DiscoverySRV
+	// DiscoveryWait is how long to wait for the local instance to show up
+	// InService in its Autoscaling group
+	DiscoveryWait time.Duration
+	// Logger defaults to the standard logger when nil
+	Logger logging.Logger
+	// Now, when set, replaces the clock of the grace periods and cooldowns,
+	// for simulations
+	Now func() time.Time
+	// Events, when set, receives the member changes and decisions
+	Events EventHandler
+	// IdentityCheck controls verifying the certificates of the other
+	// members against their addresses, IdentityOff by default
+	IdentityCheck IdentityCheck
+	// StateSealer, when set, encrypts the state file which holds the
+	// cluster token
+	StateSealer seal.Sealer
+	// Summary, when set, records the step durations and the outcome
+	Summary *Summary
+	// Metrics, when set, counts the runs, events and step durations
+	Metrics *metrics.Registry
+	// Explain logs the reason of every decision as it is made
+	Explain bool
+	// HistorySize is how many membership changes are kept in the etcd
+	// HistoryDir, 0 doesn't record them
+	HistorySize int
+	// DataDir is the data dir of the local member, a new cluster is only
+	// assumed if it is empty
+	DataDir string
+	// NewClusterReachable is the fraction of the expected members whose
+	// host must be reachable before assuming a new cluster
+	NewClusterReachable float64
+	// WaitForCapacity waits, for CapacityWait at most, until there are as
+	// many expected members as MinClusterSize, or as the desired capacity of
+	// the group when 0, before assuming a new cluster
+	WaitForCapacity bool
+	MinClusterSize  int
+	CapacityWait    time.Duration
+	// LocalVersion is the version of the local etcd, compared with the
+	// cluster version before joining according to VersionCheck
+	LocalVersion string
+	VersionCheck VersionCheck
+	// EndpointsFile and TargetsFile, when set, receive the client URLs of
+	// the members and observers as ETCDCTL_ENDPOINTS and as Prometheus
+	// targets
+	EndpointsFile string
+	TargetsFile   string
+	// PauseFile, when it exists, pauses the automatic changes, see Paused
+	PauseFile string
+	// LearnerJoin adds the local member as a learner, for the Scaler of the
+	// leader to promote once it caught up
+	LearnerJoin bool
+	// Coordinator, when set, elects the daemon that reconciles, see
+	// Supervise
+	Coordinator *Coordinator
+	// Changes, when set, limits how many members are added and removed
+	// within a window
+	Changes *ChangeLimiter
+	// ScalingCooldown is how long after a scaling activity of the
+	// Autoscaling group stale members are kept, see coolingDown
+	ScalingCooldown time.Duration
+	// RemoveStale allows removing the stale members, without it they are
+	// only reported
+	RemoveStale bool
+	// ClusterToken, when set, is the cluster token written when no
+	// bootstrap chose one, and the one Bootstrap publishes, see
+	// DeriveClusterToken
+	ClusterToken string
+	// Force allows a new cluster although a previous one left its cluster
+	// token in the state file or on the Autoscaling group
+	Force bool
+	// StateMaxAge is how old the state of an interrupted run can be to be
+	// resumed, an older one starts over from discovery. 0 doesn't limit.
+	StateMaxAge time.Duration
+	// StaleGrace is how long a member must be missing from the discovery,
+	// over several runs, before it is removed as stale
+	StaleGrace time.Duration
+	// RemovalConfirmation holds stale removals back until confirmed, with
+	// ConfirmedRemovals the names of the members an operator confirmed
+	RemovalConfirmation RemovalConfirmation
+	ConfirmedRemovals   []string
+	// MaxRemovals is the most stale members a run removes, 0 doesn't limit.
+	// ForceRemove skips it and the quorum check of guardRemovals.
+	MaxRemovals int
+	ForceRemove bool
+	// MinZones, from 2, is how many availability zones the voting members
+	// must span, without a zone holding their quorum, see checkZoneSpread
+	MinZones int
+	// EvenSize controls what happens with an even number of expected
+	// members
+	EvenSize EvenSize
+	// Snapshots, when set, saves a snapshot before every removal of a
+	// member, a failed one refuses the removal
+	Snapshots SnapshotSink
+
+	// plan, when set, has the steps record the changes they would make in
+	// it instead of making them, see Config.Plan
+	plan *Plan
+}
+
+type IdentityCheck string
+
+const (
+	IdentityOff  IdentityCheck = ""
+	IdentityWarn IdentityCheck = "warn"
+	IdentityFail IdentityCheck = "fail"
+)
+
+func (cfg Config) log() logging.Logger {
+	return logging.OrDefault(cfg.Logger)
+}
+
+func (cfg Config) now() time.Time {
+	if cfg.Now != nil {
+		return cfg.Now()
+	}
+	return time.Now()
+}
+
+// ReadConfig returns the configuration the runs write, as it is now
+func (cfg Config) ReadConfig() (string, error) {
+	return cfg.output().Read()
+}
+
+// output returns Output, the env file by default
+func (cfg Config) output() Output {
+	if cfg.Output != nil {
+		return cfg.Output
+	}
+	switch cfg.OutputFormat {
+	case "systemd":
+		return output.SystemdFile(cfg.EnvFile)
+	case "etcd-yaml":
+		return output.EtcdYAML{File: cfg.EnvFile, Base: cfg.EtcdYAMLBase}
+	case "none":
+		return output.Stdout{}
+	}
+	return output.DropInFile(cfg.EnvFile)
+}
+
+// local returns the local member when the output format needs its
+// settings, etcd ignores its variables with a configuration file
+func (cfg Config) local(myself etcd.Member) *output.Local {
+	if cfg.Output != nil || cfg.OutputFormat != "etcd-yaml" {
+		return nil
+	}
+	return &output.Local{Member: myself, DataDir: cfg.DataDir}
+}
+
+func (cfg Config) ExpectedMembers(ctx context.Context) ([]etcd.Member, error) {
+	members, err := cfg.source().GetMembers(ctx, cfg.InstanceID, cfg.URLs)
+	return members.Voters, err
+}
+
+// source returns Source, AWS by default
+func (cfg Config) source() discovery.Source {
+	if cfg.Source != nil {
+		return cfg.Source
+	}
+	return cfg.AWS
+}
+
+// DiscoverMyself returns the expected members and the local one among
+// them, retrying until DiscoveryWait elapsed as a freshly launched instance
+// isn't InService yet.
+func (cfg Config) DiscoverMyself(ctx context.Context) ([]etcd.Member, etcd.Member, error) {
+	members, myself, err := cfg.discoverMyself(ctx)
+	return members.Voters, myself, err
+}
+
+// discoverMyself is DiscoverMyself with the observers too, it fails with
+// ErrObserver right away when the local instance is one. Standbys use the
+// members shared by the active reconciler.
+func (cfg Config) discoverMyself(ctx context.Context) (discovery.Members, etcd.Member, error) {
+	deadline := time.Now().Add(cfg.DiscoveryWait)
+	for {
+		members, shared := cfg.Coordinator.members()
+		var err error
+		if !shared {
+			members, err = cfg.source().GetMembers(ctx, cfg.InstanceID, cfg.URLs)
+		}
+		if err != nil {
+			return members, etcd.Member{}, err
+		}
+		if observer, err := GetMyself(members.Observers, cfg.InstanceID); err == nil {
+			return members, observer, fmt.Errorf("%w: %s", ErrObserver, cfg.InstanceID)
+		}
+		myself, err := GetMyself(members.Voters, cfg.InstanceID)
+		if err == nil || time.Now().After(deadline) {
+			return members, myself, err
+		}
+		cfg.log().Println("Waiting for the instance to be in service")
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return members, etcd.Member{}, err
+		}
+	}
+}
+
+// render renders the configuration of etcd, with Template if set
+func (cfg Config) render(
+	members []etcd.Member,
+	myself etcd.Member,
+	state string,
+	token string,
+	forceNewCluster bool,
+) (string, error) {
+	if token == "" {
+		token = cfg.ClusterToken
+	}
+	env := renderDropIn(cfg.DiscoverySRV, members, state, token, cfg.PeerTLS, cfg.local(myself))
+	if forceNewCluster {
+		env += "ETCD_FORCE_NEW_CLUSTER=true\n"
+	}
+	if cfg.Template == nil {
+		return env, nil
+	}
+	data := output.NewTemplateData(members, myself, state, token)
+	data.DiscoverySRV = cfg.DiscoverySRV
+	data.PeerTLS = cfg.PeerTLS
+	data.DataDir = cfg.DataDir
+	data.ForceNewCluster = forceNewCluster
+	data.Env = env
+	return output.RenderTemplate(cfg.Template, data)
+}
+
+// renderDropIn renders the env file of etcd, with the DNS SRV discovery of
+// srv rather than the members when set, after the settings of local if set
+func renderDropIn(
+	srv output.DiscoverySRV,
+	members []etcd.Member,
+	state string,
+	token string,
+	peerTLS output.PeerTLS,
+	local *output.Local,
+) string {
+	env := ""
+	if local != nil {
+		env = output.RenderLocal(*local)
+	}
+	if srv.Domain != "" {
+		return env + output.RenderSRVDropIn(srv, state, token, peerTLS)
+	}
+	return env + output.RenderDropIn(members, state, token, peerTLS)
+}
+
+// writeEndpoints updates EndpointsFile and TargetsFile
+func (cfg Config) writeEndpoints(members discovery.Members) error {
+	if cfg.EndpointsFile != "" {
+		err := cfg.writeFile(cfg.EndpointsFile, output.RenderEndpoints(members.Voters, members.Observers))
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.TargetsFile != "" {
+		targets, err := output.RenderTargets(members.Voters, members.Observers)
+		if err != nil {
+			return err
+		}
+		return cfg.writeFile(cfg.TargetsFile, targets)
+	}
+	return nil
+}
+
+// writeFile writes content to file when it changed, or plans it
+func (cfg Config) writeFile(file string, content string) error {
+	if cfg.plan == nil {
+		return output.WriteIfChanged(file, content)
+	}
+	current, err := output.DropInFile(file).Read()
+	if err != nil {
+		return err
+	}
+	cfg.plan.write(file, current, content)
+	return nil
+}
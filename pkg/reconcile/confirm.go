@@ -0,0 +1,80 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// RemovalConfirmation holds the removal of stale members back until it is
+// confirmed, for operators easing into automated membership management
+type RemovalConfirmation string
+
+const (
+	// ConfirmOff removes stale members right away
+	ConfirmOff RemovalConfirmation = ""
+	// ConfirmNextRun plans the removal on one run and makes it on a later
+	// one when the member is still stale
+	ConfirmNextRun RemovalConfirmation = "next-run"
+	// ConfirmOperator plans the removal and waits for an operator to list
+	// the member in ConfirmRemovalTag or Config.ConfirmedRemovals
+	ConfirmOperator RemovalConfirmation = "operator"
+)
+
+// ConfirmRemovalTag on the Autoscaling group lists the stale members whose
+// planned removal an operator confirmed, comma separated
+const ConfirmRemovalTag = "etcdmate:confirm-removal"
+
+// confirmed returns the stale members whose removal is confirmed. The
+// others are planned: kept in state, reported as EventRemovalPlanned and
+// recorded in the history the first time.
+func (cfg Config) confirmed(ctx context.Context, state *State, stale []etcd.Member) ([]etcd.Member, error) {
+	if cfg.RemovalConfirmation == ConfirmOff || len(stale) == 0 {
+		state.PlannedRemovals = nil
+		return stale, nil
+	}
+	approved := map[string]bool{}
+	if cfg.RemovalConfirmation == ConfirmOperator {
+		for _, name := range cfg.ConfirmedRemovals {
+			approved[name] = true
+		}
+		asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		value, _, err := cfg.AWS.GetAsgTag(ctx, asgName, ConfirmRemovalTag)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				approved[name] = true
+			}
+		}
+	}
+	confirmed := []etcd.Member{}
+	for _, m := range stale {
+		planned := HasMember(state.PlannedRemovals, m)
+		if (cfg.RemovalConfirmation == ConfirmNextRun && planned) || approved[m.Name] {
+			confirmed = append(confirmed, m)
+			continue
+		}
+		waiting := "a later run"
+		if cfg.RemovalConfirmation == ConfirmOperator {
+			waiting = fmt.Sprint("its name in the ", ConfirmRemovalTag, " tag or --confirm-removal")
+		}
+		cfg.log().Println("Removal of stale member", m.Name, "planned, waiting for", waiting)
+		if !planned {
+			cfg.changed(ctx, state.HealthyMember, Event{
+				Type:    EventRemovalPlanned,
+				Member:  m,
+				Message: fmt.Sprint("removal planned, waiting for ", waiting),
+			})
+		}
+	}
+	// Members no longer stale drop out of the plan
+	state.PlannedRemovals = stale
+	return confirmed, nil
+}
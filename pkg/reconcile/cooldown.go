@@ -0,0 +1,32 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// coolingDown returns why the Autoscaling group is still converging, empty
+// once ScalingCooldown elapsed since its last scaling activity. While an
+// instance launches or terminates, the members discovered can differ from
+// those AWS ends up with, so stale members are only removed afterwards.
+func (cfg Config) coolingDown(ctx context.Context) (string, error) {
+	if cfg.ScalingCooldown <= 0 {
+		return "", nil
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	last, found, err := cfg.AWS.LastScalingActivity(ctx, asgName)
+	if err != nil || !found {
+		return "", err
+	}
+	left := cfg.ScalingCooldown - cfg.now().Sub(last)
+	if left <= 0 {
+		return "", nil
+	}
+	reason := fmt.Sprint("scaling activity of ", asgName, " cooling down for ", left.Round(time.Second))
+	cfg.explain("Not removing stale members, %s", reason)
+	return reason, nil
+}
@@ -0,0 +1,107 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// ActiveKey holds the instance ID of the active reconciler and DiscoveryKey
+// the members it discovered, both expire after Coordinator.TTL
+const (
+	ActiveKey    = "/etcdmate/active"
+	DiscoveryKey = "/etcdmate/discovery"
+)
+
+// Coordinator elects one active reconciler among the daemons with a lease on
+// ActiveKey. The others are standbys while their local member is healthy:
+// they skip their passes and discover the members from DiscoveryKey rather
+// than from AWS. Without a healthy local member or a working cluster every
+// daemon reconciles on its own as without a Coordinator.
+type Coordinator struct {
+	// TTL is how long the lease and the shared members last without being
+	// renewed by the active reconciler
+	TTL time.Duration
+
+	mu      sync.Mutex
+	active  bool
+	standby bool
+	shared  discovery.Members
+}
+
+// Standby returns whether another daemon is the active reconciler, taking or
+// renewing the lease otherwise. Errors are logged and make the local daemon
+// reconcile.
+func (co *Coordinator) Standby(ctx context.Context, cfg Config, myself etcd.Member) bool {
+	standby, shared, err := co.elect(ctx, cfg, myself)
+	if err != nil {
+		cfg.log().Println("Coordination:", err)
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if !standby && !co.active && err == nil {
+		cfg.log().Println("Active reconciler now")
+	}
+	if standby && !co.standby {
+		cfg.log().Println("Standby, another instance is the active reconciler")
+	}
+	co.active = !standby && err == nil
+	co.standby = standby
+	co.shared = shared
+	return standby
+}
+
+func (co *Coordinator) elect(ctx context.Context, cfg Config, myself etcd.Member) (bool, discovery.Members, error) {
+	c := cfg.Client
+	if myself.ClientURL == "" || !c.IsHealthy(ctx, myself) {
+		return false, discovery.Members{}, nil
+	}
+	ok, err := c.CompareAndSetKey(ctx, myself, ActiveKey, cfg.InstanceID, cfg.InstanceID, co.TTL)
+	if err == nil && !ok {
+		ok, err = c.CompareAndSetKey(ctx, myself, ActiveKey, "", cfg.InstanceID, co.TTL)
+	}
+	if err != nil || ok {
+		return false, discovery.Members{}, err
+	}
+	value, found, err := c.GetKey(ctx, myself, DiscoveryKey)
+	if err != nil || !found {
+		// The active reconciler hasn't completed a pass yet
+		return true, discovery.Members{}, err
+	}
+	var shared discovery.Members
+	if err := json.Unmarshal([]byte(value), &shared); err != nil {
+		return true, discovery.Members{}, err
+	}
+	return true, shared, nil
+}
+
+// Share publishes the members discovered by the active reconciler for the
+// standbys
+func (co *Coordinator) Share(ctx context.Context, cfg Config, myself etcd.Member, members discovery.Members) error {
+	co.mu.Lock()
+	active := co.active
+	co.mu.Unlock()
+	if !active {
+		return nil
+	}
+	value, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	return cfg.Client.SetKey(ctx, myself, DiscoveryKey, string(value), co.TTL)
+}
+
+// members returns the shared members while the local daemon is a standby
+// and has some, a nil Coordinator has none
+func (co *Coordinator) members() (discovery.Members, bool) {
+	if co == nil {
+		return discovery.Members{}, false
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return co.shared, co.standby && co.shared.Voters != nil
+}
@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// PeerRepair keeps the peer URLs the members are registered with in line
+// with the current addresses of their instances, which change e.g. with a
+// stop and start or a new network interface. The leader updates the member
+// of every expected member found under another peer URL, and reports the
+// drifts it can't repair for an operator to handle.
+type PeerRepair struct{}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (p PeerRepair) Check(ctx context.Context, cfg Config) error {
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := cfg.Client.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		return nil
+	}
+	return RepairPeerURLs(ctx, cfg, myself, expectedMembers)
+}
+
+// RepairPeerURLs updates, through hm, the members registered under another
+// peer URL than their expected member. Members are matched by name, the
+// members added but not started yet have none and are left alone.
+func RepairPeerURLs(ctx context.Context, cfg Config, hm etcd.Member, expectedMembers []etcd.Member) error {
+	c := cfg.Client
+	registered, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return err
+	}
+	for _, expected := range expectedMembers {
+		for _, m := range registered {
+			if expected.Name == "" || m.Name != expected.Name || m.SamePeerURLs(expected) {
+				continue
+			}
+			if owner, peerURL := peerURLOwner(registered, m, expected); owner != "" {
+				cfg.driftAlert(m, fmt.Sprint("the peer URL ", peerURL, " of its instance is registered for ", owner))
+				continue
+			}
+			if err := cfg.checkPaused(ctx, hm); err != nil {
+				return err
+			}
+			previous := strings.Join(m.AllPeerURLs(), ",")
+			cfg.explain(
+				"Updating the peer URL of %s from %s to %s, the address of its instance changed",
+				m.Name,
+				previous,
+				strings.Join(expected.AllPeerURLs(), ","),
+			)
+			m.PeerURL, m.PeerURLs = expected.PeerURL, expected.PeerURLs
+			if err := c.UpdateMember(ctx, hm, m); err != nil {
+				cfg.driftAlert(m, fmt.Sprint("updating its peer URL from ", previous, " failed: ", err))
+				continue
+			}
+			cfg.changed(ctx, hm, Event{
+				Type:    EventPeerURLRepaired,
+				Member:  m,
+				Message: fmt.Sprint("peer URL was ", previous),
+			})
+		}
+	}
+	return nil
+}
+
+// peerURLOwner returns the name of another member than self registered
+// with a peer URL of expected, and that peer URL
+func peerURLOwner(members []etcd.Member, self etcd.Member, expected etcd.Member) (string, string) {
+	for _, m := range members {
+		if m.ID == self.ID {
+			continue
+		}
+		for _, registered := range m.AllPeerURLs() {
+			for _, peerURL := range expected.AllPeerURLs() {
+				if registered != peerURL {
+					continue
+				}
+				if m.Name == "" {
+					return m.ID, peerURL
+				}
+				return m.Name, peerURL
+			}
+		}
+	}
+	return "", ""
+}
+
+// driftAlert reports a peer URL drift needing manual intervention
+func (cfg Config) driftAlert(m etcd.Member, reason string) {
+	message := fmt.Sprint("Peer URL of ", m.Name, " needs manual intervention: ", reason)
+	cfg.log().Println(message)
+	cfg.emit(Event{Type: EventPeerURLDrift, Member: m, Message: message})
+}
@@ -0,0 +1,39 @@
+package reconcile
+
+import (
+	"errors"
+)
+
+var (
+	ErrQuorumRisk = errors.New("Change would risk the cluster quorum")
+	// ErrNotExpectedMember means the instance isn't among the expected members
+	ErrNotExpectedMember = errors.New("Couldn't find instance in expected members")
+	// ErrUnsafeNewCluster means no member is healthy but a cluster may
+	// exist, the run is retried rather than bootstrapping a new one
+	ErrUnsafeNewCluster = errors.New("Refusing to assume a new cluster")
+	// ErrPeersUnreachable means too few peer ports answered for the local
+	// member to ever start after being added
+	ErrPeersUnreachable = errors.New("Peer ports unreachable")
+	// ErrObserver means the instance is an observer, it never joins
+	ErrObserver = errors.New("Instance is an observer")
+	// ErrPaused means the automatic changes are paused, see Config.Paused
+	ErrPaused = errors.New("Automatic changes paused")
+	// ErrCanaryFailed means the local learner failed its checks and was
+	// removed again
+	ErrCanaryFailed = errors.New("Canary checks failed")
+	// ErrRateLimited means the membership changes reached the limit of
+	// Config.Changes, the next pass goes on
+	ErrRateLimited = errors.New("Membership changes rate limited")
+	// ErrZoneSpread means the voting members don't span Config.MinZones
+	// availability zones, or one zone holds their quorum
+	ErrZoneSpread = errors.New("Members not spread across availability zones")
+	// ErrSnapshotFailed means no snapshot could be saved before removing a
+	// member, see Config.Snapshots, the member is kept
+	ErrSnapshotFailed = errors.New("Snapshot before removal failed")
+	// ErrClusterHealthy means a member of the cluster to restore is
+	// healthy, its data is newer than any snapshot
+	ErrClusterHealthy = errors.New("Refusing to restore a healthy cluster")
+	// ErrPeerURLTaken means a member of another name holds the peer URL of
+	// the local member, which has no data for it, and was kept
+	ErrPeerURLTaken = errors.New("Peer URL held by another member")
+)
@@ -0,0 +1,82 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// EvenSize controls what happens with an even number of expected members,
+// which tolerate no more failures than one member less
+type EvenSize string
+
+const (
+	EvenSizeOff    EvenSize = ""
+	EvenSizeWarn   EvenSize = "warn"
+	EvenSizeRefuse EvenSize = "refuse"
+	// EvenSizeLearner keeps a member joining an existing cluster a learner
+	// while it would make the voting members even, see holdLearner
+	EvenSizeLearner EvenSize = "learner"
+)
+
+var ErrEvenSize = errors.New("Even number of members")
+
+// checkEvenSize reports an even number of expected members, failing only
+// with EvenSizeRefuse
+func (cfg Config) checkEvenSize(members []etcd.Member) error {
+	if cfg.EvenSize == EvenSizeOff || len(members) < 2 || len(members)%2 == 1 {
+		return nil
+	}
+	tolerated := (len(members) - 1) / 2
+	switch cfg.EvenSize {
+	case EvenSizeRefuse:
+		return fmt.Errorf(
+			"%w: %d expected members tolerate %d failures, as %d would; set an odd desired capacity on the Autoscaling group, or use --even-size learner",
+			ErrEvenSize,
+			len(members),
+			tolerated,
+			len(members)-1,
+		)
+	case EvenSizeLearner:
+		cfg.explain("%d expected members, one of them is kept a learner so an odd number of members vote", len(members))
+	default:
+		cfg.log().Printf(
+			"Warning: %d expected members tolerate %d failures, as %d would, prefer an odd desired capacity\n",
+			len(members),
+			tolerated,
+			len(members)-1,
+		)
+	}
+	return nil
+}
+
+// holdLearner reports whether one more voting member, a learner to promote
+// or with adding the member to add, would leave an even number of voting
+// members with EvenSizeLearner. The voting members then stay at the largest
+// odd number the registered members allow.
+func (cfg Config) holdLearner(ctx context.Context, hm etcd.Member, adding bool) (bool, error) {
+	if cfg.EvenSize != EvenSizeLearner {
+		return false, nil
+	}
+	c := cfg.Client
+	registered, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return false, err
+	}
+	learners, err := c.ListLearners(ctx, hm)
+	if err != nil {
+		return false, err
+	}
+	total := len(registered)
+	if adding {
+		total++
+	}
+	odd := total
+	if odd%2 == 0 {
+		odd--
+	}
+	voters := len(registered) - len(learners)
+	return voters+1 > odd, nil
+}
@@ -0,0 +1,79 @@
+package reconcile
+
+import (
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/metrics"
+)
+
+type EventType string
+
+const (
+	EventMemberAdded       EventType = "member-added"
+	EventMemberRemoved     EventType = "member-removed"
+	EventBootstrapDecision EventType = "bootstrap-decision"
+	EventReconcileError    EventType = "reconcile-error"
+	// EventQuorumLost and EventQuorumRecovery report the steps of
+	// Recovery, operators should be told about every one
+	EventQuorumLost     EventType = "quorum-lost"
+	EventQuorumRecovery EventType = "quorum-recovery"
+	// EventMaintenance reports compactions and defragmentations
+	EventMaintenance EventType = "maintenance"
+	// EventScaling reports desired capacity changes of the Autoscaling
+	// group and the members promoted or released for termination
+	EventScaling EventType = "scaling"
+	// EventCanary reports the outcome of the soak of a canary member
+	EventCanary EventType = "canary"
+	// EventRemovalPlanned reports a stale member whose removal waits for a
+	// confirmation, see RemovalConfirmation
+	EventRemovalPlanned EventType = "removal-planned"
+	// EventRemovalRefused reports a stale member kept as its removal would
+	// be unsafe, see guardRemovals
+	EventRemovalRefused EventType = "removal-refused"
+	// EventPeerURLRepaired reports a member whose peer URL was updated to
+	// the address of its instance, EventPeerURLDrift one an operator has to
+	// update, see PeerRepair
+	EventPeerURLRepaired EventType = "peer-url-repaired"
+	EventPeerURLDrift    EventType = "peer-url-drift"
+	// EventInterruption reports the spot interruption notice or rebalance
+	// recommendation the local member leaves on, see WatchSpot
+	EventInterruption EventType = "interruption"
+)
+
+// Event describes something etcdmate did or decided
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Member  etcd.Member `json:",omitempty"`
+	Message string      `json:",omitempty"`
+	Err     error       `json:"-"`
+}
+
+// EventHandler is called synchronously for every event, it shouldn't block
+type EventHandler func(Event)
+
+// EventChannel returns a handler sending the events to ch. Events are
+// dropped when ch is full rather than blocking the workflow.
+func EventChannel(ch chan<- Event) EventHandler {
+	return func(e Event) {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (cfg Config) emit(e Event) {
+	cfg.Metrics.Add("etcdmate_events_total", 1, metrics.Labels{"type": string(e.Type)})
+	if cfg.Events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = cfg.now()
+	}
+	if e.Err != nil && e.Message == "" {
+		e.Message = e.Err.Error()
+	}
+	cfg.Events(e)
+}
@@ -0,0 +1,32 @@
+package reconcile
+
+import (
+	"fmt"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// explain records why a decision was made. The reasons are part of the run
+// summary and, with Config.Explain, logged as they are made.
+func (cfg Config) explain(format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	if cfg.Summary != nil {
+		cfg.Summary.Reasons = append(cfg.Summary.Reasons, reason)
+	}
+	if cfg.Explain {
+		cfg.log().Println("Why:", reason)
+	}
+}
+
+func memberNames(members []etcd.Member) []string {
+	names := []string{}
+	for _, m := range members {
+		name := m.Name
+		if name == "" {
+			// Added but never started
+			name = m.PeerURL
+		}
+		names = append(names, name)
+	}
+	return names
+}
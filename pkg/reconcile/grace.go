@@ -0,0 +1,41 @@
+package reconcile
+
+import (
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// pastGrace returns the stale members missing from the discovery for at
+// least StaleGrace, so a single inconsistent answer of AWS doesn't remove a
+// member. When each member was first found stale is kept in state.
+func (cfg Config) pastGrace(state *State, stale []etcd.Member) []etcd.Member {
+	if cfg.StaleGrace <= 0 {
+		state.StaleSince = nil
+		return stale
+	}
+	now := cfg.now()
+	since := map[string]time.Time{}
+	due := []etcd.Member{}
+	for _, m := range stale {
+		first, ok := state.StaleSince[m.PeerURL]
+		if !ok {
+			first = now
+		}
+		// Members back in the discovery are dropped, they start over
+		since[m.PeerURL] = first
+		if now.Sub(first) >= cfg.StaleGrace {
+			due = append(due, m)
+			continue
+		}
+		cfg.log().Printf(
+			"Keeping stale member %s, missing since %s, for %s more\n",
+			m.Name,
+			first.Format(time.RFC3339),
+			(cfg.StaleGrace - now.Sub(first)).Round(time.Second),
+		)
+		cfg.explain("Not removing %s yet, it must be missing from the discovery for %s", m.Name, cfg.StaleGrace)
+	}
+	state.StaleSince = since
+	return due
+}
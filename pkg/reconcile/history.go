@@ -0,0 +1,86 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// HistoryDir is the etcd directory holding the membership change history
+const HistoryDir = "/etcdmate/history"
+
+// HistoryEntry is a membership change as recorded in HistoryDir
+type HistoryEntry struct {
+	Time    time.Time
+	Type    EventType
+	Member  string
+	PeerURL string
+	// By is the instance that made the change
+	By      string
+	Message string `json:",omitempty"`
+}
+
+// changed emits e for a membership change made through hm and records it
+// in the cluster history
+func (cfg Config) changed(ctx context.Context, hm etcd.Member, e Event) {
+	cfg.emit(e)
+	if cfg.HistorySize <= 0 || cfg.plan != nil {
+		return
+	}
+	// The change is done, failing to record it only costs the history
+	err := cfg.recordHistory(ctx, hm, e)
+	if err != nil {
+		cfg.log().Println("Recording history failed:", err)
+	}
+}
+
+func (cfg Config) recordHistory(ctx context.Context, hm etcd.Member, e Event) error {
+	value, err := json.Marshal(HistoryEntry{
+		Time:    time.Now().UTC(),
+		Type:    e.Type,
+		Member:  e.Member.Name,
+		PeerURL: e.Member.PeerURL,
+		By:      cfg.InstanceID,
+		Message: e.Message,
+	})
+	if err != nil {
+		return err
+	}
+	c := cfg.Client
+	err = c.AppendKey(ctx, hm, HistoryDir, string(value))
+	if err != nil {
+		return err
+	}
+	keys, err := c.ListKeys(ctx, hm, HistoryDir)
+	if err != nil {
+		return err
+	}
+	for len(keys) > cfg.HistorySize {
+		err = c.DeleteKey(ctx, hm, keys[0].Key)
+		if err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// ReadHistory returns the recorded membership changes, oldest first
+func ReadHistory(ctx context.Context, cfg Config, hm etcd.Member) ([]HistoryEntry, error) {
+	keys, err := cfg.Client.ListKeys(ctx, hm, HistoryDir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []HistoryEntry{}
+	for _, kv := range keys {
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(kv.Value), &entry); err != nil {
+			cfg.log().Println("Ignoring unreadable history entry", kv.Key, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
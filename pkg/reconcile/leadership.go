@@ -0,0 +1,153 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// leaderTransferWait is how long a new leader has to show up after a
+// transfer
+const leaderTransferWait = 30 * time.Second
+
+// removalSettleWait is how long the cluster has to be healthy again between
+// the removals of several members
+const removalSettleWait = time.Minute
+
+// leaderLast orders members to remove so the followers go first and the
+// leader, if among them, last: the cluster then keeps its leader for as long
+// as possible and transfers the leadership only once
+func (cfg Config) leaderLast(ctx context.Context, hm etcd.Member, members []etcd.Member) []etcd.Member {
+	status, err := cfg.Client.Status(ctx, hm)
+	if err != nil {
+		return members
+	}
+	ordered := []etcd.Member{}
+	var leader []etcd.Member
+	for _, m := range members {
+		if m.ID != "" && m.ID == status.Leader {
+			leader = append(leader, m)
+			continue
+		}
+		ordered = append(ordered, m)
+	}
+	return append(ordered, leader...)
+}
+
+// SnapshotSink saves a snapshot of the cluster taken through hm, a restore
+// point before a destructive change
+type SnapshotSink interface {
+	SaveSnapshot(ctx context.Context, hm etcd.Member) error
+}
+
+// removeMember removes m through hm, within the limit of Changes and after
+// a snapshot when Snapshots is set. When m leads, the leadership is handed
+// over to another started member first, so its removal doesn't leave the
+// cluster without a leader until an election. A failed transfer only costs
+// that election, m is removed anyway.
+func (cfg Config) removeMember(ctx context.Context, hm etcd.Member, m etcd.Member) error {
+	c := cfg.Client
+	if err := cfg.Changes.allow(cfg.now()); err != nil {
+		return err
+	}
+	if cfg.plan != nil {
+		cfg.plan.MembersToRemove = append(cfg.plan.MembersToRemove, m)
+		return nil
+	}
+	if err := saveSnapshot(ctx, cfg.Snapshots, hm, m); err != nil {
+		return err
+	}
+	status, err := c.Status(ctx, hm)
+	if err == nil && m.ID != "" && status.Leader == m.ID {
+		if err := cfg.stepDown(ctx, hm, m); err != nil {
+			cfg.log().Println("Removing the leader without transferring the leadership:", err)
+		}
+	}
+	removed, err := RemoveMember(ctx, &c, cfg.log(), hm, m)
+	if err != nil || !removed {
+		return err
+	}
+	cfg.changed(ctx, hm, Event{Type: EventMemberRemoved, Member: m})
+	return nil
+}
+
+// saveSnapshot saves a snapshot with sink, if any, before m is removed
+func saveSnapshot(ctx context.Context, sink SnapshotSink, hm etcd.Member, m etcd.Member) error {
+	if sink == nil {
+		return nil
+	}
+	if err := sink.SaveSnapshot(ctx, hm); err != nil {
+		return fmt.Errorf("%w, keeping %s: %v", ErrSnapshotFailed, m.Name, err)
+	}
+	return nil
+}
+
+// StepDown hands the leadership of the local member over to another member
+// when it leads, e.g. before it stops
+func StepDown(ctx context.Context, cfg Config, myself etcd.Member) error {
+	c := cfg.Client
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		return nil
+	}
+	myself.ID = status.MemberID
+	return cfg.stepDown(ctx, myself, myself)
+}
+
+// stepDown moves the leadership away from leader to the first started
+// voting member that takes it, and waits for the new leader
+func (cfg Config) stepDown(ctx context.Context, hm etcd.Member, leader etcd.Member) error {
+	c := cfg.Client
+	registered, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return err
+	}
+	for _, m := range registered {
+		if m.ID == leader.ID {
+			leader.ClientURL = m.ClientURL
+		}
+	}
+	learners, err := c.ListLearners(ctx, hm)
+	if err != nil {
+		return err
+	}
+	for _, target := range registered {
+		// Members added but not started yet have no client URL
+		if target.ID == leader.ID || target.ClientURL == "" || HasMember(learners, target) {
+			continue
+		}
+		if !c.IsHealthy(ctx, target) {
+			continue
+		}
+		if err := c.MoveLeader(ctx, leader, target); err != nil {
+			cfg.log().Println(err)
+			continue
+		}
+		cfg.explain("Moved the leadership from %s to %s before it goes away", leader.Name, target.Name)
+		return waitForLeader(ctx, c, target, leaderTransferWait)
+	}
+	return errors.New(fmt.Sprint("No member took over the leadership of ", leader.Name))
+}
+
+// waitForLeader waits until m reports itself as the leader
+func waitForLeader(ctx context.Context, c etcd.Client, m etcd.Member, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := c.Status(ctx, m)
+		if err == nil && status.IsLeader() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("Timed out waiting for ", m.Name, " to lead"))
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+type LeaveOptions struct {
+	// RemoveData empties Config.DataDir once the member is removed, so a
+	// later start of etcd can't come back with the old member
+	RemoveData bool
+}
+
+// Leave removes the local member from the cluster and forgets the saved
+// state. Nothing stops a later join from adding it back. An instance being
+// terminated or stopped isn't discovered anymore, its member is then the one
+// recorded by the last run.
+func Leave(ctx context.Context, cfg Config, opts LeaveOptions) error {
+	c := cfg.Client
+	expectedMembers, err := cfg.ExpectedMembers(ctx)
+	if err != nil {
+		return err
+	}
+	myself, err := GetMyself(expectedMembers, cfg.InstanceID)
+	if errors.Is(err, ErrNotExpectedMember) {
+		myself, err = cfg.savedMyself(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	remaining := withoutMember(expectedMembers, myself)
+	err = CheckQuorum(ctx, c, remaining)
+	if err != nil {
+		return err
+	}
+	healthyMember, err := c.FindHealthyMember(ctx, remaining)
+	if err != nil {
+		return err
+	}
+	existingMembers, err := c.ListMembers(ctx, healthyMember)
+	if err != nil {
+		return err
+	}
+	for _, m := range existingMembers {
+		if HasMember([]etcd.Member{myself}, m) || (myself.ID != "" && m.ID == myself.ID) {
+			err = cfg.removeMember(ctx, healthyMember, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if opts.RemoveData && cfg.DataDir != "" {
+		cfg.log().Println("Removing the data of the local member from", cfg.DataDir)
+		err = removeContents(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Remove(cfg.StateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// savedMyself returns the local member as the last run registered it
+func (cfg Config) savedMyself(ctx context.Context) (etcd.Member, error) {
+	state, err := cfg.LoadState(ctx)
+	if err != nil {
+		return etcd.Member{}, err
+	}
+	myself := state.Registered
+	if myself.ID == "" {
+		myself = state.Myself
+	}
+	if myself.PeerURL == "" && myself.ID == "" {
+		return myself, errors.New(
+			"The local instance isn't among the expected members and no run recorded its member, nothing tells which member to remove",
+		)
+	}
+	cfg.log().Println("The local instance isn't among the expected members, leaving as the recorded member", myself.Name)
+	return myself, nil
+}
+
+// removeContents empties dir, keeping dir itself and its ownership
+func removeContents(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(path.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// SQSAPI is the subset of the SQS API WatchLifecycle uses
+type SQSAPI interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+}
+
+type LifecycleOptions struct {
+	// Hook is the termination lifecycle hook of the group, any hook of the
+	// group when reading Queue
+	Hook string
+	// Queue is the URL of the SQS queue the hook notifies. Without it the
+	// group is polled for the instances waiting on Hook every Interval.
+	Queue    string
+	SQS      SQSAPI
+	Interval time.Duration
+	// Lock, when set, is taken while releasing the instances of a poll or
+	// of the received notifications
+	Lock func() (func(), error)
+}
+
+// lock takes opts.Lock, when set, and returns the function releasing it
+func (opts LifecycleOptions) lock() (func(), error) {
+	if opts.Lock == nil {
+		return func() {}, nil
+	}
+	return opts.Lock()
+}
+
+// lifecycleMessage is a notification of a lifecycle hook, sent by the
+// hook itself or wrapped in the detail of an EventBridge event
+type lifecycleMessage struct {
+	Event                string
+	LifecycleHookName    string
+	LifecycleTransition  string
+	AutoScalingGroupName string
+	EC2InstanceId        string
+	LifecycleActionToken string
+	Detail               *lifecycleMessage `json:"detail"`
+}
+
+// WatchLifecycle removes the members of the instances the group scales in
+// as soon as they wait on the termination hook, then lets the group
+// terminate them, until ctx is done. The instances running it share the
+// queue: a message is only deleted once handled, one that couldn't be, e.g.
+// while the cluster can't spare the member or for the local instance, is
+// received again after the visibility timeout of the queue.
+func WatchLifecycle(ctx context.Context, cfg Config, opts LifecycleOptions) error {
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	for {
+		if opts.Queue != "" {
+			err = cfg.receiveLifecycle(ctx, asgName, opts)
+		} else {
+			err = cfg.pollLifecycle(ctx, asgName, opts)
+		}
+		if err != nil {
+			cfg.log().Println("Releasing terminating instances:", err)
+		}
+		// Receiving waits for messages already
+		if opts.Queue != "" && err == nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// pollLifecycle releases the instances of the group waiting on hook
+func (cfg Config) pollLifecycle(ctx context.Context, asgName string, opts LifecycleOptions) error {
+	release, err := opts.lock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	if err := cfg.checkPaused(ctx, myself); err != nil {
+		return err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	for _, instance := range group.Instances {
+		instanceId := aws.StringValue(instance.InstanceId)
+		if aws.StringValue(instance.LifecycleState) != "Terminating:Wait" || instanceId == cfg.InstanceID {
+			continue
+		}
+		if _, err := cfg.releaseInstance(ctx, expectedMembers, myself, asgName, opts.Hook, instanceId, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveLifecycle waits for the notifications of the queue and releases
+// the instances they are about
+func (cfg Config) receiveLifecycle(ctx context.Context, asgName string, opts LifecycleOptions) error {
+	out, err := opts.SQS.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(opts.Queue),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if len(out.Messages) == 0 {
+		return nil
+	}
+	release, err := opts.lock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	if err := cfg.checkPaused(ctx, myself); err != nil {
+		return err
+	}
+	for _, message := range out.Messages {
+		done, err := cfg.handleLifecycle(ctx, expectedMembers, myself, asgName, opts.Hook, aws.StringValue(message.Body))
+		if err != nil {
+			return err
+		}
+		if !done {
+			continue
+		}
+		_, err = opts.SQS.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(opts.Queue),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleLifecycle handles a notification, done is whether its message can
+// be deleted
+func (cfg Config) handleLifecycle(
+	ctx context.Context,
+	expectedMembers []etcd.Member,
+	myself etcd.Member,
+	asgName string,
+	hook string,
+	body string,
+) (done bool, err error) {
+	var m lifecycleMessage
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		cfg.log().Println("Deleting an invalid lifecycle notification:", err)
+		return true, nil
+	}
+	if m.Detail != nil {
+		m = *m.Detail
+	}
+	switch {
+	case m.Event == "autoscaling:TEST_NOTIFICATION":
+		return true, nil
+	case m.AutoScalingGroupName != asgName || (hook != "" && m.LifecycleHookName != hook):
+		// For the instances of another cluster sharing the queue
+		return false, nil
+	case m.LifecycleTransition != "autoscaling:EC2_INSTANCE_TERMINATING":
+		cfg.log().Println("Deleting a lifecycle notification of", m.LifecycleTransition, "for", m.EC2InstanceId)
+		return true, nil
+	case m.EC2InstanceId == cfg.InstanceID:
+		// Another instance removes the member of the local one
+		return false, nil
+	}
+	return cfg.releaseInstance(ctx, expectedMembers, myself, asgName, m.LifecycleHookName, m.EC2InstanceId, m.LifecycleActionToken)
+}
@@ -0,0 +1,108 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Maintenance compacts old revisions and defragments the members
+// periodically. Only the leader does it, so the members don't need to agree
+// on who does: when leadership moves, the new leader starts counting the
+// intervals from scratch.
+type Maintenance struct {
+	// CompactInterval is how often revisions are compacted, 0 never
+	CompactInterval time.Duration
+	// Retain is how many revisions compaction keeps
+	Retain int64
+	// DefragInterval is how often the members are defragmented, one at a
+	// time with the leader last, 0 never
+	DefragInterval time.Duration
+	// Wait is how long each member may take to defragment and be healthy
+	// again
+	Wait time.Duration
+
+	lastCompact time.Time
+	lastDefrag  time.Time
+}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (m *Maintenance) Check(ctx context.Context, cfg Config) error {
+	now := time.Now()
+	if m.lastCompact.IsZero() {
+		m.lastCompact, m.lastDefrag = now, now
+	}
+	compact := m.CompactInterval > 0 && now.Sub(m.lastCompact) >= m.CompactInterval
+	defrag := m.DefragInterval > 0 && now.Sub(m.lastDefrag) >= m.DefragInterval
+	if !compact && !defrag {
+		return nil
+	}
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		// Followers start counting again, the leader takes care of it
+		m.lastCompact, m.lastDefrag = now, now
+		return nil
+	}
+	if err := cfg.checkPaused(ctx, myself); err != nil {
+		return err
+	}
+	if compact {
+		m.lastCompact = now
+		if err := m.compact(ctx, cfg, myself, status.Revision); err != nil {
+			return err
+		}
+	}
+	if defrag {
+		m.lastDefrag = now
+		return m.defrag(ctx, cfg, expectedMembers, myself)
+	}
+	return nil
+}
+
+func (m *Maintenance) compact(ctx context.Context, cfg Config, leader etcd.Member, revision int64) error {
+	revision -= m.Retain
+	if revision <= 0 {
+		return nil
+	}
+	err := cfg.Client.Compact(ctx, leader, revision)
+	if err != nil {
+		cfg.emit(Event{Type: EventMaintenance, Member: leader, Err: fmt.Errorf("Compaction failed: %w", err)})
+		return err
+	}
+	cfg.emit(Event{Type: EventMaintenance, Member: leader, Message: fmt.Sprint("Compacted revisions before ", revision)})
+	return nil
+}
+
+// defrag goes through the members one at a time, only while all of them are
+// healthy, so only one member is ever unavailable
+func (m *Maintenance) defrag(ctx context.Context, cfg Config, expectedMembers []etcd.Member, leader etcd.Member) error {
+	c := cfg.Client
+	order := append(withoutMember(expectedMembers, leader), leader)
+	for _, member := range order {
+		err := WaitHealthy(ctx, c, expectedMembers, m.Wait)
+		if err != nil {
+			cfg.emit(Event{Type: EventMaintenance, Member: member, Err: fmt.Errorf("Defragmentation stopped: %w", err)})
+			return err
+		}
+		defragCtx, cancel := context.WithTimeout(ctx, m.Wait)
+		err = c.Defragment(defragCtx, member)
+		cancel()
+		if err != nil {
+			cfg.emit(Event{Type: EventMaintenance, Member: member, Err: fmt.Errorf("Defragmentation failed: %w", err)})
+			return err
+		}
+		cfg.emit(Event{Type: EventMaintenance, Member: member, Message: "Defragmented"})
+	}
+	return WaitHealthy(ctx, c, expectedMembers, m.Wait)
+}
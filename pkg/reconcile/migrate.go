@@ -0,0 +1,138 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+type MigrateOptions struct {
+	// TargetEnvFile is the drop-in of the etcd3 unit
+	TargetEnvFile string
+	SourceUnit    string
+	TargetUnit    string
+	// Wait bounds waiting for the turn of this node and for its member to
+	// be healthy again
+	Wait time.Duration
+}
+
+// Migrate moves the local member from an etcd2 unit to an etcd3 one. Every
+// node runs it; the nodes take turns in instance ID order, each waiting for
+// the previous ones to serve etcd 3, so a single member is down at a time.
+// The data directory is upgraded in place by etcd 3 on start.
+func Migrate(ctx context.Context, cfg Config, opts MigrateOptions) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	server, _, err := c.Version(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if isV3(server) {
+		cfg.log().Println("Local member already runs etcd", server)
+		return nil
+	}
+	err = migrationPrerequisites(ctx, cfg, expectedMembers)
+	if err != nil {
+		return err
+	}
+	members := append([]etcd.Member{}, expectedMembers...)
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	for _, m := range members {
+		if m.Name == myself.Name {
+			break
+		}
+		err := waitForV3(ctx, cfg, m, opts.Wait)
+		if err != nil {
+			return err
+		}
+	}
+	// Members upgraded before this one restarted, quorum is needed again
+	err = CheckQuorum(ctx, c, withoutMember(expectedMembers, myself))
+	if err != nil {
+		return err
+	}
+	state, err := cfg.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+	// The member is in the cluster already, etcd 3 only needs the same
+	// configuration and the v2 API etcdmate relies on
+	content := renderDropIn(cfg.DiscoverySRV, expectedMembers, "existing", state.ClusterToken, cfg.PeerTLS, nil)
+	content += "ETCD_ENABLE_V2=true\n"
+	err = output.DropInFile(opts.TargetEnvFile).Write(content)
+	if err != nil {
+		return err
+	}
+	err = output.SwitchUnit(opts.SourceUnit, opts.TargetUnit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	err = VerifyLocal(ctx, cfg, myself, opts.Wait)
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"%v, %s can be started again with systemctl disable --now %s && systemctl enable --now %s",
+			err,
+			opts.SourceUnit,
+			opts.TargetUnit,
+			opts.SourceUnit,
+		))
+	}
+	server, _, err = c.Version(ctx, myself)
+	if err != nil {
+		return err
+	}
+	cfg.log().Println("Local member migrated to etcd", server)
+	return nil
+}
+
+// migrationPrerequisites checks every member is healthy and the cluster
+// runs etcd 2.3, the only version etcd 3.0 upgrades from
+func migrationPrerequisites(ctx context.Context, cfg Config, members []etcd.Member) error {
+	for i, err := range cfg.Client.CheckHealthAll(ctx, members) {
+		if err != nil {
+			return errors.New(fmt.Sprint("All members must be healthy to migrate, ", members[i].Name, ": ", err))
+		}
+	}
+	for _, m := range members {
+		server, cluster, err := cfg.Client.Version(ctx, m)
+		if err != nil {
+			return err
+		}
+		if !isV3(server) && !strings.HasPrefix(cluster, "2.3") {
+			return errors.New(fmt.Sprint(
+				"Upgrade the cluster to etcd 2.3 first, ", m.Name, " reports cluster version ", cluster,
+			))
+		}
+	}
+	return nil
+}
+
+func waitForV3(ctx context.Context, cfg Config, m etcd.Member, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		server, _, err := cfg.Client.Version(ctx, m)
+		if err == nil && isV3(server) && cfg.Client.CheckHealth(ctx, m) == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("Timed out waiting for ", m.Name, " to be migrated first"))
+		}
+		cfg.log().Println("Waiting for", m.Name, "to be migrated first")
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func isV3(version string) bool {
+	return strings.HasPrefix(version, "3.")
+}
@@ -0,0 +1,131 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// checkNewCluster returns an ErrUnsafeNewCluster error unless nothing
+// suggests a cluster already exists. No healthy member alone isn't enough:
+// during a network partition or a quorum loss, a new cluster would split
+// the existing one. With Force, the cluster token of a previous cluster
+// doesn't count, the other signs still do.
+func (cfg Config) checkNewCluster(ctx context.Context, state *State) error {
+	c := cfg.Client
+	if err := cfg.checkPreviousToken(ctx, state); err != nil {
+		if !cfg.Force {
+			return err
+		}
+		cfg.log().Println("Forced, ignoring:", err)
+	}
+	// An etcd answering /health at all, even unhealthy, has a data dir. It
+	// is only that of this bootstrap while it waits for the others.
+	bootstrapping := false
+	for i, err := range c.CheckHealthAll(ctx, state.ExpectedMembers) {
+		if err == nil || errors.Is(err, etcd.ErrUnreachable) {
+			continue
+		}
+		m := state.ExpectedMembers[i]
+		if ferr := cfg.checkBootstrapping(ctx, m, state.ExpectedMembers); ferr != nil {
+			return fmt.Errorf("%w: %s runs etcd: %v", ErrUnsafeNewCluster, m.Name, ferr)
+		}
+		cfg.log().Println(m.Name, "runs etcd waiting for the other expected members:", err)
+		if m.PeerURL == state.Myself.PeerURL {
+			bootstrapping = true
+		}
+	}
+	// The local etcd of this bootstrap wrote its data dir
+	if cfg.DataDir != "" && !bootstrapping {
+		empty, err := emptyDir(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("%w: the local data dir %s isn't empty", ErrUnsafeNewCluster, cfg.DataDir)
+		}
+	}
+	reachable := 0
+	for _, ok := range c.HostReachableAll(ctx, state.ExpectedMembers) {
+		if ok {
+			reachable++
+		}
+	}
+	if float64(reachable) < cfg.NewClusterReachable*float64(len(state.ExpectedMembers)) {
+		return fmt.Errorf(
+			"%w: only %d of %d expected members are reachable",
+			ErrUnsafeNewCluster,
+			reachable,
+			len(state.ExpectedMembers),
+		)
+	}
+	return nil
+}
+
+// checkBootstrapping fails unless m, an etcd answering unhealthy, is still
+// forming the cluster of expected: without a leader, and listing none but
+// expected members. A member of a formed cluster, even without quorum,
+// knows a leader or other members.
+func (cfg Config) checkBootstrapping(ctx context.Context, m etcd.Member, expected []etcd.Member) error {
+	c := cfg.Client
+	status, err := c.Status(ctx, m)
+	if err != nil {
+		return err
+	}
+	if status.Leader != "" && status.Leader != "0" {
+		return errors.New(fmt.Sprint("it reports the leader ", status.Leader))
+	}
+	members, err := c.ListMembers(ctx, m)
+	if err != nil {
+		return err
+	}
+	for _, listed := range members {
+		if !HasMember(expected, listed) {
+			return errors.New(fmt.Sprint("it lists the member ", listed.Name, " ", listed.PeerURL, " which isn't expected"))
+		}
+	}
+	return nil
+}
+
+// checkPreviousToken fails when a previous cluster left its token in the
+// state file or on the Autoscaling group
+func (cfg Config) checkPreviousToken(ctx context.Context, state *State) error {
+	if state.ClusterToken != "" {
+		return fmt.Errorf("%w: this instance already bootstrapped with token %s", ErrUnsafeNewCluster, state.ClusterToken)
+	}
+	if cfg.Source != nil {
+		return nil
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	token, found, err := cfg.AWS.GetAsgTag(ctx, asgName, clusterTokenTag)
+	if err != nil {
+		return err
+	}
+	if found && token != "" {
+		return fmt.Errorf("%w: %s has the cluster token %s", ErrUnsafeNewCluster, asgName, token)
+	}
+	return nil
+}
+
+func emptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
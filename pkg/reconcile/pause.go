@@ -0,0 +1,70 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// PausedTag set to true on the Autoscaling group, PausedKey set in the
+// cluster or Config.PauseFile present pause the automatic changes: the
+// passes still discover and check the members but neither add nor remove
+// any, and the periodic checks only observe.
+const (
+	PausedTag = "etcdmate:paused"
+	PausedKey = "/etcdmate/paused"
+)
+
+// Paused returns why the automatic changes are paused, empty if they aren't.
+// PausedKey is read from hm when given; without quorum it can't be, so the
+// tag or the file are the switches to use during an outage.
+func (cfg Config) Paused(ctx context.Context, hm etcd.Member) (string, error) {
+	if cfg.PauseFile != "" {
+		if _, err := os.Stat(cfg.PauseFile); err == nil {
+			return fmt.Sprint(cfg.PauseFile, " exists"), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if cfg.Source == nil {
+		asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+		if err != nil {
+			return "", err
+		}
+		value, _, err := cfg.AWS.GetAsgTag(ctx, asgName, PausedTag)
+		if err != nil {
+			return "", err
+		}
+		if strings.EqualFold(value, "true") {
+			return fmt.Sprint(asgName, " is tagged ", PausedTag, "=true"), nil
+		}
+	}
+	if hm.ClientURL == "" {
+		return "", nil
+	}
+	reason, found, err := cfg.Client.GetKey(ctx, hm, PausedKey)
+	if err != nil {
+		cfg.log().Println("Not checking", PausedKey, err)
+		return "", nil
+	}
+	if found {
+		return fmt.Sprint(PausedKey, " is set: ", reason), nil
+	}
+	return "", nil
+}
+
+// checkPaused fails with ErrPaused while the automatic changes are paused
+func (cfg Config) checkPaused(ctx context.Context, hm etcd.Member) error {
+	reason, err := cfg.Paused(ctx, hm)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		cfg.explain("Not changing the membership, paused as %s", reason)
+		return fmt.Errorf("%w: %s", ErrPaused, reason)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// checkPeers dials the peer ports of the started members before the local
+// member is added. A member that can't reach a quorum of its peers is
+// added but never starts, etcd only lists it as unstarted, so a missing
+// security group or network ACL rule is reported here instead.
+func checkPeers(ctx context.Context, cfg Config, existingMembers []etcd.Member, myself etcd.Member) error {
+	started := []etcd.Member{}
+	for _, m := range existingMembers {
+		if m.Name != "" && m.Name != myself.Name {
+			started = append(started, m)
+		}
+	}
+	reachable := 1
+	unreachable := []string{}
+	for i, ok := range cfg.Client.HostReachableAll(ctx, started) {
+		if ok {
+			reachable++
+			continue
+		}
+		unreachable = append(unreachable, fmt.Sprint(started[i].Name, " at ", started[i].PeerURL))
+	}
+	if len(unreachable) == 0 {
+		return nil
+	}
+	// The local member counts towards the quorum of the cluster it joins
+	quorum := (len(existingMembers)+1)/2 + 1
+	message := fmt.Sprint(
+		"Peer ports of ", strings.Join(unreachable, ", "), " unreachable from ", myself.Name,
+		", check the security groups and network ACLs allow the peer port between members",
+	)
+	if reachable < quorum {
+		return fmt.Errorf("%w: %s", ErrPeersUnreachable, message)
+	}
+	cfg.log().Println("Warning:", message)
+	return nil
+}
+
+// warnUnreachablePeers reports the members added but not started yet whose
+// peer port the local member can't reach, the outside view of a new member
+// that won't start
+func warnUnreachablePeers(ctx context.Context, cfg Config, members []etcd.Member) {
+	unstarted := []etcd.Member{}
+	for _, m := range members {
+		if m.Name == "" {
+			unstarted = append(unstarted, m)
+		}
+	}
+	for i, ok := range cfg.Client.HostReachableAll(ctx, unstarted) {
+		if !ok {
+			cfg.log().Println(
+				"Warning: member", unstarted[i].ID, "was added but its peer port at", unstarted[i].PeerURL,
+				"is unreachable, check the security groups and network ACLs allow the peer port between members",
+			)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+type FileChange struct {
+	Path    string `json:"path"`
+	Diff    string `json:"diff"`
+	Content string `json:"-"`
+}
+
+// Plan describes the actions a run would perform without performing them
+type Plan struct {
+	ClusterState    string        `json:"clusterState"`
+	MembersToAdd    []etcd.Member `json:"membersToAdd"`
+	MembersToRemove []etcd.Member `json:"membersToRemove"`
+	Files           []FileChange  `json:"files"`
+	Restarts        []string      `json:"restarts"`
+	Destructive     bool          `json:"destructive"`
+	// LocalActive tells the local member is already running, a run leaves
+	// it be instead of restarting it
+	LocalActive bool `json:"localActive"`
+	// Config is the env file the plan results in, changed or not
+	Config string `json:"-"`
+	// HealthyMember is the member the changes are sent to
+	HealthyMember etcd.Member `json:"-"`
+	// StaleKept are the stale members a run leaves in the cluster, without
+	// RemoveStale or held back by the grace, the confirmation, the cooldown
+	// or the quorum guard
+	StaleKept []etcd.Member `json:"staleKept"`
+	// MembersToUpdate are the members whose peer URL a run changes
+	MembersToUpdate []etcd.Member `json:"membersToUpdate"`
+	// Observer and Proxy tell the local instance is one, a run then only
+	// writes the endpoints or the proxy configuration
+	Observer bool `json:"observer"`
+	Proxy    bool `json:"proxy"`
+}
+
+// Plan runs the steps of Reconcile from state without changing anything, up
+// to the configuration: the same policies decide, while the membership
+// changes and the files written are recorded in the plan instead of made,
+// and no event is emitted. The steps only read the cluster and AWS.
+func (cfg Config) Plan(ctx context.Context, state State) (Plan, error) {
+	plan := Plan{
+		MembersToAdd:    []etcd.Member{},
+		MembersToRemove: []etcd.Member{},
+		StaleKept:       []etcd.Member{},
+		MembersToUpdate: []etcd.Member{},
+		Files:           []FileChange{},
+		Restarts:        []string{},
+	}
+	cfg = cfg.quiet()
+	cfg.plan = &plan
+	cfg.Output = plannedOutput{Output: cfg.output(), plan: &plan}
+	cfg.Changes = cfg.Changes.clone()
+	state = state.restart()
+	for state.Step != StepVerify && state.Step != StepDone {
+		next, err := RunStep(ctx, cfg, &state)
+		if err != nil {
+			return plan, err
+		}
+		state.Step = next
+	}
+	plan.ClusterState = state.ClusterState
+	if plan.ClusterState == "" && !state.Observer && !state.Proxy {
+		// Unchanged since the last run, which only records an existing one
+		plan.ClusterState = "existing"
+	}
+	if plan.Config == "" {
+		current, err := cfg.output().Read()
+		if err != nil {
+			return plan, err
+		}
+		plan.Config = current
+	}
+	plan.HealthyMember = state.HealthyMember
+	plan.LocalActive = state.LocalActive
+	plan.Observer = state.Observer
+	plan.Proxy = state.Proxy
+	plan.Destructive = len(plan.MembersToRemove) > 0
+	return plan, nil
+}
+
+// keep records the members of stale a run keeps, those not in removed
+func (plan *Plan) keep(stale []etcd.Member, removed []etcd.Member) {
+	if plan == nil {
+		return
+	}
+	for _, m := range stale {
+		if !HasMember(removed, m) {
+			plan.StaleKept = append(plan.StaleKept, m)
+		}
+	}
+}
+
+// write records the write of content to path over current, when it changes
+func (plan *Plan) write(path string, current string, content string) {
+	if diff := output.Diff(current, content); diff != "" {
+		plan.Files = append(plan.Files, FileChange{
+			Path:    path,
+			Diff:    diff,
+			Content: content,
+		})
+	}
+}
+
+// plannedOutput records the writes to Output in plan
+type plannedOutput struct {
+	Output
+	plan *Plan
+}
+
+func (o plannedOutput) Write(content string) error {
+	current, err := o.Read()
+	if err != nil {
+		return err
+	}
+	o.plan.Config = content
+	o.plan.write(o.Path(), current, content)
+	return nil
+}
+
+func PrintPlan(w io.Writer, plan Plan, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	switch {
+	case plan.Observer:
+		fmt.Fprintln(w, "Observer, only the endpoints are written")
+	case plan.Proxy:
+		fmt.Fprintln(w, "Proxy, only the proxy configuration is written")
+	default:
+		fmt.Fprintf(w, "Cluster state: %s\n", plan.ClusterState)
+	}
+	for _, m := range plan.MembersToAdd {
+		fmt.Fprintf(w, "  + add member %s (%s)\n", m.Name, m.PeerURL)
+	}
+	for _, m := range plan.MembersToRemove {
+		fmt.Fprintf(w, "  - remove member %s (%s)\n", m.Name, m.ID)
+	}
+	for _, m := range plan.StaleKept {
+		fmt.Fprintf(w, "  = keep stale member %s (%s)\n", m.Name, m.ID)
+	}
+	for _, m := range plan.MembersToUpdate {
+		fmt.Fprintf(w, "  ~ update member %s (%s) to %s\n", m.Name, m.ID, m.PeerURL)
+	}
+	for _, f := range plan.Files {
+		fmt.Fprintf(w, "  ~ write %s\n", f.Path)
+		for _, line := range strings.Split(strings.TrimSuffix(f.Diff, "\n"), "\n") {
+			fmt.Fprintf(w, "      %s\n", line)
+		}
+	}
+	for _, unit := range plan.Restarts {
+		fmt.Fprintf(w, "  ! restart %s\n", unit)
+	}
+	if len(plan.MembersToAdd)+len(plan.MembersToRemove)+len(plan.MembersToUpdate)+len(plan.Files)+len(plan.Restarts) == 0 {
+		fmt.Fprintln(w, "  No changes")
+	}
+	fmt.Fprintf(w, "Destructive: %t\n", plan.Destructive)
+	return nil
+}
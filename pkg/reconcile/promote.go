@@ -0,0 +1,92 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Promote promotes the local member once it caught up, when it joined as a
+// learner. It's for clusters without a leader following the capacity, which
+// would promote it on its own. A canary is left to promote itself after its
+// soak, and with EvenSizeLearner a learner that would make the voting
+// members even stays one.
+func Promote(ctx context.Context, cfg Config, wait time.Duration) error {
+	c := cfg.Client
+	expectedMembers, err := cfg.ExpectedMembers(ctx)
+	if err != nil {
+		return err
+	}
+	myself, err := GetMyself(expectedMembers, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	// Learners don't serve the membership API
+	healthyMember, err := c.FindHealthyMember(ctx, withoutMember(expectedMembers, myself))
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		learner, found, err := localLearner(ctx, cfg, healthyMember, myself)
+		if err != nil {
+			return err
+		}
+		if !found {
+			cfg.log().Println("The local member isn't a learner, nothing to promote")
+			return nil
+		}
+		_, canary, err := c.GetKey(ctx, healthyMember, path.Join(CanaryDir, myself.Name))
+		if err != nil {
+			return err
+		}
+		if canary {
+			cfg.explain("Leaving the promotion of %s to its canary soak", myself.Name)
+			return nil
+		}
+		hold, err := cfg.holdLearner(ctx, healthyMember, false)
+		if err != nil {
+			return err
+		}
+		if hold {
+			cfg.explain("Keeping %s a learner, promoting it would make the voting members even", myself.Name)
+			return nil
+		}
+		// Learners added but not started yet have no name
+		if learner.Name != "" {
+			err = c.PromoteMember(ctx, healthyMember, learner)
+			if err == nil {
+				cfg.changed(ctx, healthyMember, Event{Type: EventScaling, Member: learner, Message: "learner promoted"})
+				return nil
+			}
+			cfg.log().Println("Learner", learner.Name, "not promoted yet:", err)
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = errors.New(fmt.Sprint("The local member didn't start within ", wait))
+			}
+			return err
+		}
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// localLearner returns the learner of myself, if it's one
+func localLearner(ctx context.Context, cfg Config, hm etcd.Member, myself etcd.Member) (etcd.Member, bool, error) {
+	learners, err := cfg.Client.ListLearners(ctx, hm)
+	if err != nil {
+		return etcd.Member{}, false, err
+	}
+	for _, learner := range learners {
+		if HasMember([]etcd.Member{myself}, learner) {
+			return learner, true, nil
+		}
+	}
+	return etcd.Member{}, false, nil
+}
@@ -0,0 +1,99 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// Protection keeps the scale-in protection of the instances of the
+// Autoscaling group in line with their etcd role, so a routine scale-in
+// never terminates the leader or, below ProtectAllBelow members, a member
+// quorum depends on. The leader sets it, on its own instance, and removes
+// it from the other instances, including a previous leader. Protection set
+// by hand on the instances of the group is overwritten.
+type Protection struct {
+	// ProtectAllBelow protects every healthy member while the cluster has
+	// fewer members, 0 only protects the leader
+	ProtectAllBelow int
+}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (p Protection) Check(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		return nil
+	}
+	if err := cfg.checkPaused(ctx, myself); err != nil {
+		return err
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	want := map[string]bool{cfg.InstanceID: true}
+	if len(expectedMembers) < p.ProtectAllBelow {
+		for i, err := range c.CheckHealthAll(ctx, expectedMembers) {
+			if err == nil {
+				want[instanceOf(expectedMembers[i])] = true
+			}
+		}
+	}
+	protect, unprotect := []*string{}, []*string{}
+	for _, instance := range group.Instances {
+		if aws.StringValue(instance.LifecycleState) != "InService" {
+			continue
+		}
+		protected := aws.BoolValue(instance.ProtectedFromScaleIn)
+		switch id := aws.StringValue(instance.InstanceId); {
+		case want[id] && !protected:
+			protect = append(protect, instance.InstanceId)
+		case !want[id] && protected:
+			unprotect = append(unprotect, instance.InstanceId)
+		}
+	}
+	for _, change := range []struct {
+		ids       []*string
+		protected bool
+	}{
+		{protect, true},
+		{unprotect, false},
+	} {
+		if len(change.ids) == 0 {
+			continue
+		}
+		ids := aws.StringValueSlice(change.ids)
+		sort.Strings(ids)
+		message := fmt.Sprint("Protecting ", ids, " from scale-in")
+		if !change.protected {
+			message = fmt.Sprint("Removing the scale-in protection of ", ids)
+		}
+		cfg.log().Println(message)
+		_, err := cfg.AWS.AutoScaling.SetInstanceProtectionWithContext(ctx, &autoscaling.SetInstanceProtectionInput{
+			AutoScalingGroupName: &asgName,
+			InstanceIds:          change.ids,
+			ProtectedFromScaleIn: aws.Bool(change.protected),
+		})
+		if err != nil {
+			return err
+		}
+		cfg.emit(Event{Type: EventScaling, Message: message})
+	}
+	return nil
+}
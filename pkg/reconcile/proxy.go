@@ -0,0 +1,93 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+// configureProxy points the proxy at the started voting members that are
+// still discovered and healthy, so it never keeps a removed member nor one
+// whose instance is gone. The configuration is only written when it
+// changes.
+func (cfg Config) configureProxy(ctx context.Context, state *State) error {
+	state.Proxy = true
+	c := cfg.Client
+	members, err := cfg.source().GetMembers(ctx, cfg.InstanceID, cfg.URLs)
+	if err != nil {
+		return err
+	}
+	if err := cfg.writeEndpoints(members); err != nil {
+		return err
+	}
+	hm, err := c.FindHealthyMember(ctx, members.Voters)
+	if err != nil {
+		return err
+	}
+	registered, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return err
+	}
+	learners, err := c.ListLearners(ctx, hm)
+	if err != nil {
+		return err
+	}
+	discovered := map[string]bool{}
+	for _, m := range members.Voters {
+		discovered[m.PeerURL] = true
+	}
+	for _, l := range learners {
+		delete(discovered, l.PeerURL)
+	}
+	endpoints := []etcd.Member{}
+	for _, m := range registered {
+		if m.ClientURL != "" && discovered[m.PeerURL] {
+			endpoints = append(endpoints, m)
+		}
+	}
+	if len(endpoints) == 0 {
+		return errors.New("No started member of the cluster is discovered, keeping the proxy configuration")
+	}
+	endpoints = cfg.healthyEndpoints(ctx, endpoints)
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	state.ExpectedMembers = endpoints
+	cfg.explain("The proxy endpoints are the started voting members still discovered: %s", strings.Join(memberNames(endpoints), ", "))
+	content, err := output.RenderProxy(*cfg.Proxy, endpoints)
+	if err != nil {
+		return err
+	}
+	current, err := cfg.output().Read()
+	if err != nil {
+		return err
+	}
+	if current == content {
+		cfg.log().Println("Proxy endpoints unchanged")
+		return nil
+	}
+	cfg.log().Println("Writing the proxy configuration to", cfg.output().Path())
+	return cfg.output().Write(content)
+}
+
+// healthyEndpoints leaves out the endpoints failing their health check, all
+// of them are kept when none passes, the proxy being no better off with
+// fewer. The next pass adds a member back once healthy.
+func (cfg Config) healthyEndpoints(ctx context.Context, endpoints []etcd.Member) []etcd.Member {
+	c := cfg.Client
+	errs := c.CheckHealthAll(ctx, endpoints)
+	healthy := []etcd.Member{}
+	for i, m := range endpoints {
+		if errs[i] != nil {
+			cfg.explain("Leaving %s out of the proxy endpoints, it is unhealthy: %v", m.Name, errs[i])
+			continue
+		}
+		healthy = append(healthy, m)
+	}
+	if len(healthy) == 0 {
+		return endpoints
+	}
+	return healthy
+}
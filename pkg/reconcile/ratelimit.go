@@ -0,0 +1,54 @@
+package reconcile
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChangeLimiter caps the members added and removed within a sliding Window,
+// so a discovery gone wrong can't churn the whole membership in a few
+// passes. Once Max changes were made, the next ones fail with
+// ErrRateLimited until the oldest leaves the window, and the following
+// passes recheck the cluster before going on. A nil ChangeLimiter doesn't
+// limit.
+type ChangeLimiter struct {
+	Max    int
+	Window time.Duration
+
+	mu      sync.Mutex
+	changes []time.Time
+}
+
+// clone returns a ChangeLimiter with the changes of l, for a plan to count
+// its changes without using up those of the runs
+func (l *ChangeLimiter) clone() *ChangeLimiter {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &ChangeLimiter{Max: l.Max, Window: l.Window, changes: append([]time.Time{}, l.changes...)}
+}
+
+// allow records a change made at now when the limit allows it
+func (l *ChangeLimiter) allow(now time.Time) error {
+	if l == nil || l.Max <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recent := l.changes[:0]
+	for _, t := range l.changes {
+		if now.Sub(t) < l.Window {
+			recent = append(recent, t)
+		}
+	}
+	l.changes = recent
+	if len(l.changes) >= l.Max {
+		next := l.changes[0].Add(l.Window).Sub(now).Round(time.Second)
+		return fmt.Errorf("%w: %d membership changes within %s, next one in %s", ErrRateLimited, l.Max, l.Window, next)
+	}
+	l.changes = append(l.changes, now)
+	return nil
+}
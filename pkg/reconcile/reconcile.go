@@ -0,0 +1,629 @@
+// Package reconcile implements the etcdmate workflows on top of the
+// discovery, etcd and output packages: joining the local instance, and
+// bootstrapping, scaling, rolling out, recovering and restoring the cluster.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/metrics"
+	"github.com/viruxel/etcdmate/pkg/tracing"
+)
+
+// Reconcile runs the join workflow, resuming from the persisted state
+func Reconcile(ctx context.Context, cfg Config) (_ State, err error) {
+	ctx, span := tracing.Start(ctx, "reconcile")
+	defer func() { span.End(err) }()
+	defer observeRun(cfg.Metrics, time.Now(), &err)
+	state, err := cfg.LoadState(ctx)
+	if err != nil {
+		return state, err
+	}
+	state = cfg.checkResume(ctx, state)
+	for state.Step != StepDone {
+		cfg.log().Printf("Running step %s\n", state.Step)
+		stop := cfg.Summary.Time(stepPhases[state.Step])
+		stepCtx, stepSpan := tracing.Start(ctx, fmt.Sprint("reconcile.", state.Step))
+		stepStart := time.Now()
+		next, err := RunStep(stepCtx, cfg, &state)
+		cfg.Metrics.Set(
+			"etcdmate_step_duration_seconds",
+			time.Since(stepStart).Seconds(),
+			metrics.Labels{"step": string(state.Step)},
+		)
+		stepSpan.End(err)
+		stop()
+		if err != nil {
+			cfg.emit(Event{Type: EventReconcileError, Err: err})
+			return state, err
+		}
+		state.Step = next
+		err = cfg.SaveState(ctx, state)
+		if err != nil {
+			return state, err
+		}
+	}
+	cfg.Summary.decide(decision(state))
+	observeMembers(cfg.Metrics, state)
+	return state, nil
+}
+
+// observeRun records the outcome and duration of a Reconcile call
+func observeRun(m *metrics.Registry, start time.Time, err *error) {
+	result := "success"
+	if *err != nil {
+		result = "error"
+		m.Set("etcdmate_last_error_timestamp_seconds", float64(time.Now().Unix()), nil)
+	} else {
+		m.Set("etcdmate_last_success_timestamp_seconds", float64(time.Now().Unix()), nil)
+	}
+	m.Add("etcdmate_reconcile_runs_total", 1, metrics.Labels{"result": result})
+	m.Set("etcdmate_reconcile_duration_seconds", time.Since(start).Seconds(), nil)
+}
+
+// observeMembers records how many members are expected and how many the
+// run found registered, when it looked
+func observeMembers(m *metrics.Registry, state State) {
+	m.Set("etcdmate_members_expected", float64(len(state.ExpectedMembers)), nil)
+	if state.ClusterState == "existing" {
+		m.Set("etcdmate_members_registered", float64(len(state.ExistingMembers)), nil)
+	}
+}
+
+// decision describes the outcome of a complete run
+func decision(state State) string {
+	switch {
+	case state.Observer:
+		return "observer, only the endpoints were written"
+	case state.Proxy:
+		return "proxy, only the proxy configuration was written"
+	case state.LocalActive:
+		return "local member already active, configuration kept"
+	case state.ClusterState == "":
+		return "expected members unchanged, nothing to do"
+	}
+	return fmt.Sprint("configured to join the ", state.ClusterState, " cluster")
+}
+
+func RunStep(ctx context.Context, cfg Config, state *State) (Step, error) {
+	c := cfg.Client
+	switch state.Step {
+	case StepDiscover:
+		if cfg.Proxy != nil {
+			if err := cfg.configureProxy(ctx, state); err != nil {
+				return state.Step, err
+			}
+			return StepDone, nil
+		}
+		members, myself, err := cfg.discoverMyself(ctx)
+		if err == nil || errors.Is(err, ErrObserver) {
+			if werr := cfg.writeEndpoints(members); werr != nil {
+				return state.Step, werr
+			}
+			if rerr := cfg.updateRecords(ctx, members); rerr != nil {
+				return state.Step, rerr
+			}
+		}
+		state.Observer = errors.Is(err, ErrObserver)
+		if state.Observer {
+			cfg.explain("The local instance is tagged %s=%s, it only gets the endpoints", discovery.RoleTag, discovery.RoleObserver)
+			return StepDone, nil
+		}
+		if err != nil {
+			return state.Step, err
+		}
+		expectedMembers := members.Voters
+		state.ExpectedMembers = expectedMembers
+		state.Observers = members.Observers
+		state.Myself = myself
+		warnZoneBalance(cfg, expectedMembers)
+		if err := cfg.checkZoneSpread(expectedMembers); err != nil {
+			return state.Step, err
+		}
+		if err := cfg.checkEvenSize(expectedMembers); err != nil {
+			return state.Step, err
+		}
+		cfg.explain(
+			"Expected members are %s: %s",
+			cfg.source().Describe(),
+			strings.Join(memberNames(expectedMembers), ", "),
+		)
+		if state.AppliedMembers != nil && sameMembers(expectedMembers, state.AppliedMembers) {
+			current, err := cfg.output().Read()
+			if err != nil {
+				return state.Step, err
+			}
+			if current == state.AppliedConfig {
+				cfg.log().Println("Expected members unchanged since the last run")
+				cfg.explain("Nothing to do, the expected members and the env file are those of the last successful run")
+				return StepDone, nil
+			}
+		}
+		return StepHealthCheck, nil
+	case StepHealthCheck:
+		if members, ok := LocalActive(ctx, &c, state.Myself); ok {
+			cfg.log().Println("Local member already active, only verifying")
+			cfg.explain(
+				"Existing cluster, the local etcd at %s is healthy and listed as started member %s",
+				state.Myself.ClientURL,
+				state.Myself.Name,
+			)
+			state.LocalActive = true
+			state.HealthyMember = state.Myself
+			state.ExistingMembers = members
+			state.ClusterState = "existing"
+			warnUnreachablePeers(ctx, cfg, members)
+			return StepRemoveStale, nil
+		}
+		healthyMember, err := c.FindHealthyMember(ctx, state.ExpectedMembers)
+		if err != nil && !errors.Is(err, etcd.ErrNoHealthyMember) {
+			return state.Step, err
+		}
+		if err != nil {
+			if gerr := cfg.checkNewCluster(ctx, state); gerr != nil {
+				cfg.explain("Not assuming a new cluster although no member is healthy: %v", gerr)
+				return state.Step, gerr
+			}
+			if cfg.WaitForCapacity {
+				waited, werr := cfg.waitForCapacity(ctx, len(state.ExpectedMembers))
+				if werr != nil {
+					return state.Step, werr
+				}
+				if waited {
+					// The instances that came meanwhile may run etcd already
+					return StepDiscover, nil
+				}
+			}
+			cfg.log().Println(err)
+			cfg.explain(
+				"New cluster, none of the %d expected members runs etcd, no cluster token is saved or published and the local data dir is empty: %v",
+				len(state.ExpectedMembers),
+				err,
+			)
+			cfg.emit(Event{
+				Type:    EventBootstrapDecision,
+				Message: "No healthy member found, assuming new cluster",
+			})
+			state.ClusterState = "new"
+			return StepWriteConfig, nil
+		}
+		existingMembers, err := c.ListMembers(ctx, healthyMember)
+		if err != nil {
+			// A healthy member means the cluster exists, try again later
+			return state.Step, err
+		}
+		cfg.explain(
+			"Existing cluster, %s answered /health with health true and lists the members %s",
+			healthyMember.Name,
+			strings.Join(memberNames(existingMembers), ", "),
+		)
+		err = checkIdentities(ctx, cfg, withoutMember(state.ExpectedMembers, state.Myself))
+		if err != nil {
+			return state.Step, err
+		}
+		err = checkVersion(ctx, cfg, healthyMember)
+		if err != nil {
+			return state.Step, err
+		}
+		state.HealthyMember = healthyMember
+		state.ExistingMembers = existingMembers
+		state.ClusterState = "existing"
+		return StepRemoveStale, nil
+	case StepRemoveStale:
+		if err := cfg.reregister(ctx, state); err != nil {
+			return state.Step, err
+		}
+		stale, err := cfg.staleWithOwner(state)
+		if err != nil {
+			return state.Step, err
+		}
+		stale = cfg.leaderLast(ctx, state.HealthyMember, stale)
+		if !cfg.RemoveStale {
+			for _, m := range stale {
+				cfg.log().Println("Would remove stale member", m.Name, m.PeerURL, "with --remove-stale or --force")
+			}
+			state.StaleKept = len(stale) > 0
+			cfg.plan.keep(stale, nil)
+			return StepAddSelf, nil
+		}
+		due, err := cfg.confirmed(ctx, state, cfg.pastGrace(state, stale))
+		if err != nil {
+			return state.Step, err
+		}
+		state.StaleKept = len(due) < len(stale)
+		cfg.plan.keep(stale, due)
+		stale = due
+		if len(stale) > 0 {
+			if err := cfg.checkPaused(ctx, state.HealthyMember); err != nil {
+				return state.Step, err
+			}
+			cooling, err := cfg.coolingDown(ctx)
+			if err != nil {
+				return state.Step, err
+			}
+			if cooling != "" {
+				cfg.log().Println("Keeping", len(stale), "stale members,", cooling)
+				state.StaleKept = true
+				cfg.plan.keep(stale, nil)
+				return StepAddSelf, nil
+			}
+		}
+		safe, err := cfg.guardRemovals(ctx, state.HealthyMember, state.ExistingMembers, stale)
+		if err != nil {
+			return state.Step, err
+		}
+		if len(safe) < len(stale) {
+			state.StaleKept = true
+		}
+		cfg.plan.keep(stale, safe)
+		stale = safe
+		for i, m := range stale {
+			if i > 0 && cfg.plan == nil {
+				// Each removal changes the quorum, let the cluster settle
+				// before the next one
+				err := WaitHealthy(ctx, c, []etcd.Member{state.HealthyMember}, removalSettleWait)
+				if err != nil {
+					return state.Step, err
+				}
+			}
+			cfg.explain(
+				"Removing %s (%s), it is a cluster member but no expected member has its name or peer URL",
+				m.Name,
+				m.PeerURL,
+			)
+			err := cfg.removeMember(ctx, state.HealthyMember, m)
+			if err != nil {
+				return state.Step, err
+			}
+			state.ExistingMembers = withoutID(state.ExistingMembers, m.ID)
+		}
+		return StepAddSelf, nil
+	case StepAddSelf:
+		owner, found, err := previousOwner(state.ExistingMembers, state.Myself, cfg.DataDir)
+		if err != nil {
+			return state.Step, err
+		}
+		if found {
+			// Kept by the removal policy, adopting it would start an etcd
+			// without its data
+			return state.Step, fmt.Errorf(
+				"%w: %s (%s) holds %s, the local data dir is empty",
+				ErrPeerURLTaken,
+				owner.Name,
+				owner.ID,
+				owner.PeerURL,
+			)
+		}
+		if HasMember(state.ExistingMembers, state.Myself) {
+			cfg.explain("Not adding the local member, %s is already registered", state.Myself.PeerURL)
+			err := updatePeerURL(ctx, cfg, state.HealthyMember, state.ExistingMembers, state.Myself)
+			if err != nil {
+				return state.Step, err
+			}
+		} else {
+			cfg.explain("Adding the local member, no cluster member has the peer URL %s", state.Myself.PeerURL)
+			if err := cfg.checkPaused(ctx, state.HealthyMember); err != nil {
+				return state.Step, err
+			}
+			err := checkPeers(ctx, cfg, state.ExistingMembers, state.Myself)
+			if err != nil {
+				return state.Step, err
+			}
+			added, err := cfg.addSelf(ctx, state.HealthyMember, state.Myself)
+			if errors.Is(err, etcd.ErrUnstartedMembers) {
+				cfg.explain("etcd refuses the addition while members added before haven't started, --remove-stale removes the stale ones and --lock-backend makes concurrent joins take turns")
+			}
+			if err != nil {
+				return state.Step, err
+			}
+			if added {
+				cfg.changed(ctx, state.HealthyMember, Event{Type: EventMemberAdded, Member: state.Myself})
+			}
+		}
+		return StepWriteConfig, nil
+	case StepWriteConfig:
+		if state.LocalActive {
+			return StepVerify, nil
+		}
+		content, err := cfg.render(
+			state.ExpectedMembers,
+			state.Myself,
+			state.ClusterState,
+			state.ClusterToken,
+			false,
+		)
+		if err != nil {
+			return state.Step, err
+		}
+		err = cfg.output().Write(content)
+		if err != nil {
+			return state.Step, err
+		}
+		return StepVerify, nil
+	case StepVerify:
+		if state.ClusterState == "existing" {
+			members, err := c.ListMembers(ctx, state.HealthyMember)
+			if err != nil {
+				return state.Step, err
+			}
+			if !HasMember(members, state.Myself) {
+				return state.Step, errors.New(fmt.Sprint(
+					"Member not registered in cluster ", state.Myself.Name,
+				))
+			}
+			for _, m := range members {
+				if HasMember([]etcd.Member{m}, state.Myself) {
+					state.Registered = m
+				}
+			}
+		}
+		// Only a confirmed membership is worth skipping the next runs for
+		state.AppliedMembers = nil
+		state.AppliedConfig = ""
+		if state.ClusterState == "existing" && !state.StaleKept {
+			current, err := cfg.output().Read()
+			if err != nil {
+				return state.Step, err
+			}
+			state.AppliedMembers = state.ExpectedMembers
+			state.AppliedConfig = current
+		}
+		return StepDone, nil
+	}
+	return state.Step, errors.New(fmt.Sprint("Unknown step ", state.Step))
+}
+
+// updatePeerURL changes the peer URL the local member is registered with
+// when it no longer is the expected one, e.g. once its tags moved it to
+// https. Members are matched by name so the change isn't taken for a new
+// member.
+func updatePeerURL(ctx context.Context, cfg Config, hm etcd.Member, members []etcd.Member, myself etcd.Member) error {
+	for _, m := range members {
+		if myself.Name == "" || m.Name != myself.Name || m.SamePeerURLs(myself) {
+			continue
+		}
+		cfg.explain(
+			"Updating the peer URL of the local member from %s to %s",
+			strings.Join(m.AllPeerURLs(), ","),
+			strings.Join(myself.AllPeerURLs(), ","),
+		)
+		if err := cfg.checkPaused(ctx, hm); err != nil {
+			return err
+		}
+		m.PeerURL, m.PeerURLs = myself.PeerURL, myself.PeerURLs
+		return cfg.updateMember(ctx, hm, m)
+	}
+	return nil
+}
+
+// updateMember changes the peer URLs m is registered with, or plans it
+func (cfg Config) updateMember(ctx context.Context, hm etcd.Member, m etcd.Member) error {
+	if cfg.plan != nil {
+		cfg.plan.MembersToUpdate = append(cfg.plan.MembersToUpdate, m)
+		return nil
+	}
+	return cfg.Client.UpdateMember(ctx, hm, m)
+}
+
+// checkIdentities verifies the certificates of the reachable members match
+// their addresses, failing only with IdentityFail
+func checkIdentities(ctx context.Context, cfg Config, members []etcd.Member) error {
+	if cfg.IdentityCheck == IdentityOff {
+		return nil
+	}
+	for _, err := range cfg.Client.CheckIdentityAll(ctx, members) {
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, etcd.ErrIdentityMismatch) && cfg.IdentityCheck == IdentityFail {
+			return err
+		}
+		cfg.log().Println("Warning:", err)
+	}
+	return nil
+}
+
+func StaleMembers(
+	expectedMembers []etcd.Member,
+	existingMembers []etcd.Member,
+) []etcd.Member {
+	stale := []etcd.Member{}
+	for _, exiM := range existingMembers {
+		if !HasMember(expectedMembers, exiM) {
+			stale = append(stale, exiM)
+		}
+	}
+	return stale
+}
+
+func GetMyself(expectedMembers []etcd.Member, insId string) (etcd.Member, error) {
+	for _, member := range expectedMembers {
+		if instanceOf(member) == insId {
+			return member, nil
+		}
+	}
+	return etcd.Member{}, fmt.Errorf("%w: %s", ErrNotExpectedMember, insId)
+}
+
+// LocalActive reports whether the local etcd is healthy and already a
+// started member of the cluster, returning the cluster members if so.
+func LocalActive(ctx context.Context, c EtcdClient, myself etcd.Member) ([]etcd.Member, bool) {
+	if _, err := c.FindHealthyMember(ctx, []etcd.Member{myself}); err != nil {
+		return nil, false
+	}
+	members, err := c.ListMembers(ctx, myself)
+	if err != nil {
+		return nil, false
+	}
+	for _, m := range members {
+		// Only started members have a name
+		if m.Name == myself.Name {
+			return members, true
+		}
+	}
+	return nil, false
+}
+
+// AddMember registers m, retrying for a while when the cluster can't take
+// the change. It reports false if m was already registered, which can
+// happen when a previous run was interrupted right after adding it.
+func AddMember(
+	ctx context.Context,
+	c EtcdClient,
+	logger logging.Logger,
+	hm etcd.Member,
+	m etcd.Member,
+) (bool, error) {
+	logger = logging.OrDefault(logger)
+	for attempt := 1; ; attempt++ {
+		err := c.AddMember(ctx, hm, m)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, etcd.ErrMemberConflict):
+			logger.Println("Member already registered, adopting it", m.PeerURL)
+			return false, nil
+		case errors.Is(err, etcd.ErrClusterUnhealthy) && attempt < addMemberAttempts:
+			logger.Println(err)
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return false, err
+			}
+		default:
+			return false, err
+		}
+	}
+}
+
+const addMemberAttempts = 6
+
+// RemoveMember unregisters m, retrying for a while when the cluster can't
+// take the change like AddMember. It reports false if m was already gone,
+// e.g. removed by a previous attempt whose answer was lost.
+func RemoveMember(
+	ctx context.Context,
+	c EtcdClient,
+	logger logging.Logger,
+	hm etcd.Member,
+	m etcd.Member,
+) (bool, error) {
+	logger = logging.OrDefault(logger)
+	for attempt := 1; ; attempt++ {
+		err := c.RemoveMember(ctx, hm, m)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, etcd.ErrMemberNotFound):
+			logger.Println("Member already removed", m.PeerURL)
+			return false, nil
+		case errors.Is(err, etcd.ErrClusterUnhealthy) && attempt < addMemberAttempts:
+			logger.Println(err)
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return false, err
+			}
+		default:
+			return false, err
+		}
+	}
+}
+
+// addSelf registers the local member, as a learner with LearnerJoin or
+// while a voter would make the voting members even, within the limit of
+// Changes and holding AddLock
+func (cfg Config) addSelf(ctx context.Context, hm etcd.Member, myself etcd.Member) (bool, error) {
+	if err := cfg.Changes.allow(cfg.now()); err != nil {
+		return false, err
+	}
+	if cfg.plan != nil {
+		cfg.plan.MembersToAdd = append(cfg.plan.MembersToAdd, myself)
+		return false, nil
+	}
+	entry, err := cfg.lockAdd(ctx, hm, myself)
+	if err != nil {
+		return false, err
+	}
+	added, err := cfg.addMember(ctx, hm, myself)
+	if entry != nil && (err != nil || !added) {
+		cfg.unlockAdd(ctx, *entry)
+	}
+	return added, err
+}
+
+func (cfg Config) addMember(ctx context.Context, hm etcd.Member, myself etcd.Member) (bool, error) {
+	c := cfg.Client
+	hold, err := cfg.holdLearner(ctx, hm, true)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case hold:
+		cfg.explain("Adding the local member as a learner, a voter would make the voting members even")
+	case !cfg.LearnerJoin:
+		return AddMember(ctx, &c, cfg.log(), hm, myself)
+	default:
+		cfg.explain("Adding the local member as a learner, the leader promotes it once it caught up")
+	}
+	_, err = c.AddLearner(ctx, hm, myself)
+	if errors.Is(err, etcd.ErrMemberConflict) {
+		cfg.log().Println("Member already registered, adopting it", myself.PeerURL)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// instanceOf returns the instance ID of a discovered member, members
+// discovered before names could be templated are named after it
+func instanceOf(m etcd.Member) string {
+	if m.Instance != "" {
+		return m.Instance
+	}
+	return m.Name
+}
+
+func HasMember(members []etcd.Member, m etcd.Member) bool {
+	for _, member := range members {
+		// Members added but not started yet have no name
+		if (m.Name != "" && member.Name == m.Name) || member.PeerURL == m.PeerURL {
+			return true
+		}
+	}
+	return false
+}
+
+func sameMembers(a []etcd.Member, b []etcd.Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || !a[i].SamePeerURLs(b[i]) || a[i].ClientURL != b[i].ClientURL {
+			return false
+		}
+	}
+	return true
+}
+
+func withoutMember(members []etcd.Member, m etcd.Member) []etcd.Member {
+	result := []etcd.Member{}
+	for _, member := range members {
+		if member.Name != m.Name {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// sleep waits for d, returning early when the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
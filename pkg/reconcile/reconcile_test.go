@@ -0,0 +1,713 @@
+package reconcile_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	etcdfake "github.com/viruxel/etcdmate/pkg/etcd/fake"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/output"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+// testURLs avoid the ports of an etcd running locally
+var testURLs = discovery.URLs{
+	ClientSchema: "http",
+	ClientPort:   22379,
+	PeerSchema:   "http",
+	PeerPort:     22380,
+}
+
+// world is an Autoscaling group of fake instances i-1, i-2... at
+// 127.0.20.1, 127.0.20.2... and the fake etcd cluster they form
+type world struct {
+	t       *testing.T
+	aws     *fake.AWS
+	cluster *etcdfake.Cluster
+	dir     string
+}
+
+func newWorld(t *testing.T, instances int) *world {
+	w := &world{t: t, aws: fake.New(), cluster: etcdfake.NewCluster(), dir: t.TempDir()}
+	t.Cleanup(w.cluster.Close)
+	w.aws.AddGroup("etcd", 1, 9)
+	for i := 1; i <= instances; i++ {
+		w.aws.Launch("etcd", fmt.Sprint("i-", i), w.ip(i))
+	}
+	return w
+}
+
+func (w *world) ip(i int) string {
+	return fmt.Sprint("127.0.20.", i)
+}
+
+func (w *world) peerURL(i int) string {
+	return fmt.Sprint("http://", w.ip(i), ":", testURLs.PeerPort)
+}
+
+// start runs the etcd of the member name at the address of instance i
+func (w *world) start(name string, i int) {
+	clientURL := fmt.Sprint("http://", w.ip(i), ":", testURLs.ClientPort)
+	if _, err := w.cluster.Start(name, w.peerURL(i), clientURL); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// member is the member the instance i is expected as
+func (w *world) member(i int) etcd.Member {
+	return etcd.Member{
+		Name:      fmt.Sprint("i-", i),
+		PeerURL:   w.peerURL(i),
+		ClientURL: fmt.Sprint("http://", w.ip(i), ":", testURLs.ClientPort),
+	}
+}
+
+func (w *world) config(instanceID string) reconcile.Config {
+	client, err := etcd.New(
+		etcd.WithLogger(logging.Discard),
+		etcd.WithTimeout(2*time.Second),
+		etcd.WithDialTimeout(time.Second),
+	)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return reconcile.Config{
+		AWS:        w.aws.Services(),
+		Client:     client,
+		URLs:       testURLs,
+		InstanceID: instanceID,
+		StateFile:  path.Join(w.dir, instanceID+".state"),
+		EnvFile:    path.Join(w.dir, instanceID+".env"),
+		Logger:     logging.Discard,
+	}
+}
+
+func (w *world) members() []string {
+	names := []string{}
+	for _, m := range w.cluster.Members() {
+		if m.Name == "" {
+			names = append(names, "unstarted "+m.PeerURL)
+		} else {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+func TestReconcile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// setup starts the cluster, the local instance is i-3
+		setup       func(w *world)
+		removeStale bool
+		forceRemove bool
+		maxRemovals int
+		staleGrace  time.Duration
+		dataDir     bool
+		state       string
+		err         error
+		members     []string
+	}{
+		{
+			name:    "new cluster",
+			setup:   func(w *world) {},
+			state:   "new",
+			members: []string{},
+		},
+		{
+			name: "new cluster beside a member waiting for the others",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.cluster.SetHealthy("i-1", false)
+				w.cluster.SetLeader("")
+			},
+			state:   "new",
+			members: []string{"i-1"},
+		},
+		{
+			name: "no new cluster beside a member of a formed one",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.cluster.SetHealthy("i-1", false)
+			},
+			err:     reconcile.ErrUnsafeNewCluster,
+			members: []string{"i-1"},
+		},
+		{
+			name: "join",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+			},
+			state:   "existing",
+			members: []string{"i-1", "i-2", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "stale member removed",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			removeStale: true,
+			state:       "existing",
+			members:     []string{"i-1", "i-2", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "stale member kept without RemoveStale",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			state:   "existing",
+			members: []string{"i-1", "i-2", "i-gone", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "stale member kept within the grace",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			removeStale: true,
+			staleGrace:  time.Hour,
+			state:       "existing",
+			members:     []string{"i-1", "i-2", "i-gone", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			// Members running beside the group, e.g. of instances AWS
+			// reports out of service
+			name: "stale members removed up to the limit",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 8)
+				w.start("i-lost", 9)
+			},
+			removeStale: true,
+			maxRemovals: 1,
+			state:       "existing",
+			members:     []string{"i-1", "i-2", "i-lost", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "stale members removed beyond the limit with ForceRemove",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 8)
+				w.start("i-lost", 9)
+			},
+			removeStale: true,
+			maxRemovals: 1,
+			forceRemove: true,
+			state:       "existing",
+			members:     []string{"i-1", "i-2", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			// AWS reports a healthy instance out of service, removing its
+			// member would leave i-1 alone of two voters
+			name: "healthy stale member kept by the quorum guard",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-healthy", 8)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			removeStale: true,
+			state:       "existing",
+			members:     []string{"i-1", "i-healthy", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "local instance not in service",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.aws.SetLifecycleState("i-3", "Pending")
+			},
+			err:     reconcile.ErrNotExpectedMember,
+			members: []string{"i-1", "i-2"},
+		},
+		{
+			name: "previous owner of the local peer URL replaced",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-old", 3)
+				w.cluster.Stop("i-old")
+			},
+			removeStale: true,
+			dataDir:     true,
+			state:       "existing",
+			members:     []string{"i-1", "i-2", "unstarted http://127.0.20.3:22380"},
+		},
+		{
+			name: "previous owner of the local peer URL kept without RemoveStale",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-old", 3)
+				w.cluster.Stop("i-old")
+			},
+			dataDir: true,
+			err:     reconcile.ErrPeerURLTaken,
+			members: []string{"i-1", "i-2", "i-old"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWorld(t, 3)
+			tc.setup(w)
+			cfg := w.config("i-3")
+			cfg.RemoveStale = tc.removeStale
+			cfg.ForceRemove = tc.forceRemove
+			cfg.MaxRemovals = tc.maxRemovals
+			cfg.StaleGrace = tc.staleGrace
+			if tc.dataDir {
+				cfg.DataDir = path.Join(w.dir, "data")
+			}
+			state, err := reconcile.Reconcile(context.Background(), cfg)
+			if !errors.Is(err, tc.err) || (err != nil && tc.err == nil) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if got := w.members(); strings.Join(got, ",") != strings.Join(tc.members, ",") {
+				t.Errorf("got members %v, want %v", got, tc.members)
+			}
+			if tc.err != nil {
+				return
+			}
+			if state.ClusterState != tc.state {
+				t.Errorf("got cluster state %q, want %q", state.ClusterState, tc.state)
+			}
+			env, err := ioutil.ReadFile(cfg.EnvFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "ETCD_INITIAL_CLUSTER_STATE=" + tc.state
+			if !strings.Contains(string(env), want) {
+				t.Errorf("env file lacks %s:\n%s", want, env)
+			}
+			for i := 1; i <= 3; i++ {
+				member := fmt.Sprint("i-", i, "=", w.peerURL(i))
+				if !strings.Contains(string(env), member) {
+					t.Errorf("env file lacks the member %s:\n%s", member, env)
+				}
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		setup       func(w *world)
+		removeStale bool
+		maxRemovals int
+		staleGrace  time.Duration
+		// observer and proxy make the local instance one
+		observer    bool
+		proxy       bool
+		state       string
+		add         int
+		remove      int
+		kept        int
+		destructive bool
+	}{
+		{
+			name:  "new cluster",
+			setup: func(w *world) {},
+			state: "new",
+		},
+		{
+			name: "join",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+			},
+			state: "existing",
+			add:   1,
+		},
+		{
+			name: "stale member removed",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			removeStale: true,
+			state:       "existing",
+			add:         1,
+			remove:      1,
+			destructive: true,
+		},
+		{
+			name: "stale member kept without RemoveStale",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			state: "existing",
+			add:   1,
+			kept:  1,
+		},
+		{
+			name: "stale members kept by the removal guard",
+			setup: func(w *world) {
+				w.aws.Launch("etcd", "i-4", w.ip(4))
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-4", 4)
+				w.start("i-gone", 8)
+				w.start("i-lost", 9)
+				w.cluster.Stop("i-gone")
+				w.cluster.Stop("i-lost")
+			},
+			removeStale: true,
+			maxRemovals: 1,
+			state:       "existing",
+			add:         1,
+			remove:      1,
+			kept:        1,
+			destructive: true,
+		},
+		{
+			name: "stale member kept within the grace",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+				w.start("i-gone", 9)
+				w.cluster.Stop("i-gone")
+			},
+			removeStale: true,
+			staleGrace:  time.Hour,
+			state:       "existing",
+			add:         1,
+			kept:        1,
+		},
+		{
+			name: "observer",
+			setup: func(w *world) {
+				w.aws.Instance("i-3").Tags = map[string]string{discovery.RoleTag: discovery.RoleObserver}
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+			},
+			observer: true,
+		},
+		{
+			name: "proxy",
+			setup: func(w *world) {
+				w.start("i-1", 1)
+				w.start("i-2", 2)
+			},
+			proxy: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWorld(t, 3)
+			tc.setup(w)
+			before := w.members()
+			cfg := w.config("i-3")
+			cfg.RemoveStale = tc.removeStale
+			cfg.MaxRemovals = tc.maxRemovals
+			cfg.StaleGrace = tc.staleGrace
+			cfg.EndpointsFile = path.Join(w.dir, "endpoints")
+			if tc.proxy {
+				cfg.Proxy = &output.Proxy{Mode: "gateway", ListenAddr: "127.0.0.1:23790"}
+			}
+			plan, err := cfg.Reconciler("").Plan(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.ClusterState != tc.state {
+				t.Errorf("got cluster state %q, want %q", plan.ClusterState, tc.state)
+			}
+			if len(plan.MembersToAdd) != tc.add || len(plan.MembersToRemove) != tc.remove || len(plan.StaleKept) != tc.kept {
+				t.Errorf(
+					"got %d additions, %d removals and %d kept, want %d, %d and %d",
+					len(plan.MembersToAdd), len(plan.MembersToRemove), len(plan.StaleKept),
+					tc.add, tc.remove, tc.kept,
+				)
+			}
+			if plan.Destructive != tc.destructive {
+				t.Errorf("got destructive %t, want %t", plan.Destructive, tc.destructive)
+			}
+			if plan.Observer != tc.observer || plan.Proxy != tc.proxy {
+				t.Errorf("got observer %t and proxy %t, want %t and %t", plan.Observer, plan.Proxy, tc.observer, tc.proxy)
+			}
+			// The endpoints, and the env file or the proxy configuration
+			// unless observing
+			files := 2
+			if tc.observer {
+				files = 1
+			}
+			if len(plan.Files) != files {
+				t.Errorf("got %d files to write, want %d", len(plan.Files), files)
+			}
+			if after := w.members(); strings.Join(after, ",") != strings.Join(before, ",") {
+				t.Errorf("the plan changed the members from %v to %v", before, after)
+			}
+			for _, file := range []string{cfg.EnvFile, cfg.EndpointsFile} {
+				if _, err := ioutil.ReadFile(file); err == nil {
+					t.Error("the plan wrote", file)
+				}
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expected []int
+		age      time.Duration
+		// state is the cluster state in the env file, new when the run
+		// resumed the interrupted one, existing when it started over
+		state string
+	}{
+		{
+			name:     "resumed",
+			expected: []int{1, 2, 3},
+			age:      time.Minute,
+			state:    "new",
+		},
+		{
+			name:     "started over when the expected members changed",
+			expected: []int{1, 2, 3, 4},
+			age:      time.Minute,
+			state:    "existing",
+		},
+		{
+			name:     "started over when older than StateMaxAge",
+			expected: []int{1, 2, 3},
+			age:      2 * time.Hour,
+			state:    "existing",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWorld(t, 3)
+			w.start("i-1", 1)
+			w.start("i-2", 2)
+			cfg := w.config("i-3")
+			cfg.StateMaxAge = time.Hour
+			// An interrupted run which planned a new cluster, before the
+			// others formed it
+			interrupted := reconcile.State{
+				InstanceID:   "i-3",
+				Step:         reconcile.StepWriteConfig,
+				ClusterState: "new",
+				Myself:       w.member(3),
+				UpdatedAt:    time.Now().Add(-tc.age),
+			}
+			for _, i := range tc.expected {
+				interrupted.ExpectedMembers = append(interrupted.ExpectedMembers, w.member(i))
+			}
+			data, err := json.Marshal(interrupted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(cfg.StateFile, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := reconcile.Reconcile(context.Background(), cfg); err != nil {
+				t.Fatal(err)
+			}
+			env, err := ioutil.ReadFile(cfg.EnvFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "ETCD_INITIAL_CLUSTER_STATE=" + tc.state
+			if !strings.Contains(string(env), want) {
+				t.Errorf("env file lacks %s:\n%s", want, env)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	w := newWorld(t, 3)
+	w.start("i-1", 1)
+	w.start("i-2", 2)
+	w.cluster.DisableV2()
+	cfg := w.config("i-3")
+	cfg.HistorySize = 1
+	ctx := context.Background()
+	hm := w.member(1)
+	if err := cfg.Client.AppendKey(ctx, hm, reconcile.HistoryDir, `{"Type":"member-removed"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconcile.Reconcile(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The addition of i-3 is recorded, the older entry pruned
+	entries, err := reconcile.ReadHistory(ctx, cfg, hm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Type != reconcile.EventMemberAdded || entries[0].Member != "i-3" {
+		t.Errorf("got history %+v, want only the addition of i-3", entries)
+	}
+}
+
+func TestTopologyPublisher(t *testing.T) {
+	w := newWorld(t, 3)
+	for i := 1; i <= 3; i++ {
+		w.start(fmt.Sprint("i-", i), i)
+	}
+	w.cluster.DisableV2()
+	ctx := context.Background()
+	// Only the leader, the first started member, publishes it
+	for _, id := range []string{"i-2", "i-1"} {
+		if err := (&reconcile.TopologyPublisher{}).Check(ctx, w.config(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := w.config("i-1")
+	value, found, err := cfg.Client.GetKey(ctx, w.member(1), reconcile.TopologyKey)
+	if err != nil || !found {
+		t.Fatalf("got %s found %t, %v, want the topology", reconcile.TopologyKey, found, err)
+	}
+	var topology reconcile.Topology
+	if err := json.Unmarshal([]byte(value), &topology); err != nil {
+		t.Fatal(err)
+	}
+	if topology.By != "i-1" || len(topology.Members) != 3 {
+		t.Errorf("got the topology of %d members by %s, want 3 by i-1", len(topology.Members), topology.By)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	w := newWorld(t, 3)
+	for i := 1; i <= 3; i++ {
+		w.start(fmt.Sprint("i-", i), i)
+	}
+	w.cluster.DisableV2()
+	ctx := context.Background()
+	active := &reconcile.Coordinator{TTL: time.Second}
+	standby := &reconcile.Coordinator{TTL: time.Second}
+	if active.Standby(ctx, w.config("i-1"), w.member(1)) {
+		t.Fatal("i-1 didn't take the lease")
+	}
+	if !standby.Standby(ctx, w.config("i-2"), w.member(2)) {
+		t.Fatal("i-2 took the lease held by i-1")
+	}
+	if active.Standby(ctx, w.config("i-1"), w.member(1)) {
+		t.Fatal("i-1 didn't renew its lease")
+	}
+	// i-1 stops renewing, its lease expires
+	time.Sleep(1100 * time.Millisecond)
+	if standby.Standby(ctx, w.config("i-2"), w.member(2)) {
+		t.Error("i-2 didn't take the expired lease")
+	}
+}
+
+func TestPause(t *testing.T) {
+	w := newWorld(t, 3)
+	w.start("i-1", 1)
+	w.start("i-2", 2)
+	w.cluster.DisableV2()
+	cfg := w.config("i-3")
+	ctx := context.Background()
+	if err := cfg.Client.SetKey(ctx, w.member(1), reconcile.PausedKey, "disk replacement", 0); err != nil {
+		t.Fatal(err)
+	}
+	reason, err := cfg.Paused(ctx, w.member(1))
+	if err != nil || !strings.Contains(reason, "disk replacement") {
+		t.Errorf("got paused as %q, %v, want the reason in %s", reason, err, reconcile.PausedKey)
+	}
+	if _, err := reconcile.Reconcile(ctx, cfg); !errors.Is(err, reconcile.ErrPaused) {
+		t.Fatalf("got error %v, want %v", err, reconcile.ErrPaused)
+	}
+	if got := w.members(); strings.Join(got, ",") != "i-1,i-2" {
+		t.Errorf("got members %v, want i-3 not added while paused", got)
+	}
+}
+
+func TestReplaceMember(t *testing.T) {
+	t.Run("new instance joined as a voter on its own", func(t *testing.T) {
+		w := newWorld(t, 3)
+		for i := 1; i <= 3; i++ {
+			w.start(fmt.Sprint("i-", i), i)
+		}
+		// The new instance boots and joins before the learner is added
+		go func() {
+			for w.aws.Pending("etcd") == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			w.aws.Fill("etcd", "i-4", w.ip(4))
+			w.start("i-4", 4)
+		}()
+		cfg := w.config("i-1")
+		opts := reconcile.RolloutOptions{Wait: time.Minute}
+		if err := reconcile.Replace(context.Background(), cfg, opts, "i-2"); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.members(); strings.Join(got, ",") != "i-1,i-3,i-4" {
+			t.Errorf("got members %v, want i-2 replaced by i-4", got)
+		}
+	})
+	t.Run("capacity set back when no instance comes", func(t *testing.T) {
+		w := newWorld(t, 3)
+		for i := 1; i <= 3; i++ {
+			w.start(fmt.Sprint("i-", i), i)
+		}
+		cfg := w.config("i-1")
+		if err := reconcile.Replace(context.Background(), cfg, reconcile.RolloutOptions{}, "i-2"); err == nil {
+			t.Fatal("replaced i-2 without a new instance")
+		}
+		if pending := w.aws.Pending("etcd"); pending != 0 {
+			t.Errorf("got %d pending instances, want the desired capacity set back", pending)
+		}
+	})
+}
+
+func TestScaleDownNamedMembers(t *testing.T) {
+	w := newWorld(t, 3)
+	for i := 1; i <= 3; i++ {
+		w.start(fmt.Sprint("etcd-", w.ip(i)), i)
+	}
+	cfg := w.config("i-1")
+	name, err := discovery.ParseNameTemplate("etcd-{{.PrivateIP}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.URLs.Name = name
+	opts := reconcile.ScaleDownOptions{TargetSize: 2, IgnoreZones: true}
+	if err := reconcile.ScaleDown(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The youngest instance, i-3, is removed by the peer URL of its member
+	if got := w.members(); strings.Join(got, ",") != "etcd-127.0.20.1,etcd-127.0.20.2" {
+		t.Errorf("got members %v, want the member of i-3 removed", got)
+	}
+}
+
+func TestDeriveClusterToken(t *testing.T) {
+	token := reconcile.DeriveClusterToken("123456789012", "us-east-1", "etcd")
+	if again := reconcile.DeriveClusterToken("123456789012", "us-east-1", "etcd"); again != token {
+		t.Errorf("got %s then %s, want the same token for the same group", token, again)
+	}
+	for _, other := range []string{
+		reconcile.DeriveClusterToken("123456789012", "us-east-1", "etcd-staging"),
+		reconcile.DeriveClusterToken("123456789012", "eu-west-1", "etcd"),
+		reconcile.DeriveClusterToken("210987654321", "us-east-1", "etcd"),
+	} {
+		if other == token {
+			t.Errorf("got %s for another group, want a different token", other)
+		}
+	}
+}
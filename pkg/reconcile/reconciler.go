@@ -0,0 +1,288 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+// Discovery finds the members the cluster is expected to have
+type Discovery interface {
+	ExpectedMembers(ctx context.Context) ([]etcd.Member, error)
+}
+
+// EtcdClient is the subset of the etcd client the join workflow uses
+type EtcdClient interface {
+	FindHealthyMember(ctx context.Context, members []etcd.Member) (etcd.Member, error)
+	ListMembers(ctx context.Context, hm etcd.Member) ([]etcd.Member, error)
+	AddMember(ctx context.Context, hm etcd.Member, am etcd.Member) error
+	RemoveMember(ctx context.Context, hm etcd.Member, rm etcd.Member) error
+}
+
+// Output stores the generated etcd configuration
+type Output interface {
+	Path() string
+	Read() (string, error)
+	Write(content string) error
+}
+
+// Reconciler joins the local instance to the cluster in two phases: Plan
+// inspects the cluster without changing anything and Apply performs a plan.
+type Reconciler struct {
+	Discovery  Discovery
+	Client     EtcdClient
+	Output     Output
+	InstanceID string
+	// Token is the cluster token to write, if any
+	Token        string
+	PeerTLS      output.PeerTLS
+	DiscoverySRV output.DiscoverySRV
+	Logger       logging.Logger
+	Events       EventHandler
+	// CheckNew, when set, vetoes assuming a new cluster when no member is
+	// healthy
+	CheckNew func(ctx context.Context, expectedMembers []etcd.Member) error
+	// Render, when set, renders the configuration instead of DiscoverySRV,
+	// PeerTLS and Token
+	Render func(members []etcd.Member, myself etcd.Member, state string) (string, error)
+	// Snapshots, when set, saves a snapshot before every removal
+	Snapshots SnapshotSink
+	// RemoveStale allows removing the stale members, as Config.RemoveStale
+	RemoveStale bool
+	// DataDir is the local etcd data dir, as Config.DataDir
+	DataDir string
+	// Guard, when set, returns the stale members that can be removed
+	// without risking the quorum, see Config.guardRemovals
+	Guard func(ctx context.Context, hm etcd.Member, existing []etcd.Member, stale []etcd.Member) ([]etcd.Member, error)
+
+	// config, set by Config.Reconciler, plans with the steps of Reconcile,
+	// see Config.Plan
+	config *Config
+}
+
+// Reconciler returns a Reconciler backed by the configured AWS discovery,
+// etcd client and env file.
+func (cfg Config) Reconciler(token string) *Reconciler {
+	client := cfg.Client
+	return &Reconciler{
+		Discovery:    cfg,
+		Client:       &client,
+		Output:       cfg.output(),
+		InstanceID:   cfg.InstanceID,
+		Token:        token,
+		PeerTLS:      cfg.PeerTLS,
+		DiscoverySRV: cfg.DiscoverySRV,
+		Logger:       cfg.Logger,
+		Events:       cfg.Events,
+		Snapshots:    cfg.Snapshots,
+		RemoveStale:  cfg.RemoveStale,
+		DataDir:      cfg.DataDir,
+		Guard:        cfg.quiet().guardRemovals,
+		CheckNew: func(ctx context.Context, expectedMembers []etcd.Member) error {
+			return cfg.checkNewCluster(ctx, &State{ClusterToken: token, ExpectedMembers: expectedMembers})
+		},
+		Render: func(members []etcd.Member, myself etcd.Member, state string) (string, error) {
+			return cfg.render(members, myself, state, token, false)
+		},
+		config: &cfg,
+	}
+}
+
+func (r *Reconciler) emit(e Event) {
+	Config{Events: r.Events}.emit(e)
+}
+
+// Plan inspects the cluster and returns the changes Apply would make. Built
+// by Config.Reconciler, it decides as Reconcile does, from the state file.
+func (r *Reconciler) Plan(ctx context.Context) (Plan, error) {
+	if r.config != nil {
+		state, err := r.config.LoadState(ctx)
+		if err != nil {
+			return Plan{}, err
+		}
+		state.ClusterToken = r.Token
+		return r.config.Plan(ctx, state)
+	}
+	c := r.Client
+	plan := Plan{
+		ClusterState:    "new",
+		MembersToAdd:    []etcd.Member{},
+		MembersToRemove: []etcd.Member{},
+		Files:           []FileChange{},
+		Restarts:        []string{},
+	}
+	expectedMembers, err := r.Discovery.ExpectedMembers(ctx)
+	if err != nil {
+		return plan, err
+	}
+	myself, err := GetMyself(expectedMembers, r.InstanceID)
+	if err != nil {
+		return plan, err
+	}
+	if existingMembers, ok := LocalActive(ctx, c, myself); ok {
+		// Verify only, the running member keeps its configuration
+		plan.ClusterState = "existing"
+		plan.HealthyMember = myself
+		plan.LocalActive = true
+		if err := r.planRemovals(ctx, &plan, existingMembers, StaleMembers(expectedMembers, existingMembers)); err != nil {
+			return plan, err
+		}
+		plan.Destructive = len(plan.MembersToRemove) > 0
+		return plan, nil
+	}
+	healthyMember, err := c.FindHealthyMember(ctx, expectedMembers)
+	if err != nil && !errors.Is(err, etcd.ErrNoHealthyMember) {
+		return plan, err
+	}
+	if err == nil {
+		existingMembers, err := c.ListMembers(ctx, healthyMember)
+		if err != nil {
+			// A healthy member means the cluster exists
+			return plan, err
+		}
+		plan.ClusterState = "existing"
+		plan.HealthyMember = healthyMember
+		stale := StaleMembers(expectedMembers, existingMembers)
+		owner, found, err := previousOwner(existingMembers, myself, r.DataDir)
+		if err != nil {
+			return plan, err
+		}
+		if found {
+			stale = append(stale, owner)
+		}
+		if err := r.planRemovals(ctx, &plan, existingMembers, stale); err != nil {
+			return plan, err
+		}
+		registered := existingMembers
+		if found && HasMember(plan.MembersToRemove, owner) {
+			registered = withoutID(existingMembers, owner.ID)
+		}
+		if !HasMember(registered, myself) {
+			plan.MembersToAdd = append(plan.MembersToAdd, myself)
+		}
+	} else if r.CheckNew != nil {
+		if err := r.CheckNew(ctx, expectedMembers); err != nil {
+			return plan, err
+		}
+	}
+	content := renderDropIn(r.DiscoverySRV, expectedMembers, plan.ClusterState, r.Token, r.PeerTLS, nil)
+	if r.Render != nil {
+		content, err = r.Render(expectedMembers, myself, plan.ClusterState)
+		if err != nil {
+			return plan, err
+		}
+	}
+	plan.Config = content
+	current, err := r.Output.Read()
+	if err != nil {
+		return plan, err
+	}
+	if diff := output.Diff(current, content); diff != "" {
+		plan.Files = append(plan.Files, FileChange{
+			Path:    r.Output.Path(),
+			Diff:    diff,
+			Content: content,
+		})
+	}
+	plan.Destructive = len(plan.MembersToRemove) > 0
+	return plan, nil
+}
+
+// planRemovals splits stale between the members a run removes and those it
+// keeps, all of them without RemoveStale
+func (r *Reconciler) planRemovals(ctx context.Context, plan *Plan, existing []etcd.Member, stale []etcd.Member) error {
+	plan.StaleKept = []etcd.Member{}
+	if !r.RemoveStale {
+		plan.StaleKept = stale
+		return nil
+	}
+	allowed, err := r.guard(ctx, plan.HealthyMember, existing, stale)
+	if err != nil {
+		return err
+	}
+	plan.MembersToRemove = allowed
+	for _, m := range stale {
+		if !HasMember(allowed, m) {
+			plan.StaleKept = append(plan.StaleKept, m)
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) guard(ctx context.Context, hm etcd.Member, existing []etcd.Member, stale []etcd.Member) ([]etcd.Member, error) {
+	if r.Guard == nil {
+		return stale, nil
+	}
+	return r.Guard(ctx, hm, existing, stale)
+}
+
+// Apply performs the plan membership changes and writes the configuration.
+// Restarts are left to the caller.
+func (r *Reconciler) Apply(ctx context.Context, plan Plan) error {
+	err := r.apply(ctx, plan)
+	if err != nil {
+		r.emit(Event{Type: EventReconcileError, Err: err})
+	}
+	return err
+}
+
+func (r *Reconciler) apply(ctx context.Context, plan Plan) error {
+	c := r.Client
+	removals, err := r.guardApply(ctx, plan)
+	if err != nil {
+		return err
+	}
+	for _, m := range removals {
+		if err := saveSnapshot(ctx, r.Snapshots, plan.HealthyMember, m); err != nil {
+			return err
+		}
+		removed, err := RemoveMember(ctx, c, r.Logger, plan.HealthyMember, m)
+		if err != nil {
+			return err
+		}
+		if removed {
+			r.emit(Event{Type: EventMemberRemoved, Member: m})
+		}
+	}
+	for _, m := range plan.MembersToAdd {
+		added, err := AddMember(ctx, c, r.Logger, plan.HealthyMember, m)
+		if err != nil {
+			return err
+		}
+		if added {
+			r.emit(Event{Type: EventMemberAdded, Member: m})
+		}
+	}
+	for _, f := range plan.Files {
+		err := r.Output.Write(f.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guardApply checks the removals of plan again against the current
+// members, the cluster may have lost some since the plan
+func (r *Reconciler) guardApply(ctx context.Context, plan Plan) ([]etcd.Member, error) {
+	if len(plan.MembersToRemove) == 0 || !r.RemoveStale {
+		return []etcd.Member{}, nil
+	}
+	existing, err := r.Client.ListMembers(ctx, plan.HealthyMember)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := r.guard(ctx, plan.HealthyMember, existing, plan.MembersToRemove)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range plan.MembersToRemove {
+		if !HasMember(allowed, m) {
+			r.emit(Event{Type: EventRemovalRefused, Member: m, Message: "the quorum guard refused it since the plan"})
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,80 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+// updateRecords points the Route53 records of Records at the members and
+// observers, before their URLs are written. The record of a member no
+// longer expected is only removed once its instance is terminated, its
+// peers may still need to reach it meanwhile.
+func (cfg Config) updateRecords(ctx context.Context, members discovery.Members) error {
+	// A plan leaves the records be, they don't change the membership
+	if cfg.Records == nil || cfg.plan != nil {
+		return nil
+	}
+	records := *cfg.Records
+	var err error
+	if records.Owner == "" {
+		records.Owner, err = cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+		if err != nil {
+			return err
+		}
+	}
+	if records.Domain == "" {
+		records.Domain, err = records.ZoneName(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	current, err := records.Records(ctx)
+	if err != nil {
+		return err
+	}
+	byName := map[string]output.Record{}
+	for _, r := range current {
+		byName[r.Name] = r
+	}
+	expected := map[string]bool{}
+	upsert := []output.Record{}
+	for _, m := range append(append([]etcd.Member{}, members.Voters...), members.Observers...) {
+		if m.Address == "" {
+			// Not an EC2 instance
+			continue
+		}
+		name := strings.ToLower(m.Name)
+		expected[name] = true
+		r, ok := byName[name]
+		if ok && r.Owner != records.Owner {
+			return errors.New(fmt.Sprint(
+				"The Route53 record ", name, ".", records.Domain, " exists and wasn't created by etcdmate for ", records.Owner,
+			))
+		}
+		if !ok || r.Address != m.Address || r.Instance != m.Instance {
+			cfg.log().Println("Pointing the Route53 record", name+"."+records.Domain, "at", m.Address)
+			upsert = append(upsert, output.Record{Name: name, Address: m.Address, Instance: m.Instance})
+		}
+	}
+	remove := []output.Record{}
+	for _, r := range current {
+		if r.Owner != records.Owner || expected[r.Name] {
+			continue
+		}
+		gone, err := cfg.AWS.InstanceGone(ctx, r.Instance)
+		if err != nil {
+			return err
+		}
+		if gone {
+			cfg.log().Println("Removing the Route53 record", r.Name+"."+records.Domain+",", r.Instance, "is terminated")
+			remove = append(remove, r)
+		}
+	}
+	return records.Change(ctx, upsert, remove)
+}
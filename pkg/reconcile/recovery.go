@@ -0,0 +1,318 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+// SnapshotSource restores the latest backup into a data dir
+type SnapshotSource interface {
+	RestoreLatest(ctx context.Context, dataDir string, m etcd.Member) error
+}
+
+// Recovery recreates the cluster after a majority of its members has been
+// unreachable for After. The surviving member with the lowest instance ID
+// restarts with ETCD_FORCE_NEW_CLUSTER, or without survivors the lowest
+// expected member restores the latest snapshot. The other members then move
+// their data dir aside and join the new cluster. Every step is emitted as an
+// event. Data dirs are never deleted, only renamed with a .recovery suffix.
+type Recovery struct {
+	After   time.Duration
+	Unit    string
+	DataDir string
+	// Snapshots, when set, is used when no member survived
+	Snapshots SnapshotSource
+	// Wait is how long the local member has to become healthy
+	Wait time.Duration
+
+	lostSince  time.Time
+	recovering bool
+}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (r *Recovery) Check(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	healthy, unreachable := 0, 0
+	survivors := []etcd.Member{}
+	for i, err := range c.CheckHealthAll(ctx, expectedMembers) {
+		switch {
+		case err == nil:
+			healthy++
+		case errors.Is(err, etcd.ErrUnreachable):
+			unreachable++
+			continue
+		}
+		survivors = append(survivors, expectedMembers[i])
+	}
+	if healthy > 0 {
+		// A member only reports healthy when the cluster has quorum
+		if !r.lostSince.IsZero() {
+			cfg.emit(Event{Type: EventQuorumRecovery, Message: "Cluster has quorum again"})
+			r.lostSince = time.Time{}
+		}
+		if r.recovering {
+			return r.rejoin(ctx, cfg, myself)
+		}
+		return nil
+	}
+	if unreachable*2 <= len(expectedMembers) {
+		// A majority is still there, this is an election or a transient
+		// failure rather than a permanent loss
+		r.lostSince = time.Time{}
+		return nil
+	}
+	if r.lostSince.IsZero() {
+		r.lostSince = time.Now()
+		cfg.emit(Event{Type: EventQuorumLost, Message: fmt.Sprintf(
+			"%d of %d members unreachable, recovering at %s unless they come back",
+			unreachable,
+			len(expectedMembers),
+			r.lostSince.Add(r.After).Format(time.RFC3339),
+		)})
+		return nil
+	}
+	if time.Since(r.lostSince) < r.After || r.recovering {
+		return nil
+	}
+	// The cluster can't tell without quorum, only the tag and file count
+	if err := cfg.checkPaused(ctx, etcd.Member{}); err != nil {
+		return err
+	}
+	r.recovering = true
+	candidates := survivors
+	if len(candidates) == 0 {
+		candidates = expectedMembers
+	}
+	names := memberNames(candidates)
+	sort.Strings(names)
+	coordinator := names[0]
+	if coordinator != myself.Name {
+		cfg.emit(Event{
+			Type:    EventQuorumRecovery,
+			Message: fmt.Sprint("Waiting for ", coordinator, " to recreate the cluster"),
+		})
+		return nil
+	}
+	state, err := cfg.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+	if len(survivors) == 0 {
+		err = r.fromSnapshot(ctx, cfg, myself, state.ClusterToken)
+	} else {
+		err = r.fromSurvivor(ctx, cfg, myself, state.ClusterToken)
+	}
+	if err != nil {
+		cfg.emit(Event{Type: EventQuorumRecovery, Member: myself, Err: fmt.Errorf("Recovery failed, the cluster must be recovered manually: %w", err)})
+		return err
+	}
+	cfg.emit(Event{
+		Type:    EventQuorumRecovery,
+		Member:  myself,
+		Message: "Recreated the cluster, the other members join it next",
+	})
+	return nil
+}
+
+// Restore recreates the cluster from the latest snapshot after the loss of
+// every member, run by an operator on each instance. The lowest expected
+// member restores the snapshot as a cluster of its own, refusing while any
+// expected member is healthy, the others wait up to Wait for it and join
+// with their data dir moved aside.
+func (r *Recovery) Restore(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	sorted := append([]etcd.Member{}, expectedMembers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	coordinator := sorted[0]
+	if coordinator.Name != myself.Name {
+		deadline := time.Now().Add(r.Wait)
+		for !c.IsHealthy(ctx, coordinator) {
+			if time.Now().After(deadline) {
+				return errors.New(fmt.Sprint(coordinator.Name, " didn't restore the cluster within ", r.Wait))
+			}
+			cfg.log().Println("Waiting for", coordinator.Name, "to restore the cluster")
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return err
+			}
+		}
+		r.recovering = true
+		return r.rejoin(ctx, cfg, myself)
+	}
+	for i, err := range c.CheckHealthAll(ctx, expectedMembers) {
+		if err == nil {
+			return fmt.Errorf("%w: %s", ErrClusterHealthy, expectedMembers[i].Name)
+		}
+	}
+	state, err := cfg.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+	return r.fromSnapshot(ctx, cfg, myself, state.ClusterToken)
+}
+
+func (r *Recovery) fromSurvivor(ctx context.Context, cfg Config, myself etcd.Member, token string) error {
+	cfg.emit(Event{
+		Type:    EventQuorumRecovery,
+		Member:  myself,
+		Message: "Forcing a new cluster from the data of the local member",
+	})
+	// The cluster of the local member alone, not that of the SRV records
+	single := cfg
+	single.DiscoverySRV = output.DiscoverySRV{}
+	forced, err := single.render([]etcd.Member{myself}, myself, "existing", token, true)
+	if err != nil {
+		return err
+	}
+	content, err := single.render([]etcd.Member{myself}, myself, "existing", token, false)
+	if err != nil {
+		return err
+	}
+	err = cfg.output().Write(forced)
+	if err != nil {
+		return err
+	}
+	err = output.RestartUnit(r.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	err = VerifyLocal(ctx, cfg, myself, r.Wait)
+	// Never force a new cluster again on a later restart
+	if werr := cfg.output().Write(content); werr != nil && err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (r *Recovery) fromSnapshot(ctx context.Context, cfg Config, myself etcd.Member, token string) error {
+	if r.Snapshots == nil {
+		return errors.New("No member survived and no backups are configured, the cluster must be recovered manually")
+	}
+	cfg.emit(Event{
+		Type:    EventQuorumRecovery,
+		Member:  myself,
+		Message: "No member survived, restoring the latest snapshot",
+	})
+	// The cluster of the local member alone, not that of the SRV records
+	single := cfg
+	single.DiscoverySRV = output.DiscoverySRV{}
+	content, err := single.render([]etcd.Member{myself}, myself, "existing", token, false)
+	if err != nil {
+		return err
+	}
+	err = output.StopUnit(r.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := r.moveDataDir(cfg)
+	if err != nil {
+		return err
+	}
+	err = r.Snapshots.RestoreLatest(ctx, r.DataDir, myself)
+	if err != nil {
+		return err
+	}
+	err = chownAll(r.DataDir, uid, gid)
+	if err != nil {
+		return err
+	}
+	err = cfg.output().Write(content)
+	if err != nil {
+		return err
+	}
+	err = output.RestartUnit(r.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	return VerifyLocal(ctx, cfg, myself, r.Wait)
+}
+
+// rejoin moves the data dir of a member left out of the recreated cluster
+// aside and adds it back
+func (r *Recovery) rejoin(ctx context.Context, cfg Config, myself etcd.Member) error {
+	if _, ok := LocalActive(ctx, &cfg.Client, myself); ok {
+		r.recovering = false
+		return nil
+	}
+	cfg.emit(Event{
+		Type:    EventQuorumRecovery,
+		Member:  myself,
+		Message: "Joining the recreated cluster with an empty data dir",
+	})
+	err := output.StopUnit(r.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := r.moveDataDir(cfg)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(r.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+	err = chownAll(r.DataDir, uid, gid)
+	if err != nil {
+		return err
+	}
+	state, err := Reconcile(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	err = output.RestartUnit(r.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	err = VerifyLocal(ctx, cfg, state.Myself, r.Wait)
+	if err != nil {
+		return err
+	}
+	r.recovering = false
+	cfg.emit(Event{Type: EventQuorumRecovery, Member: myself, Message: "Rejoined the recreated cluster"})
+	return nil
+}
+
+// moveDataDir renames the data dir and returns its owner
+func (r *Recovery) moveDataDir(cfg Config) (int, int, error) {
+	st, err := os.Stat(r.DataDir)
+	if os.IsNotExist(err) {
+		return os.Getuid(), os.Getgid(), nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	aside := fmt.Sprint(r.DataDir, ".recovery-", time.Now().UTC().Format("20060102T150405Z"))
+	cfg.log().Println("Moving", r.DataDir, "to", aside)
+	err = os.Rename(r.DataDir, aside)
+	if err != nil {
+		return 0, 0, err
+	}
+	sys := st.Sys().(*syscall.Stat_t)
+	return int(sys.Uid), int(sys.Gid), nil
+}
+
+func chownAll(dir string, uid int, gid int) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
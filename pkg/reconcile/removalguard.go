@@ -0,0 +1,88 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// guardRemovals returns the stale members whose removal keeps the cluster
+// safe, in order: at most MaxRemovals of them, and only while the healthy
+// voting members left keep the quorum of the cluster as it is before each
+// removal. During an outage AWS can report healthy instances out of
+// service, removing their members would finish off the quorum the outage
+// spared. The others are kept and reported as EventRemovalRefused, unless
+// ForceRemove.
+func (cfg Config) guardRemovals(ctx context.Context, hm etcd.Member, existing []etcd.Member, stale []etcd.Member) ([]etcd.Member, error) {
+	if cfg.ForceRemove || len(stale) == 0 {
+		return stale, nil
+	}
+	c := cfg.Client
+	learners, err := c.ListLearners(ctx, hm)
+	if err != nil {
+		return nil, err
+	}
+	voters := []etcd.Member{}
+	for _, m := range existing {
+		if !HasMember(learners, m) {
+			voters = append(voters, m)
+		}
+	}
+	healthy := map[string]bool{}
+	for i, err := range c.CheckHealthAll(ctx, voters) {
+		healthy[voters[i].PeerURL] = err == nil
+	}
+	allowed := []etcd.Member{}
+	for _, m := range stale {
+		if cfg.MaxRemovals > 0 && len(allowed) >= cfg.MaxRemovals {
+			cfg.refuseRemoval(m, fmt.Sprint("at most ", cfg.MaxRemovals, " removals per run"))
+			continue
+		}
+		if HasMember(learners, m) {
+			// Learners don't vote
+			allowed = append(allowed, m)
+			continue
+		}
+		// Members added but not started yet have no name
+		remaining := []etcd.Member{}
+		left := 0
+		for _, r := range voters {
+			if r.PeerURL == m.PeerURL {
+				continue
+			}
+			remaining = append(remaining, r)
+			if healthy[r.PeerURL] {
+				left++
+			}
+		}
+		quorum := len(voters)/2 + 1
+		if left < quorum {
+			cfg.refuseRemoval(m, fmt.Sprintf(
+				"%d healthy members would be left of %d voting members, short of their quorum of %d",
+				left,
+				len(voters),
+				quorum,
+			))
+			continue
+		}
+		allowed = append(allowed, m)
+		voters = remaining
+	}
+	return allowed, nil
+}
+
+func (cfg Config) refuseRemoval(m etcd.Member, reason string) {
+	cfg.log().Println("Not removing stale member", m.Name, m.PeerURL+",", reason, "(--force-remove overrides)")
+	cfg.explain("Keeping %s (%s), %s", m.Name, m.PeerURL, reason)
+	cfg.emit(Event{Type: EventRemovalRefused, Member: m, Message: reason})
+}
+
+// quiet returns cfg without events, metrics nor summary, for the checks of
+// a plan which changes nothing
+func (cfg Config) quiet() Config {
+	cfg.Events = nil
+	cfg.Metrics = nil
+	cfg.Summary = nil
+	return cfg
+}
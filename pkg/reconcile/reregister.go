@@ -0,0 +1,47 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+)
+
+// reregister updates the peer URL the local member is registered with when
+// the address of the instance changed since the last complete run, e.g.
+// after a stop and start, even when the member name changed with it. The
+// member is found by the ID recorded then, see State.Registered. The local
+// etcd still runs with the previous address, so it is configured again.
+func (cfg Config) reregister(ctx context.Context, state *State) error {
+	previous := state.Registered
+	if previous.ID == "" || previous.SamePeerURLs(state.Myself) {
+		return nil
+	}
+	for i, m := range state.ExistingMembers {
+		if m.ID != previous.ID || m.SamePeerURLs(state.Myself) {
+			continue
+		}
+		cfg.log().Println(
+			"Address of the local instance changed since the last run, updating the peer URL of member",
+			m.ID,
+			"from",
+			m.PeerURL,
+			"to",
+			state.Myself.PeerURL,
+		)
+		if err := cfg.checkPaused(ctx, state.HealthyMember); err != nil {
+			return err
+		}
+		updated := m
+		updated.PeerURL, updated.PeerURLs = state.Myself.PeerURL, state.Myself.PeerURLs
+		if err := cfg.updateMember(ctx, state.HealthyMember, updated); err != nil {
+			return err
+		}
+		cfg.changed(ctx, state.HealthyMember, Event{
+			Type:    EventPeerURLRepaired,
+			Member:  updated,
+			Message: fmt.Sprint("local peer URL was ", m.PeerURL),
+		})
+		state.ExistingMembers[i] = updated
+		state.LocalActive = false
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+type RolloutOptions struct {
+	All  bool
+	Wait time.Duration
+}
+
+// Rollout replaces the members running an outdated launch configuration or
+// template one at a time: a new instance is launched and added as a learner,
+// promoted once it caught up, and only then the old member is removed and
+// its instance terminated.
+func Rollout(ctx context.Context, cfg Config, opts RolloutOptions) error {
+	asg := cfg.AWS.AutoScaling
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	refreshes, err := asg.DescribeInstanceRefreshesWithContext(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: &asgName,
+	})
+	if err != nil {
+		return err
+	}
+	for _, refresh := range refreshes.InstanceRefreshes {
+		switch *refresh.Status {
+		case "Pending", "InProgress", "Cancelling", "RollbackInProgress":
+			return errors.New(fmt.Sprint(
+				"Instance refresh ", *refresh.InstanceRefreshId, " is ", *refresh.Status,
+			))
+		}
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	outdated := []string{}
+	for _, instance := range group.Instances {
+		if *instance.LifecycleState != "InService" {
+			continue
+		}
+		if opts.All || Outdated(group, instance) {
+			outdated = append(outdated, *instance.InstanceId)
+		}
+	}
+	cfg.log().Println("Instances to replace", outdated)
+	for _, oldId := range outdated {
+		if oldId == cfg.InstanceID {
+			cfg.log().Println("Skipping local instance, run rollout from another member to replace it")
+			continue
+		}
+		err := ReplaceMember(ctx, cfg, opts, asg, asgName, oldId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace replaces the member of instance id of the local Autoscaling group
+// the same way Rollout does
+func Replace(ctx context.Context, cfg Config, opts RolloutOptions, id string) error {
+	if id == cfg.InstanceID {
+		return errors.New("Run replace-member from another member to replace the local one")
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	for _, instance := range group.Instances {
+		if *instance.InstanceId != id {
+			continue
+		}
+		if *instance.LifecycleState != "InService" {
+			return errors.New(fmt.Sprint("Instance ", id, " is ", *instance.LifecycleState))
+		}
+		return ReplaceMember(ctx, cfg, opts, cfg.AWS.AutoScaling, asgName, id)
+	}
+	return errors.New(fmt.Sprint("Instance ", id, " is not in ", asgName))
+}
+
+// Outdated tells whether the instance was launched from something other
+// than the group's current launch configuration or template, the same
+// criteria an ASG instance refresh uses.
+func Outdated(group *autoscaling.Group, instance *autoscaling.Instance) bool {
+	if group.LaunchConfigurationName != nil {
+		return instance.LaunchConfigurationName == nil ||
+			*instance.LaunchConfigurationName != *group.LaunchConfigurationName
+	}
+	if group.LaunchTemplate != nil {
+		if instance.LaunchTemplate == nil ||
+			aws.StringValue(instance.LaunchTemplate.LaunchTemplateId) != aws.StringValue(group.LaunchTemplate.LaunchTemplateId) {
+			return true
+		}
+		version := aws.StringValue(group.LaunchTemplate.Version)
+		// $Latest and $Default can't be compared without resolving them
+		if strings.HasPrefix(version, "$") {
+			return false
+		}
+		return aws.StringValue(instance.LaunchTemplate.Version) != version
+	}
+	return false
+}
+
+// ReplaceMember launches a new instance in asgName, adds it as a learner and
+// promotes it, then removes the member of oldId and terminates it. When it
+// fails before oldId was detached, the new instance is given up, so the
+// group keeps its capacity.
+func ReplaceMember(
+	ctx context.Context,
+	cfg Config,
+	opts RolloutOptions,
+	asg discovery.AutoScalingAPI,
+	asgName string,
+	oldId string,
+) (err error) {
+	c := cfg.Client
+	cfg.log().Println("Replacing instance", oldId)
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, instance := range group.Instances {
+		known[*instance.InstanceId] = true
+	}
+	desired := *group.DesiredCapacity + 1
+	if desired > *group.MaxSize {
+		return errors.New(fmt.Sprintf(
+			"Autoscaling group max size %d doesn't allow an extra instance",
+			*group.MaxSize,
+		))
+	}
+	cfg.log().Println("Setting desired capacity of", asgName, "to", desired)
+	_, err = asg.SetDesiredCapacityWithContext(ctx, &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: &asgName,
+		DesiredCapacity:      &desired,
+		HonorCooldown:        aws.Bool(false),
+	})
+	if err != nil {
+		return err
+	}
+	replacement := replacement{asg: asg, asgName: asgName, capacity: *group.DesiredCapacity}
+	defer func() {
+		if err != nil && !replacement.detached {
+			replacement.undo(cfg)
+		}
+	}()
+	newInstance, err := waitForNewInstance(ctx, cfg, asgName, known, opts.Wait)
+	if err != nil {
+		return err
+	}
+	replacement.instance = newInstance.InstanceId
+	expectedMembers, err := cfg.AWS.GetExpectedMembers(ctx, oldId, cfg.URLs)
+	if err != nil {
+		return err
+	}
+	oldMember, err := GetMyself(expectedMembers, oldId)
+	if err != nil {
+		return err
+	}
+	newMember := cfg.URLs.Member(newInstance)
+	healthyMember, err := c.FindHealthyMember(ctx, withoutMember(expectedMembers, newMember))
+	if err != nil {
+		return err
+	}
+	replacement.hm = healthyMember
+	learner, voter, err := addReplacement(ctx, cfg, healthyMember, newMember)
+	if err != nil {
+		return err
+	}
+	replacement.member = &learner
+	deadline := time.Now().Add(opts.Wait)
+	for !voter {
+		err = c.PromoteMember(ctx, healthyMember, learner)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		cfg.log().Println(err)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+	remaining := withoutMember(expectedMembers, oldMember)
+	err = CheckQuorum(ctx, c, remaining)
+	if err != nil {
+		return err
+	}
+	cfg.log().Println("Detaching instance", oldId, "from", asgName)
+	_, err = asg.DetachInstancesWithContext(ctx, &autoscaling.DetachInstancesInput{
+		AutoScalingGroupName:           &asgName,
+		InstanceIds:                    []*string{&oldId},
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	replacement.detached = true
+	existingMembers, err := c.ListMembers(ctx, healthyMember)
+	if err != nil {
+		return err
+	}
+	for _, m := range existingMembers {
+		if m.PeerURL == oldMember.PeerURL {
+			err = cfg.removeMember(ctx, healthyMember, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = WaitHealthy(ctx, c, remaining, opts.Wait)
+	if err != nil {
+		return err
+	}
+	cfg.log().Println("Terminating instance", oldId)
+	_, err = cfg.AWS.EC2.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []*string{&oldId},
+	})
+	return err
+}
+
+// addReplacement adds newMember as a learner through hm. The new instance
+// may have joined already on its own, at boot, then its member is the
+// added one, and voter tells whether it joined as a voter.
+func addReplacement(ctx context.Context, cfg Config, hm etcd.Member, newMember etcd.Member) (etcd.Member, bool, error) {
+	c := cfg.Client
+	joined, voter, err := findReplacement(ctx, cfg, hm, newMember)
+	if err != nil {
+		return newMember, false, err
+	}
+	if joined != nil {
+		return *joined, voter, nil
+	}
+	learner, err := c.AddLearner(ctx, hm, newMember)
+	if err != nil {
+		// It may have joined since the members were listed
+		joined, voter, findErr := findReplacement(ctx, cfg, hm, newMember)
+		if findErr == nil && joined != nil {
+			return *joined, voter, nil
+		}
+		return learner, false, err
+	}
+	cfg.changed(ctx, hm, Event{Type: EventMemberAdded, Member: learner})
+	return learner, false, nil
+}
+
+// findReplacement returns the member with the peer URL of newMember, nil if
+// there is none, and whether it is a voter
+func findReplacement(ctx context.Context, cfg Config, hm etcd.Member, newMember etcd.Member) (*etcd.Member, bool, error) {
+	c := cfg.Client
+	members, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, m := range members {
+		if m.PeerURL != newMember.PeerURL {
+			continue
+		}
+		learners, err := c.ListLearners(ctx, hm)
+		if err != nil {
+			return nil, false, err
+		}
+		voter := !HasMember(learners, m)
+		cfg.log().Println("The new instance joined already as", m.ID, "voter:", voter)
+		return &m, voter, nil
+	}
+	return nil, false, nil
+}
+
+// replacement is the state of a ReplaceMember to undo
+type replacement struct {
+	asg     discovery.AutoScalingAPI
+	asgName string
+	// capacity is the desired capacity before the new instance
+	capacity int64
+	// instance is the new instance, nil until it is in service
+	instance *string
+	// member is the member of the new instance once added through hm
+	member   *etcd.Member
+	hm       etcd.Member
+	detached bool
+}
+
+// undo gives up the new instance: its member is removed, and it is detached
+// and terminated, or the desired capacity is set back when it didn't come.
+// Setting the capacity back with the new instance in service would let the
+// group pick the instance to terminate, possibly a member.
+func (r replacement) undo(cfg Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if r.instance == nil {
+		cfg.log().Println("Setting desired capacity of", r.asgName, "back to", r.capacity)
+		_, err := r.asg.SetDesiredCapacityWithContext(ctx, &autoscaling.SetDesiredCapacityInput{
+			AutoScalingGroupName: &r.asgName,
+			DesiredCapacity:      &r.capacity,
+			HonorCooldown:        aws.Bool(false),
+		})
+		if err != nil {
+			cfg.log().Println("Failed to set the desired capacity back:", err)
+		}
+		return
+	}
+	if r.member != nil {
+		if _, err := RemoveMember(ctx, &cfg.Client, cfg.log(), r.hm, *r.member); err != nil {
+			cfg.log().Println("Failed to remove the member of the new instance:", err)
+			return
+		}
+	}
+	cfg.log().Println("Detaching and terminating the new instance", *r.instance)
+	_, err := r.asg.DetachInstancesWithContext(ctx, &autoscaling.DetachInstancesInput{
+		AutoScalingGroupName:           &r.asgName,
+		InstanceIds:                    []*string{r.instance},
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	})
+	if err != nil {
+		cfg.log().Println("Failed to detach the new instance:", err)
+		return
+	}
+	_, err = cfg.AWS.EC2.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []*string{r.instance},
+	})
+	if err != nil {
+		cfg.log().Println("Failed to terminate the new instance:", err)
+	}
+}
+
+func waitForNewInstance(
+	ctx context.Context,
+	cfg Config,
+	asgName string,
+	known map[string]bool,
+	wait time.Duration,
+) (ec2.Instance, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+		if err != nil {
+			return ec2.Instance{}, err
+		}
+		for _, instance := range group.Instances {
+			if known[*instance.InstanceId] || *instance.LifecycleState != "InService" {
+				continue
+			}
+			cfg.log().Println("New instance in service", *instance.InstanceId)
+			instances, err := cfg.AWS.GetEC2Instances(ctx, []*string{instance.InstanceId})
+			if err != nil {
+				return ec2.Instance{}, err
+			}
+			if len(instances) == 0 {
+				break
+			}
+			return instances[0], nil
+		}
+		if time.Now().After(deadline) {
+			return ec2.Instance{}, errors.New("Timed out waiting for a new instance")
+		}
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return ec2.Instance{}, err
+		}
+	}
+}
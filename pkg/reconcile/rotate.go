@@ -0,0 +1,130 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/certs"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+// RotationDir holds a key per member set to the last rotation it completed
+const RotationDir = "/etcdmate/rotation"
+
+type RotateOptions struct {
+	// ID names the rotation, every node must run it with the same ID
+	ID string
+	// Files are the certificates etcd uses, restored on failure
+	Files certs.Files
+	// Issue writes new certificates over Files
+	Issue func(ctx context.Context) error
+	Unit  string
+	// Wait bounds waiting for the turn of this node and for its member to
+	// be healthy again
+	Wait time.Duration
+}
+
+// RotateCerts issues new certificates for the local member and restarts
+// it. Every node runs it; the nodes take turns in instance ID order, each
+// waiting for the previous ones to complete the rotation so a single member
+// is down at a time. If the member doesn't come back with the new
+// certificates, the previous ones are restored.
+func RotateCerts(ctx context.Context, cfg Config, opts RotateOptions) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	hm, err := c.FindHealthyMember(ctx, expectedMembers)
+	if err != nil {
+		return err
+	}
+	done, err := rotatedMembers(ctx, c, hm, opts.ID)
+	if err != nil {
+		return err
+	}
+	if done[myself.Name] {
+		cfg.log().Println("Local member already completed rotation", opts.ID)
+		return nil
+	}
+	members := append([]etcd.Member{}, expectedMembers...)
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	deadline := time.Now().Add(opts.Wait)
+	for _, m := range members {
+		if m.Name == myself.Name {
+			break
+		}
+		for !done[m.Name] {
+			if time.Now().After(deadline) {
+				return errors.New(fmt.Sprint("Timed out waiting for ", m.Name, " to rotate its certificates first"))
+			}
+			cfg.log().Println("Waiting for", m.Name, "to rotate its certificates first")
+			if err := sleep(ctx, 10*time.Second); err != nil {
+				return err
+			}
+			done, err = rotatedMembers(ctx, c, hm, opts.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// The previous member may still be catching up after its restart
+	err = CheckQuorum(ctx, c, withoutMember(expectedMembers, myself))
+	if err != nil {
+		return err
+	}
+	err = opts.Files.Backup()
+	if err != nil {
+		return err
+	}
+	err = opts.Issue(ctx)
+	if err != nil {
+		return err
+	}
+	cfg.log().Println("Issued new certificates, restarting", opts.Unit)
+	err = restartAndVerify(ctx, cfg, opts, myself)
+	if err != nil {
+		cfg.log().Println("Rotation failed, restoring the previous certificates:", err)
+		if rerr := opts.Files.Restore(); rerr != nil {
+			return errors.New(fmt.Sprint(err, ", restoring the previous certificates failed: ", rerr))
+		}
+		if rerr := restartAndVerify(ctx, cfg, opts, myself); rerr != nil {
+			return errors.New(fmt.Sprint(err, ", rolling back failed: ", rerr))
+		}
+		return err
+	}
+	hm, err = c.FindHealthyMember(ctx, expectedMembers)
+	if err != nil {
+		return err
+	}
+	// Forgotten a week later so old rotations don't pile up
+	return c.SetKey(ctx, hm, RotationDir+"/"+myself.Name, opts.ID, 7*24*time.Hour)
+}
+
+func restartAndVerify(ctx context.Context, cfg Config, opts RotateOptions, myself etcd.Member) error {
+	err := output.RestartUnit(opts.Unit, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	return VerifyLocal(ctx, cfg, myself, opts.Wait)
+}
+
+// rotatedMembers returns the names of the members that completed rotation id
+func rotatedMembers(ctx context.Context, c etcd.Client, hm etcd.Member, id string) (map[string]bool, error) {
+	keys, err := c.ListKeys(ctx, hm, RotationDir)
+	if err != nil {
+		return nil, err
+	}
+	done := map[string]bool{}
+	for _, kv := range keys {
+		if kv.Value == id {
+			done[path.Base(kv.Key)] = true
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,182 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+type ScaleDownOptions struct {
+	TargetSize int
+	Wait       time.Duration
+	Terminate  bool
+	// IgnoreZones allows removing the last member of an availability zone
+	IgnoreZones bool
+}
+
+// ScaleDown removes the youngest members one at a time until the cluster
+// has targetSize members, detaching each instance from the Autoscaling group
+// before removing it from etcd so it can't rejoin. The last member of an
+// availability zone is kept unless IgnoreZones is set.
+func ScaleDown(ctx context.Context, cfg Config, opts ScaleDownOptions) error {
+	c, targetSize := cfg.Client, opts.TargetSize
+	asg := cfg.AWS.AutoScaling
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	if min := *group.MinSize; int64(targetSize) < min {
+		return errors.New(fmt.Sprintf(
+			"Target size %d is below the Autoscaling group minimum size %d",
+			targetSize,
+			min,
+		))
+	}
+	expectedMembers, err := cfg.ExpectedMembers(ctx)
+	if err != nil {
+		return err
+	}
+	instanceIds := []*string{}
+	for _, m := range expectedMembers {
+		instanceIds = append(instanceIds, aws.String(instanceOf(m)))
+	}
+	instances, err := cfg.AWS.GetEC2Instances(ctx, instanceIds)
+	if err != nil {
+		return err
+	}
+	sort.Sort(youngestFirst(instances))
+	remaining := expectedMembers
+	for _, instance := range instances {
+		if len(remaining) <= targetSize {
+			break
+		}
+		victim, err := GetMyself(remaining, *instance.InstanceId)
+		if err != nil {
+			return err
+		}
+		if !opts.IgnoreZones && lastInZone(remaining, victim) {
+			cfg.log().Println("Keeping", victim.Name, "the last member in", victim.Zone)
+			continue
+		}
+		remaining = withoutMember(remaining, victim)
+		err = CheckQuorum(ctx, c, remaining)
+		if err != nil {
+			return err
+		}
+		healthyMember, err := c.FindHealthyMember(ctx, remaining)
+		if err != nil {
+			return err
+		}
+		existingMembers, err := c.ListMembers(ctx, healthyMember)
+		if err != nil {
+			return err
+		}
+		cfg.log().Println("Detaching instance", *instance.InstanceId, "from", asgName)
+		_, err = asg.DetachInstancesWithContext(ctx, &autoscaling.DetachInstancesInput{
+			AutoScalingGroupName:           &asgName,
+			InstanceIds:                    []*string{instance.InstanceId},
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		// The names of the members may be templated, their peer URLs
+		// are those of the instances
+		for _, m := range existingMembers {
+			if m.PeerURL == victim.PeerURL {
+				err = cfg.removeMember(ctx, healthyMember, m)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = WaitHealthy(ctx, c, remaining, opts.Wait)
+		if err != nil {
+			return err
+		}
+		if opts.Terminate {
+			cfg.log().Println("Terminating instance", *instance.InstanceId)
+			_, err = cfg.AWS.EC2.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+				InstanceIds: []*string{instance.InstanceId},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(remaining) > targetSize {
+		return errors.New(fmt.Sprintf(
+			"Scaled down to %d members only, the others are the last in their availability zone",
+			len(remaining),
+		))
+	}
+	warnZoneBalance(cfg, remaining)
+	cfg.log().Printf("Cluster scaled down to %d members\n", len(remaining))
+	return nil
+}
+
+// CheckQuorum makes sure enough of the remaining members are healthy to
+// keep quorum once the others are removed
+func CheckQuorum(ctx context.Context, c etcd.Client, remaining []etcd.Member) error {
+	healthy := 0
+	for _, err := range c.CheckHealthAll(ctx, remaining) {
+		if err == nil {
+			healthy++
+		}
+	}
+	quorum := len(remaining)/2 + 1
+	if healthy < quorum {
+		return fmt.Errorf(
+			"%w: %d healthy members, %d needed",
+			ErrQuorumRisk,
+			healthy,
+			quorum,
+		)
+	}
+	return nil
+}
+
+// WaitHealthy waits until all members report healthy
+func WaitHealthy(ctx context.Context, c etcd.Client, members []etcd.Member, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		healthy := true
+		for _, err := range c.CheckHealthAll(ctx, members) {
+			if err != nil {
+				healthy = false
+			}
+		}
+		if healthy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("Timed out waiting for members to become healthy")
+		}
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+type youngestFirst []ec2.Instance
+
+func (s youngestFirst) Len() int      { return len(s) }
+func (s youngestFirst) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s youngestFirst) Less(i, j int) bool {
+	if s[i].LaunchTime.Equal(*s[j].LaunchTime) {
+		return *s[i].InstanceId > *s[j].InstanceId
+	}
+	return s[i].LaunchTime.After(*s[j].LaunchTime)
+}
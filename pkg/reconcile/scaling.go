@@ -0,0 +1,224 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Scaler follows the desired capacity of the Autoscaling group. Only the
+// leader acts on it: it promotes the learners that caught up with it, see
+// Config.LearnerJoin, and with Hook removes the member of an instance the
+// group scales in before letting the group terminate it, rather than
+// removing it as stale once it is gone.
+type Scaler struct {
+	// Hook is the termination lifecycle hook of the group. The instances
+	// waiting on it are removed from the cluster when the others keep
+	// quorum, then their lifecycle action is completed.
+	Hook string
+	// Wait is how long new instances may take to be in service after the
+	// desired capacity grew before it is reported
+	Wait time.Duration
+
+	desired int64
+	growing time.Time
+}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (s *Scaler) Check(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	expectedMembers, myself, err := cfg.DiscoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		// A new leader starts from the group as it finds it
+		s.desired, s.growing = 0, time.Time{}
+		return nil
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	group, err := cfg.AWS.DescribeAsg(ctx, asgName)
+	if err != nil {
+		return err
+	}
+	desired := aws.Int64Value(group.DesiredCapacity)
+	if s.desired != 0 && desired != s.desired {
+		message := fmt.Sprintf("Desired capacity of %s changed from %d to %d", asgName, s.desired, desired)
+		cfg.log().Println(message)
+		cfg.emit(Event{Type: EventScaling, Message: message})
+		if desired > s.desired {
+			s.growing = time.Now()
+		}
+	}
+	s.desired = desired
+	if !s.growing.IsZero() {
+		s.checkGrowth(cfg, asgName, group)
+	}
+	if err := cfg.checkPaused(ctx, myself); err != nil {
+		return err
+	}
+	if err := s.promote(ctx, cfg, myself); err != nil {
+		return err
+	}
+	if s.Hook != "" {
+		return s.release(ctx, cfg, asgName, group, expectedMembers, myself)
+	}
+	return nil
+}
+
+// checkGrowth reports the instances still missing after a scale-up, they
+// join on their own once in service
+func (s *Scaler) checkGrowth(cfg Config, asgName string, group *autoscaling.Group) {
+	missing := aws.Int64Value(group.DesiredCapacity)
+	for _, instance := range group.Instances {
+		if aws.StringValue(instance.LifecycleState) == "InService" {
+			missing--
+		}
+	}
+	switch {
+	case missing <= 0:
+		cfg.log().Println("Scale-up of", asgName, "complete")
+		s.growing = time.Time{}
+	case time.Since(s.growing) > s.Wait:
+		cfg.log().Printf("Still waiting for %d instances of %s after %s\n", missing, asgName, s.Wait)
+		s.growing = time.Now()
+	}
+}
+
+// promote promotes the learners that started, etcd refuses until they
+// caught up so they are tried again on the next pass. With EvenSizeLearner
+// a learner is kept while the voting members would become even.
+func (s *Scaler) promote(ctx context.Context, cfg Config, myself etcd.Member) error {
+	c := cfg.Client
+	learners, err := c.ListLearners(ctx, myself)
+	if err != nil {
+		return err
+	}
+	// Canaries promote themselves once soaked
+	canaries, err := c.ListKeys(ctx, myself, CanaryDir)
+	if err != nil {
+		return err
+	}
+	soaking := map[string]bool{}
+	for _, kv := range canaries {
+		soaking[path.Base(kv.Key)] = true
+	}
+	for _, learner := range learners {
+		// Learners added but not started yet have no name
+		if learner.Name == "" || soaking[learner.Name] {
+			continue
+		}
+		hold, err := cfg.holdLearner(ctx, myself, false)
+		if err != nil {
+			return err
+		}
+		if hold {
+			cfg.explain("Keeping %s a learner, promoting it would make the voting members even", learner.Name)
+			continue
+		}
+		if err := c.PromoteMember(ctx, myself, learner); err != nil {
+			cfg.log().Println("Learner", learner.Name, "not promoted yet:", err)
+			continue
+		}
+		cfg.changed(ctx, myself, Event{Type: EventScaling, Member: learner, Message: "learner promoted"})
+	}
+	return nil
+}
+
+// release removes the members of the instances waiting on Hook and lets
+// the group terminate them
+func (s *Scaler) release(
+	ctx context.Context,
+	cfg Config,
+	asgName string,
+	group *autoscaling.Group,
+	expectedMembers []etcd.Member,
+	myself etcd.Member,
+) error {
+	for _, instance := range group.Instances {
+		if aws.StringValue(instance.LifecycleState) != "Terminating:Wait" {
+			continue
+		}
+		if _, err := cfg.releaseInstance(ctx, expectedMembers, myself, asgName, s.Hook, aws.StringValue(instance.InstanceId), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseInstance removes the member of instanceId, waiting on hook, when
+// the expected members left in service keep quorum, then completes its
+// lifecycle action, with token when known. released is false while the
+// cluster can't spare the member.
+func (cfg Config) releaseInstance(
+	ctx context.Context,
+	expectedMembers []etcd.Member,
+	myself etcd.Member,
+	asgName string,
+	hook string,
+	instanceId string,
+	token string,
+) (released bool, err error) {
+	c := cfg.Client
+	described, err := cfg.AWS.GetEC2Instances(ctx, []*string{aws.String(instanceId)})
+	if err != nil {
+		return false, err
+	}
+	existingMembers, err := c.ListMembers(ctx, myself)
+	if err != nil {
+		return false, err
+	}
+	for _, ec2Instance := range described {
+		victim := cfg.URLs.Member(ec2Instance)
+		for _, m := range existingMembers {
+			if !HasMember([]etcd.Member{m}, victim) {
+				continue
+			}
+			if err := CheckQuorum(ctx, c, expectedMembers); err != nil {
+				cfg.log().Println("Not releasing", instanceId, "for termination yet:", err)
+				return false, nil
+			}
+			if err := cfg.removeMember(ctx, myself, m); err != nil {
+				return false, err
+			}
+		}
+	}
+	cfg.completeLifecycle(ctx, asgName, hook, instanceId, token)
+	return true, nil
+}
+
+// completeLifecycle lets the termination of instanceId, waiting on hook,
+// go on, with token when known. A failure is only logged.
+func (cfg Config) completeLifecycle(ctx context.Context, asgName string, hook string, instanceId string, token string) {
+	cfg.log().Println("Completing the lifecycle action of", instanceId)
+	input := &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(asgName),
+		LifecycleHookName:     aws.String(hook),
+		InstanceId:            aws.String(instanceId),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	}
+	if token != "" {
+		input.LifecycleActionToken = aws.String(token)
+	}
+	_, err := cfg.AWS.AutoScaling.CompleteLifecycleActionWithContext(ctx, input)
+	if err != nil {
+		// Another hook may hold it, or it timed out already
+		cfg.log().Println("Completing the lifecycle action of", instanceId, "failed:", err)
+		return
+	}
+	cfg.emit(Event{Type: EventScaling, Message: fmt.Sprint("Released ", instanceId, " for termination")})
+}
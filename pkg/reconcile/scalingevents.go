@@ -0,0 +1,111 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// scalingDetailTypes are the events of the group worth a pass
+var scalingDetailTypes = map[string]bool{
+	"EC2 Instance Launch Successful":          true,
+	"EC2 Instance Terminate Successful":       true,
+	"EC2 Instance-terminate Lifecycle Action": true,
+}
+
+// ScalingEvents starts passes of Supervise as the group launches or
+// terminates instances, from the EventBridge events an SQS queue receives,
+// rather than at the next Interval
+type ScalingEvents struct {
+	Queue string
+	SQS   SQSAPI
+}
+
+// scalingEvent is an EventBridge event of Autoscaling or EC2
+type scalingEvent struct {
+	Source     string
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		AutoScalingGroupName string
+		EC2InstanceId        string
+		InstanceID           string `json:"instance-id"`
+		State                string
+	}
+}
+
+// Watch sends to trigger for every event about the group of the local
+// instance until ctx is done. A failed receive is retried after a while,
+// the interval of Supervise keeps the passes going meanwhile.
+func (w ScalingEvents) Watch(ctx context.Context, cfg Config, trigger chan<- struct{}) error {
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		if err := w.receive(ctx, cfg, asgName, trigger); err != nil && ctx.Err() == nil {
+			cfg.log().Println("Receiving scaling events:", err)
+			if err := sleep(ctx, 30*time.Second); err != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// receive waits for the events of the queue, deleting them once read since
+// the queue is the local instance's own
+func (w ScalingEvents) receive(ctx context.Context, cfg Config, asgName string, trigger chan<- struct{}) error {
+	out, err := w.SQS.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(w.Queue),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		return err
+	}
+	for _, message := range out.Messages {
+		var e scalingEvent
+		if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &e); err != nil {
+			cfg.log().Println("Deleting an invalid scaling event:", err)
+		} else if reason, ok := e.about(asgName); ok {
+			cfg.log().Println("Scaling event:", reason)
+			// The pass must see the group and instances as they are now
+			cfg.AWS.Cache.Invalidate()
+			// A pass already pending covers this event too
+			select {
+			case trigger <- struct{}{}:
+			default:
+			}
+		}
+		_, err = w.SQS.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(w.Queue),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// about tells whether e is a launch or termination in asgName, and
+// describes it. EC2 state changes don't name the group, the rule of the
+// queue is expected to only match its instances.
+func (e scalingEvent) about(asgName string) (string, bool) {
+	switch e.Source {
+	case "aws.autoscaling":
+		if e.Detail.AutoScalingGroupName != asgName || !scalingDetailTypes[e.DetailType] {
+			return "", false
+		}
+		return e.DetailType + " " + e.Detail.EC2InstanceId, true
+	case "aws.ec2":
+		if e.DetailType != "EC2 Instance State-change Notification" || e.Detail.State == "pending" {
+			return "", false
+		}
+		return e.Detail.InstanceID + " " + e.Detail.State, true
+	}
+	return "", false
+}
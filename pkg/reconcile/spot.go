@@ -0,0 +1,104 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Instance metadata paths of the notices WatchSpot reads, missing while
+// there is no notice
+const (
+	spotInstanceActionPath = "spot/instance-action"
+	rebalancePath          = "events/recommendations/rebalance"
+)
+
+// SpotMetadataAPI is the subset of the instance metadata API WatchSpot uses
+type SpotMetadataAPI interface {
+	GetMetadataWithContext(aws.Context, string) (string, error)
+}
+
+type SpotOptions struct {
+	Metadata SpotMetadataAPI
+	// Rebalance also leaves on a rebalance recommendation, which comes
+	// before an interruption notice but isn't always followed by one
+	Rebalance bool
+	// Hook, when set, is the termination lifecycle hook of the group the
+	// local instance is released to once its member left
+	Hook string
+	// Interval is how often the metadata is polled, AWS gives two minutes
+	// notice before an interruption
+	Interval time.Duration
+	// Stop, when set, is called on a notice before the member leaves. It
+	// must only return once no pass of Supervise runs anymore, or a pass
+	// could add the member back.
+	Stop func()
+}
+
+// WatchSpot polls the instance metadata for a spot interruption notice of
+// the local instance, or a rebalance recommendation with Rebalance, until
+// ctx is done. On a notice the passes are stopped with Stop, then the local
+// member leaves the cluster, see Leave, so the instance doesn't leave a
+// dead member behind. left tells whether it did. ctx must outlive the
+// passes, the member leaves with it.
+func WatchSpot(ctx context.Context, cfg Config, opts SpotOptions) (left bool, err error) {
+	for {
+		notice, err := spotNotice(ctx, opts)
+		if err != nil {
+			cfg.log().Println("Reading the spot notices:", err)
+		}
+		if notice != "" {
+			cfg.emit(Event{Type: EventInterruption, Message: fmt.Sprint(notice, ", leaving the cluster")})
+			if opts.Stop != nil {
+				opts.Stop()
+			}
+			return true, cfg.leaveOnNotice(ctx, opts)
+		}
+		if err := sleep(ctx, opts.Interval); err != nil {
+			return false, nil
+		}
+	}
+}
+
+// spotNotice describes the notice of the local instance, "" without any
+func spotNotice(ctx context.Context, opts SpotOptions) (string, error) {
+	paths := []string{spotInstanceActionPath}
+	if opts.Rebalance {
+		paths = append(paths, rebalancePath)
+	}
+	for _, path := range paths {
+		content, err := opts.Metadata.GetMetadataWithContext(ctx, path)
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if path == rebalancePath {
+			return fmt.Sprint("Rebalance recommendation ", content), nil
+		}
+		return fmt.Sprint("Spot interruption notice ", content), nil
+	}
+	return "", nil
+}
+
+// leaveOnNotice removes the local member then releases the instance to
+// Hook. Its etcd stops on its own once removed.
+func (cfg Config) leaveOnNotice(ctx context.Context, opts SpotOptions) error {
+	if err := Leave(ctx, cfg, LeaveOptions{}); err != nil {
+		return err
+	}
+	if opts.Hook == "" {
+		return nil
+	}
+	asgName, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	cfg.completeLifecycle(ctx, asgName, opts.Hook, cfg.InstanceID, "")
+	return nil
+}
@@ -0,0 +1,189 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/seal"
+)
+
+type Step string
+
+const (
+	StepDiscover    Step = "discover"
+	StepHealthCheck Step = "health-check"
+	StepRemoveStale Step = "remove-stale"
+	StepAddSelf     Step = "add-self"
+	StepWriteConfig Step = "write-config"
+	StepVerify      Step = "verify"
+	StepDone        Step = "done"
+)
+
+// State is persisted after every step so an interrupted join can resume
+// where it left off instead of repeating membership mutations.
+type State struct {
+	InstanceID      string
+	Step            Step
+	ClusterState    string
+	ClusterToken    string
+	ExpectedMembers []etcd.Member
+	Observers       []etcd.Member
+	ExistingMembers []etcd.Member
+	HealthyMember   etcd.Member
+	Myself          etcd.Member
+	// LocalActive is set when the local etcd already serves as a member,
+	// its configuration is then left untouched
+	LocalActive bool
+	// Observer is set when the local instance is an observer, only the
+	// endpoints are written then
+	Observer bool
+	// Proxy is set when the local instance is a proxy, ExpectedMembers are
+	// then its endpoints
+	Proxy bool
+	// StaleKept is set when the run left stale members in the cluster for
+	// now, it then doesn't count as complete
+	StaleKept bool
+	// StaleSince records when each stale member, by peer URL, was first
+	// found missing from the discovery, see Config.StaleGrace
+	StaleSince map[string]time.Time `json:",omitempty"`
+	// PlannedRemovals are the stale members whose removal waits for a
+	// confirmation, see Config.RemovalConfirmation
+	PlannedRemovals []etcd.Member `json:",omitempty"`
+	// Registered is the local member as the cluster listed it at the end
+	// of the last complete run, its ID finds it again after the address of
+	// the instance changed, see reregister
+	Registered etcd.Member `json:",omitempty"`
+	// AppliedMembers and AppliedConfig record the outcome of the last
+	// complete run, a run with the same inputs has nothing to do
+	AppliedMembers []etcd.Member
+	AppliedConfig  string
+	UpdatedAt      time.Time
+}
+
+func LoadState(file string, insId string, logger logging.Logger) (State, error) {
+	return loadState(context.Background(), file, nil, insId, logger)
+}
+
+// LoadState reads the state file, opening it with StateSealer when set
+func (cfg Config) LoadState(ctx context.Context) (State, error) {
+	return loadState(ctx, cfg.StateFile, cfg.StateSealer, cfg.InstanceID, cfg.Logger)
+}
+
+func loadState(
+	ctx context.Context,
+	file string,
+	sealer seal.Sealer,
+	insId string,
+	logger logging.Logger,
+) (State, error) {
+	logger = logging.OrDefault(logger)
+	fresh := State{InstanceID: insId, Step: StepDiscover}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return fresh, nil
+	}
+	if err != nil {
+		return fresh, err
+	}
+	if seal.IsSealed(data) {
+		if sealer == nil {
+			return fresh, errors.New(fmt.Sprint("State file is encrypted, no key configured ", file))
+		}
+		data, err = sealer.Open(ctx, data)
+		if err != nil {
+			return fresh, err
+		}
+	}
+	// A plaintext file is still read when a sealer is set, it gets
+	// encrypted on the next save
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		logger.Println("Ignoring unreadable state file", file, err)
+		return fresh, nil
+	}
+	if state.InstanceID != insId {
+		logger.Println("Ignoring state file of another instance", state.InstanceID)
+		return fresh, nil
+	}
+	if state.Step == StepDone {
+		return state.restart(), nil
+	}
+	logger.Printf("Resuming from step %s\n", state.Step)
+	return state, nil
+}
+
+// restart returns the state of a run starting over from discovery, which
+// keeps the cluster identity and last outcome across runs
+func (state State) restart() State {
+	return State{
+		InstanceID:      state.InstanceID,
+		Step:            StepDiscover,
+		ClusterToken:    state.ClusterToken,
+		AppliedMembers:  state.AppliedMembers,
+		AppliedConfig:   state.AppliedConfig,
+		StaleSince:      state.StaleSince,
+		PlannedRemovals: state.PlannedRemovals,
+		Registered:      state.Registered,
+	}
+}
+
+// checkResume starts an interrupted run over from discovery when its state
+// is older than StateMaxAge or the discovery no longer gives the members it
+// was resumed with, the cluster may have changed since
+func (cfg Config) checkResume(ctx context.Context, state State) State {
+	if state.Step == StepDiscover || state.Step == StepDone {
+		return state
+	}
+	if cfg.StateMaxAge > 0 && time.Since(state.UpdatedAt) > cfg.StateMaxAge {
+		cfg.log().Printf("Starting over, the state of step %s was saved %s ago\n", state.Step, time.Since(state.UpdatedAt).Round(time.Second))
+		return state.restart()
+	}
+	members, myself, err := cfg.discoverMyself(ctx)
+	if err != nil || !sameMembers(members.Voters, state.ExpectedMembers) || !sameMembers([]etcd.Member{myself}, []etcd.Member{state.Myself}) {
+		cfg.log().Printf("Starting over, the expected members changed since step %s\n", state.Step)
+		return state.restart()
+	}
+	return state
+}
+
+func SaveState(file string, state State) error {
+	return saveState(context.Background(), file, nil, state)
+}
+
+// SaveState writes the state file, sealing it with StateSealer when set
+func (cfg Config) SaveState(ctx context.Context, state State) error {
+	return saveState(ctx, cfg.StateFile, cfg.StateSealer, state)
+}
+
+func saveState(ctx context.Context, file string, sealer seal.Sealer, state State) error {
+	state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if sealer != nil {
+		data, err = sealer.Seal(ctx, data)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(path.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated state
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
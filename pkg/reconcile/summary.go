@@ -0,0 +1,127 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// Summary collects the phase durations, actions and outcome of a run for
+// the report printed at its end. A nil Summary records nothing.
+type Summary struct {
+	Phases  []PhaseTiming
+	Actions []string
+	// Reasons explain the decisions, see Config.Explain
+	Reasons  []string
+	Decision string
+	Error    string `json:",omitempty"`
+	Total    float64
+	start    time.Time
+}
+
+// PhaseTiming is the time spent in a phase, in seconds
+type PhaseTiming struct {
+	Name    string
+	Seconds float64
+}
+
+func NewSummary() *Summary {
+	return &Summary{start: time.Now()}
+}
+
+// Time starts timing the phase name and returns the function stopping it.
+// Time spent in a phase over several calls adds up.
+func (s *Summary) Time(name string) func() {
+	if s == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		seconds := time.Since(start).Seconds()
+		for i := range s.Phases {
+			if s.Phases[i].Name == name {
+				s.Phases[i].Seconds += seconds
+				return
+			}
+		}
+		s.Phases = append(s.Phases, PhaseTiming{Name: name, Seconds: seconds})
+	}
+}
+
+// Events returns a handler recording the events as actions before passing
+// them on to next
+func (s *Summary) Events(next EventHandler) EventHandler {
+	return func(e Event) {
+		action := string(e.Type)
+		if e.Member.Name != "" || e.Member.PeerURL != "" {
+			action = fmt.Sprint(action, " ", e.Member.Name, " ", e.Member.PeerURL)
+		}
+		if e.Message != "" {
+			action = fmt.Sprint(action, ": ", e.Message)
+		}
+		s.Actions = append(s.Actions, action)
+		if next != nil {
+			next(e)
+		}
+	}
+}
+
+func (s *Summary) decide(decision string) {
+	if s != nil {
+		s.Decision = decision
+	}
+}
+
+// Finish records the run result, call it once before Print or WriteFile
+func (s *Summary) Finish(err error) {
+	s.Total = time.Since(s.start).Seconds()
+	if err != nil {
+		s.Error = err.Error()
+		s.Decision = "failed"
+	}
+}
+
+func (s *Summary) Print(logger logging.Logger) {
+	logger = logging.OrDefault(logger)
+	phases := []string{}
+	for _, p := range s.Phases {
+		phases = append(phases, fmt.Sprintf("%s %.2fs", p.Name, p.Seconds))
+	}
+	logger.Printf("Summary: %s in %.2fs\n", s.Decision, s.Total)
+	logger.Println("Summary phases:", strings.Join(phases, ", "))
+	for _, reason := range s.Reasons {
+		logger.Println("Summary reason:", reason)
+	}
+	for _, action := range s.Actions {
+		logger.Println("Summary action:", action)
+	}
+	if s.Error != "" {
+		logger.Println("Summary error:", s.Error)
+	}
+}
+
+func (s *Summary) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func (s *Summary) WriteFile(file string) error {
+	data, err := s.JSON()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// stepPhases groups the steps into the phases reported in the summary
+var stepPhases = map[Step]string{
+	StepDiscover:    "discovery",
+	StepHealthCheck: "health",
+	StepRemoveStale: "membership",
+	StepAddSelf:     "membership",
+	StepWriteConfig: "output",
+	StepVerify:      "verify",
+}
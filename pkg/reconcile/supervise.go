@@ -0,0 +1,177 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+type SuperviseOptions struct {
+	Interval           time.Duration
+	RestartUnit        string
+	RestartMinInterval time.Duration
+	// SystemdReload reloads systemd when the configuration changes without
+	// a RestartUnit, etcd picks the change up at its next start
+	SystemdReload bool
+	// VerifyTimeout, when set, is how long the local member has to become
+	// healthy after a restart
+	VerifyTimeout time.Duration
+	// Trigger, when set, starts a pass without waiting for the interval
+	Trigger <-chan struct{}
+	// Report, when set, is called with the outcome of every pass
+	Report func(State, error)
+	// AfterPass, when set, is called after every pass and restart
+	AfterPass func(context.Context)
+	// StepDown hands the leadership over to another member when stopping
+	// while the local member leads, see StepDown
+	StepDown bool
+	// Reload, when set, delivers changes to the configuration and these
+	// options, e.g. after a SIGHUP. They are applied between passes and
+	// start a new one.
+	Reload <-chan func(*Config, *SuperviseOptions)
+	// Lock, when set, is taken for every pass, with its restart and
+	// AfterPass, and released while waiting for the next one
+	Lock func() (func(), error)
+}
+
+// lock takes opts.Lock, when set, and returns the function releasing it
+func (opts SuperviseOptions) lock() (func(), error) {
+	if opts.Lock == nil {
+		return func() {}, nil
+	}
+	return opts.Lock()
+}
+
+// Supervise reconciles periodically and restarts the etcd unit when the
+// generated configuration changes. Restarts are rate limited and deferred
+// once while the local member is the leader, to avoid needless elections.
+// With a Coordinator in cfg, standbys skip their passes and only update the
+// endpoints from the members shared by the active reconciler.
+func Supervise(ctx context.Context, cfg Config, opts SuperviseOptions) error {
+	c := cfg.Client
+	var lastRestart time.Time
+	pendingRestart := false
+	deferred := false
+	var state State
+	for {
+		if cfg.Coordinator != nil && cfg.Coordinator.Standby(ctx, cfg, state.Myself) {
+			members, shared := cfg.Coordinator.members()
+			var err error
+			if shared {
+				err = cfg.writeEndpoints(members)
+			}
+			if opts.Report != nil {
+				opts.Report(state, err)
+			}
+			if err != nil {
+				cfg.log().Println(err)
+			}
+			if opts.AfterPass != nil {
+				opts.AfterPass(ctx)
+			}
+			if err := nextPass(ctx, &cfg, &opts); err != nil {
+				return stop(cfg, opts, state, err)
+			}
+			continue
+		}
+		release, err := opts.lock()
+		if err != nil {
+			cfg.log().Println(err)
+			if err := nextPass(ctx, &cfg, &opts); err != nil {
+				return stop(cfg, opts, state, err)
+			}
+			continue
+		}
+		before, _ := cfg.output().Read()
+		state, err = Reconcile(ctx, cfg)
+		if opts.Report != nil {
+			opts.Report(state, err)
+		}
+		if err == nil && cfg.Coordinator != nil && !state.Observer {
+			members := discovery.Members{Voters: state.ExpectedMembers, Observers: state.Observers}
+			if err := cfg.Coordinator.Share(ctx, cfg, state.Myself, members); err != nil {
+				cfg.log().Println("Sharing the members:", err)
+			}
+		}
+		if err != nil {
+			cfg.log().Println(err)
+		} else {
+			after, _ := cfg.output().Read()
+			if before != after {
+				cfg.log().Println("Configuration changed")
+				pendingRestart = opts.RestartUnit != ""
+				if !pendingRestart && opts.SystemdReload {
+					if err := output.ReloadSystemd(cfg.Logger); err != nil {
+						cfg.log().Println(err)
+					}
+				}
+			}
+		}
+		if pendingRestart {
+			leader, err := c.IsLeader(ctx, state.Myself)
+			if err != nil {
+				cfg.log().Println(err)
+			}
+			switch {
+			case time.Since(lastRestart) < opts.RestartMinInterval:
+				cfg.log().Println("Delaying restart, last restart was at", lastRestart)
+			case leader && !deferred:
+				cfg.log().Println("Delaying restart, local member is the leader")
+				deferred = true
+			default:
+				err := output.RestartUnit(opts.RestartUnit, cfg.Logger)
+				if err != nil {
+					cfg.log().Println(err)
+				} else {
+					lastRestart = time.Now()
+					pendingRestart = false
+					deferred = false
+					if opts.VerifyTimeout > 0 {
+						err := VerifyLocal(ctx, cfg, state.Myself, opts.VerifyTimeout)
+						if err != nil {
+							cfg.log().Println(err)
+						}
+					}
+				}
+			}
+		}
+		if opts.AfterPass != nil {
+			opts.AfterPass(ctx)
+		}
+		release()
+		if err := nextPass(ctx, &cfg, &opts); err != nil {
+			return stop(cfg, opts, state, err)
+		}
+	}
+}
+
+// nextPass returns when the next pass is due or triggered, applying a
+// reload meanwhile
+func nextPass(ctx context.Context, cfg *Config, opts *SuperviseOptions) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(opts.Interval):
+	case <-opts.Trigger:
+		cfg.log().Println("Reconcile triggered")
+	case reload := <-opts.Reload:
+		reload(cfg, opts)
+		cfg.log().Println("Configuration reloaded")
+	}
+	return nil
+}
+
+// stop ends Supervise with err, stepping down first with StepDown. The
+// context of Supervise is done by then, the transfer gets its own.
+func stop(cfg Config, opts SuperviseOptions, state State, err error) error {
+	if opts.StepDown && state.Myself.ClientURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*leaderTransferWait)
+		defer cancel()
+		if err := StepDown(ctx, cfg, state.Myself); err != nil {
+			cfg.log().Println("Stepping down:", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// TopologyKey holds the reconciled Topology as JSON, for backup tools, load
+// balancers and dashboards to read from the cluster itself
+const TopologyKey = "/etcdmate/topology"
+
+// Topology describes the members and observers of the cluster
+type Topology struct {
+	ClusterVersion string `json:",omitempty"`
+	Leader         string `json:",omitempty"`
+	Members        []TopologyMember
+	UpdatedAt      time.Time
+	// By is the instance that wrote it
+	By string
+}
+
+// TopologyMember is a member or an observer of the Topology
+type TopologyMember struct {
+	Name      string
+	ID        string `json:",omitempty"`
+	Instance  string `json:",omitempty"`
+	Zone      string `json:",omitempty"`
+	ClientURL string
+	PeerURL   string
+	// Role is member or observer
+	Role    string
+	Version string `json:",omitempty"`
+	Healthy bool
+	// Lag is how many raft entries the member is behind the leader, only
+	// gathered by DescribeTopology
+	Lag uint64 `json:",omitempty"`
+}
+
+// TopologyPublisher keeps TopologyKey current. Only the leader writes it,
+// and only when the topology changed.
+type TopologyPublisher struct {
+	last []TopologyMember
+}
+
+// Check is meant to run after every pass of Supervise, see
+// SuperviseOptions.AfterPass
+func (p *TopologyPublisher) Check(ctx context.Context, cfg Config) error {
+	c := cfg.Client
+	members, myself, err := cfg.discoverMyself(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if !status.IsLeader() {
+		// Write it again if leadership comes back
+		p.last = nil
+		return nil
+	}
+	topology, err := cfg.topology(ctx, members, myself, status)
+	if err != nil {
+		return err
+	}
+	if sameTopology(p.last, topology.Members) {
+		return nil
+	}
+	topology.By = cfg.InstanceID
+	topology.UpdatedAt = time.Now().UTC()
+	value, err := json.Marshal(topology)
+	if err != nil {
+		return err
+	}
+	err = c.SetKey(ctx, myself, TopologyKey, string(value), 0)
+	if err != nil {
+		return err
+	}
+	p.last = topology.Members
+	return nil
+}
+
+// topology gathers the Topology of members through hm, whose status is
+// status
+func (cfg Config) topology(ctx context.Context, members discovery.Members, hm etcd.Member, status etcd.Status) (Topology, error) {
+	c := cfg.Client
+	registered, err := c.ListMembers(ctx, hm)
+	if err != nil {
+		return Topology{}, err
+	}
+	topology := Topology{Members: []TopologyMember{}}
+	_, topology.ClusterVersion, _ = c.Version(ctx, hm)
+	for _, m := range members.Voters {
+		tm := topologyMember(ctx, cfg, m, "member")
+		for _, r := range registered {
+			if HasMember([]etcd.Member{r}, m) {
+				tm.ID = r.ID
+			}
+		}
+		if tm.ID != "" && tm.ID == status.Leader {
+			topology.Leader = tm.Name
+		}
+		topology.Members = append(topology.Members, tm)
+	}
+	for _, m := range members.Observers {
+		topology.Members = append(topology.Members, topologyMember(ctx, cfg, m, "observer"))
+	}
+	return topology, nil
+}
+
+// DescribeTopology gathers the Topology of the cluster through a healthy
+// member, with the lag of every healthy member. It works from any instance
+// of the cluster, the local one doesn't need to be a member.
+func DescribeTopology(ctx context.Context, cfg Config) (Topology, error) {
+	c := cfg.Client
+	members, err := cfg.source().GetMembers(ctx, cfg.InstanceID, cfg.URLs)
+	if err != nil {
+		return Topology{}, err
+	}
+	hm, err := c.FindHealthyMember(ctx, members.Voters)
+	if err != nil {
+		return Topology{}, err
+	}
+	status, err := c.Status(ctx, hm)
+	if err != nil {
+		return Topology{}, err
+	}
+	topology, err := cfg.topology(ctx, members, hm, status)
+	if err != nil {
+		return Topology{}, err
+	}
+	all := append(append([]etcd.Member{}, members.Voters...), members.Observers...)
+	indexes := map[string]uint64{}
+	for i, tm := range topology.Members {
+		if !tm.Healthy {
+			continue
+		}
+		if s, err := c.Status(ctx, all[i]); err == nil {
+			indexes[tm.Name] = s.RaftIndex
+		}
+	}
+	if leaderIndex, ok := indexes[topology.Leader]; ok {
+		for i, tm := range topology.Members {
+			if index, ok := indexes[tm.Name]; ok && index < leaderIndex {
+				topology.Members[i].Lag = leaderIndex - index
+			}
+		}
+	}
+	topology.By = cfg.InstanceID
+	topology.UpdatedAt = time.Now().UTC()
+	return topology, nil
+}
+
+// DOT renders t as a Graphviz graph: the members grouped by zone, the
+// leader doubly circled with edges to the members it replicates to, the
+// unhealthy members in red and the observers dashed
+func (t Topology) DOT() string {
+	zones := []string{}
+	byZone := map[string][]TopologyMember{}
+	for _, tm := range t.Members {
+		if _, ok := byZone[tm.Zone]; !ok {
+			zones = append(zones, tm.Zone)
+		}
+		byZone[tm.Zone] = append(byZone[tm.Zone], tm)
+	}
+	sort.Strings(zones)
+	dot := "digraph etcd {\n"
+	if t.ClusterVersion != "" {
+		dot += fmt.Sprintf("  label=%q;\n", "etcd "+t.ClusterVersion)
+	}
+	dot += "  node [shape=circle];\n"
+	for i, zone := range zones {
+		indent := "  "
+		if zone != "" {
+			dot += fmt.Sprintf("  subgraph cluster_%d {\n    label=%q;\n", i, zone)
+			indent = "    "
+		}
+		for _, tm := range byZone[zone] {
+			label := tm.Name
+			if tm.Version != "" {
+				label += "\\n" + tm.Version
+			}
+			if tm.Lag > 0 {
+				label += fmt.Sprintf("\\nlag %d", tm.Lag)
+			}
+			attrs := []string{fmt.Sprintf("label=\"%s\"", strings.ReplaceAll(label, `"`, `\"`))}
+			if tm.Name == t.Leader {
+				attrs = append(attrs, "shape=doublecircle")
+			}
+			if !tm.Healthy {
+				attrs = append(attrs, "color=red", "fontcolor=red")
+			}
+			if tm.Role == "observer" {
+				attrs = append(attrs, "style=dashed")
+			}
+			dot += fmt.Sprintf("%s%q [%s];\n", indent, tm.Name, strings.Join(attrs, ", "))
+		}
+		if zone != "" {
+			dot += "  }\n"
+		}
+	}
+	if t.Leader != "" {
+		for _, tm := range t.Members {
+			if tm.Role == "member" && tm.Name != t.Leader {
+				dot += fmt.Sprintf("  %q -> %q;\n", t.Leader, tm.Name)
+			}
+		}
+	}
+	return dot + "}\n"
+}
+
+func topologyMember(ctx context.Context, cfg Config, m etcd.Member, role string) TopologyMember {
+	tm := TopologyMember{
+		Name:      m.Name,
+		Instance:  m.Instance,
+		Zone:      m.Zone,
+		ClientURL: m.ClientURL,
+		PeerURL:   m.PeerURL,
+		Role:      role,
+		Healthy:   cfg.Client.IsHealthy(ctx, m),
+	}
+	if tm.Healthy {
+		tm.Version, _, _ = cfg.Client.Version(ctx, m)
+	}
+	return tm
+}
+
+func sameTopology(a []TopologyMember, b []TopologyMember) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// VerifyLocal waits until the local member serves /health and is listed as
+// a started member of the cluster.
+func VerifyLocal(ctx context.Context, cfg Config, myself etcd.Member, timeout time.Duration) error {
+	c := cfg.Client
+	cfg.log().Println("Verifying local member", myself.Name)
+	deadline := time.Now().Add(timeout)
+	for {
+		err := c.CheckHealth(ctx, myself)
+		if err == nil {
+			if _, ok := LocalActive(ctx, &c, myself); ok {
+				cfg.log().Println("Local member is healthy")
+				return nil
+			}
+			err = errors.New("Local member not started in the cluster")
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("Local member verification failed: ", err))
+		}
+		cfg.log().Println(err)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+type VerifyOptions struct {
+	// Wait is how long the local member has to pass the checks
+	Wait time.Duration
+	// Learner accepts a local member not promoted yet
+	Learner bool
+	// MaxLag is how many raft entries the local member may be behind the
+	// leader
+	MaxLag uint64
+}
+
+// Verify waits until the local member is healthy, started, a voting member
+// unless Learner, and caught up with the leader, for the orchestration to
+// check a join that happened in another run.
+func Verify(ctx context.Context, cfg Config, opts VerifyOptions) error {
+	expectedMembers, err := cfg.ExpectedMembers(ctx)
+	if err != nil {
+		return err
+	}
+	myself, err := GetMyself(expectedMembers, cfg.InstanceID)
+	if err != nil {
+		return err
+	}
+	cfg.log().Println("Verifying local member", myself.Name)
+	deadline := time.Now().Add(opts.Wait)
+	for {
+		err := cfg.verifyMember(ctx, expectedMembers, myself, opts)
+		if err == nil {
+			cfg.log().Println("Local member is healthy and caught up")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("Local member verification failed: ", err))
+		}
+		cfg.log().Println(err)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyMember runs the checks of Verify once
+func (cfg Config) verifyMember(ctx context.Context, expectedMembers []etcd.Member, myself etcd.Member, opts VerifyOptions) error {
+	c := cfg.Client
+	if err := c.CheckHealth(ctx, myself); err != nil {
+		return err
+	}
+	members, ok := LocalActive(ctx, &c, myself)
+	if !ok {
+		return errors.New("Local member not started in the cluster")
+	}
+	if !opts.Learner && len(members) > 1 {
+		// Learners don't serve the membership API
+		hm, err := c.FindHealthyMember(ctx, withoutMember(expectedMembers, myself))
+		if err != nil {
+			return err
+		}
+		_, learner, err := localLearner(ctx, cfg, hm, myself)
+		if err != nil {
+			return err
+		}
+		if learner {
+			return errors.New("Local member is still a learner")
+		}
+	}
+	local, err := c.Status(ctx, myself)
+	if err != nil {
+		return err
+	}
+	if local.Leader == "" || local.Leader == "0" {
+		return errors.New("Local member has no leader")
+	}
+	if local.IsLeader() {
+		return nil
+	}
+	for _, m := range members {
+		if m.ID != local.Leader {
+			continue
+		}
+		leader, err := c.Status(ctx, m)
+		if err != nil {
+			return err
+		}
+		if leader.RaftIndex > local.RaftIndex+opts.MaxLag {
+			return errors.New(fmt.Sprint(
+				"Local member is ", leader.RaftIndex-local.RaftIndex, " raft entries behind the leader ", m.Name,
+			))
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprint("Leader ", local.Leader, " not found in the members"))
+}
@@ -0,0 +1,88 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// VersionCheck controls comparing the local etcd version with the cluster
+// version before joining an existing cluster
+type VersionCheck string
+
+const (
+	VersionOff  VersionCheck = ""
+	VersionWarn VersionCheck = "warn"
+	VersionFail VersionCheck = "fail"
+)
+
+var ErrVersionSkew = errors.New("Incompatible etcd version")
+
+// checkVersion compares the local etcd version with the cluster version hm
+// reports, failing only with VersionFail. etcd only joins a cluster of the
+// same major version and the same or the previous minor version.
+func checkVersion(ctx context.Context, cfg Config, hm etcd.Member) error {
+	if cfg.VersionCheck == VersionOff || cfg.LocalVersion == "" {
+		return nil
+	}
+	_, cluster, err := cfg.Client.Version(ctx, hm)
+	if err != nil {
+		return err
+	}
+	err = compatibleVersions(cfg.LocalVersion, cluster)
+	if err == nil {
+		cfg.explain("The local etcd %s can join a cluster running %s", cfg.LocalVersion, cluster)
+		return nil
+	}
+	if cfg.VersionCheck == VersionFail {
+		return err
+	}
+	cfg.log().Println("Warning:", err)
+	return nil
+}
+
+func compatibleVersions(local, cluster string) error {
+	localMajor, localMinor, ok := majorMinor(local)
+	if !ok {
+		return nil
+	}
+	// A new cluster reports not_decided until its members agree
+	clusterMajor, clusterMinor, ok := majorMinor(cluster)
+	if !ok {
+		return nil
+	}
+	switch {
+	case localMajor != clusterMajor:
+		return fmt.Errorf("%w: the local etcd %s can't join a cluster running %s", ErrVersionSkew, local, cluster)
+	case localMinor < clusterMinor:
+		return fmt.Errorf("%w: the local etcd %s is older than the cluster version %s", ErrVersionSkew, local, cluster)
+	case localMinor > clusterMinor+1:
+		return fmt.Errorf(
+			"%w: the local etcd %s is more than one minor version ahead of the cluster version %s, upgrade one minor version at a time",
+			ErrVersionSkew,
+			local,
+			cluster,
+		)
+	}
+	return nil
+}
+
+func majorMinor(version string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
@@ -0,0 +1,59 @@
+package reconcile
+
+import (
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// previousOwner returns the started member registered with the peer URL of
+// myself under another name, left by a previous instance with the same
+// address, e.g. one replaced before its member was removed. The local etcd
+// would start as that member without its data. It only counts as such with
+// the local data dir known and empty, the member may be the local one
+// otherwise.
+func previousOwner(existing []etcd.Member, myself etcd.Member, dataDir string) (etcd.Member, bool, error) {
+	if myself.Name == "" || dataDir == "" {
+		return etcd.Member{}, false, nil
+	}
+	for _, m := range existing {
+		if m.Name == "" || m.Name == myself.Name || !m.SamePeerURLs(myself) {
+			continue
+		}
+		empty, err := emptyDir(dataDir)
+		if err != nil || !empty {
+			return etcd.Member{}, false, err
+		}
+		return m, true, nil
+	}
+	return etcd.Member{}, false, nil
+}
+
+// staleWithOwner returns the stale members of state, with the previous
+// owner of the peer URL of the local member, see previousOwner
+func (cfg Config) staleWithOwner(state *State) ([]etcd.Member, error) {
+	stale := StaleMembers(state.ExpectedMembers, state.ExistingMembers)
+	if state.LocalActive {
+		return stale, nil
+	}
+	owner, found, err := previousOwner(state.ExistingMembers, state.Myself, cfg.DataDir)
+	if err != nil || !found {
+		return stale, err
+	}
+	cfg.explain(
+		"%s (%s) is stale, it holds the peer URL %s of the local member whose data dir is empty",
+		owner.Name,
+		owner.ID,
+		owner.PeerURL,
+	)
+	return append(stale, owner), nil
+}
+
+// withoutID returns members without the one of id
+func withoutID(members []etcd.Member, id string) []etcd.Member {
+	left := []etcd.Member{}
+	for _, m := range members {
+		if m.ID != id {
+			left = append(left, m)
+		}
+	}
+	return left
+}
@@ -0,0 +1,74 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// zoneCounts returns the number of members per availability zone, members
+// of an unknown zone aren't counted
+func zoneCounts(members []etcd.Member) map[string]int {
+	counts := map[string]int{}
+	for _, m := range members {
+		if m.Zone != "" {
+			counts[m.Zone]++
+		}
+	}
+	return counts
+}
+
+// lastInZone reports whether m is the only one of members in its zone
+func lastInZone(members []etcd.Member, m etcd.Member) bool {
+	return m.Zone != "" && zoneCounts(members)[m.Zone] == 1
+}
+
+// warnZoneBalance logs when losing a single availability zone would lose
+// the quorum of members
+func warnZoneBalance(cfg Config, members []etcd.Member) {
+	if len(members) < 2 {
+		return
+	}
+	counts := zoneCounts(members)
+	zones := []string{}
+	for zone := range counts {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	quorum := len(members)/2 + 1
+	for _, zone := range zones {
+		if counts[zone] >= quorum {
+			cfg.log().Printf(
+				"Warning: %d of the %d members are in %s, losing that zone loses quorum\n",
+				counts[zone],
+				len(members),
+				zone,
+			)
+		}
+	}
+}
+
+// checkZoneSpread refuses voting members spanning fewer than MinZones
+// availability zones, or with the quorum in a single one, so no
+// configuration is written for them
+func (cfg Config) checkZoneSpread(members []etcd.Member) error {
+	if cfg.MinZones < 2 {
+		return nil
+	}
+	counts := zoneCounts(members)
+	remedy := fmt.Sprintf(
+		"launch the instances of the Autoscaling group in at least %d zones, e.g. with subnets of more zones in its VPCZoneIdentifier, and keep fewer than half of them in any zone, or lower --min-zones",
+		cfg.MinZones,
+	)
+	if len(counts) < cfg.MinZones {
+		return fmt.Errorf("%w: the %d members span %d zones, %d required; %s", ErrZoneSpread, len(members), len(counts), cfg.MinZones, remedy)
+	}
+	quorum := len(members)/2 + 1
+	for zone, count := range counts {
+		if count >= quorum {
+			return fmt.Errorf("%w: %d of the %d members are in %s, it holds their quorum; %s", ErrZoneSpread, count, len(members), zone, remedy)
+		}
+	}
+	return nil
+}
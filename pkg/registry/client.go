@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Registry is where members register and find each other, served by
+// serve-registry, see Client, or kept in a central etcd cluster, see Etcd
+type Registry interface {
+	Register(ctx context.Context, e Entry) error
+	Deregister(ctx context.Context, name string) error
+	Entries(ctx context.Context) ([]Entry, error)
+}
+
+// Client registers and lists the members of Cluster in the registry at URL
+type Client struct {
+	URL     string
+	Token   string
+	Cluster string
+	// HTTP defaults to http.DefaultClient
+	HTTP *http.Client
+}
+
+// Register adds or refreshes the entry of a member
+func (c Client) Register(ctx context.Context, e Entry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "PUT", c.membersURL(e.Name), body)
+	return err
+}
+
+// Deregister removes the entry of the member name
+func (c Client) Deregister(ctx context.Context, name string) error {
+	_, err := c.do(ctx, "DELETE", c.membersURL(name), nil)
+	return err
+}
+
+// Entries lists the registered members
+func (c Client) Entries(ctx context.Context) ([]Entry, error) {
+	data, err := c.do(ctx, "GET", c.membersURL(""), nil)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c Client) membersURL(name string) string {
+	u := strings.TrimSuffix(c.URL, "/") + "/v1/clusters/" + url.PathEscape(c.Cluster) + "/members"
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+func (c Client) do(ctx context.Context, method string, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("Registry %s %s failed: %s %s", method, u, resp.Status, bytes.TrimSpace(data)))
+	}
+	return data, nil
+}
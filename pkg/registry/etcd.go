@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Etcd keeps the entries in a central etcd cluster, the cluster of clusters
+// pattern, each as JSON under Prefix/CLUSTER/members/NAME in the v3 key
+// space, under a lease of its TTL
+type Etcd struct {
+	Client etcd.Client
+	// Endpoints are the members of the central cluster, by client URL
+	Endpoints []etcd.Member
+	Prefix    string
+	Cluster   string
+}
+
+// Register adds or refreshes the entry of a member
+func (s Etcd) Register(ctx context.Context, e Entry) error {
+	hm, err := s.Client.FindHealthyMember(ctx, s.Endpoints)
+	if err != nil {
+		return err
+	}
+	e.Updated = time.Now().UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.Client.SetKey(ctx, hm, s.dir()+"/"+e.Name, string(data), time.Duration(e.TTL)*time.Second)
+}
+
+// Deregister removes the entry of the member name
+func (s Etcd) Deregister(ctx context.Context, name string) error {
+	hm, err := s.Client.FindHealthyMember(ctx, s.Endpoints)
+	if err != nil {
+		return err
+	}
+	return s.Client.DeleteKey(ctx, hm, s.dir()+"/"+name)
+}
+
+// Entries lists the registered members, etcd drops the expired ones
+func (s Etcd) Entries(ctx context.Context) ([]Entry, error) {
+	hm, err := s.Client.FindHealthyMember(ctx, s.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := s.Client.ListKeys(ctx, hm, s.dir())
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, kv := range kvs {
+		var e Entry
+		if err := json.Unmarshal([]byte(kv.Value), &e); err != nil {
+			return nil, errors.New(fmt.Sprint("Unreadable registry entry ", kv.Key, ": ", err))
+		}
+		e.Name = path.Base(kv.Key)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s Etcd) dir() string {
+	return path.Join("/", s.Prefix, s.Cluster, "members")
+}
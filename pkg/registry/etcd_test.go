@@ -0,0 +1,60 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	etcdfake "github.com/viruxel/etcdmate/pkg/etcd/fake"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/registry"
+)
+
+func TestEtcd(t *testing.T) {
+	cluster := etcdfake.NewCluster()
+	t.Cleanup(cluster.Close)
+	central, err := cluster.Start("central-1", "http://127.0.21.1:22380", "http://127.0.21.1:22379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The central cluster only serves the v3 API
+	cluster.DisableV2()
+	client, err := etcd.New(etcd.WithLogger(logging.Discard), etcd.WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := registry.Etcd{Client: client, Endpoints: []etcd.Member{central}, Prefix: "/etcdmate/clusters", Cluster: "default"}
+	ctx := context.Background()
+	for _, e := range []registry.Entry{
+		{Name: "dc1-a", PeerURL: "https://10.1.0.1:2380", ClientURL: "https://10.1.0.1:2379"},
+		{Name: "dc1-b", PeerURL: "https://10.1.0.2:2380", ClientURL: "https://10.1.0.2:2379", TTL: 1},
+		{Name: "dc1-c", PeerURL: "https://10.1.0.3:2380", ClientURL: "https://10.1.0.3:2379"},
+	} {
+		if err := s.Register(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Deregister(ctx, "dc1-c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := cluster.Keys()["/etcdmate/clusters/default/members/dc1-a"]; !found {
+		t.Errorf("got keys %v, want the entry of dc1-a under the prefix", cluster.Keys())
+	}
+	entries, err := s.Entries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "dc1-a" || entries[1].Name != "dc1-b" {
+		t.Errorf("got entries %+v, want dc1-a and dc1-b", entries)
+	}
+	// The lease of dc1-b expires
+	time.Sleep(1100 * time.Millisecond)
+	entries, err = s.Entries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "dc1-a" || entries[0].PeerURL != "https://10.1.0.1:2380" {
+		t.Errorf("got entries %+v, want dc1-a", entries)
+	}
+}
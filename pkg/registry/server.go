@@ -0,0 +1,248 @@
+// Package registry serves and queries a small HTTP registry of the members
+// of etcd clusters, so members etcdmate can't discover from AWS, e.g. on
+// premises, and the discovered ones can find each other.
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// SourceAWS marks the entries etcdmate registers for the members it
+// discovered from AWS
+const SourceAWS = "aws"
+
+// Entry is a registered member
+type Entry struct {
+	Name      string
+	ClientURL string
+	PeerURL   string
+	Zone      string `json:",omitempty"`
+	// Source tells who registered the member, SourceAWS for etcdmate
+	Source string `json:",omitempty"`
+	// TTL is how many seconds the entry lasts unless registered again, 0
+	// until it is deleted
+	TTL     int64 `json:",omitempty"`
+	Updated time.Time
+}
+
+// Member returns the etcd member of e
+func (e Entry) Member() etcd.Member {
+	return etcd.Member{Name: e.Name, ClientURL: e.ClientURL, PeerURL: e.PeerURL, Zone: e.Zone}
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.TTL > 0 && now.Sub(e.Updated) > time.Duration(e.TTL)*time.Second
+}
+
+// Server exposes, to clients sending Token as a bearer token:
+//
+//	GET    /v1/clusters/CLUSTER/members       the entries of the cluster
+//	PUT    /v1/clusters/CLUSTER/members/NAME  register the member NAME
+//	DELETE /v1/clusters/CLUSTER/members/NAME  remove it
+type Server struct {
+	Token string
+	// File, when set, keeps the entries across restarts
+	File   string
+	Logger logging.Logger
+
+	mu       sync.Mutex
+	clusters map[string]map[string]Entry
+}
+
+// NewServer returns a server with the entries of file, if any
+func NewServer(token string, file string, logger logging.Logger) (*Server, error) {
+	if token == "" {
+		return nil, errors.New("The registry needs a token")
+	}
+	s := &Server{Token: token, File: file, Logger: logger, clusters: map[string]map[string]Entry{}}
+	if file == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.clusters); err != nil {
+		return nil, errors.New(fmt.Sprint("Unreadable registry file ", file, ": ", err))
+	}
+	return s, nil
+}
+
+func (s *Server) log() logging.Logger {
+	return logging.OrDefault(s.Logger)
+}
+
+// Serve listens on addr until ctx is done, with TLS when certFile and
+// keyFile are set
+func (s *Server) Serve(ctx context.Context, addr string, certFile string, keyFile string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if certFile != "" {
+		err = srv.ServeTLS(l, certFile, keyFile)
+	} else {
+		err = srv.Serve(l)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/clusters/", s.authorized(s.handleClusters))
+	return mux
+}
+
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+s.Token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleClusters routes /v1/clusters/CLUSTER/members[/NAME]
+func (s *Server) handleClusters(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/clusters/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "members":
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, s.entries(parts[0]))
+	case len(parts) == 3 && parts[0] != "" && parts[1] == "members" && parts[2] != "":
+		switch r.Method {
+		case "PUT":
+			s.handleRegister(w, r, parts[0], parts[2])
+		case "DELETE":
+			s.handleDelete(w, parts[0], parts[2])
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request, cluster string, name string) {
+	var e Entry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, u := range []string{e.PeerURL, e.ClientURL} {
+		if parsed, err := url.Parse(u); err != nil || parsed.Host == "" {
+			http.Error(w, fmt.Sprint("Invalid URL ", u), http.StatusBadRequest)
+			return
+		}
+	}
+	if e.TTL < 0 {
+		http.Error(w, "Negative TTL", http.StatusBadRequest)
+		return
+	}
+	e.Name = name
+	e.Updated = time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clusters[cluster] == nil {
+		s.clusters[cluster] = map[string]Entry{}
+	}
+	if previous, ok := s.clusters[cluster][name]; !ok || previous.PeerURL != e.PeerURL {
+		s.log().Println("Registered", name, e.PeerURL, "in", cluster)
+	}
+	s.clusters[cluster][name] = e
+	if err := s.save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, e)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, cluster string, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clusters[cluster][name]; !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	delete(s.clusters[cluster], name)
+	s.log().Println("Deleted", name, "from", cluster)
+	if err := s.save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// entries returns the unexpired entries of cluster by name, dropping the
+// expired ones
+func (s *Server) entries(cluster string) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	entries := []Entry{}
+	for name, e := range s.clusters[cluster] {
+		if e.expired(now) {
+			s.log().Println("Entry of", name, "in", cluster, "expired")
+			delete(s.clusters[cluster], name)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// save writes the entries to File, call it with mu held
+func (s *Server) save() error {
+	if s.File == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.clusters, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.File + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.File)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
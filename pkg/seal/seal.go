@@ -0,0 +1,105 @@
+// Package seal envelope encrypts small local files with a KMS key
+package seal
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Sealer encrypts data at rest
+type Sealer interface {
+	Seal(ctx context.Context, plaintext []byte) ([]byte, error)
+	Open(ctx context.Context, sealed []byte) ([]byte, error)
+}
+
+// KMSAPI is the subset of the KMS API the sealer uses
+type KMSAPI interface {
+	GenerateDataKeyWithContext(aws.Context, *kms.GenerateDataKeyInput, ...request.Option) (*kms.GenerateDataKeyOutput, error)
+	DecryptWithContext(aws.Context, *kms.DecryptInput, ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// KMS seals with a data key generated for every write and stored next to
+// the data, encrypted by KeyID
+type KMS struct {
+	KMS   KMSAPI
+	KeyID string
+}
+
+const formatKMS = "kms-aes-gcm"
+
+// envelope is the sealed file format, encoding/json stores the byte slices
+// base64 encoded
+type envelope struct {
+	Sealed string
+	Key    []byte
+	Nonce  []byte
+	Data   []byte
+}
+
+// IsSealed reports whether data was produced by a Sealer of this package
+func IsSealed(data []byte) bool {
+	var env envelope
+	return json.Unmarshal(data, &env) == nil && env.Sealed != ""
+}
+
+func (s KMS) Seal(ctx context.Context, plaintext []byte) ([]byte, error) {
+	resp, err := s.KMS.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(s.KeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{
+		Sealed: formatKMS,
+		Key:    resp.CiphertextBlob,
+		Nonce:  nonce,
+		Data:   gcm.Seal(nil, nonce, plaintext, nil),
+	})
+}
+
+func (s KMS) Open(ctx context.Context, sealed []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		return nil, err
+	}
+	if env.Sealed != formatKMS {
+		return nil, errors.New("Unknown sealed format " + env.Sealed)
+	}
+	resp, err := s.KMS.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob: env.Key,
+		KeyId:          aws.String(s.KeyID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, env.Nonce, env.Data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
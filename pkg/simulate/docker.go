@@ -0,0 +1,64 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// docker runs the docker CLI and returns its trimmed output
+func docker(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("docker %s failed: %v: %s", args[0], err, out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runEtcd starts an etcd container for n configured by the env file
+// etcdmate wrote
+func (s *Simulation) runEtcd(ctx context.Context, n *node) error {
+	clientURL := fmt.Sprintf("http://%s:2379", n.ip)
+	peerURL := fmt.Sprintf("http://%s:2380", n.ip)
+	_, err := docker(
+		ctx,
+		"run", "-d",
+		"--name", s.container(n),
+		"--label", label,
+		"--network", s.opts.Network,
+		"--ip", n.ip,
+		"--env-file", n.cfg.EnvFile,
+		"-e", "ETCD_NAME="+n.id,
+		"-e", "ETCD_DATA_DIR=/var/lib/etcd",
+		"-e", "ETCD_LISTEN_CLIENT_URLS=http://0.0.0.0:2379",
+		"-e", "ETCD_ADVERTISE_CLIENT_URLS="+clientURL,
+		"-e", "ETCD_LISTEN_PEER_URLS=http://0.0.0.0:2380",
+		"-e", "ETCD_INITIAL_ADVERTISE_PEER_URLS="+peerURL,
+		"--entrypoint", "/usr/local/bin/etcd",
+		s.opts.Image,
+	)
+	return err
+}
+
+func (s *Simulation) removeEtcd(ctx context.Context, n *node) error {
+	_, err := docker(ctx, "rm", "-f", s.container(n))
+	return err
+}
+
+func (s *Simulation) container(n *node) string {
+	return s.opts.Network + "-" + n.id
+}
+
+const label = "etcdmate-simulate"
+
+// cleanup removes every container and the network of a simulation,
+// including those left behind by an interrupted one
+func (s *Simulation) cleanup(ctx context.Context) {
+	ids, err := docker(ctx, "ps", "-aq", "--filter", "label="+label, "--filter", "network="+s.opts.Network)
+	if err == nil && ids != "" {
+		docker(ctx, append([]string{"rm", "-f"}, strings.Fields(ids)...)...)
+	}
+	docker(ctx, "network", "rm", s.opts.Network)
+}
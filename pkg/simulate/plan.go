@@ -0,0 +1,365 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	etcdfake "github.com/viruxel/etcdmate/pkg/etcd/fake"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+// Scenario describes a cluster and what happens to its instances, for Plan
+type Scenario struct {
+	// Members is the size of the initial cluster, healthy and configured.
+	// Instances are named i-1, i-2... in launch order.
+	Members int `json:"members"`
+	// Zones are given to the instances in turn
+	Zones []string `json:"zones"`
+	Steps []Step   `json:"steps"`
+}
+
+// Step is one event, exactly one of its fields is set
+type Step struct {
+	// Launch is how many instances the group launches
+	Launch int `json:"launch,omitempty"`
+	// Terminate terminates an instance, its member goes down with it
+	Terminate string `json:"terminate,omitempty"`
+	// Partition makes an instance unreachable, it doesn't run etcdmate
+	// until Heal
+	Partition string `json:"partition,omitempty"`
+	Heal      string `json:"heal,omitempty"`
+	// Wait moves the clock, e.g. past a grace period or a cooldown
+	Wait string `json:"wait,omitempty"`
+}
+
+func (s Step) String() string {
+	switch {
+	case s.Launch > 0:
+		return fmt.Sprint("launch ", s.Launch)
+	case s.Terminate != "":
+		return "terminate " + s.Terminate
+	case s.Partition != "":
+		return "partition " + s.Partition
+	case s.Heal != "":
+		return "heal " + s.Heal
+	}
+	return "wait " + s.Wait
+}
+
+// ParseScenario reads a YAML or JSON scenario
+func ParseScenario(data []byte) (Scenario, error) {
+	var sc Scenario
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		var err error
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return sc, errors.New(fmt.Sprint("Invalid scenario: ", err))
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sc); err != nil {
+		return sc, errors.New(fmt.Sprint("Invalid scenario: ", err))
+	}
+	if sc.Members < 1 {
+		return sc, errors.New("Invalid scenario: members must be at least 1")
+	}
+	for i, step := range sc.Steps {
+		set := 0
+		for _, ok := range []bool{step.Launch > 0, step.Terminate != "", step.Partition != "", step.Heal != "", step.Wait != ""} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return sc, errors.New(fmt.Sprint("Invalid scenario: step ", i+1, " must have exactly one of launch, terminate, partition, heal and wait"))
+		}
+		if step.Wait != "" {
+			if _, err := time.ParseDuration(step.Wait); err != nil {
+				return sc, errors.New(fmt.Sprint("Invalid scenario: step ", i+1, ": ", err))
+			}
+		}
+	}
+	return sc, nil
+}
+
+type PlanOptions struct {
+	// Policy sets the options of the configuration of every instance, those
+	// the scenario checks such as StaleGrace or MinZones
+	Policy func(*reconcile.Config)
+	// Explain adds the reasons of the decisions to the plan
+	Explain bool
+	// Out gets the plan
+	Out io.Writer
+	// Log, when set, gets the logs of the instances
+	Log io.Writer
+}
+
+type planInstance struct {
+	id          string
+	cfg         reconcile.Config
+	started     bool
+	terminated  bool
+	partitioned bool
+}
+
+type planner struct {
+	opts      PlanOptions
+	scenario  Scenario
+	aws       *fake.AWS
+	cluster   *etcdfake.Cluster
+	client    etcd.Client
+	dir       string
+	instances []*planInstance
+	offset    time.Duration
+	events    []reconcile.Event
+}
+
+// Plan plays the scenario against an in-memory AWS and etcd: after each
+// step every running instance does one reconciliation pass, whose actions
+// and refusals are printed. Members listen on 127.0.10.0/24, which Linux
+// routes to the loopback.
+func Plan(ctx context.Context, sc Scenario, opts PlanOptions) error {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	client, err := etcd.New(etcd.WithLogger(logging.Discard), etcd.WithTimeout(2*time.Second), etcd.WithDialTimeout(time.Second))
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "etcdmate-plan-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	launches := sc.Members
+	for _, step := range sc.Steps {
+		launches += step.Launch
+	}
+	if launches > 250 {
+		return errors.New(fmt.Sprint("Scenario launches ", launches, " instances, at most 250 are supported"))
+	}
+	p := &planner{
+		opts:     opts,
+		scenario: sc,
+		aws:      fake.New(),
+		cluster:  etcdfake.NewCluster(),
+		client:   client,
+		dir:      dir,
+	}
+	defer p.cluster.Close()
+	p.aws.AddGroup(group, 1, int64(launches))
+
+	initial := []string{}
+	for i := 0; i < sc.Members; i++ {
+		in := p.launch()
+		if _, err := p.cluster.Start(in.id, p.peerURL(in), p.clientURL(in)); err != nil {
+			return err
+		}
+		in.started = true
+		initial = append(initial, in.id)
+	}
+	fmt.Fprintln(opts.Out, "Step 0: cluster of", strings.Join(initial, ", "))
+	p.round(ctx)
+	for i, step := range sc.Steps {
+		fmt.Fprintf(opts.Out, "Step %d: %s\n", i+1, step)
+		if err := p.apply(step); err != nil {
+			return errors.New(fmt.Sprint("Step ", i+1, ": ", err))
+		}
+		p.round(ctx)
+	}
+	return nil
+}
+
+func (p *planner) now() time.Time {
+	return time.Now().Add(p.offset)
+}
+
+func (p *planner) ip(in *planInstance) string {
+	return fmt.Sprint("127.0.10.", strings.TrimPrefix(in.id, "i-"))
+}
+
+func (p *planner) clientURL(in *planInstance) string {
+	return fmt.Sprint("http://", p.ip(in), ":", planURLs.ClientPort)
+}
+
+func (p *planner) peerURL(in *planInstance) string {
+	return fmt.Sprint("http://", p.ip(in), ":", planURLs.PeerPort)
+}
+
+// planURLs avoid the ports of an etcd running locally
+var planURLs = discovery.URLs{
+	ClientSchema: "http",
+	ClientPort:   12379,
+	PeerSchema:   "http",
+	PeerPort:     12380,
+}
+
+// launch adds an instance to the group, in the next zone
+func (p *planner) launch() *planInstance {
+	in := &planInstance{id: fmt.Sprint("i-", len(p.instances)+1)}
+	instance := p.aws.Launch(group, in.id, p.ip(in))
+	if len(p.scenario.Zones) > 0 {
+		instance.AvailabilityZone = p.scenario.Zones[len(p.instances)%len(p.scenario.Zones)]
+	}
+	p.aws.SetActivityTime(group, p.now())
+	logger := logging.Discard
+	if p.opts.Log != nil {
+		logger = log.New(p.opts.Log, in.id+" ", log.LstdFlags)
+	}
+	in.cfg = reconcile.Config{
+		AWS:        p.aws.Services(),
+		Client:     p.client,
+		URLs:       planURLs,
+		InstanceID: in.id,
+		StateFile:  path.Join(p.dir, in.id+".state"),
+		EnvFile:    path.Join(p.dir, in.id+".env"),
+		Logger:     logger,
+	}
+	if p.opts.Policy != nil {
+		p.opts.Policy(&in.cfg)
+	}
+	in.cfg.Now = p.now
+	in.cfg.Events = func(e reconcile.Event) {
+		p.events = append(p.events, e)
+	}
+	p.instances = append(p.instances, in)
+	return in
+}
+
+func (p *planner) instance(id string) (*planInstance, error) {
+	for _, in := range p.instances {
+		if in.id == id && !in.terminated {
+			return in, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprint("No running instance ", id))
+}
+
+func (p *planner) apply(step Step) error {
+	switch {
+	case step.Launch > 0:
+		ids := []string{}
+		for i := 0; i < step.Launch; i++ {
+			ids = append(ids, p.launch().id)
+		}
+		fmt.Fprintln(p.opts.Out, "  launched", strings.Join(ids, ", "))
+	case step.Terminate != "":
+		in, err := p.instance(step.Terminate)
+		if err != nil {
+			return err
+		}
+		p.aws.Terminate(in.id)
+		p.aws.SetActivityTime(group, p.now())
+		p.cluster.Stop(in.id)
+		in.terminated = true
+	case step.Partition != "":
+		in, err := p.instance(step.Partition)
+		if err != nil {
+			return err
+		}
+		p.cluster.Stop(in.id)
+		in.partitioned = true
+	case step.Heal != "":
+		in, err := p.instance(step.Heal)
+		if err != nil {
+			return err
+		}
+		p.cluster.Resume(in.id)
+		in.partitioned = false
+	default:
+		d, err := time.ParseDuration(step.Wait)
+		if err != nil {
+			return err
+		}
+		p.offset += d
+	}
+	return nil
+}
+
+// round runs a pass on every running instance, oldest first, starting the
+// etcd of those that joined
+func (p *planner) round(ctx context.Context) {
+	for _, in := range p.instances {
+		if in.terminated {
+			continue
+		}
+		if in.partitioned {
+			fmt.Fprintf(p.opts.Out, "  %s: partitioned\n", in.id)
+			continue
+		}
+		p.events = nil
+		cfg := in.cfg
+		cfg.Summary = reconcile.NewSummary()
+		state, err := reconcile.Reconcile(ctx, cfg)
+		actions := []string{}
+		for _, e := range p.events {
+			if e.Type == reconcile.EventReconcileError {
+				continue
+			}
+			action := string(e.Type)
+			if e.Member.Name != "" {
+				action += " " + e.Member.Name
+			}
+			if e.Message != "" {
+				action += ": " + e.Message
+			}
+			actions = append(actions, action)
+		}
+		if err == nil && !in.started && !state.Observer {
+			if _, startErr := p.cluster.Start(in.id, p.peerURL(in), p.clientURL(in)); startErr != nil {
+				err = startErr
+			} else {
+				in.started = true
+				actions = append(actions, "started etcd")
+			}
+		}
+		if err != nil {
+			actions = append(actions, "error: "+err.Error())
+		}
+		if len(actions) == 0 {
+			actions = append(actions, "nothing to do")
+		}
+		if p.opts.Explain {
+			for _, reason := range cfg.Summary.Reasons {
+				actions = append(actions, "why: "+reason)
+			}
+		}
+		for _, action := range actions {
+			fmt.Fprintf(p.opts.Out, "  %s: %s\n", in.id, action)
+		}
+	}
+	members := []string{}
+	for _, m := range p.cluster.Members() {
+		name := m.Name
+		if name == "" {
+			// Added, its etcd not started yet
+			name = m.PeerURL
+			for _, in := range p.instances {
+				if p.peerURL(in) == m.PeerURL {
+					name = in.id
+				}
+			}
+		}
+		if in, err := p.instance(name); err != nil || in.partitioned || !in.started {
+			name += " (down)"
+		}
+		members = append(members, name)
+	}
+	fmt.Fprintln(p.opts.Out, "  members:", strings.Join(members, ", "))
+}
@@ -0,0 +1,381 @@
+// Package simulate runs the etcdmate workflows against etcd containers and
+// an in-memory AWS, so changes can be checked before they reach real fleets.
+// The containers must be reachable from the host, which is the case for
+// Docker bridge networks on Linux.
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/discovery/fake"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/logging"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+const group = "etcdmate-simulate"
+
+type Options struct {
+	// Image is an etcd 3 image with /usr/local/bin/etcd
+	Image string
+	// Network is the Docker network created for the simulation
+	Network string
+	// Subnet of the network, members get addresses from its tenth one
+	Subnet string
+	// Size is the cluster size, scale-up adds two members to it
+	Size int
+	// BootDelay is how long a launched instance takes to run etcdmate,
+	// rollouts rely on it to add the learner first
+	BootDelay time.Duration
+	Wait      time.Duration
+	Logger    logging.Logger
+}
+
+type node struct {
+	id  string
+	ip  string
+	cfg reconcile.Config
+}
+
+// Simulation plays the Autoscaling group: it launches an instance running
+// etcdmate and etcd for every pending launch and removes the containers of
+// detached and terminated instances.
+type Simulation struct {
+	opts   Options
+	aws    *fake.AWS
+	client etcd.Client
+	dir    string
+	ips    []net.IP
+
+	mu    sync.Mutex
+	nodes []*node
+	next  int
+}
+
+type scenario struct {
+	name string
+	run  func(context.Context) error
+}
+
+// Run bootstraps a cluster then scales it up, replaces a member and scales
+// it down, checking after each step that the members are those of the group
+// and healthy.
+func Run(ctx context.Context, opts Options) error {
+	s, err := newSimulation(opts)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(s.dir)
+	s.cleanup(context.Background())
+	defer s.cleanup(context.Background())
+	_, err = docker(ctx, "network", "create", "--label", label, "--subnet", opts.Subnet, opts.Network)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scenarios := []scenario{
+		{"bootstrap", s.bootstrap},
+		{"scale-up", s.scaleUp},
+		{"replace", s.replace},
+		{"scale-down", s.scaleDown},
+	}
+	for i, sc := range scenarios {
+		s.log().Println("Scenario", sc.name)
+		err := sc.run(ctx)
+		if err == nil {
+			err = s.checkCluster(ctx)
+		}
+		if err != nil {
+			return errors.New(fmt.Sprint("Scenario ", sc.name, " failed: ", err))
+		}
+		s.log().Println("Scenario", sc.name, "passed")
+		if i == 0 {
+			go s.autoscale(ctx)
+		}
+	}
+	return nil
+}
+
+func newSimulation(opts Options) (*Simulation, error) {
+	base, _, err := net.ParseCIDR(opts.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	base = base.To4()
+	if base == nil {
+		return nil, errors.New(fmt.Sprint("Subnet must be IPv4 ", opts.Subnet))
+	}
+	ips := []net.IP{}
+	for i := 10; i < 250; i++ {
+		ips = append(ips, net.IPv4(base[0], base[1], base[2], byte(i)))
+	}
+	client, err := etcd.New(etcd.WithLogger(logging.Discard), etcd.WithTimeout(5*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "etcdmate-simulate-")
+	if err != nil {
+		return nil, err
+	}
+	aws := fake.New()
+	aws.AddGroup(group, 1, int64(opts.Size+3))
+	return &Simulation{opts: opts, aws: aws, client: client, dir: dir, ips: ips}, nil
+}
+
+func (s *Simulation) log() logging.Logger {
+	return logging.OrDefault(s.opts.Logger)
+}
+
+// newNode allocates the next instance ID and address
+func (s *Simulation) newNode() (*node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= len(s.ips) {
+		return nil, errors.New("No address left in the subnet")
+	}
+	n := &node{id: fmt.Sprintf("i-sim%04d", s.next), ip: s.ips[s.next].String()}
+	s.next++
+	n.cfg = reconcile.Config{
+		AWS:    s.aws.Services(),
+		Client: s.client,
+		URLs: discovery.URLs{
+			ClientSchema: "http",
+			ClientPort:   2379,
+			PeerSchema:   "http",
+			PeerPort:     2380,
+		},
+		InstanceID:          n.id,
+		StateFile:           path.Join(s.dir, n.id+".state"),
+		EnvFile:             path.Join(s.dir, n.id+".env"),
+		Logger:              log.New(os.Stderr, n.id+" ", log.LstdFlags),
+		NewClusterReachable: 1,
+		RemoveStale:         true,
+	}
+	s.nodes = append(s.nodes, n)
+	return n, nil
+}
+
+func (s *Simulation) bootstrap(ctx context.Context) error {
+	nodes := []*node{}
+	for i := 0; i < s.opts.Size; i++ {
+		n, err := s.newNode()
+		if err != nil {
+			return err
+		}
+		s.aws.Launch(group, n.id, n.ip)
+		nodes = append(nodes, n)
+	}
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			errs[i] = reconcile.Bootstrap(ctx, n.cfg, reconcile.BootstrapOptions{Size: len(nodes), Wait: s.opts.Wait})
+			if errs[i] == nil {
+				errs[i] = s.runEtcd(ctx, n)
+			}
+		}(i, n)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) scaleUp(ctx context.Context) error {
+	return s.setDesired(ctx, s.opts.Size+2)
+}
+
+// replace replaces the oldest member but the first, which runs the command
+func (s *Simulation) replace(ctx context.Context) error {
+	live := s.live()
+	if len(live) < 2 {
+		return errors.New("Not enough members to replace one")
+	}
+	return reconcile.Replace(ctx, live[0].cfg, reconcile.RolloutOptions{Wait: s.opts.Wait}, live[1].id)
+}
+
+func (s *Simulation) scaleDown(ctx context.Context) error {
+	return reconcile.ScaleDown(ctx, s.live()[0].cfg, reconcile.ScaleDownOptions{
+		TargetSize: s.opts.Size,
+		Wait:       s.opts.Wait,
+		Terminate:  true,
+	})
+}
+
+// setDesired changes the capacity and waits for the launched members
+func (s *Simulation) setDesired(ctx context.Context, size int) error {
+	asgName := group
+	desired := int64(size)
+	_, err := s.aws.SetDesiredCapacityWithContext(ctx, &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: &asgName,
+		DesiredCapacity:      &desired,
+	})
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(s.opts.Wait)
+	for len(s.live()) != size || s.aws.Pending(group) > 0 {
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("Timed out waiting for ", size, " instances"))
+		}
+		if err := sleep(ctx, 2*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoscale launches pending instances and removes the containers of
+// instances that left the group
+func (s *Simulation) autoscale(ctx context.Context) {
+	for {
+		for i := s.aws.Pending(group); i > 0; i-- {
+			n, err := s.newNode()
+			if err != nil {
+				s.log().Println(err)
+				break
+			}
+			s.aws.Fill(group, n.id, n.ip)
+			go func() {
+				if err := s.boot(ctx, n); err != nil {
+					s.log().Println("Instance", n.id, "failed to join:", err)
+				}
+			}()
+		}
+		for _, n := range s.gone() {
+			s.log().Println("Removing the container of", n.id)
+			if err := s.removeEtcd(ctx, n); err != nil {
+				s.log().Println(err)
+			}
+		}
+		if err := sleep(ctx, 2*time.Second); err != nil {
+			return
+		}
+	}
+}
+
+// boot runs what the user data of an instance would: etcdmate join, then
+// etcd with the generated configuration
+func (s *Simulation) boot(ctx context.Context, n *node) error {
+	if err := sleep(ctx, s.opts.BootDelay); err != nil {
+		return err
+	}
+	state, err := reconcile.Reconcile(ctx, n.cfg)
+	if err != nil {
+		return err
+	}
+	err = s.runEtcd(ctx, n)
+	if err != nil {
+		return err
+	}
+	return reconcile.VerifyLocal(ctx, n.cfg, state.Myself, s.opts.Wait)
+}
+
+// live returns the nodes still in the group, oldest first
+func (s *Simulation) live() []*node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live := []*node{}
+	for _, n := range s.nodes {
+		if i := s.aws.Instance(n.id); i != nil && i.LifecycleState == "InService" {
+			live = append(live, n)
+		}
+	}
+	return live
+}
+
+// gone returns the nodes that left the group, forgetting them
+func (s *Simulation) gone() []*node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gone, kept := []*node{}, []*node{}
+	for _, n := range s.nodes {
+		if i := s.aws.Instance(n.id); i != nil && i.LifecycleState != "InService" {
+			gone = append(gone, n)
+		} else {
+			kept = append(kept, n)
+		}
+	}
+	s.nodes = kept
+	return gone
+}
+
+// checkCluster waits until the cluster members are exactly the instances
+// of the group and all healthy
+func (s *Simulation) checkCluster(ctx context.Context) error {
+	deadline := time.Now().Add(s.opts.Wait)
+	for {
+		err := s.clusterMatches(ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		s.log().Println("Waiting for the cluster:", err)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Simulation) clusterMatches(ctx context.Context) error {
+	live := s.live()
+	if len(live) == 0 {
+		return errors.New("No instance in the group")
+	}
+	expectedMembers, err := live[0].cfg.ExpectedMembers(ctx)
+	if err != nil {
+		return err
+	}
+	for i, err := range s.client.CheckHealthAll(ctx, expectedMembers) {
+		if err != nil {
+			return errors.New(fmt.Sprint(expectedMembers[i].Name, " isn't healthy: ", err))
+		}
+	}
+	members, err := s.client.ListMembers(ctx, expectedMembers[0])
+	if err != nil {
+		return err
+	}
+	want, got := []string{}, []string{}
+	for _, m := range expectedMembers {
+		want = append(want, m.Name)
+	}
+	for _, m := range members {
+		got = append(got, m.Name)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		return errors.New(fmt.Sprint("Members are ", got, ", the group has ", want))
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
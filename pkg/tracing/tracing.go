@@ -0,0 +1,254 @@
+// Package tracing records spans and exports them with OTLP over HTTP in the
+// JSON encoding, which keeps the OpenTelemetry SDK out of the dependencies.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// maxSpans bounds the spans kept while the collector is unreachable
+const maxSpans = 4096
+
+// Tracer buffers ended spans until they are exported
+type Tracer struct {
+	// Endpoint is the OTLP/HTTP base URL, e.g. http://localhost:4318
+	Endpoint    string
+	ServiceName string
+	Attributes  map[string]string
+	HTTPClient  *http.Client
+	Logger      logging.Logger
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func NewTracer(endpoint string, serviceName string, logger logging.Logger) *Tracer {
+	return &Tracer{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Attributes:  map[string]string{},
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		Logger:      logger,
+	}
+}
+
+// Span is a timed operation, a nil Span ignores every call
+type Span struct {
+	tracer     *Tracer
+	name       string
+	traceID    string
+	spanID     string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+type contextKey int
+
+const (
+	tracerKey contextKey = iota
+	spanKey
+)
+
+// WithTracer returns a context whose Start calls record spans in t
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey, t)
+}
+
+// Start begins a span, child of the span in ctx if any. Without a tracer in
+// ctx it returns ctx unchanged and a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t, _ := ctx.Value(tracerKey).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		spanID:     randomID(8),
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	if parent, ok := ctx.Value(spanKey).(*Span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if s != nil {
+		s.attributes[key] = value
+	}
+}
+
+// End records the span with err as its status
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxSpans {
+		t.spans = t.spans[1:]
+	}
+	t.spans = append(t.spans, s)
+}
+
+// Flush exports the buffered spans, they are kept for the next flush when
+// the export fails
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	err := t.export(ctx, spans)
+	if err != nil {
+		t.mu.Lock()
+		t.spans = append(spans, t.spans...)
+		if len(t.spans) > maxSpans {
+			t.spans = t.spans[len(t.spans)-maxSpans:]
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes every interval until ctx is done
+func (t *Tracer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := t.Flush(ctx); err != nil {
+			logging.OrDefault(t.Logger).Println("Exporting spans failed:", err)
+		}
+	}
+}
+
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	resource := map[string]string{"service.name": t.ServiceName}
+	for k, v := range t.Attributes {
+		resource[k] = v
+	}
+	otlpSpans := []otlpSpan{}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attributes),
+		}
+		if s.err != nil {
+			span.Status = &otlpStatus{Code: statusError, Message: s.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": attributes(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "etcdmate"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(t.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprint("OTLP export failed with status ", resp.StatusCode))
+	}
+	return nil
+}
+
+const (
+	spanKindInternal = 1
+	statusError      = 2
+)
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	result := []otlpAttribute{}
+	for k, v := range m {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		result = append(result, a)
+	}
+	return result
+}
+
+// randomID returns n random bytes hex encoded, as OTLP/JSON expects ids
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,74 @@
+// Package wiretrace logs HTTP requests and responses for troubleshooting.
+package wiretrace
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/viruxel/etcdmate/pkg/logging"
+)
+
+// bodyLimit is how much of an error response body is logged
+const bodyLimit = 512
+
+// Transport logs the method, URL, status and latency of every request.
+// Query values are redacted and headers and request bodies aren't logged as
+// they carry credentials; the start of error response bodies is.
+type Transport struct {
+	Next   http.RoundTripper
+	Logger logging.Logger
+}
+
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := logging.OrDefault(t.Logger)
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	latency := time.Since(start).Round(time.Millisecond)
+	target := redact(req.URL)
+	if err != nil {
+		logger.Printf("HTTP %s %s failed after %s: %v\n", req.Method, target, latency, err)
+		return resp, err
+	}
+	logger.Printf("HTTP %s %s %d in %s\n", req.Method, target, resp.StatusCode, latency)
+	if resp.StatusCode >= 400 {
+		prefix := make([]byte, bodyLimit)
+		n, _ := io.ReadFull(resp.Body, prefix)
+		prefix = prefix[:n]
+		logger.Printf("HTTP %s %s body: %q\n", req.Method, target, prefix)
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), resp.Body), resp.Body}
+	}
+	return resp, nil
+}
+
+// Client returns client, or a new one, with its transport wrapped
+func Client(client *http.Client, logger logging.Logger) *http.Client {
+	traced := &http.Client{}
+	if client != nil {
+		*traced = *client
+	}
+	traced.Transport = Transport{Next: traced.Transport, Logger: logger}
+	return traced
+}
+
+func redact(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	for key := range query {
+		query.Set(key, "REDACTED")
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
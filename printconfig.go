@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	printConfigCmd = kingpin.Command(
+		"print-config",
+		"Print every effective setting with where it comes from: a flag, an environment variable or the default.",
+	)
+	printConfigFormat = printConfigCmd.Flag(
+		"format",
+		"Output format.",
+	).Default(
+		"text",
+	).Enum("text", "json")
+)
+
+// Setting is an effective setting as print-config shows it
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+	// Envar is the environment variable the setting can be given with
+	Envar string `json:",omitempty"`
+}
+
+const (
+	sourceFlag    = "flag"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+// settings resolves the global flags, whose values kingpin already merged
+// from args, the environment and the defaults, to their sources. Secrets
+// are masked.
+func settings(app *kingpin.Application, args []string) ([]Setting, error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return nil, err
+	}
+	given := map[string]bool{}
+	for _, element := range ctx.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			given[flag.Model().Name] = true
+		}
+	}
+	result := []Setting{}
+	for _, flag := range app.Model().Flags {
+		if flag.Hidden || flag.Name == "help" || flag.Name == "version" {
+			continue
+		}
+		s := Setting{Name: flag.Name, Value: flagValue(flag), Source: sourceDefault, Envar: flag.Envar}
+		switch {
+		case given[flag.Name]:
+			s.Source = sourceFlag
+		case flag.Envar != "" && configVars[flag.Envar] != "":
+			s.Source = sourceFile
+		case flag.Envar != "" && os.Getenv(flag.Envar) != "":
+			s.Source = sourceEnv
+		}
+		if secretSetting(flag.Name) && s.Value != "" {
+			s.Value = "********"
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// flagValue formats the value of flag, the String method of some kingpin
+// values prints their internals
+func flagValue(flag *kingpin.FlagModel) string {
+	getter, ok := flag.Value.(kingpin.Getter)
+	if !ok {
+		return flag.String()
+	}
+	switch v := getter.Get().(type) {
+	case *[]string:
+		return strings.Join(*v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func secretSetting(name string) bool {
+	return strings.Contains(name, "token") || strings.HasSuffix(name, "auth-key") || strings.HasSuffix(name, "password")
+}
+
+// printConfig writes the settings of the command line args to w
+func printConfig(w io.Writer, args []string, format string) error {
+	all, err := settings(kingpin.CommandLine, args)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(all)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range all {
+		source := s.Source
+		switch source {
+		case sourceEnv:
+			source = fmt.Sprint(sourceEnv, " (", s.Envar, ")")
+		case sourceFile:
+			source = fmt.Sprint(sourceFile, " (", s.Envar, ")")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Value, source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	runAsUser = kingpin.Flag(
+		"user",
+		"Drop to this user after reading the key material, requires starting as root.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_USER",
+	).String()
+	runAsGroup = kingpin.Flag(
+		"group",
+		"Drop to this group, the primary group of --user by default.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_GROUP",
+	).String()
+)
+
+// dropPrivileges switches to --user and --group once the TLS material is
+// loaded. The directories of the given files are created and handed over
+// first, together with the files that already exist, as the unprivileged
+// user still needs to write them.
+func dropPrivileges(files ...string) error {
+	if *runAsUser == "" {
+		return nil
+	}
+	if *restartUnit != "" {
+		return errors.New("--restart-unit needs root, it can't be used with --user")
+	}
+	u, err := user.Lookup(*runAsUser)
+	if err != nil {
+		return err
+	}
+	gid := u.Gid
+	if *runAsGroup != "" {
+		g, err := user.LookupGroup(*runAsGroup)
+		if err != nil {
+			return err
+		}
+		gid = g.Gid
+	}
+	uidNum, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gidNum, err := strconv.Atoi(gid)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		dir := path.Dir(file)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, uidNum, gidNum); err != nil {
+			return err
+		}
+		if err := os.Chown(file, uidNum, gidNum); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Clear the supplementary groups of root before switching
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gidNum); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(uidNum); err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		return errors.New(fmt.Sprint("Still running as root after switching to ", *runAsUser))
+	}
+	return nil
+}
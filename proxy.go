@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+var (
+	proxyOf = kingpin.Flag(
+		"proxy-of",
+		"Autoscaling group of the cluster the local instance proxies: instead of joining, write the configuration of an etcd gateway or gRPC proxy to the members of that group.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_PROXY_OF",
+	).String()
+	proxyOfTags = kingpin.Flag(
+		"proxy-of-tag",
+		"Like --proxy-of, the Autoscaling group of the cluster is the one with this KEY=VALUE tag, or KEY for any value. Repeat for a group with all of them.",
+	).Envar(
+		"ETCDMATE_PROXY_OF_TAG",
+	).Strings()
+	proxyMode = kingpin.Flag(
+		"proxy-mode",
+		"Proxy the instances of --proxy-of run, etcd gateway (TCP) or grpc-proxy.",
+	).Default(
+		"grpc-proxy",
+	).Envar(
+		"ETCDMATE_PROXY_MODE",
+	).Enum("gateway", "grpc-proxy")
+	proxyListenAddr = kingpin.Flag(
+		"proxy-listen-addr",
+		"Address the proxy listens on for the clients.",
+	).Default(
+		"127.0.0.1:23790",
+	).Envar(
+		"ETCDMATE_PROXY_LISTEN_ADDR",
+	).String()
+)
+
+// proxying tells whether the local instance is a proxy rather than a member
+func proxying() bool {
+	return *proxyOf != "" || len(*proxyOfTags) > 0
+}
+
+// proxyGroup returns the Autoscaling group of the cluster a proxy follows,
+// --proxy-of or the one found by --proxy-of-tag
+func proxyGroup(ctx context.Context, svc discovery.AWS) (string, error) {
+	if *proxyOf != "" {
+		return *proxyOf, nil
+	}
+	filters := []discovery.TagFilter{}
+	for _, text := range *proxyOfTags {
+		filter, err := discovery.ParseTagFilter(text)
+		if err != nil {
+			return "", misconfigured(err)
+		}
+		filters = append(filters, filter)
+	}
+	return svc.FindAsg(ctx, filters)
+}
+
+// newProxy returns the proxy of --proxy-of, nil for a member
+func newProxy() *output.Proxy {
+	if !proxying() {
+		return nil
+	}
+	return &output.Proxy{Mode: *proxyMode, ListenAddr: *proxyListenAddr}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/backup"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	quorumRecoveryAfter = kingpin.Flag(
+		"quorum-recovery-after",
+		"In daemon mode, recreate the cluster once a majority of members was unreachable this long, 0 never.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_QUORUM_RECOVERY_AFTER",
+	).Duration()
+
+	restoreCmd = kingpin.Command(
+		"restore",
+		"Recreate the cluster from the latest snapshot of --backup-s3-url after every member was lost, run on each instance.",
+	)
+	restoreWait = restoreCmd.Flag(
+		"wait-timeout",
+		"How long to wait for the restored member, and for the lowest one to restore the cluster.",
+	).Default(
+		"15m",
+	).Duration()
+)
+
+// newRecovery returns nil unless --quorum-recovery-after is set
+func newRecovery(sess *session.Session) (*reconcile.Recovery, error) {
+	if *quorumRecoveryAfter <= 0 {
+		return nil, nil
+	}
+	if *restartUnit == "" {
+		return nil, errors.New("--quorum-recovery-after needs --restart-unit")
+	}
+	recovery := &reconcile.Recovery{
+		After:   *quorumRecoveryAfter,
+		Unit:    *restartUnit,
+		DataDir: *etcdDataDir,
+		Wait:    *verifyTimeout,
+	}
+	if recovery.Wait == 0 {
+		recovery.Wait = 5 * time.Minute
+	}
+	if *backupS3URL != "" {
+		snapshots, err := newRestorer(sess)
+		if err != nil {
+			return nil, err
+		}
+		recovery.Snapshots = snapshots
+	}
+	return recovery, nil
+}
+
+// runRestore restores the cluster from the latest snapshot, see
+// reconcile.Recovery.Restore
+func runRestore(ctx context.Context, sess *session.Session, cfg reconcile.Config) error {
+	snapshots, err := newRestorer(sess)
+	if err != nil {
+		return err
+	}
+	recovery := &reconcile.Recovery{
+		Unit:      *restartUnit,
+		DataDir:   *etcdDataDir,
+		Snapshots: snapshots,
+		Wait:      *restoreWait,
+	}
+	return recovery.Restore(ctx, cfg)
+}
+
+func newRestorer(sess *session.Session) (backup.Restorer, error) {
+	store, err := backup.NewStore(s3.New(sess), *backupS3URL)
+	if err != nil {
+		return backup.Restorer{}, err
+	}
+	return backup.Restorer{
+		Store:       store,
+		Snapshotter: snapshotter(),
+		Dir:         *backupDir,
+	}, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/registry"
+)
+
+var (
+	registryURL = kingpin.Flag(
+		"registry-url",
+		"URL of an etcdmate registry, see serve-registry: the local member is registered there and the members registered by others, e.g. on premises, are expected members too.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_REGISTRY_URL",
+	).String()
+	registryToken = kingpin.Flag(
+		"registry-token",
+		"Bearer token of the registry.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_REGISTRY_TOKEN",
+	).String()
+	registryCluster = kingpin.Flag(
+		"registry-cluster",
+		"Name of the cluster in the registry.",
+	).Default(
+		"default",
+	).Envar(
+		"ETCDMATE_REGISTRY_CLUSTER",
+	).String()
+	registryEtcdEndpoints = kingpin.Flag(
+		"registry-etcd-endpoints",
+		"Client URLs of a central etcd cluster keeping the registry under --registry-etcd-prefix, instead of --registry-url. Repeatable or comma separated.",
+	).Envar(
+		"ETCDMATE_REGISTRY_ETCD_ENDPOINTS",
+	).Strings()
+	registryEtcdPrefix = kingpin.Flag(
+		"registry-etcd-prefix",
+		"Key prefix of the registry in the central etcd cluster, the members of a cluster are under PREFIX/CLUSTER/members.",
+	).Default(
+		"/etcdmate/clusters",
+	).Envar(
+		"ETCDMATE_REGISTRY_ETCD_PREFIX",
+	).String()
+	registryEtcdCAFile = kingpin.Flag(
+		"registry-etcd-ca-file",
+		"CA of the central etcd cluster.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_REGISTRY_ETCD_CA_FILE",
+	).String()
+	registryEtcdCertFile = kingpin.Flag(
+		"registry-etcd-cert-file",
+		"Client certificate for the central etcd cluster.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_REGISTRY_ETCD_CERT_FILE",
+	).String()
+	registryEtcdKeyFile = kingpin.Flag(
+		"registry-etcd-key-file",
+		"Key of --registry-etcd-cert-file.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_REGISTRY_ETCD_KEY_FILE",
+	).String()
+	registryTTL = kingpin.Flag(
+		"registry-ttl",
+		"How long the registry keeps the entry of the local member unless registered again, 0 until it is deleted. Daemons register again on every pass.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_REGISTRY_TTL",
+	).Duration()
+
+	serveRegistryCmd = kingpin.Command(
+		"serve-registry",
+		"Serve a registry of the members of etcd clusters, for members etcdmate can't discover from AWS.",
+	)
+	serveRegistryListen = serveRegistryCmd.Flag(
+		"listen",
+		"Address to serve the registry on.",
+	).Default(
+		":2390",
+	).String()
+	serveRegistryToken = serveRegistryCmd.Flag(
+		"token",
+		"Bearer token the clients must send.",
+	).Envar(
+		"ETCDMATE_REGISTRY_TOKEN",
+	).Required().String()
+	serveRegistryFile = serveRegistryCmd.Flag(
+		"data-file",
+		"File keeping the entries across restarts, in memory only if empty.",
+	).Default(
+		"",
+	).String()
+	serveRegistryCert = serveRegistryCmd.Flag(
+		"tls-cert",
+		"Certificate to serve the registry with https.",
+	).Default(
+		"",
+	).String()
+	serveRegistryKey = serveRegistryCmd.Flag(
+		"tls-key",
+		"Key of --tls-cert.",
+	).Default(
+		"",
+	).String()
+)
+
+// serveRegistry runs the registry until ctx is done, it needs nothing of
+// AWS
+func serveRegistry(ctx context.Context) error {
+	server, err := registry.NewServer(*serveRegistryToken, *serveRegistryFile, log.Default())
+	if err != nil {
+		return err
+	}
+	log.Println("Serving the registry on", *serveRegistryListen)
+	return server.Serve(ctx, *serveRegistryListen, *serveRegistryCert, *serveRegistryKey)
+}
+
+// newRegistry returns nil unless --registry-url or
+// --registry-etcd-endpoints is set
+func newRegistry() (registry.Registry, error) {
+	if *registryURL != "" {
+		return registry.Client{
+			URL:     *registryURL,
+			Token:   *registryToken,
+			Cluster: *registryCluster,
+		}, nil
+	}
+	endpoints := registryEndpoints()
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+	client, err := etcd.New(
+		etcd.WithTLS(*registryEtcdCAFile, *registryEtcdCertFile, *registryEtcdKeyFile),
+		etcd.WithTimeout(*timeout),
+		etcd.WithDialTimeout(*dialTimeout),
+		etcd.WithLogger(log.Default()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return registry.Etcd{
+		Client:    client,
+		Endpoints: endpoints,
+		Prefix:    *registryEtcdPrefix,
+		Cluster:   *registryCluster,
+	}, nil
+}
+
+// registryEndpoints returns the members of the central etcd cluster
+func registryEndpoints() []etcd.Member {
+	endpoints := []etcd.Member{}
+	for _, flag := range *registryEtcdEndpoints {
+		for _, u := range strings.Split(flag, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				endpoints = append(endpoints, etcd.Member{Name: u, ClientURL: u})
+			}
+		}
+	}
+	return endpoints
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var configFile = kingpin.Flag(
+	"config-file",
+	"File of ETCDMATE_* variables, one VAR=value per line as in a systemd env file, lists comma separated, or with a .yaml or .yml extension a YAML mapping of the settings by flag name. It wins over the environment, flags win over both. Daemons re-read it on SIGHUP.",
+).Default(
+	"",
+).Envar(
+	"ETCDMATE_CONFIG_FILE",
+).String()
+
+var (
+	configCmd = kingpin.Command(
+		"config",
+		"Work with --config-file.",
+	)
+	configValidateCmd = configCmd.Command(
+		"validate",
+		"Check --config-file and the settings it results in, without any AWS or etcd request.",
+	)
+)
+
+// reloadable are the flags a SIGHUP applies to a running daemon, the others
+// only change on a restart
+var reloadable = []string{
+	"interval",
+	"restart-min-interval",
+	"verify-timeout",
+	"step-down",
+	"explain",
+	"history-size",
+	"discovery-wait",
+	"pause-file",
+}
+
+// configVars are the variables read from --config-file
+var configVars = map[string]string{}
+
+// readConfigFile parses the VAR=value lines of file, skipping blank lines
+// and comments, or its YAML settings
+func readConfigFile(file string) (map[string]string, error) {
+	if yamlConfigFile(file) {
+		return readYAMLConfigFile(file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected VAR=value", file, n))
+		}
+		vars[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+	}
+	return vars, scanner.Err()
+}
+
+// loadConfigFile applies --config-file to the flags not given in args. With
+// names, only these flags are set, back to their environment variable or
+// default when the file no longer sets them.
+func loadConfigFile(app *kingpin.Application, args []string, names []string) error {
+	vars, err := readConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return err
+	}
+	given := map[string]bool{}
+	for _, element := range ctx.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			given[flag.Model().Name] = true
+		}
+	}
+	only := map[string]bool{}
+	for _, name := range names {
+		only[name] = true
+	}
+	for _, flag := range app.Model().Flags {
+		if given[flag.Name] || flag.Envar == "" || (names != nil && !only[flag.Name]) {
+			continue
+		}
+		value, ok := vars[flag.Envar]
+		if !ok && names == nil {
+			continue
+		}
+		if !ok {
+			value = os.Getenv(flag.Envar)
+			if value == "" {
+				value = strings.Join(flag.Default, ",")
+			}
+			if value == "" && flag.IsBoolFlag() {
+				value = "false"
+			}
+		}
+		if err := setFlag(flag, value); err != nil {
+			return errors.New(fmt.Sprint(flag.Envar, ": ", err))
+		}
+	}
+	configVars = vars
+	return nil
+}
+
+// setFlag replaces the value of flag, lists included
+func setFlag(flag *kingpin.FlagModel, value string) error {
+	getter, ok := flag.Value.(kingpin.Getter)
+	if !ok {
+		return flag.Value.Set(value)
+	}
+	list, ok := getter.Get().(*[]string)
+	if !ok {
+		return flag.Value.Set(value)
+	}
+	*list = nil
+	for _, item := range strings.Split(value, ",") {
+		if item == "" {
+			continue
+		}
+		if err := flag.Value.Set(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadConfigFile re-reads --config-file for the reloadable flags, keeping
+// their previous values when the result doesn't validate
+func reloadConfigFile(command string) error {
+	previous := map[string]string{}
+	flags := map[string]*kingpin.FlagModel{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		flags[flag.Name] = flag
+	}
+	for _, name := range reloadable {
+		previous[name] = flagValue(flags[name])
+	}
+	restore := func() {
+		for name, value := range previous {
+			if err := setFlag(flags[name], value); err != nil {
+				log.Println("Restoring", name, "failed:", err)
+			}
+		}
+	}
+	if err := loadConfigFile(kingpin.CommandLine, os.Args[1:], reloadable); err != nil {
+		restore()
+		return err
+	}
+	errs := 0
+	for _, p := range validateConfig(command) {
+		if !p.Warning {
+			log.Println("Configuration problem:", p)
+			errs++
+		}
+	}
+	if errs > 0 {
+		restore()
+		return errors.New(fmt.Sprintf("%d configuration problems, keeping the previous settings", errs))
+	}
+	return nil
+}
+
+// reloadedSettings returns the change of the reloadable settings to apply
+// to a running Supervise. The values are read now, not when it is applied.
+func reloadedSettings() func(*reconcile.Config, *reconcile.SuperviseOptions) {
+	interval, restartMinInterval, verifyTimeout, stepDown := *interval, *restartMinInterval, *verifyTimeout, *stepDown
+	explain, historySize, discoveryWait, pauseFile := *explain, *historySize, *discoveryWait, *pauseFile
+	return func(cfg *reconcile.Config, opts *reconcile.SuperviseOptions) {
+		opts.Interval = interval
+		opts.RestartMinInterval = restartMinInterval
+		opts.VerifyTimeout = verifyTimeout
+		opts.StepDown = stepDown
+		cfg.Explain = explain
+		cfg.HistorySize = historySize
+		cfg.DiscoveryWait = discoveryWait
+		cfg.PauseFile = pauseFile
+	}
+}
+
+// watchReloads reloads the TLS files and --config-file on every SIGHUP
+// until ctx is done, sending the reloaded settings to reloads. applied is
+// called with the configuration once Supervise applied them.
+func watchReloads(
+	ctx context.Context,
+	client etcd.Client,
+	reloads chan<- func(*reconcile.Config, *reconcile.SuperviseOptions),
+	applied func(reconcile.Config),
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		log.Println("Reloading on SIGHUP")
+		reloaded, err := client.ReloadTLS()
+		if err != nil {
+			log.Println("Reloading TLS files failed:", err)
+		} else if reloaded {
+			log.Println("TLS files reloaded")
+		}
+		if *configFile == "" {
+			continue
+		}
+		if err := reloadConfigFile(joinCmd.FullCommand()); err != nil {
+			log.Println("Reloading", *configFile, "failed:", err)
+			continue
+		}
+		apply := reloadedSettings()
+		select {
+		case <-ctx.Done():
+			return
+		case reloads <- func(cfg *reconcile.Config, opts *reconcile.SuperviseOptions) {
+			apply(cfg, opts)
+			applied(*cfg)
+		}:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var reportS3URL = kingpin.Flag(
+	"report-s3-url",
+	"Upload the JSON run summary of one-shot runs under this s3://bucket/prefix.",
+).Default(
+	"",
+).Envar(
+	"ETCDMATE_REPORT_S3_URL",
+).String()
+
+// uploadReport stores the summary as
+// <prefix>/<Autoscaling group>/<instance>/<timestamp>.json
+func uploadReport(sess *session.Session, cfg reconcile.Config, summary *reconcile.Summary) error {
+	if summary == nil || *reportS3URL == "" {
+		return nil
+	}
+	u, err := url.Parse(*reportS3URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return errors.New(fmt.Sprint("Invalid S3 URL ", *reportS3URL))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cluster, err := cfg.AWS.GetAsg(ctx, cfg.InstanceID)
+	if err != nil {
+		// Still worth keeping, the error is in the report
+		cluster = "unknown"
+	}
+	body, err := summary.JSON()
+	if err != nil {
+		return err
+	}
+	key := path.Join(
+		strings.TrimPrefix(u.Path, "/"),
+		cluster,
+		cfg.InstanceID,
+		time.Now().UTC().Format("20060102T150405Z")+".json",
+	)
+	_, err = s3.New(sess).PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(u.Host),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("Uploaded the run summary to", fmt.Sprint("s3://", u.Host, "/", key))
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	detailedExitCodes = kingpin.Flag(
+		"detailed-exit-codes",
+		"Exit with 0 when nothing changed, 2 when the run changed the membership or the env file,"+
+			" 5 when it configured the member of a new cluster,"+
+			" 1 on a transient failure, 3 on a misconfiguration and 4 when an unsafe action was refused."+
+			" Without it, any failure exits with 1.",
+	).Envar(
+		"ETCDMATE_DETAILED_EXIT_CODES",
+	).Bool()
+	resultFile = kingpin.Flag(
+		"result-file",
+		"Write the outcome of the run, its exit code, changes and error, to this file as JSON.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_RESULT_FILE",
+	).String()
+	outputJSON = kingpin.Flag(
+		"output-json",
+		"Print the result of the run to stdout as JSON, as written to --result-file.",
+	).Envar(
+		"ETCDMATE_OUTPUT_JSON",
+	).Bool()
+)
+
+// Exit codes with --detailed-exit-codes
+const (
+	exitUnchanged        = 0
+	exitTransient        = 1
+	exitChanged          = 2
+	exitMisconfiguration = 3
+	exitRefused          = 4
+	exitNewCluster       = 5
+)
+
+// errMisconfiguration marks the failures only a change of the configuration
+// fixes
+var errMisconfiguration = errors.New("Misconfiguration")
+
+// refusals are the errors of the unsafe actions etcdmate refused to take
+var refusals = []error{
+	reconcile.ErrQuorumRisk,
+	reconcile.ErrUnsafeNewCluster,
+	reconcile.ErrPeersUnreachable,
+	reconcile.ErrPaused,
+	reconcile.ErrRateLimited,
+	reconcile.ErrCanaryFailed,
+	reconcile.ErrVersionSkew,
+	reconcile.ErrZoneSpread,
+	reconcile.ErrEvenSize,
+	reconcile.ErrClusterHealthy,
+	reconcile.ErrPeerURLTaken,
+	etcd.ErrIdentityMismatch,
+}
+
+// Result is the outcome of a run written to --result-file
+type Result struct {
+	Outcome    string `json:"outcome"`
+	ExitCode   int    `json:"exitCode"`
+	Command    string `json:"command"`
+	InstanceID string `json:"instanceId,omitempty"`
+	// ClusterState is "new" or "existing" once a join decided
+	ClusterState   string   `json:"clusterState,omitempty"`
+	MembersAdded   []string `json:"membersAdded,omitempty"`
+	MembersRemoved []string `json:"membersRemoved,omitempty"`
+	// Files are the configuration files the run rewrote
+	Files   []string  `json:"files,omitempty"`
+	Changes []string  `json:"changes,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// runResult collects what the run changed
+type runResult struct {
+	command      string
+	instanceID   string
+	clusterState string
+	files        map[string]string
+	changes      []string
+	added        []string
+	removed      []string
+}
+
+var result = &runResult{files: map[string]string{}}
+
+// changeEvents are the events of a change to the cluster
+var changeEvents = map[reconcile.EventType]bool{
+	reconcile.EventMemberAdded:     true,
+	reconcile.EventMemberRemoved:   true,
+	reconcile.EventQuorumRecovery:  true,
+	reconcile.EventCanary:          true,
+	reconcile.EventScaling:         true,
+	reconcile.EventPeerURLRepaired: true,
+}
+
+// Events returns a handler recording the changes before passing the events
+// on to next
+func (r *runResult) Events(next reconcile.EventHandler) reconcile.EventHandler {
+	return func(e reconcile.Event) {
+		if changeEvents[e.Type] {
+			change := string(e.Type)
+			if e.Member.Name != "" {
+				change = fmt.Sprint(change, " ", e.Member.Name)
+			}
+			if e.Message != "" {
+				change = fmt.Sprint(change, ": ", e.Message)
+			}
+			r.changes = append(r.changes, change)
+		}
+		switch e.Type {
+		case reconcile.EventMemberAdded:
+			r.added = append(r.added, e.Member.Name)
+		case reconcile.EventMemberRemoved:
+			r.removed = append(r.removed, e.Member.Name)
+		}
+		if next != nil {
+			next(e)
+		}
+	}
+}
+
+// watchFile remembers the content of file to report it as a change when the
+// run rewrote it
+func (r *runResult) watchFile(file string) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Not watching", file, "for changes:", err)
+		return
+	}
+	r.files[file] = string(content)
+}
+
+// outcome classifies err and the changes of the run
+func (r *runResult) outcome(err error) Result {
+	res := Result{
+		Outcome:        "unchanged",
+		ExitCode:       exitUnchanged,
+		Command:        r.command,
+		InstanceID:     r.instanceID,
+		ClusterState:   r.clusterState,
+		MembersAdded:   r.added,
+		MembersRemoved: r.removed,
+		Changes:        r.changes,
+		Time:           time.Now().UTC(),
+	}
+	for file, before := range r.files {
+		content, _ := ioutil.ReadFile(file)
+		if string(content) != before {
+			res.Files = append(res.Files, file)
+			res.Changes = append(res.Changes, fmt.Sprint("env file ", file, " updated"))
+		}
+	}
+	sort.Strings(res.Files)
+	if len(res.Changes) > 0 {
+		res.Outcome, res.ExitCode = "changed", exitChanged
+		if r.clusterState == "new" {
+			res.Outcome, res.ExitCode = "new-cluster", exitNewCluster
+		}
+	}
+	if err == nil {
+		return res
+	}
+	res.Error = err.Error()
+	res.Outcome, res.ExitCode = "transient-failure", exitTransient
+	if errors.Is(err, errMisconfiguration) || errors.Is(err, discovery.ErrNotInASG) {
+		res.Outcome, res.ExitCode = "misconfiguration", exitMisconfiguration
+	}
+	for _, refusal := range refusals {
+		if errors.Is(err, refusal) {
+			res.Outcome, res.ExitCode = "refused", exitRefused
+		}
+	}
+	return res
+}
+
+// exit ends the run with the exit code of its outcome, see
+// --detailed-exit-codes, after writing --result-file
+func exit(err error) {
+	res := result.outcome(err)
+	if *resultFile != "" || *outputJSON {
+		data, werr := json.MarshalIndent(res, "", "  ")
+		if werr == nil && *resultFile != "" {
+			werr = ioutil.WriteFile(*resultFile, data, 0644)
+		}
+		if werr == nil && *outputJSON {
+			_, werr = fmt.Fprintln(os.Stdout, string(data))
+		}
+		if werr != nil {
+			log.Println("Writing the result failed:", werr)
+		}
+	}
+	if err != nil {
+		errorLogger().Println(err)
+	}
+	switch {
+	case *detailedExitCodes:
+		os.Exit(res.ExitCode)
+	case err != nil:
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// misconfigured marks err as a misconfiguration
+func misconfigured(err error) error {
+	return fmt.Errorf("%w: %v", errMisconfiguration, err)
+}
+
+// watchEnvFiles watches the env files a join writes, those of every cluster
+// with --clusters-file
+func watchEnvFiles(cfg reconcile.Config) {
+	if *clustersFile == "" {
+		result.watchFile(cfg.EnvFile)
+		return
+	}
+	specs, err := loadClusters(*clustersFile)
+	if err != nil {
+		return
+	}
+	for _, spec := range specs {
+		result.watchFile(spec.EnvFile)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/output"
+)
+
+var (
+	route53ZoneID = kingpin.Flag(
+		"route53-zone-id",
+		"Hosted zone getting an A record NAME.DOMAIN per member pointing at its private address, for URL templates using stable names.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ROUTE53_ZONE_ID",
+	).String()
+	route53Domain = kingpin.Flag(
+		"route53-domain",
+		"Domain of the member records, the name of --route53-zone-id if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ROUTE53_DOMAIN",
+	).String()
+	route53Owner = kingpin.Flag(
+		"route53-owner",
+		"Owner written next to the member records, only the records of this owner are changed. The Autoscaling group of the local instance if empty.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_ROUTE53_OWNER",
+	).String()
+)
+
+// newRecords returns the Route53 records of --route53-zone-id, nil without
+func newRecords(sess *session.Session) *output.Route53 {
+	if *route53ZoneID == "" {
+		return nil
+	}
+	return &output.Route53{
+		API:    route53.New(sess),
+		ZoneID: *route53ZoneID,
+		Domain: *route53Domain,
+		Owner:  *route53Owner,
+	}
+}
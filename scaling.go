@@ -0,0 +1,87 @@
+package main
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	followCapacity = kingpin.Flag(
+		"follow-capacity",
+		"In daemon mode, have the leader follow the desired capacity of the Autoscaling group: report scale-ups, promote learners and release instances waiting on --termination-hook.",
+	).Envar(
+		"ETCDMATE_FOLLOW_CAPACITY",
+	).Bool()
+	terminationHook = kingpin.Flag(
+		"termination-hook",
+		"Termination lifecycle hook of the Autoscaling group, scaled in members are removed before their instance is released to it.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_TERMINATION_HOOK",
+	).String()
+	learnerJoin = kingpin.Flag(
+		"learner-join",
+		"Add the local member as a learner, promoted by the leader with --follow-capacity once it caught up.",
+	).Envar(
+		"ETCDMATE_LEARNER_JOIN",
+	).Bool()
+	evenSize = kingpin.Flag(
+		"even-size",
+		"With an even number of expected members: off, warn, refuse to configure the local member, or learner to keep a joining member a learner while it would make the voting members even.",
+	).Default(
+		"warn",
+	).Envar(
+		"ETCDMATE_EVEN_SIZE",
+	).Enum("off", "warn", "refuse", "learner")
+	scaleUpWait = kingpin.Flag(
+		"scale-up-wait",
+		"How long new instances may take to be in service after a scale-up before it is reported.",
+	).Default(
+		"10m",
+	).Envar(
+		"ETCDMATE_SCALE_UP_WAIT",
+	).Duration()
+	scalingCooldown = kingpin.Flag(
+		"scaling-cooldown",
+		"Keep stale members until this long after the last scaling activity of the Autoscaling group, e.g. an instance launch, 0 removes them right away.",
+	).Default(
+		"0s",
+	).Envar(
+		"ETCDMATE_SCALING_COOLDOWN",
+	).Duration()
+	scaleInProtection = kingpin.Flag(
+		"scale-in-protection",
+		"In daemon mode, have the leader protect its instance from scale-in and remove the protection of the others.",
+	).Envar(
+		"ETCDMATE_SCALE_IN_PROTECTION",
+	).Bool()
+	protectMembersBelow = kingpin.Flag(
+		"protect-members-below",
+		"With --scale-in-protection, protect every healthy member while the cluster has fewer members than this, 0 only the leader.",
+	).Default(
+		"0",
+	).Envar(
+		"ETCDMATE_PROTECT_MEMBERS_BELOW",
+	).Int()
+)
+
+// newProtection returns nil unless --scale-in-protection is set
+func newProtection() *reconcile.Protection {
+	if !*scaleInProtection {
+		return nil
+	}
+	return &reconcile.Protection{ProtectAllBelow: *protectMembersBelow}
+}
+
+// newScaler returns nil unless --follow-capacity is set
+func newScaler() *reconcile.Scaler {
+	if !*followCapacity {
+		return nil
+	}
+	return &reconcile.Scaler{
+		Hook: *terminationHook,
+		Wait: *scaleUpWait,
+	}
+}
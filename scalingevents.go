@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var eventsQueue = kingpin.Flag(
+	"events-sqs-queue",
+	"In daemon mode, SQS queue URL of the local instance receiving the EventBridge events of the Autoscaling group, a launch or termination starts a pass without waiting for --interval.",
+).Default(
+	"",
+).Envar(
+	"ETCDMATE_EVENTS_SQS_QUEUE",
+).String()
+
+// newScalingEvents returns nil unless --events-sqs-queue is set
+func newScalingEvents(sess *session.Session) *reconcile.ScalingEvents {
+	if *eventsQueue == "" {
+		return nil
+	}
+	return &reconcile.ScalingEvents{Queue: *eventsQueue, SQS: sqs.New(sess)}
+}
+
+// watchScalingEvents returns a trigger firing on the scaling events as well
+// as on trigger, the one of the control API, if any
+func watchScalingEvents(
+	ctx context.Context,
+	cfg reconcile.Config,
+	events *reconcile.ScalingEvents,
+	trigger <-chan struct{},
+) <-chan struct{} {
+	if events == nil {
+		return trigger
+	}
+	merged := make(chan struct{}, 1)
+	go func() {
+		if err := events.Watch(ctx, cfg, merged); err != nil {
+			log.Println("Not watching scaling events:", err)
+		}
+	}()
+	if trigger != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-trigger:
+				}
+				select {
+				case merged <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+	return merged
+}
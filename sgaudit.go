@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var securityGroupAudit = kingpin.Flag(
+	"security-group-audit",
+	"Verify the security groups of the instance let the other members reach the client and peer ports before joining: off, warn or fail.",
+).Default(
+	"warn",
+).Envar(
+	"ETCDMATE_SECURITY_GROUP_AUDIT",
+).Enum("off", "warn", "fail")
+
+// auditSecurityGroups only fails with --security-group-audit fail and
+// missing rules, not when the groups can't be described
+func auditSecurityGroups(ctx context.Context, cfg reconcile.Config) error {
+	if *securityGroupAudit == "off" {
+		return nil
+	}
+	missing, err := cfg.AWS.AuditSecurityGroups(ctx, cfg.InstanceID, cfg.URLs)
+	if err != nil {
+		log.Println("Not auditing the security groups:", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	err = errors.New(fmt.Sprint(
+		"Security groups of ", cfg.InstanceID, " don't allow ", strings.Join(missing, ", "),
+	))
+	if *securityGroupAudit == "fail" {
+		return err
+	}
+	log.Println("Warning:", err)
+	return nil
+}
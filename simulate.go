@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/simulate"
+)
+
+var (
+	simulateCmd = kingpin.Command(
+		"simulate",
+		"Run bootstrap, scale-up, replacement and scale-down against local etcd containers and a fake AWS.",
+	)
+	simulateImage = simulateCmd.Flag(
+		"image",
+		"etcd image of the members.",
+	).Default(
+		"quay.io/coreos/etcd:v3.5.15",
+	).String()
+	simulateNetwork = simulateCmd.Flag(
+		"network",
+		"Docker network created for the simulation.",
+	).Default(
+		"etcdmate-simulate",
+	).String()
+	simulateSubnet = simulateCmd.Flag(
+		"subnet",
+		"Subnet of the Docker network.",
+	).Default(
+		"172.30.99.0/24",
+	).String()
+	simulateSize = simulateCmd.Flag(
+		"size",
+		"Cluster size.",
+	).Default(
+		"3",
+	).Int()
+	simulateBootDelay = simulateCmd.Flag(
+		"boot-delay",
+		"How long launched instances take to run etcdmate.",
+	).Default(
+		"20s",
+	).Duration()
+	simulateWait = simulateCmd.Flag(
+		"wait-timeout",
+		"How long each scenario may take.",
+	).Default(
+		"5m",
+	).Duration()
+)
+
+func runSimulation(ctx context.Context) error {
+	return simulate.Run(ctx, simulate.Options{
+		Image:     *simulateImage,
+		Network:   *simulateNetwork,
+		Subnet:    *simulateSubnet,
+		Size:      *simulateSize,
+		BootDelay: *simulateBootDelay,
+		Wait:      *simulateWait,
+		Logger:    log.Default(),
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+	"github.com/viruxel/etcdmate/pkg/simulate"
+)
+
+var (
+	simulatePlanCmd = kingpin.Command(
+		"simulate-plan",
+		"Play a scenario of launches, terminations and partitions against a fake AWS and etcd, printing what every instance would do with the policy flags given.",
+	)
+	simulatePlanScenario = simulatePlanCmd.Flag(
+		"scenario",
+		"YAML or JSON scenario file.",
+	).Required().String()
+)
+
+// runSimulatePlan runs before anything AWS is set up, the scenario brings
+// its own
+func runSimulatePlan(ctx context.Context) error {
+	data, err := ioutil.ReadFile(*simulatePlanScenario)
+	if err != nil {
+		return err
+	}
+	scenario, err := simulate.ParseScenario(data)
+	if err != nil {
+		return misconfigured(err)
+	}
+	return simulate.Plan(ctx, scenario, simulate.PlanOptions{
+		Policy: func(cfg *reconcile.Config) {
+			applyPolicy(cfg)
+			cfg.Changes = newChangeLimiter()
+		},
+		Explain: *explain,
+		Out:     os.Stdout,
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	spotWatch = kingpin.Flag(
+		"spot-watch",
+		"In daemon mode, remove the local member and stop as soon as its spot instance gets an interruption notice.",
+	).Envar(
+		"ETCDMATE_SPOT_WATCH",
+	).Bool()
+	spotRebalance = kingpin.Flag(
+		"spot-rebalance",
+		"Also leave on a rebalance recommendation, which comes earlier than an interruption notice.",
+	).Envar(
+		"ETCDMATE_SPOT_REBALANCE",
+	).Bool()
+	spotInterval = kingpin.Flag(
+		"spot-interval",
+		"How often the instance metadata is polled for spot notices.",
+	).Default(
+		"5s",
+	).Envar(
+		"ETCDMATE_SPOT_INTERVAL",
+	).Duration()
+
+	spotWatchCmd = kingpin.Command(
+		"spot-watch",
+		"Remove the local member as soon as its spot instance gets an interruption notice, then exit.",
+	)
+)
+
+func spotOptions(sess *session.Session) reconcile.SpotOptions {
+	return reconcile.SpotOptions{
+		Metadata:  newMetadata(sess),
+		Rebalance: *spotRebalance,
+		Hook:      *terminationHook,
+		Interval:  *spotInterval,
+	}
+}
+
+// newSpotWatch returns nil unless --spot-watch is set
+func newSpotWatch(sess *session.Session) *reconcile.SpotOptions {
+	if !*spotWatch {
+		return nil
+	}
+	opts := spotOptions(sess)
+	return &opts
+}
+
+// watchSpot has the local member leave on a spot notice. It returns the
+// context of the daemon, canceled on a notice so that no pass adds the
+// member back while it leaves, and stopped, to call once the passes
+// returned: the member only leaves then, and stopped waits until it did.
+func watchSpot(ctx context.Context, cfg reconcile.Config, opts reconcile.SpotOptions) (context.Context, func()) {
+	daemonCtx, cancel := context.WithCancel(ctx)
+	var mu sync.Mutex
+	noticed, finished := false, false
+	passesStopped := make(chan struct{})
+	left := make(chan struct{})
+	opts.Stop = func() {
+		mu.Lock()
+		noticed = !finished
+		mu.Unlock()
+		cancel()
+		<-passesStopped
+	}
+	go func() {
+		defer close(left)
+		if _, err := reconcile.WatchSpot(ctx, cfg, opts); err != nil {
+			log.Println("Leaving on the spot notice failed:", err)
+		}
+	}()
+	return daemonCtx, func() {
+		mu.Lock()
+		finished = true
+		wait := noticed
+		mu.Unlock()
+		cancel()
+		close(passesStopped)
+		if wait {
+			<-left
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+)
+
+var (
+	discoveryMode = kingpin.Flag(
+		"discovery",
+		"Where the expected members come from: the Autoscaling group of the local instance, the running instances matching --tag-filter whatever their group, the DNS SRV records of --dns-domain, or the static list of --members-file and --member.",
+	).Default(
+		"asg",
+	).Envar(
+		"ETCDMATE_DISCOVERY",
+	).Enum("asg", "tags", "dns", "static")
+	tagFilters = kingpin.Flag(
+		"tag-filter",
+		"With --discovery tags, a KEY=VALUE tag the instances of the cluster have, or KEY for any value. Repeat for instances matching all of them.",
+	).Envar(
+		"ETCDMATE_TAG_FILTER",
+	).Strings()
+	dnsDomain = kingpin.Flag(
+		"dns-domain",
+		"With --discovery dns, the domain of the _etcd-server-ssl._tcp or _etcd-server._tcp SRV records of the peers, --discovery-srv-name adding a suffix.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_DNS_DOMAIN",
+	).String()
+	membersFile = kingpin.Flag(
+		"members-file",
+		"With --discovery static, a JSON list of the members, [{\"Name\": \"etcd-1\", \"Address\": \"10.0.0.1\"}, ...], or with ClientURL and PeerURL rather than an Address. Read on every pass.",
+	).Default(
+		"",
+	).Envar(
+		"ETCDMATE_MEMBERS_FILE",
+	).String()
+	staticMembers = kingpin.Flag(
+		"member",
+		"With --discovery static, a NAME=ADDRESS member, after those of --members-file. Repeat for every member.",
+	).Envar(
+		"ETCDMATE_MEMBER",
+	).Strings()
+)
+
+func newDNSSource(localIP string) discovery.Source {
+	return discovery.DNS{
+		Domain:  *dnsDomain,
+		Name:    *discoverySRVName,
+		LocalIP: localIP,
+		Logger:  log.Default(),
+	}
+}
+
+func newStaticSource(localIP string) (discovery.Source, error) {
+	source := discovery.Static{
+		File:    *membersFile,
+		LocalIP: localIP,
+		Logger:  log.Default(),
+	}
+	for _, text := range *staticMembers {
+		member, err := discovery.ParseStaticMember(text)
+		if err != nil {
+			return nil, err
+		}
+		source.Members = append(source.Members, member)
+	}
+	return source, nil
+}
+
+func newTagSource(svc discovery.AWS) (discovery.Source, error) {
+	source := discovery.Tags{AWS: svc}
+	for _, text := range *tagFilters {
+		filter, err := discovery.ParseTagFilter(text)
+		if err != nil {
+			return nil, err
+		}
+		source.Filters = append(source.Filters, filter)
+	}
+	return source, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/etcd"
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	topCmd = kingpin.Command(
+		"top",
+		"Show the health of the members, the leader, the zones and the recent membership changes, refreshed until interrupted.",
+	)
+	topRefresh = topCmd.Flag(
+		"refresh",
+		"Time between two refreshes.",
+	).Default(
+		"2s",
+	).Duration()
+	topHistory = topCmd.Flag(
+		"history",
+		"How many recent membership changes to show, from the history kept with --history-size.",
+	).Default(
+		"10",
+	).Int()
+)
+
+// runTop redraws the status of the cluster every --refresh until ctx is
+// done, errors are shown rather than ending it as they are what an operator
+// watches for during an incident
+func runTop(ctx context.Context, cfg reconcile.Config) error {
+	// The view replaces the logs, which would scroll it away
+	log.SetOutput(ioutil.Discard)
+	for {
+		topology, err := reconcile.DescribeTopology(ctx, cfg)
+		var history []reconcile.HistoryEntry
+		if err == nil {
+			history, err = topHistoryEntries(ctx, cfg, topology)
+		}
+		fmt.Fprint(os.Stdout, "\033[H\033[2J")
+		renderTop(os.Stdout, topology, history, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*topRefresh):
+		}
+	}
+}
+
+// topHistoryEntries returns the last --history changes, newest first
+func topHistoryEntries(ctx context.Context, cfg reconcile.Config, topology reconcile.Topology) ([]reconcile.HistoryEntry, error) {
+	for _, tm := range topology.Members {
+		if !tm.Healthy || tm.Role != "member" {
+			continue
+		}
+		entries, err := reconcile.ReadHistory(ctx, cfg, etcd.Member{Name: tm.Name, ClientURL: tm.ClientURL})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > *topHistory {
+			entries = entries[len(entries)-*topHistory:]
+		}
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+		return entries, nil
+	}
+	return nil, nil
+}
+
+func renderTop(w io.Writer, topology reconcile.Topology, history []reconcile.HistoryEntry, err error) {
+	fmt.Fprintln(w, "etcdmate top", time.Now().Format("15:04:05"), "- refreshed every", *topRefresh)
+	if err != nil {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Error:", err)
+		return
+	}
+	healthy := 0
+	zones := map[string]int{}
+	for _, tm := range topology.Members {
+		if tm.Role != "member" {
+			continue
+		}
+		if tm.Healthy {
+			healthy++
+		}
+		zones[tm.Zone]++
+	}
+	voters := 0
+	for _, n := range zones {
+		voters += n
+	}
+	leader := topology.Leader
+	if leader == "" {
+		leader = "none"
+	}
+	fmt.Fprintf(w, "Version %s, leader %s, %d/%d members healthy, quorum %d\n",
+		topology.ClusterVersion, leader, healthy, voters, voters/2+1)
+	spread := []string{}
+	for zone, n := range zones {
+		if zone == "" {
+			zone = "unknown"
+		}
+		spread = append(spread, fmt.Sprint(zone, ": ", n))
+	}
+	sort.Strings(spread)
+	fmt.Fprintln(w, "Zones:", strings.Join(spread, ", "))
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tROLE\tZONE\tHEALTH\tVERSION\tLAG\tCLIENT URL")
+	for _, tm := range topology.Members {
+		name := tm.Name
+		if tm.Name == topology.Leader {
+			name += " *"
+		}
+		health := "healthy"
+		if !tm.Healthy {
+			health = "UNHEALTHY"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", name, tm.Role, tm.Zone, health, tm.Version, tm.Lag, tm.ClientURL)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Recent changes:")
+	if len(history) == 0 {
+		fmt.Fprintln(w, "  none recorded, see --history-size")
+	}
+	for _, e := range history {
+		line := fmt.Sprint("  ", e.Time.Local().Format("2006-01-02 15:04:05"), " ", e.Type, " ", e.Member)
+		if e.Message != "" {
+			line += ": " + e.Message
+		}
+		if e.By != "" {
+			line += " (by " + e.By + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/reconcile"
+)
+
+var (
+	topologyCmd = kingpin.Command(
+		"topology",
+		"Print the members of the cluster with the leader, zones, health and lag, for dashboards and documentation.",
+	)
+	topologyFormat = topologyCmd.Flag(
+		"format",
+		"Output format: json, or dot for Graphviz.",
+	).Default(
+		"json",
+	).Enum("json", "dot")
+)
+
+// printTopology prints the topology of the cluster, it changes nothing
+func printTopology(ctx context.Context, cfg reconcile.Config) error {
+	topology, err := reconcile.DescribeTopology(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if *topologyFormat == "dot" {
+		fmt.Print(topology.DOT())
+		return nil
+	}
+	data, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/viruxel/etcdmate/pkg/discovery"
+	"github.com/viruxel/etcdmate/pkg/etcd"
+)
+
+// Problem is a mistake in the settings and how to fix it. Warnings are
+// settings that work but likely aren't what was meant.
+type Problem struct {
+	Message string
+	Fix     string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	return fmt.Sprint(p.Message, "; ", p.Fix)
+}
+
+// validateConfig checks the resolved settings of command together so every
+// mistake is reported at once, before any AWS or etcd request
+func validateConfig(command string) []Problem {
+	problems := []Problem{}
+	add := func(message, fix string) {
+		problems = append(problems, Problem{Message: message, Fix: fix})
+	}
+	warn := func(message, fix string) {
+		problems = append(problems, Problem{Message: message, Fix: fix, Warning: true})
+	}
+
+	if *configFile != "" {
+		known := map[string]bool{}
+		for _, flag := range kingpin.CommandLine.Model().Flags {
+			known[flag.Envar] = true
+		}
+		unknown := []string{}
+		for name := range configVars {
+			if !known[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			if yamlConfigFile(*configFile) {
+				setting := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, "ETCDMATE_"), "_", "-"))
+				add(fmt.Sprint(setting, " in ", *configFile, " isn't a setting"), "check the name against the flags of etcdmate --help")
+			} else if strings.HasPrefix(name, "ETCDMATE_") {
+				warn(fmt.Sprint(name, " in ", *configFile, " isn't a setting, it is ignored"), "check the name against the flags of etcdmate --help")
+			}
+		}
+	}
+
+	issuers := []string{}
+	for _, f := range []struct{ flag, value string }{
+		{"--vault-addr", *vaultAddr},
+		{"--cfssl-url", *cfsslURL},
+		{"--ca-parameter", *caParameter},
+		{"--spire-socket", *spireSocket},
+	} {
+		if f.value != "" {
+			issuers = append(issuers, f.flag)
+		}
+	}
+	if len(issuers) > 1 {
+		add(
+			fmt.Sprint("Only one certificate issuer can be used, not ", strings.Join(issuers, " and ")),
+			"keep the one issuer to use",
+		)
+	}
+	hasIssuer := len(issuers) > 0
+
+	if *retries < 0 {
+		add("--retries can't be negative", "use 0 to not retry")
+	}
+	if *retries > 0 && *retryBackoff <= 0 {
+		add("--retry-backoff must be positive", "set the delay before the first retry, e.g. 500ms")
+	}
+	if *probeTimeout < 0 || *healthSearchTimeout < 0 {
+		add("--probe-timeout and --health-search-timeout can't be negative", "use 0 to not bound them")
+	}
+	if *probeTimeout > 0 && *healthSearchTimeout > 0 && *probeTimeout > *healthSearchTimeout {
+		warn(
+			"--probe-timeout is longer than --health-search-timeout, slow probes are cut short by the search",
+			"set --probe-timeout at most to --health-search-timeout",
+		)
+	}
+	if *awsRecord != "" && *awsReplay != "" {
+		add("--aws-record and --aws-replay can't be combined", "record and replay in separate runs")
+	}
+	if *clientSchema == "https" && *caFile == "" && !hasIssuer {
+		warn(
+			"--client-schema is https without --ca-file, member certificates are checked against the system CAs",
+			"set --ca-file to the CA of the cluster or configure a certificate issuer",
+		)
+	}
+	if *peerSchema == "https" && *peerCAFile == "" && !hasIssuer {
+		warn(
+			"--peer-schema is https without --peer-ca-file, etcd won't verify its peers",
+			"set --peer-ca-file or configure a certificate issuer",
+		)
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		add("--cert-file and --key-file go together", "set both or neither")
+	}
+	if (*peerCertFile == "") != (*peerKeyFile == "") {
+		add("--peer-cert-file and --peer-key-file go together", "set both or neither")
+	}
+	if (*etcdUsername == "") != (*etcdPassword == "") {
+		add("--etcd-username and --etcd-password go together", "set both when etcd has auth enabled")
+	}
+	if *insecureSkipVerify {
+		warn("--insecure-skip-verify accepts any certificate from etcd", "set --ca-file, and --tls-server-name when the certificates don't name the URL hosts")
+	}
+	if *tlsServerName != "" && *clientSchema != "https" && *clientURLTemplate == "" {
+		warn("--tls-server-name has no effect without https client URLs", "set --client-schema https")
+	}
+	if *clientPort == *peerPort {
+		add(
+			fmt.Sprint("--client-port and --peer-port are both ", *clientPort),
+			"use different ports, etcd defaults to 2379 and 2380",
+		)
+	}
+	if *healthAddr != "" {
+		_, port, err := net.SplitHostPort(*healthAddr)
+		switch {
+		case err != nil:
+			add(fmt.Sprint("--health-addr ", *healthAddr, ": ", err), "use host:port, e.g. 127.0.0.1:9191")
+		case port == strconv.Itoa(*clientPort) || port == strconv.Itoa(*peerPort):
+			add(
+				fmt.Sprint("--health-addr uses port ", port, " of etcd"),
+				"pick a port etcd doesn't listen on",
+			)
+		}
+	}
+	if *discoverySRVName != "" && *discoverySRV == "" && *discoveryMode != "dns" {
+		warn("--discovery-srv-name is only used with --discovery-srv or --discovery dns", "set --discovery-srv to the domain")
+	}
+	if *identityInterface != "" && *identitySource == "metadata" {
+		warn("--identity-interface is only used without metadata", "set --identity local or auto")
+	}
+	if _, err := discovery.ParseSubnets(*advertiseSubnets...); err != nil {
+		add(fmt.Sprint("--advertise-subnet: ", err), "use CIDRs, e.g. 10.40.0.0/24,10.40.1.0/24")
+	}
+	if *advertiseInterface != "" {
+		if _, err := discovery.ParseInterface(*advertiseInterface); err != nil {
+			add(err.Error(), "use the eth name, e.g. eth1, whatever the OS calls it")
+		}
+	}
+	// The address types of the client and of the peer URLs
+	addressTypes := []string{}
+	for _, f := range []struct{ flag, value string }{
+		{"--client-address-type", *clientAddressType},
+		{"--peer-address-type", *peerAddressType},
+	} {
+		if f.value == "" {
+			addressTypes = append(addressTypes, *addressType)
+			continue
+		}
+		addressTypes = append(addressTypes, f.value)
+		if _, err := discovery.ParseAddressType(f.value); err != nil {
+			add(fmt.Sprint(f.flag, ": ", err), "leave it empty to use --address-type")
+		}
+	}
+	if (len(*advertiseSubnets) > 0 || *advertiseInterface != "") && allDNS(addressTypes) {
+		warn(
+			"--advertise-subnet and --advertise-interface only apply to the ip address types",
+			"use an ip address type or drop them",
+		)
+	}
+	if *advertiseIP != "primary" && !hasPrivateIP(addressTypes) {
+		warn("--advertise-ip only applies to the private-ip address type", "use --address-type private-ip or drop it")
+	}
+	for _, f := range []struct{ flag, value string }{
+		{"--client-url-template", *clientURLTemplate},
+		{"--peer-url-template", *peerURLTemplate},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if _, err := discovery.ParseURLTemplate(f.value); err != nil {
+			add(
+				fmt.Sprint(f.flag, ": ", err),
+				"use a Go template giving a URL with a schema, host and port, e.g. https://{{.Name}}.etcd.internal:2380",
+			)
+		}
+	}
+	if *memberNameTemplate != "" {
+		if _, err := discovery.ParseNameTemplate(*memberNameTemplate); err != nil {
+			add(
+				fmt.Sprint("--member-name-template: ", err),
+				"use a Go template of the instance attributes, e.g. etcd-{{.AvailabilityZoneSuffix}}-{{.LaunchIndex}}",
+			)
+		}
+	}
+	if _, err := discovery.NameSourceTemplate(*nameSource); err != nil {
+		add(fmt.Sprint("--name-source: ", err), "use instance-id, private-dns, tag:KEY or az-index")
+	} else if *nameSource != "instance-id" && *memberNameTemplate != "" {
+		add("--name-source and --member-name-template can't be combined", "drop --name-source, the template names the members")
+	}
+	for _, remote := range *remoteAsgs {
+		parts := strings.SplitN(remote, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			add(fmt.Sprint("Invalid --remote-asg ", remote), "use region:name, e.g. eu-west-1:etcd")
+		}
+	}
+	if _, err := etcd.ParseCipherSuites(*tlsCipherSuites); err != nil {
+		add(fmt.Sprint("--tls-cipher-suites: ", err), "use the Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	}
+	if *runAsUser != "" && *restartUnit != "" {
+		add("--restart-unit needs root, it can't be used with --user", "drop --user or restart etcd another way")
+	}
+	if *configOutput != "file" && *restartUnit != "" {
+		add(
+			fmt.Sprint("--restart-unit can't be used with --config-output ", *configOutput, ", etcd doesn't run as a systemd unit there"),
+			"drop --restart-unit, the OS applies the configuration itself",
+		)
+	}
+	if *outputFormat != "envfile" && *configOutput != "file" {
+		add(
+			fmt.Sprint("--output-format ", *outputFormat, " can't be used with --config-output ", *configOutput),
+			"drop --output-format, the OS takes the configuration in its own format",
+		)
+	}
+	if *systemdReload && (*configOutput != "file" || *outputFormat == "none" || *runAsUser != "") {
+		add(
+			"--systemd-reload needs root and a file read by systemd, it can't be used with --user, --output-format none or another --config-output",
+			"drop --systemd-reload",
+		)
+	}
+	if *outputFormat == "none" && *restartUnit != "" {
+		add("--restart-unit can't be used with --output-format none, no file changes", "drop --restart-unit and restart etcd with the printed configuration")
+	}
+	if *templateFile != "" && *configOutput != "file" {
+		add(fmt.Sprint("--template-file can't be used with --config-output ", *configOutput), "drop --template-file")
+	} else if *templateFile != "" && (*outputFormat == "systemd" || *outputFormat == "etcd-yaml") {
+		add(
+			fmt.Sprint("--template-file renders the whole file, it can't be used with --output-format ", *outputFormat),
+			"render the format in the template and drop --output-format",
+		)
+	}
+	if *templateFile != "" && proxying() {
+		warn("--template-file is not used with --proxy-of, the proxy gets its own configuration", "drop --template-file")
+	}
+	if *etcdYAMLBase != "" && *outputFormat != "etcd-yaml" {
+		warn("--etcd-yaml-base is only used with --output-format etcd-yaml", "drop it or set --output-format etcd-yaml")
+	}
+	if *quorumRecoveryAfter > 0 && *restartUnit == "" {
+		add("--quorum-recovery-after needs --restart-unit", "set --restart-unit to the etcd unit")
+	}
+	if *terminationHook != "" && !*followCapacity && command != lifecycleCmd.FullCommand() {
+		add("--termination-hook needs --follow-capacity", "set --follow-capacity on the daemons, or run lifecycle")
+	}
+	if command == lifecycleCmd.FullCommand() && *lifecycleQueue == "" && *terminationHook == "" {
+		add("lifecycle needs --queue-url or --termination-hook", "set the SQS queue the hook notifies, or the hook to poll the group for")
+	}
+	if command == lifecycleCmd.FullCommand() && *lifecycleQueue == "" && *interval <= 0 {
+		add("--interval must be positive to poll the group", "set how often to poll, e.g. 60s")
+	}
+	if *learnerJoin && !*followCapacity && *daemon {
+		warn(
+			"--learner-join without --follow-capacity, learners are only promoted by a leader following the capacity",
+			"set --follow-capacity on the daemons, or run etcdmate promote after each join",
+		)
+	}
+	if (*planFormat == "ignition" || *planFormat == "butane") && !*dryRun {
+		warn(fmt.Sprint("--plan-format ", *planFormat, " has no effect without --dry-run"), "set --dry-run to print the config")
+	}
+	if command == topCmd.FullCommand() && *topRefresh <= 0 {
+		add("--refresh must be positive", "set how often top refreshes, e.g. 2s")
+	}
+	if *metricsAddr != "" && !*daemon {
+		warn("--metrics-addr is only served in daemon mode", "use --pushgateway-url or --textfile for one-shot runs")
+	}
+	if *textfile != "" && *daemon {
+		warn("--textfile is only written by one-shot runs", "scrape the metrics of the daemon on --metrics-addr instead")
+	}
+	if *textfile != "" && !strings.HasSuffix(*textfile, ".prom") {
+		warn("--textfile doesn't end in .prom, the node_exporter textfile collector ignores it", "name the file e.g. etcdmate.prom")
+	}
+	if *registryURL != "" && *registryToken == "" {
+		add("--registry-url needs --registry-token", "set the token the registry was started with")
+	}
+	if *registryURL != "" && len(*registryEtcdEndpoints) > 0 {
+		add("--registry-url and --registry-etcd-endpoints can't be combined", "keep the registry in one place")
+	}
+	if (*serveRegistryCert == "") != (*serveRegistryKey == "") {
+		add("serve-registry needs both --tls-cert and --tls-key", "set both or neither")
+	}
+	if command == restoreCmd.FullCommand() && (*backupS3URL == "" || *restartUnit == "" || *etcdDataDir == "") {
+		add("restore needs --backup-s3-url, --restart-unit and --etcd-data-dir", "set where the snapshots are, the unit of etcd and its data dir")
+	}
+	if *outputJSON && (*dryRun || *outputFormat == "none") {
+		add("--output-json can't share stdout with --dry-run or --output-format none", "write the result with --result-file instead")
+	}
+	if *spotWatch && !*daemon {
+		warn("--spot-watch has no effect on one-shot runs", "run etcdmate spot-watch next to it, or etcdmate as a daemon")
+	}
+	if *eventsQueue != "" && !*daemon {
+		warn("--events-sqs-queue has no effect on one-shot runs", "run etcdmate as a daemon")
+	}
+	if *backupBeforeRemove && *backupS3URL == "" {
+		add("--backup-before-remove needs --backup-s3-url", "set the S3 URL the snapshots are uploaded under")
+	}
+	if *repairPeerURLs && !*daemon && *verifyTimeout == 0 {
+		warn("--repair-peer-urls has no effect on one-shot runs without --verify-timeout", "set --verify-timeout or run etcdmate as a daemon")
+	}
+	if *evenSize == "learner" && !*followCapacity && *daemon {
+		warn(
+			"--even-size learner without --follow-capacity, the learners kept for an odd number of voting members are only promoted by a leader following the capacity",
+			"set --follow-capacity on the daemons, or run etcdmate promote after each join",
+		)
+	}
+	if *canary && !*daemon && (*restartUnit == "" || *verifyTimeout == 0) {
+		add(
+			"--canary needs --restart-unit and --verify-timeout outside daemon mode, the learner must run to be soaked",
+			"set both or run etcdmate as a daemon",
+		)
+	}
+	if !*removeStale && !*force {
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--stale-grace", *staleGrace > 0},
+			{"--removal-confirmation", *removalConfirmation != "off"},
+			{"--scaling-cooldown", *scalingCooldown > 0},
+			{"--max-removals-per-run", *maxRemovals > 0},
+			{"--force-remove", *forceRemove},
+		} {
+			if f.set {
+				warn(fmt.Sprint(f.flag, " has no effect, stale members are only reported"), "set --remove-stale")
+			}
+		}
+	}
+	if len(*confirmRemovals) > 0 && *removalConfirmation != "operator" {
+		warn("--confirm-removal has no effect without --removal-confirmation operator", "set --removal-confirmation operator")
+	}
+	if *maxRemovals < 0 {
+		add("--max-removals-per-run can't be negative", "use 0 to not limit the removals")
+	}
+	if *maxChangesPerInterval < 0 {
+		add("--max-changes-per-interval can't be negative", "use 0 to not limit the changes")
+	}
+	if *minZones < 0 || *minZones == 1 {
+		add("--min-zones must be 0 or at least 2", "use 0 to not check the spread of the members, 2 or 3 to require it")
+	}
+	if *protectMembersBelow != 0 && !*scaleInProtection {
+		warn("--protect-members-below has no effect without --scale-in-protection", "set --scale-in-protection")
+	}
+	if *daemon && *interval <= 0 {
+		add("--interval must be positive in daemon mode", "set how often to reconcile, e.g. 60s")
+	}
+	if *coordinate && *coordinationTTL != 0 && *coordinationTTL <= *interval {
+		add(
+			"--coordination-ttl must be longer than --interval, the lease would expire between passes",
+			"leave --coordination-ttl unset or make it a few intervals",
+		)
+	}
+	if command == rotateCertsCmd.FullCommand() && (!hasIssuer || *restartUnit == "") {
+		add(
+			"rotate-certs needs a certificate issuer and --restart-unit",
+			"set --restart-unit and one of --vault-addr, --cfssl-url, --ca-parameter or --spire-socket",
+		)
+	}
+	if (*nomadJob != "") != (*identitySource == "nomad") {
+		add("--nomad-job and --identity nomad go together", "set both to run etcd as a Nomad job")
+	}
+	if *discoveryMode == "tags" && len(*tagFilters) == 0 {
+		add("--discovery tags needs --tag-filter", "set the tags of the instances of the cluster, e.g. etcd-cluster=prod")
+	}
+	if *discoveryMode != "tags" && len(*tagFilters) > 0 {
+		warn("--tag-filter has no effect without --discovery tags", "set --discovery tags")
+	}
+	if *discoveryMode == "dns" && *dnsDomain == "" {
+		add("--discovery dns needs --dns-domain", "set the domain of the SRV records, e.g. etcd.internal")
+	}
+	if *discoveryMode != "dns" && *dnsDomain != "" {
+		warn("--dns-domain has no effect without --discovery dns", "set --discovery dns")
+	}
+	if *discoveryMode == "static" && *membersFile == "" && len(*staticMembers) == 0 {
+		add("--discovery static needs --members-file or --member", "list the members, e.g. --member etcd-1=10.0.0.1")
+	}
+	if *discoveryMode != "static" && (*membersFile != "" || len(*staticMembers) > 0) {
+		warn("--members-file and --member have no effect without --discovery static", "set --discovery static")
+	}
+	for _, text := range *staticMembers {
+		if _, err := discovery.ParseStaticMember(text); err != nil {
+			add(fmt.Sprint("--member: ", err), "use the name and address of the member, e.g. etcd-1=10.0.0.1")
+		}
+	}
+	// The other sources than the Autoscaling group of the local instance
+	source, hint := "", ""
+	switch {
+	case *cloud != "aws":
+		source, hint = "--cloud "+*cloud, "drop it, only the Autoscaling groups of AWS support it"
+	case *nomadJob != "":
+		source, hint = "--nomad-job", "drop it, Nomad schedules the members"
+	case *discoveryMode == "tags":
+		source, hint = "--discovery tags", "drop it, the members are those of the tagged instances"
+	case *discoveryMode == "dns":
+		source, hint = "--discovery dns", "drop it, the members are those of the SRV records"
+	case *discoveryMode == "static":
+		source, hint = "--discovery static", "drop it, the members are those listed"
+	}
+	if *cloud != "aws" {
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--nomad-job", *nomadJob != ""},
+			{"--discovery " + *discoveryMode, *discoveryMode != "asg"},
+			{"--identity " + *identitySource, *identitySource != "metadata"},
+		} {
+			if f.set {
+				add(fmt.Sprint("--cloud ", *cloud, " and ", f.flag, " can't be combined"), "discover the members from the group of the instances")
+			}
+		}
+		switch {
+		case *cloud == "gcp" && *addressType == "public-dns":
+			add("--address-type public-dns isn't supported with --cloud gcp", "use private-ip, public-ip or private-dns")
+		case *cloud == "azure" && *addressType != "private-ip" && *addressType != "private":
+			add(fmt.Sprint("--address-type ", *addressType, " isn't supported with --cloud azure"), "use private-ip, the members use their private IP address")
+		}
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--client-address-type", *clientAddressType != ""},
+			{"--peer-address-type", *peerAddressType != ""},
+			{"--member-name-template", *memberNameTemplate != ""},
+			{"--name-source", *nameSource != "instance-id"},
+			{"--advertise-ip", *advertiseIP != "primary"},
+			{"--client-url-template", *clientURLTemplate != ""},
+			{"--peer-url-template", *peerURLTemplate != ""},
+		} {
+			if f.set {
+				warn(fmt.Sprint(f.flag, " has no effect with --cloud ", *cloud), "the members are named after their instances, their URLs use --address-type")
+			}
+		}
+	}
+	if *nomadJob != "" && *discoveryMode != "asg" {
+		add(fmt.Sprint("--nomad-job and --discovery ", *discoveryMode, " can't be combined"), "discover the members from one source")
+	}
+	if source != "" {
+		switch command {
+		case joinCmd.FullCommand(), verifyCmd.FullCommand(), topologyCmd.FullCommand(), topCmd.FullCommand():
+		default:
+			add(fmt.Sprint(command, " needs the Autoscaling group, it can't be used with ", source), "only use join, verify, topology and top")
+		}
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--clusters-file", *clustersFile != ""},
+			{"--remote-asg", len(*remoteAsgs) > 0},
+			{"--registry-url", *registryURL != ""},
+			{"--registry-etcd-endpoints", len(*registryEtcdEndpoints) > 0},
+			{"--follow-capacity", *followCapacity},
+			{"--scale-in-protection", *scaleInProtection},
+			{"--scaling-cooldown", *scalingCooldown > 0},
+			{"--termination-hook", *terminationHook != ""},
+			{"--removal-confirmation operator", *removalConfirmation == "operator"},
+			{"--name-source az-index", *nameSource == "az-index"},
+			{"--cluster-token auto", *clusterToken == "auto"},
+			{"--events-sqs-queue", *eventsQueue != ""},
+		} {
+			if f.set {
+				add(fmt.Sprint(f.flag, " needs the Autoscaling group, it can't be used with ", source), hint)
+			}
+		}
+	}
+	if *minClusterSize < 0 {
+		add("--min-cluster-size can't be negative", "set it to the initial number of members")
+	}
+	if *waitForCapacity && source != "" && *minClusterSize == 0 {
+		add(fmt.Sprint("--wait-for-capacity needs --min-cluster-size with ", source), "set --min-cluster-size to the initial number of members")
+	}
+	if !*waitForCapacity && *minClusterSize > 0 {
+		warn("--min-cluster-size has no effect without --wait-for-capacity", "set --wait-for-capacity")
+	}
+	switch {
+	case *lockBackend == "s3" && *lockS3URL == "":
+		add("--lock-backend s3 needs --lock-s3-url", "set --lock-s3-url to s3://bucket/prefix")
+	case *lockBackend != "none" && source != "" && *lockName == "":
+		add(fmt.Sprint("--lock-backend needs --lock-name with ", source), "set --lock-name to a name of the cluster")
+	case *lockBackend != "none" && *lockTTL <= 0:
+		add("--lock-ttl must be positive", "set it longer than etcd takes to start")
+	}
+	switch {
+	case *route53ZoneID != "" && (*nomadJob != "" || *discoveryMode == "dns" || *discoveryMode == "static" || *cloud != "aws"):
+		add(fmt.Sprint("--route53-zone-id names EC2 instances, it can't be used with ", source), "drop --route53-zone-id")
+	case *route53ZoneID != "" && source != "" && *route53Owner == "":
+		add(fmt.Sprint("--route53-zone-id needs --route53-owner with ", source), "set --route53-owner to a name of the cluster")
+	case *route53ZoneID == "" && (*route53Domain != "" || *route53Owner != ""):
+		warn("--route53-domain and --route53-owner have no effect without --route53-zone-id", "set --route53-zone-id")
+	}
+	if *proxyOf != "" && len(*proxyOfTags) > 0 {
+		add("--proxy-of and --proxy-of-tag can't be combined", "name the group of the cluster or give its tags")
+	}
+	for _, text := range *proxyOfTags {
+		if _, err := discovery.ParseTagFilter(text); err != nil {
+			add(err.Error(), "use KEY=VALUE or KEY")
+		}
+	}
+	if proxying() {
+		if command != joinCmd.FullCommand() && command != topologyCmd.FullCommand() && command != topCmd.FullCommand() {
+			add(fmt.Sprint(command, " acts on the members, it can't be used with --proxy-of"), "run it on an instance of the cluster")
+		}
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--clusters-file", *clustersFile != ""},
+			{"--nomad-job", *nomadJob != ""},
+			{"--cloud " + *cloud, *cloud != "aws"},
+			{"--discovery " + *discoveryMode, *discoveryMode != "asg"},
+			{"--remote-asg", len(*remoteAsgs) > 0},
+			{"--registry-url", *registryURL != ""},
+			{"--registry-etcd-endpoints", len(*registryEtcdEndpoints) > 0},
+			{"--discovery-srv", *discoverySRV != ""},
+			{"--backup-s3-url", *backupS3URL != ""},
+			{"--quorum-recovery-after", *quorumRecoveryAfter > 0},
+			{"--compact-interval", *compactInterval > 0},
+			{"--defrag-interval", *defragInterval > 0},
+			{"--publish-topology", *publishTopology},
+			{"--coordinate", *coordinate},
+			{"--follow-capacity", *followCapacity},
+			{"--scale-in-protection", *scaleInProtection},
+			{"--canary", *canary},
+			{"--repair-peer-urls", *repairPeerURLs},
+			{"--termination-hook", *terminationHook != ""},
+			{"--config-output talos", *configOutput == "talos"},
+			{"--output-format etcd-yaml", *outputFormat == "etcd-yaml"},
+			{"--route53-zone-id", *route53ZoneID != ""},
+			{"--spot-watch", *spotWatch},
+		} {
+			if f.set {
+				add(
+					fmt.Sprint(f.flag, " manages the members, it can't be used with --proxy-of"),
+					"drop it, a proxy only follows the membership",
+				)
+			}
+		}
+		if *daemon && *restartUnit == "" && *configOutput == "file" {
+			warn("Without --restart-unit the proxy keeps its endpoints until restarted", "set --restart-unit to the unit of the proxy")
+		}
+	}
+	if *clustersFile != "" {
+		if command != joinCmd.FullCommand() {
+			add("--clusters-file is only supported by join", "run the other commands once per cluster")
+		}
+		for _, f := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--backup-s3-url", *backupS3URL != ""},
+			{"--quorum-recovery-after", *quorumRecoveryAfter > 0},
+			{"--compact-interval", *compactInterval > 0},
+			{"--defrag-interval", *defragInterval > 0},
+			{"--discovery-srv", *discoverySRV != ""},
+			{"--publish-topology", *publishTopology},
+			{"--coordinate", *coordinate},
+			{"--follow-capacity", *followCapacity},
+			{"--scale-in-protection", *scaleInProtection},
+			{"--canary", *canary},
+			{"--repair-peer-urls", *repairPeerURLs},
+			{"--registry-url", *registryURL != ""},
+			{"--registry-etcd-endpoints", len(*registryEtcdEndpoints) > 0},
+			{"--config-output", *configOutput != "file"},
+			{"--output-format none", *outputFormat == "none"},
+			{"--events-sqs-queue", *eventsQueue != ""},
+			{"--spot-watch", *spotWatch},
+		} {
+			if f.set {
+				add(
+					fmt.Sprint(f.flag, " is not supported with --clusters-file"),
+					"run one etcdmate per cluster to use it",
+				)
+			}
+		}
+	}
+	return problems
+}
+
+func allDNS(addressTypes []string) bool {
+	for _, t := range addressTypes {
+		if !strings.HasSuffix(t, "-dns") {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPrivateIP(addressTypes []string) bool {
+	for _, t := range addressTypes {
+		if t == "private-ip" || t == "private" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfigFile reports the problems of --config-file and of the join
+// settings it results in, for config validate
+func validateConfigFile() error {
+	if *configFile == "" {
+		return misconfigured(errors.New("config validate needs --config-file"))
+	}
+	if errs := logProblems(joinCmd.FullCommand()); errs > 0 {
+		return misconfigured(errors.New(fmt.Sprintf("%d configuration problems in %s", errs, *configFile)))
+	}
+	log.Println(*configFile, "is valid")
+	return nil
+}
+
+// mustValidateConfig logs every problem and exits unless they all are
+// warnings
+func mustValidateConfig(command string) {
+	if errs := logProblems(command); errs > 0 {
+		exit(misconfigured(errors.New(fmt.Sprintf("%d configuration problems", errs))))
+	}
+}
+
+// logProblems logs the problems of the settings of command and returns how
+// many aren't warnings
+func logProblems(command string) int {
+	errs := 0
+	for _, p := range validateConfig(command) {
+		if p.Warning {
+			log.Println("Warning:", p)
+			continue
+		}
+		log.Println("Configuration problem:", p)
+		errs++
+	}
+	return errs
+}